@@ -12,7 +12,9 @@ import (
 	"github.com/celestix/gotgproto/dispatcher"
 	intErrors "github.com/celestix/gotgproto/errors"
 	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/flood"
 	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/metrics"
 	"github.com/celestix/gotgproto/sessionMaker"
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/session"
@@ -20,6 +22,7 @@ import (
 	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/telegram/dcs"
 	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/telegram/updates"
 	"github.com/gotd/td/tg"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -68,25 +71,50 @@ type Client struct {
 	// Session info of the authenticated user, use sessionMaker.NewSession function to fill this field.
 	sessionStorage session.Storage
 	// Self contains details of logged in user in the form of *tg.User.
+	// It is set again on every reconnect, use CreateContext to read it while the client is running.
 	Self *tg.User
 	// Code for the language used on the device's OS, ISO 639-1 standard.
 	SystemLangCode string
 	// Code for the language used on the client, ISO 639-1 standard.
 	ClientLangCode string
 	// PeerStorage is the storage for all the peers.
-	// It is recommended to use storage.NewPeerStorage function for this field.
+	// It is recommended to use storage.NewPeerStorage or storage.NewPeerStorageWithStore function for this field.
 	PeerStorage *storage.PeerStorage
 	// NoAutoAuth is a flag to disable automatic authentication
 	// if the current session is invalid.
 	NoAutoAuth bool
+	// DropPendingUpdates is a flag to skip the updates which were missed while the client was offline.
+	// They are only skipped when the client starts for the first time, not when it reconnects.
+	DropPendingUpdates bool
+	// FloodControl retries the requests failing with FLOOD_WAIT and rate limits the sent messages,
+	// it is nil if the flood control was disabled through ClientOpts.DisableFloodControl.
+	FloodControl *flood.Controller
 
 	authConversator AuthConversator
-	clientType      clientType
-	ctx             context.Context
-	err             error
-	autoFetchReply  bool
-	cancel          context.CancelFunc
-	running         bool
+	// updatesManager and updatesFilter are kept across reconnects, so that the updates state
+	// is kept as well and the updates missed while reconnecting are fetched.
+	updatesManager *updates.Manager
+	updatesFilter  *updatesFilter
+	// updatesStarted reports whether the updates were received once, the pending updates
+	// are only dropped on the first start.
+	updatesStarted bool
+	clientType     clientType
+	// parentCtx is the context provided through ClientOpts, the context of every run is derived from it.
+	parentCtx      context.Context
+	ctx            context.Context
+	err            error
+	autoFetchReply bool
+	cancel         context.CancelFunc
+	// lock protects the state of the client, lifecycle serializes Start and Restart.
+	lock      sync.Mutex
+	lifecycle sync.Mutex
+	state     ClientState
+	opts      *ClientOpts
+	// done is closed when the client stops running, c.err is set before.
+	done    chan struct{}
+	metrics metrics.Recorder
+	// runner replaces the telegram client run by runOnce in tests.
+	runner func(ctx context.Context, reconnect bool, onStart func()) error
 	*telegram.Client
 	appId   int
 	apiHash string
@@ -130,11 +158,26 @@ type ClientOpts struct {
 	// Custom client device
 	Device *telegram.DeviceConfig
 	// Panic handles all the panics that occur during handler execution.
+	// Use dispatcher.StringPanicHandler to adapt a handler taking the panic as a single string.
 	PanicHandler dispatcher.PanicHandler
 	// Error handles all the unknown errors which are returned by the handler callback functions.
+	// Use dispatcher.StringErrorHandler to adapt a handler taking the message of the error.
 	ErrorHandler dispatcher.ErrorHandler
+	// Concurrency enables processing of updates in a worker pool, so that a slow handler doesn't block the whole client.
+	// Updates from the same chat are still processed in the order they arrived.
+	//
+	// Updates are processed serially by default.
+	Concurrency *dispatcher.ConcurrencyOpts
+	// EntityResolution decides when the users, chats and channels which are missing from an update are fetched.
+	// ext.ResolveLazy fetches them only when they are requested by a handler, i.e. through Update.EffectiveUser,
+	// while ext.ResolveEager fetches them before the update is passed to the handlers.
+	//
+	// Set to `ext.ResolveLazy` by default.
+	EntityResolution ext.EntityResolution
 	// Custom Middlewares
 	Middlewares []telegram.Middleware
+	// HandlerMiddlewares wrap the callbacks of all the handlers of the dispatcher, see dispatcher.Middleware.
+	HandlerMiddlewares []dispatcher.Middleware
 	// Custom Run() Middleware
 	// Can be used for floodWaiter package
 	// https://github.com/celestix/gotgproto/blob/beta/examples/middleware/main.go#L41
@@ -172,10 +215,50 @@ type ClientOpts struct {
 	// NoAutoAuth is a flag to disable automatic authentication
 	// if the current session is invalid.
 	NoAutoAuth bool
+	// Setting this field to true will lead to skip the updates which were missed while the client was offline,
+	// otherwise the client catches up on them through getDifference and dispatches them on start.
+	// The updates missed while the client reconnects are never skipped.
+	//
+	// Set to `false` by default.
+	DropPendingUpdates bool
+	// Reconnect configures how the client reconnects after it stopped running with an error,
+	// i.e. because of a network failure.
+	//
+	// If not provided, default ReconnectOpts will be used.
+	Reconnect *ReconnectOpts
+	// DisableReconnect stops the client when it stops running with an error instead of reconnecting.
+	DisableReconnect bool
+	// OnConnected is called when the client is started and logged in.
+	OnConnected func(c *Client)
+	// OnDisconnected is called with the error the client stopped running with before it reconnects.
+	OnDisconnected func(c *Client, err error)
+	// OnReconnected is called when the client is running again after it was disconnected.
+	OnReconnected func(c *Client)
+	// OnAuthLost is called when the session of the client was revoked or expired,
+	// the client is stopped with an error wrapping errors.ErrSessionRevoked.
+	OnAuthLost func(c *Client, err error)
+	// FloodControl configures the retries of the requests failing with FLOOD_WAIT or SLOWMODE_WAIT
+	// and the rate limits of sent messages. It applies to every request of the client, including
+	// the ones made through ext.Context.
+	//
+	// If not provided, flood.BotOpts() will be used for bots, and flood waits are only retried for users.
+	FloodControl *flood.Opts
+	// DisableFloodControl disables the flood control of the client, i.e. to use a custom middleware.
+	DisableFloodControl bool
+	// Metrics records the requests made by the client and the updates processed by its dispatcher,
+	// i.e. a prommetrics.Recorder or an otelmetrics.Recorder.
+	//
+	// Nothing is recorded by default.
+	Metrics metrics.Recorder
 }
 
 // NewClient creates a new gotgproto client and logs in to telegram.
 func NewClient(appId int, apiHash string, cType clientType, opts *ClientOpts) (*Client, error) {
+	return newClient(appId, apiHash, cType, opts, nil)
+}
+
+// newClient creates a new client, setup is called before the client is started if it isn't nil.
+func newClient(appId int, apiHash string, cType clientType, opts *ClientOpts, setup func(*Client)) (*Client, error) {
 	if opts == nil {
 		opts = &ClientOpts{
 			SystemLangCode: "en",
@@ -199,38 +282,59 @@ func NewClient(appId int, apiHash string, cType clientType, opts *ClientOpts) (*
 	}
 
 	d := dispatcher.NewNativeDispatcher(opts.AutoFetchReply, opts.FetchEntireReplyChain, opts.ErrorHandler, opts.PanicHandler, peerStorage)
+	d.Concurrency = opts.Concurrency
+	d.EntityResolution = opts.EntityResolution
+	d.Use(opts.HandlerMiddlewares...)
+	if opts.Metrics != nil {
+		d.Metrics = opts.Metrics
+	}
 
 	c := Client{
-		Resolver:          opts.Resolver,
-		PublicKeys:        opts.PublicKeys,
-		DC:                opts.DC,
-		DCList:            opts.DCList,
-		MigrationTimeout:  opts.MigrationTimeout,
-		AckBatchSize:      opts.AckBatchSize,
-		AckInterval:       opts.AckInterval,
-		RetryInterval:     opts.RetryInterval,
-		MaxRetries:        opts.MaxRetries,
-		ExchangeTimeout:   opts.ExchangeTimeout,
-		DialTimeout:       opts.DialTimeout,
-		CompressThreshold: opts.CompressThreshold,
-		DisableCopyright:  opts.DisableCopyright,
-		Logger:            opts.Logger,
-		SystemLangCode:    opts.SystemLangCode,
-		ClientLangCode:    opts.ClientLangCode,
-		NoAutoAuth:        opts.NoAutoAuth,
-		authConversator:   opts.AuthConversator,
-		Dispatcher:        d,
-		PeerStorage:       peerStorage,
-		sessionStorage:    sessionStorage,
-		clientType:        cType,
-		ctx:               ctx,
-		autoFetchReply:    opts.AutoFetchReply,
-		cancel:            cancel,
-		appId:             appId,
-		apiHash:           apiHash,
+		Resolver:           opts.Resolver,
+		PublicKeys:         opts.PublicKeys,
+		DC:                 opts.DC,
+		DCList:             opts.DCList,
+		MigrationTimeout:   opts.MigrationTimeout,
+		AckBatchSize:       opts.AckBatchSize,
+		AckInterval:        opts.AckInterval,
+		RetryInterval:      opts.RetryInterval,
+		MaxRetries:         opts.MaxRetries,
+		ExchangeTimeout:    opts.ExchangeTimeout,
+		DialTimeout:        opts.DialTimeout,
+		CompressThreshold:  opts.CompressThreshold,
+		DisableCopyright:   opts.DisableCopyright,
+		Logger:             opts.Logger,
+		SystemLangCode:     opts.SystemLangCode,
+		ClientLangCode:     opts.ClientLangCode,
+		NoAutoAuth:         opts.NoAutoAuth,
+		DropPendingUpdates: opts.DropPendingUpdates,
+		authConversator:    opts.AuthConversator,
+		Dispatcher:         d,
+		PeerStorage:        peerStorage,
+		sessionStorage:     sessionStorage,
+		clientType:         cType,
+		parentCtx:          opts.Context,
+		ctx:                ctx,
+		autoFetchReply:     opts.AutoFetchReply,
+		cancel:             cancel,
+		appId:              appId,
+		apiHash:            apiHash,
+		metrics:            opts.Metrics,
 	}
 
+	if !opts.DisableFloodControl {
+		floodOpts := opts.FloodControl
+		if floodOpts == nil && cType.getType() == clientTypeVBot {
+			floodOpts = flood.BotOpts()
+		}
+		c.FloodControl = flood.New(floodOpts)
+	}
+
+	c.initUpdatesManager()
 	c.printCredit()
+	if setup != nil {
+		setup(&c)
+	}
 
 	return &c, c.Start(opts)
 }
@@ -248,7 +352,20 @@ func (c *Client) initTelegramClient(
 			LangCode:       c.ClientLangCode,
 		}
 	}
-	c.Client = telegram.NewClient(c.appId, c.apiHash, telegram.Options{
+	// Pass the updates returned by requests to the manager as well, so that it doesn't see gaps for them.
+	chain := make([]telegram.Middleware, 0, len(middlewares)+3)
+	if c.FloodControl != nil {
+		chain = append(chain, c.FloodControl)
+	}
+	// Retried requests are recorded once per attempt.
+	if c.metrics != nil {
+		chain = append(chain, metrics.Middleware(c.metrics))
+	}
+	middlewares = append(
+		append(chain, middlewares...),
+		c.updatesFilter.middleware(c.selfID, c.updatesManager.Handle),
+	)
+	client := telegram.NewClient(c.appId, c.apiHash, telegram.Options{
 		DCList:            c.DCList,
 		Resolver:          c.Resolver,
 		DC:                c.DC,
@@ -261,29 +378,54 @@ func (c *Client) initTelegramClient(
 		ExchangeTimeout:   c.ExchangeTimeout,
 		DialTimeout:       c.DialTimeout,
 		CompressThreshold: c.CompressThreshold,
-		UpdateHandler:     c.Dispatcher,
+		UpdateHandler:     c.updatesManager,
 		SessionStorage:    c.sessionStorage,
 		Logger:            c.Logger,
 		Device:            *device,
 		Middlewares:       middlewares,
 	})
+	// The client is rebuilt on every reconnect while CreateContext and API may be called concurrently.
+	c.lock.Lock()
+	c.Client = client
+	c.lock.Unlock()
+}
+
+// initUpdatesManager creates the updates manager of the client, which is run again on every reconnect.
+func (c *Client) initUpdatesManager() {
+	c.updatesFilter = &updatesFilter{next: c.Dispatcher}
+	updatesConfig := updates.Config{
+		Handler: c.updatesFilter,
+		Logger:  c.Logger,
+	}
+	// Keep the in-memory defaults of gotd if the peer storage has no database.
+	if c.PeerStorage.SqlSession != nil {
+		updatesStorage := storage.NewUpdatesStorage(c.PeerStorage)
+		updatesConfig.Storage = updatesStorage
+		updatesConfig.AccessHasher = updatesStorage
+	}
+	c.updatesManager = updates.New(updatesConfig)
 }
 
-func (c *Client) login() error {
+// login authorizes the client if its session is unauthorized.
+// A reconnecting client isn't authorized again, as its session was revoked.
+func (c *Client) login(ctx context.Context, reconnect bool) error {
 	authClient := c.Auth()
-	status, err := authClient.Status(c.ctx)
+	status, err := authClient.Status(ctx)
 	if err != nil {
 		return errors.Wrap(err, "auth status")
 	}
 	if status.Authorized {
 		return nil
 	}
+	if reconnect {
+		return intErrors.ErrSessionRevoked
+	}
 	if c.clientType.getType() == clientTypeVPhone {
 		if c.NoAutoAuth {
 			return intErrors.ErrSessionUnauthorized
 		}
 		err = authFlow(
-			c.ctx, authClient,
+			ctx, authClient,
 			c.authConversator,
 			c.clientType.getValue(),
 			auth.SendCodeOptions{},
@@ -293,7 +435,7 @@ func (c *Client) login() error {
 		}
 	} else {
 		if !status.Authorized {
-			if _, err := c.Auth().Bot(c.ctx, c.clientType.getValue()); err != nil {
+			if _, err := c.Auth().Bot(ctx, c.clientType.getValue()); err != nil {
 				return errors.Wrap(err, "login")
 			}
 		}
@@ -311,9 +453,9 @@ Licensed under the terms of GNU General Public License v3
 	}
 }
 
-func (c *Client) initialize(wg *sync.WaitGroup) func(ctx context.Context) error {
+func (c *Client) initialize(reconnect bool, onStart func()) func(ctx context.Context) error {
 	return func(ctx context.Context) error {
-		err := c.login()
+		err := c.login(ctx, reconnect)
 		if err != nil {
 			return err
 		}
@@ -322,19 +464,32 @@ func (c *Client) initialize(wg *sync.WaitGroup) func(ctx context.Context) error
 			return err
 		}
 
+		c.lock.Lock()
 		c.Self = self
+		c.lock.Unlock()
 
 		c.Dispatcher.Initialize(ctx, c.Stop, c.Client, self)
 
-		c.PeerStorage.AddPeer(self.ID, self.AccessHash, storage.TypeUser, self.Username)
-		// notify channel that client is up
-		wg.Done()
-		c.running = true
-		<-c.ctx.Done()
-		return c.ctx.Err()
+		c.PeerStorage.SavePeer(storage.PeerFromUser(self))
+		return c.runUpdates(ctx, c.API(), self, onStart)
 	}
 }
 
+// runUpdates runs the updates manager until ctx is done, onStart is called once it receives updates.
+func (c *Client) runUpdates(ctx context.Context, api updates.API, self *tg.User, onStart func()) error {
+	// The manager keeps its state once it stopped running, it is reset so that it can run again.
+	defer c.updatesManager.Reset()
+	return c.updatesManager.Run(ctx, api, self.ID, updates.AuthOptions{
+		IsBot:  self.Bot,
+		Forget: c.DropPendingUpdates && !c.updatesStarted,
+		OnStart: func(ctx context.Context) {
+			c.updatesStarted = true
+			// notify channel that client is up
+			onStart()
+		},
+	})
+}
+
 // ExportStringSession EncodeSessionToString encodes the client session to a string in base64.
 //
 // Note: You must not share this string with anyone, it contains auth details for your logged in account.
@@ -351,32 +506,94 @@ func (c *Client) ExportStringSession() (string, error) {
 	return functions.EncodeSessionToString(c.PeerStorage.GetSession())
 }
 
-// Idle keeps the current goroutined blocked until the client is stopped.
+// Idle keeps the current goroutined blocked until the client is stopped,
+// either through Client.Stop or because it stopped running with an error, which is returned.
 func (c *Client) Idle() error {
-	<-c.ctx.Done()
+	for {
+		c.lock.Lock()
+		done := c.done
+		c.lock.Unlock()
+		if done == nil {
+			<-c.ctx.Done()
+			return nil
+		}
+		<-done
+		// Wait for a restart in progress, Idle only returns once the client is stopped for good.
+		c.lifecycle.Lock()
+		c.lock.Lock()
+		stopped := c.done == done
+		err := c.runErr()
+		c.lock.Unlock()
+		c.lifecycle.Unlock()
+		if stopped {
+			return err
+		}
+	}
+}
+
+// runErr returns the error the client stopped running with, it is nil if the client was stopped.
+func (c *Client) runErr() error {
+	if errors.Is(c.err, context.Canceled) {
+		return nil
+	}
 	return c.err
 }
 
 // CreateContext creates a new pseudo updates context.
 // A context retrieved from this method should be reused.
 func (c *Client) CreateContext() *ext.Context {
+	c.lock.Lock()
+	ctx, self, client := c.ctx, c.Self, c.Client
+	c.lock.Unlock()
+	api := client.API()
 	return ext.NewContext(
-		c.ctx,
-		c.API(),
+		ctx,
+		api,
 		c.PeerStorage,
-		c.Self,
-		message.NewSender(c.API()),
+		self,
+		message.NewSender(api),
 		&tg.Entities{
 			Users: map[int64]*tg.User{
-				c.Self.ID: c.Self,
+				self.ID: self,
 			},
 		},
 		c.autoFetchReply,
 	)
 }
 
+// selfID returns the ID of the logged in user, 0 until the client is logged in.
+func (c *Client) selfID() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.Self == nil {
+		return 0
+	}
+	return c.Self.ID
+}
+
+// API returns the raw tg client of the telegram client, which is replaced on every reconnect.
+func (c *Client) API() *tg.Client {
+	c.lock.Lock()
+	client := c.Client
+	c.lock.Unlock()
+	return client.API()
+}
+
+// State returns the current state of the client.
+func (c *Client) State() ClientState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+func (c *Client) setState(state ClientState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.state = state
+}
+
 // Stop cancels the context.Context being used for the client
-// and stops it.
+// and stops it, it doesn't wait for the client to stop.
 //
 // Notes:
 //
@@ -385,47 +602,86 @@ func (c *Client) CreateContext() *ext.Context {
 // 2.) You can call Client.Start() to start the client again
 // if it was stopped using this method.
 func (c *Client) Stop() {
-	c.cancel()
-	c.running = false
+	c.lock.Lock()
+	cancel := c.cancel
+	c.lock.Unlock()
+	cancel()
 }
 
 // Start connects the client to telegram servers and logins.
 // It will return error if the client is already running.
+//
+// Once started, the client reconnects whenever it stops running with an error until it is stopped,
+// see ClientOpts.Reconnect.
 func (c *Client) Start(opts *ClientOpts) error {
-	if c.running {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+	return c.start(opts)
+}
+
+// Restart stops the client, waits till it stops and starts it again with the options it was last started with.
+//
+// Note: It must not be called from a handler, as the client waits for the handlers to return before it stops.
+func (c *Client) Restart() error {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+	c.lock.Lock()
+	done, opts := c.done, c.opts
+	c.lock.Unlock()
+	c.Stop()
+	if done != nil {
+		<-done
+	}
+	return c.start(opts)
+}
+
+// start starts the client, c.lifecycle must be held.
+func (c *Client) start(opts *ClientOpts) error {
+	c.lock.Lock()
+	if c.state != StateStopped {
+		c.lock.Unlock()
 		return intErrors.ErrClientAlreadyRunning
 	}
-	if c.ctx.Err() == context.Canceled {
-		c.ctx, c.cancel = context.WithCancel(context.Background())
+	if c.ctx.Err() != nil {
+		c.ctx, c.cancel = context.WithCancel(c.parentCtx)
 	}
+	ctx, cancel := c.ctx, c.cancel
+	done, started := make(chan struct{}), make(chan struct{})
+	c.state = StateStarting
+	c.opts = opts
+	c.done = done
+	c.err = nil
+	c.lock.Unlock()
+
+	go func() {
+		err := c.run(ctx, opts, started)
+		cancel()
+		c.lock.Lock()
+		c.err = err
+		c.state = StateStopped
+		c.lock.Unlock()
+		close(done)
+	}()
 
-	c.initTelegramClient(opts.Device, opts.Middlewares)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func(c *Client) {
-		if opts.RunMiddleware == nil {
-			c.err = c.Run(c.ctx, c.initialize(&wg))
-		} else {
-			c.err = opts.RunMiddleware(
-				c.Run,
-				c.ctx,
-				c.initialize(&wg),
-			)
-		}
-
+	// wait till client starts
+	select {
+	case <-started:
+		return nil
+	case <-done:
+		c.lock.Lock()
+		defer c.lock.Unlock()
 		if c.err != nil {
-			wg.Done()
+			return c.err
 		}
-	}(c)
-
-	// wait till client starts
-	wg.Wait()
-	return c.err
+		return ctx.Err()
+	}
 }
 
 // RefreshContext casts the new context.Context and telegram session
 // to ext.Context (It may be used after doing Stop and Start calls respectively.)
 func (c *Client) RefreshContext(ctx *ext.Context) {
+	c.lock.Lock()
 	(*ctx).Context = c.ctx
+	c.lock.Unlock()
 	(*ctx).Raw = c.API()
 }
@@ -0,0 +1,33 @@
+package gotgproto
+
+// ClientState is the state of a client, see Client.State.
+type ClientState int
+
+const (
+	// StateStopped means the client is not running, it was stopped or it failed to start.
+	StateStopped ClientState = iota
+	// StateStarting means the client is connecting to telegram and logging in.
+	StateStarting
+	// StateRunning means the client is up and receiving updates.
+	StateRunning
+	// StateReconnecting means the client lost its connection and is waiting to reconnect.
+	StateReconnecting
+	// StateRestarting means the client crashed and is waiting to be restarted by a Manager.
+	StateRestarting
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateRestarting:
+		return "restarting"
+	}
+	return "unknown"
+}
@@ -0,0 +1,217 @@
+package gotgproto
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	intErrors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// attempt is a scripted run of the telegram client, it calls onStart if start is true
+// and returns err, or waits until the client is stopped if err is nil.
+type attempt struct {
+	start bool
+	err   error
+}
+
+type testRunner struct {
+	lock     sync.Mutex
+	attempts []attempt
+	// reconnects records the reconnect flag of every run.
+	reconnects []bool
+}
+
+func (r *testRunner) run(ctx context.Context, reconnect bool, onStart func()) error {
+	r.lock.Lock()
+	r.reconnects = append(r.reconnects, reconnect)
+	a := attempt{start: true}
+	if len(r.attempts) > 0 {
+		a, r.attempts = r.attempts[0], r.attempts[1:]
+	}
+	r.lock.Unlock()
+	if a.start {
+		onStart()
+	}
+	if a.err != nil {
+		return a.err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (r *testRunner) runs() []bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]bool(nil), r.reconnects...)
+}
+
+func newTestClient(attempts ...attempt) (*Client, *testRunner) {
+	r := &testRunner{attempts: attempts}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{parentCtx: context.Background(), ctx: ctx, cancel: cancel, runner: r.run}, r
+}
+
+func idle(t *testing.T, c *Client) error {
+	t.Helper()
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Idle() }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't stop")
+		return nil
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	lost := errors.New("connection lost")
+	c, r := newTestClient(
+		attempt{start: true, err: lost},
+		attempt{err: errors.New("dial failed")},
+		attempt{start: true},
+	)
+	var connected, disconnected, reconnected int
+	reconnectedCh := make(chan struct{})
+	err := c.Start(&ClientOpts{
+		Reconnect:   &ReconnectOpts{MinBackoff: time.Millisecond},
+		OnConnected: func(*Client) { connected++ },
+		OnDisconnected: func(_ *Client, err error) {
+			if !errors.Is(err, lost) {
+				t.Errorf("unexpected disconnect error %v", err)
+			}
+			disconnected++
+		},
+		OnReconnected: func(*Client) {
+			reconnected++
+			close(reconnectedCh)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-reconnectedCh
+	if s := c.State(); s != StateRunning {
+		t.Fatalf("unexpected state %s", s)
+	}
+	c.Stop()
+	if err := idle(t, c); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if connected != 1 || disconnected != 1 || reconnected != 1 {
+		t.Fatalf("unexpected hook calls: connected %d, disconnected %d, reconnected %d", connected, disconnected, reconnected)
+	}
+	if runs := r.runs(); len(runs) != 3 || runs[0] || !runs[1] || !runs[2] {
+		t.Fatalf("unexpected runs %v", runs)
+	}
+	if s := c.State(); s != StateStopped {
+		t.Fatalf("unexpected state %s", s)
+	}
+}
+
+func TestClientStartError(t *testing.T) {
+	failed := errors.New("bad token")
+	c, r := newTestClient(attempt{err: failed})
+	if err := c.Start(&ClientOpts{Reconnect: &ReconnectOpts{MinBackoff: time.Millisecond}}); !errors.Is(err, failed) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if runs := r.runs(); len(runs) != 1 {
+		t.Fatalf("expected the first start not to be retried, got %d runs", len(runs))
+	}
+}
+
+func TestClientAuthLost(t *testing.T) {
+	c, _ := newTestClient(attempt{start: true, err: tgerr.New(401, "SESSION_REVOKED")})
+	var lost error
+	err := c.Start(&ClientOpts{
+		Reconnect:  &ReconnectOpts{MinBackoff: time.Millisecond},
+		OnAuthLost: func(_ *Client, err error) { lost = err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = idle(t, c)
+	if !errors.Is(err, intErrors.ErrSessionRevoked) || !tgerr.Is(err, "SESSION_REVOKED") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if lost != err {
+		t.Fatalf("unexpected OnAuthLost error %v", lost)
+	}
+}
+
+func TestClientMaxAttempts(t *testing.T) {
+	failed := errors.New("dial failed")
+	c, r := newTestClient(
+		attempt{start: true, err: errors.New("connection lost")},
+		attempt{err: failed},
+		attempt{err: failed},
+	)
+	err := c.Start(&ClientOpts{Reconnect: &ReconnectOpts{MinBackoff: time.Millisecond, MaxAttempts: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idle(t, c); !errors.Is(err, failed) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if runs := r.runs(); len(runs) != 3 {
+		t.Fatalf("expected 3 runs, got %d", len(runs))
+	}
+}
+
+func TestClientRestart(t *testing.T) {
+	c, r := newTestClient()
+	opts := &ClientOpts{DisableReconnect: true}
+	if err := c.Start(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(opts); !errors.Is(err, intErrors.ErrClientAlreadyRunning) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := c.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.State(); s != StateRunning {
+		t.Fatalf("unexpected state %s", s)
+	}
+	if runs := r.runs(); len(runs) != 2 || runs[1] {
+		t.Fatalf("expected the client to be started again, got runs %v", runs)
+	}
+	c.Stop()
+	if err := idle(t, c); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestClientUpdatesState(t *testing.T) {
+	p, _ := storage.NewPeerStorage(nil, true)
+	c := &Client{Dispatcher: dispatcher.NewNativeDispatcher(false, false, nil, nil, p), PeerStorage: p, DropPendingUpdates: true}
+	c.initUpdatesManager()
+	i := gotgprototest.NewInvoker()
+	i.Reply(&tg.UpdatesGetStateRequest{}, &tg.UpdatesState{Pts: 10, Date: 1, Seq: 1})
+	i.Reply(&tg.UpdatesGetDifferenceRequest{}, &tg.UpdatesDifferenceEmpty{Date: 1, Seq: 1})
+	self := gotgprototest.Bot(1, "test_bot")
+	for run := 0; run < 2; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		started := false
+		err := c.runUpdates(ctx, tg.NewClient(i), self, func() {
+			started = true
+			cancel()
+		})
+		if !started {
+			t.Fatalf("run %d: updates didn't start: %v", run, err)
+		}
+	}
+	// The state is fetched only once, the pending updates are dropped on the first start
+	// and the state is kept while reconnecting.
+	if n := len(gotgprototest.RequestsOf[*tg.UpdatesGetStateRequest](i)); n != 1 {
+		t.Fatalf("updates.getState called %d times, want 1", n)
+	}
+}
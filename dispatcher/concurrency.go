@@ -0,0 +1,160 @@
+package dispatcher
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/celestix/gotgproto/metrics"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+// BackpressurePolicy decides what happens to a new update when the queue of its worker is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock blocks the incoming updates till the worker has space in its queue.
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDrop drops the new update if the worker queue is full.
+	BackpressureDrop
+)
+
+// ConcurrencyOpts object contains parameters for processing updates concurrently.
+// Updates from the same chat are always processed in the order they arrived,
+// while updates from different chats are processed in parallel.
+type ConcurrencyOpts struct {
+	// Workers is the number of goroutines processing updates.
+	// runtime.NumCPU() is used by default.
+	Workers int
+	// QueueSize is the number of updates which can wait in the queue of a worker.
+	// 100 is used by default.
+	QueueSize int
+	// Policy decides what happens to new updates once a worker queue is full.
+	// BackpressureBlock is used by default.
+	Policy BackpressurePolicy
+}
+
+type job struct {
+	ctx    context.Context
+	e      tg.Entities
+	update tg.UpdateClass
+}
+
+type workerPool struct {
+	queues []chan job
+	policy BackpressurePolicy
+	// next is used to spread updates which don't belong to any chat over the workers.
+	next atomic.Uint64
+	// depth is the number of queued updates.
+	depth   atomic.Int64
+	metrics metrics.Recorder
+}
+
+func newWorkerPool(ctx context.Context, opts *ConcurrencyOpts, handle func(context.Context, tg.Entities, tg.UpdateClass) error, rec metrics.Recorder) *workerPool {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = 100
+	}
+	wp := &workerPool{
+		queues:  make([]chan job, workers),
+		policy:  opts.Policy,
+		metrics: rec,
+	}
+	for i := range wp.queues {
+		queue := make(chan job, queueSize)
+		wp.queues[i] = queue
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-queue:
+					wp.metrics.QueueDepth(j.ctx, int(wp.depth.Add(-1)))
+					// Errors are already passed to the error handler by the dispatcher.
+					_ = handle(j.ctx, j.e, j.update)
+				}
+			}
+		}()
+	}
+	return wp
+}
+
+// enqueue sends the update to the worker responsible for its chat.
+func (wp *workerPool) enqueue(j job) {
+	var n uint64
+	if key := getUpdateChatKey(j.update); key != 0 {
+		n = uint64(key)
+	} else {
+		n = wp.next.Add(1)
+	}
+	queue := wp.queues[n%uint64(len(wp.queues))]
+	// The depth is increased before the update is queued, so that it never goes negative.
+	wp.metrics.QueueDepth(j.ctx, int(wp.depth.Add(1)))
+	if wp.policy == BackpressureDrop {
+		select {
+		case queue <- j:
+		default:
+			wp.metrics.QueueDepth(j.ctx, int(wp.depth.Add(-1)))
+			wp.metrics.Dropped(j.ctx, j.update.TypeName())
+			log.Println("Dropped an update because the dispatcher queue is full")
+		}
+		return
+	}
+	select {
+	case queue <- j:
+	case <-j.ctx.Done():
+		wp.metrics.QueueDepth(j.ctx, int(wp.depth.Add(-1)))
+	}
+}
+
+// getUpdateChatKey returns the PeerID of the chat an update belongs to, 0 if it doesn't belong to any chat.
+func getUpdateChatKey(update tg.UpdateClass) storage.PeerID {
+	var peer tg.PeerClass
+	switch u := update.(type) {
+	case interface{ GetMessage() tg.MessageClass }:
+		m, ok := u.GetMessage().(interface{ GetPeerID() tg.PeerClass })
+		if !ok {
+			return 0
+		}
+		peer = m.GetPeerID()
+	case *tg.UpdateBotCallbackQuery:
+		peer = u.Peer
+	case *tg.UpdateBotInlineQuery:
+		return storage.NewPeerID(u.UserID, storage.TypeUser)
+	case *tg.UpdatePendingJoinRequests:
+		peer = u.Peer
+	case *tg.UpdateChatParticipant:
+		return storage.NewPeerID(u.ChatID, storage.TypeChat)
+	case *tg.UpdateChannelParticipant:
+		return storage.NewPeerID(u.ChannelID, storage.TypeChannel)
+	default:
+		return 0
+	}
+	return storage.PeerIDFromPeer(peer)
+}
+
+// clone copies the entity maps so that an update can be processed without sharing them with other goroutines.
+func (e entities) clone() tg.Entities {
+	c := tg.Entities{
+		Short:    e.Short,
+		Users:    make(map[int64]*tg.User, len(e.Users)),
+		Chats:    make(map[int64]*tg.Chat, len(e.Chats)),
+		Channels: make(map[int64]*tg.Channel, len(e.Channels)),
+	}
+	for id, user := range e.Users {
+		c.Users[id] = user
+	}
+	for id, chat := range e.Chats {
+		c.Chats[id] = chat
+	}
+	for id, channel := range e.Channels {
+		c.Channels[id] = channel
+	}
+	return c
+}
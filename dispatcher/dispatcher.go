@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"log"
 	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/metrics"
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/message"
@@ -45,16 +48,58 @@ type NativeDispatcher struct {
 	Panic PanicHandler
 	// Error handles all the unknown errors which are returned by the handler callback functions.
 	Error ErrorHandler
+	// Concurrency enables concurrent processing of updates, updates are processed serially if it is nil.
+	// It must be set before the client is started.
+	Concurrency *ConcurrencyOpts
+	// EntityResolution decides when the users, chats and channels missing from an update are fetched.
+	// It must be set before the client is started.
+	EntityResolution ext.EntityResolution
+	// Metrics records the processed updates, the executions of the handlers and the depth of the queue.
+	// It must be set before the client is started, metrics.Nop is used by default.
+	Metrics metrics.Recorder
 	// handlerMap is used for internal functionality of NativeDispatcher.
 	handlerMap map[int][]Handler
 	// handlerGroups is used for internal functionality of NativeDispatcher.
 	handlerGroups []int
+	// middlewares wrap the handlers of all the groups, groupMiddlewares the ones of a group.
+	middlewares      []Middleware
+	groupMiddlewares map[int][]Middleware
+	// handlersLock guards the handlers and the middlewares, which can be added while updates are handled.
+	handlersLock sync.RWMutex
 
 	pStorage *storage.PeerStorage
+	workers  *workerPool
+	resolver *ext.EntityResolver
 }
 
-type PanicHandler func(*ext.Context, *ext.Update, string)
-type ErrorHandler func(*ext.Context, *ext.Update, string) error
+// PanicHandler handles a panic recovered from a handler, it receives the value passed to panic
+// and the stack trace of the goroutine which panicked.
+type PanicHandler func(ctx *ext.Context, u *ext.Update, recovered any, stack []byte)
+
+// ErrorHandler handles an error returned by a handler, it receives the error as returned by the handler
+// so that it can be inspected with errors.Is, errors.As or the helpers of the errors package,
+// i.e. errors.IsWriteForbidden. The returned error decides how the dispatcher proceeds, i.e. ContinueGroups.
+type ErrorHandler func(ctx *ext.Context, u *ext.Update, err error) error
+
+// StringErrorHandler adapts an error handler receiving the message of the error,
+// as taken by ErrorHandler in the previous versions, to an ErrorHandler.
+func StringErrorHandler(h func(*ext.Context, *ext.Update, string) error) ErrorHandler {
+	return func(ctx *ext.Context, u *ext.Update, err error) error {
+		return h(ctx, u, err.Error())
+	}
+}
+
+// StringPanicHandler adapts a panic handler receiving the recovered value followed by the stack trace
+// in a single string, as taken by PanicHandler in the previous versions, to a PanicHandler.
+func StringPanicHandler(h func(*ext.Context, *ext.Update, string)) PanicHandler {
+	return func(ctx *ext.Context, u *ext.Update, recovered any, stack []byte) {
+		h(ctx, u, panicMessage(recovered, stack))
+	}
+}
+
+func panicMessage(recovered any, stack []byte) string {
+	return fmt.Sprintf("%s\n", recovered) + string(stack)
+}
 
 // MakeDispatcher creates new custom dispatcher which process and handles incoming updates.
 func NewNativeDispatcher(setReply bool, setEntireReplyChain bool, eHandler ErrorHandler, pHandler PanicHandler, p *storage.PeerStorage) *NativeDispatcher {
@@ -69,10 +114,11 @@ func NewNativeDispatcher(setReply bool, setEntireReplyChain bool, eHandler Error
 		setEntireReplyChain: setEntireReplyChain,
 		Error:               eHandler,
 		Panic:               pHandler,
+		Metrics:             metrics.Nop{},
 	}
 }
 
-func defaultErrorHandler(_ *ext.Context, _ *ext.Update, err string) error {
+func defaultErrorHandler(_ *ext.Context, _ *ext.Update, err error) error {
 	log.Println("An error occured while handling update:", err)
 	return ContinueGroups
 }
@@ -87,10 +133,20 @@ func (u *entities) short() {
 }
 
 func (dp *NativeDispatcher) Initialize(ctx context.Context, cancel context.CancelFunc, client *telegram.Client, self *tg.User) {
-	dp.client = client.API()
+	dp.InitializeRaw(ctx, cancel, client.API(), self)
+}
+
+// InitializeRaw initializes the dispatcher with the provided raw tg client instead of a telegram.Client,
+// it is useful to run the dispatcher over a custom tg.Invoker, i.e. in tests.
+func (dp *NativeDispatcher) InitializeRaw(ctx context.Context, cancel context.CancelFunc, client *tg.Client, self *tg.User) {
+	dp.client = client
 	dp.sender = message.NewSender(dp.client)
 	dp.self = self
 	dp.cancel = cancel
+	dp.resolver = ext.NewEntityResolver(ctx, dp.client, dp.pStorage, dp.EntityResolution)
+	if dp.Concurrency != nil {
+		dp.workers = newWorkerPool(ctx, dp.Concurrency, dp.dispatch, dp.Metrics)
+	}
 }
 
 // Handle function handles all the incoming updates, map entities and dispatches updates for further handling.
@@ -119,10 +175,22 @@ func (dp *NativeDispatcher) Handle(ctx context.Context, updates tg.UpdatesClass)
 	case *tg.UpdateShort:
 		upds = []tg.UpdateClass{u.Update}
 		e.short()
+	case *tg.UpdateShortMessage, *tg.UpdateShortChatMessage:
+		// The senders of short messages are fetched by the entity resolver.
+		update, _ := shortMessageUpdate(dp.self.ID, u)
+		upds = []tg.UpdateClass{update}
+		e.short()
 	default:
 		return nil
 	}
 
+	if dp.workers != nil {
+		for _, update := range upds {
+			dp.workers.enqueue(job{ctx: ctx, e: e.clone(), update: update})
+		}
+		return nil
+	}
+
 	var err error
 	for _, update := range upds {
 		multierr.AppendInto(&err, dp.dispatch(ctx, tg.Entities(e), update))
@@ -138,24 +206,39 @@ func (dp *NativeDispatcher) dispatch(ctx context.Context, e tg.Entities, update
 }
 
 func (dp *NativeDispatcher) handleUpdate(ctx context.Context, e tg.Entities, update tg.UpdateClass) error {
-	u := ext.GetNewUpdate(ctx, dp.client, dp.self.ID, dp.pStorage, &e, update)
+	dp.Metrics.Update(ctx, update.TypeName())
+	if u, ok := update.(interface{ GetMessage() tg.MessageClass }); ok {
+		// Remember the message the peers without an access hash were seen in, so that they can still be used.
+		if msg, ok := u.GetMessage().(*tg.Message); ok {
+			dp.pStorage.SaveMessageContext(msg)
+		}
+	}
+	u := ext.GetNewUpdate(ctx, dp.resolver, dp.self.ID, &e, update)
 	dp.handleUpdateRepliedToMessage(u, ctx)
 	c := ext.NewContext(ctx, dp.client, dp.pStorage, dp.self, dp.sender, &e, dp.setReply)
 	var err error
+	// current is the group of the handler being executed.
+	current := 0
 	defer func() {
 		if r := recover(); r != nil {
-			errorStack := fmt.Sprintf("%s\n", r) + string(debug.Stack())
+			dp.Metrics.Panic(ctx, current)
+			stack := debug.Stack()
 			if dp.Panic != nil {
-				dp.Panic(c, u, errorStack)
+				dp.Panic(c, u, r, stack)
 				return
 			} else {
-				log.Println(errorStack)
+				log.Println(panicMessage(r, stack))
 			}
 		}
 	}()
-	for _, group := range dp.handlerGroups {
-		for _, handler := range dp.handlerMap[group] {
-			err = handler.CheckUpdate(c, u)
+	for _, g := range dp.groups() {
+		group := g.id
+		current = group
+		c.CallbackMiddleware = g.middleware
+		for _, handler := range g.handlers {
+			start := time.Now()
+			err = RunHandler(c, u, handler)
+			dp.Metrics.Handler(ctx, group, time.Since(start), handlerError(err))
 			if err == nil || errors.Is(err, ContinueGroups) {
 				continue
 			} else if errors.Is(err, EndGroups) {
@@ -166,7 +249,7 @@ func (dp *NativeDispatcher) handleUpdate(ctx context.Context, e tg.Entities, upd
 				dp.cancel()
 				return nil
 			} else {
-				err = dp.Error(c, u, err.Error())
+				err = dp.Error(c, u, err)
 				switch err {
 				case ContinueGroups:
 					continue
@@ -181,6 +264,35 @@ func (dp *NativeDispatcher) handleUpdate(ctx context.Context, e tg.Entities, upd
 	return err
 }
 
+// handlerGroup is a group of handlers as it was when an update started being handled.
+type handlerGroup struct {
+	id         int
+	handlers   []Handler
+	middleware Middleware
+}
+
+// groups returns the groups of handlers in the order they are executed.
+func (dp *NativeDispatcher) groups() []handlerGroup {
+	dp.handlersLock.RLock()
+	defer dp.handlersLock.RUnlock()
+	groups := make([]handlerGroup, len(dp.handlerGroups))
+	for i, group := range dp.handlerGroups {
+		// Handlers are only appended, so the slice keeps the handlers it had.
+		groups[i] = handlerGroup{id: group, handlers: dp.handlerMap[group], middleware: dp.middlewareOf(group)}
+	}
+	return groups
+}
+
+// handlerError returns the error returned by a handler unless it is used to control the dispatcher.
+func handlerError(err error) error {
+	for _, control := range []error{ContinueGroups, EndGroups, SkipCurrentGroup, StopClient} {
+		if errors.Is(err, control) {
+			return nil
+		}
+	}
+	return err
+}
+
 func (dp *NativeDispatcher) handleUpdateRepliedToMessage(u *ext.Update, ctx context.Context) {
 	msg := u.EffectiveMessage
 	if msg == nil || !dp.setReply {
@@ -205,7 +317,7 @@ func saveUsersPeers(u tg.UserClassArray, p *storage.PeerStorage) {
 		if !ok {
 			continue
 		}
-		p.AddPeer(c.ID, c.AccessHash, storage.TypeUser, c.Username)
+		p.SavePeer(storage.PeerFromUser(c))
 	}
 }
 
@@ -213,13 +325,13 @@ func saveChatsPeers(u tg.ChatClassArray, p *storage.PeerStorage) {
 	for _, chat := range u {
 		channel, ok := chat.(*tg.Channel)
 		if ok {
-			p.AddPeer(channel.ID, channel.AccessHash, storage.TypeChannel, channel.Username)
+			p.SavePeer(storage.PeerFromChannel(channel))
 			continue
 		}
 		chat, ok := chat.(*tg.Chat)
 		if !ok {
 			continue
 		}
-		p.AddPeer(chat.ID, storage.DefaultAccessHash, storage.TypeChat, storage.DefaultUsername)
+		p.SavePeer(storage.PeerFromChat(chat))
 	}
 }
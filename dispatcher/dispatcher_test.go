@@ -0,0 +1,417 @@
+package dispatcher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	mtp_errors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/metrics"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+var (
+	alice = gotgprototest.User(10, "Alice")
+	group = gotgprototest.Channel(100, "Group", true)
+)
+
+func newClient(d *dispatcher.NativeDispatcher, p *storage.PeerStorage) *gotgprototest.Client {
+	c := gotgprototest.NewClient(&gotgprototest.ClientOpts{Dispatcher: d, PeerStorage: p})
+	c.AddUsers(alice)
+	c.AddChats(group)
+	return c
+}
+
+func textUpdate(id int, text string) tg.UpdateClass {
+	return gotgprototest.NewMessage(gotgprototest.TextMessage(id, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, text))
+}
+
+// record returns a handler which records its name and returns the provided error.
+func record(calls *[]string, name string, err error) dispatcher.Handler {
+	return handlers.NewAnyUpdate(func(*ext.Context, *ext.Update) error {
+		*calls = append(*calls, name)
+		return err
+	})
+}
+
+func TestDispatcherGroups(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "continue", err: nil, want: "a1 a2 b1"},
+		{name: "continue groups", err: dispatcher.ContinueGroups, want: "a1 a2 b1"},
+		{name: "end groups", err: dispatcher.EndGroups, want: "a1"},
+		{name: "skip current group", err: dispatcher.SkipCurrentGroup, want: "a1 b1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			c := newClient(nil, nil)
+			c.Dispatcher.AddHandlerToGroup(record(&calls, "b1", nil), 2)
+			c.Dispatcher.AddHandlerToGroup(record(&calls, "a1", tt.err), 1)
+			c.Dispatcher.AddHandlerToGroup(record(&calls, "a2", nil), 1)
+			_ = c.Handle(textUpdate(1, "hello"))
+			if got := strings.Join(calls, " "); got != tt.want {
+				t.Fatalf("got calls %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatcherAddHandlerWhileHandling(t *testing.T) {
+	c := newClient(nil, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(group int) {
+			defer wg.Done()
+			c.Dispatcher.AddHandlerToGroup(handlers.NewAnyUpdate(func(*ext.Context, *ext.Update) error { return nil }), group)
+		}(i)
+		go func(id int) {
+			defer wg.Done()
+			_ = c.Handle(textUpdate(id, "hello"))
+		}(i + 1)
+	}
+	wg.Wait()
+}
+
+func TestDispatcherStopClient(t *testing.T) {
+	c := newClient(nil, nil)
+	c.Dispatcher.AddHandler(handlers.NewAnyUpdate(func(*ext.Context, *ext.Update) error {
+		return dispatcher.StopClient
+	}))
+	if err := c.Handle(textUpdate(1, "stop")); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Stopped() {
+		t.Fatal("expected client to be stopped")
+	}
+}
+
+func TestDispatcherErrorHandler(t *testing.T) {
+	var got error
+	p, _ := storage.NewPeerStorage(nil, true)
+	d := dispatcher.NewNativeDispatcher(false, false, func(_ *ext.Context, _ *ext.Update, err error) error {
+		got = err
+		return dispatcher.EndGroups
+	}, nil, p)
+	c := newClient(d, p)
+	var calls []string
+	forbidden := fmt.Errorf("send reply: %w", tgerr.New(403, "CHAT_WRITE_FORBIDDEN"))
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "a", forbidden), 0)
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "b", nil), 1)
+	_ = c.Handle(textUpdate(1, "hello"))
+	if got != forbidden || !mtp_errors.IsWriteForbidden(got) {
+		t.Fatalf("error handler got %v", got)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected the error handler to end groups, got calls %v", calls)
+	}
+}
+
+func TestDispatcherStringErrorHandler(t *testing.T) {
+	var got string
+	p, _ := storage.NewPeerStorage(nil, true)
+	d := dispatcher.NewNativeDispatcher(false, false, dispatcher.StringErrorHandler(func(_ *ext.Context, _ *ext.Update, err string) error {
+		got = err
+		return dispatcher.ContinueGroups
+	}), nil, p)
+	c := newClient(d, p)
+	var calls []string
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "a", errors.New("boom")), 0)
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "b", nil), 1)
+	_ = c.Handle(textUpdate(1, "hello"))
+	if got != "boom" {
+		t.Fatalf("error handler got %q", got)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("expected the error handler to continue groups, got calls %v", calls)
+	}
+}
+
+func TestDispatcherPanicHandler(t *testing.T) {
+	var (
+		recovered any
+		stack     []byte
+		legacy    string
+	)
+	p, _ := storage.NewPeerStorage(nil, true)
+	d := dispatcher.NewNativeDispatcher(false, false, nil, func(ctx *ext.Context, u *ext.Update, r any, s []byte) {
+		recovered, stack = r, s
+		dispatcher.StringPanicHandler(func(_ *ext.Context, _ *ext.Update, s string) { legacy = s })(ctx, u, r, s)
+	}, p)
+	c := newClient(d, p)
+	c.Dispatcher.AddHandler(handlers.NewAnyUpdate(func(*ext.Context, *ext.Update) error {
+		panic("handler panicked")
+	}))
+	_ = c.Handle(textUpdate(1, "hello"))
+	if recovered != "handler panicked" {
+		t.Fatalf("unexpected recovered value %v", recovered)
+	}
+	if !strings.Contains(string(stack), "dispatcher_test.go") {
+		t.Fatalf("unexpected stack %q", stack)
+	}
+	if !strings.HasPrefix(legacy, "handler panicked\n") {
+		t.Fatalf("unexpected legacy panic message %q", legacy)
+	}
+}
+
+func TestDispatcherEntities(t *testing.T) {
+	c := newClient(nil, nil)
+	var (
+		user *tg.User
+		chat *tg.Channel
+	)
+	c.Dispatcher.AddHandler(handlers.NewAnyUpdate(func(_ *ext.Context, u *ext.Update) error {
+		user = u.EffectiveUser()
+		chat = u.GetChannel()
+		return nil
+	}))
+	_ = c.Handle(textUpdate(1, "hello"))
+	if user == nil || user.ID != alice.ID {
+		t.Fatalf("unexpected effective user %v", user)
+	}
+	if chat == nil || chat.ID != group.ID {
+		t.Fatalf("unexpected channel %v", chat)
+	}
+}
+
+func TestDispatcherConcurrency(t *testing.T) {
+	p, _ := storage.NewPeerStorage(nil, true)
+	d := dispatcher.NewNativeDispatcher(false, false, nil, nil, p)
+	d.Concurrency = &dispatcher.ConcurrencyOpts{Workers: 4}
+	c := newClient(d, p)
+	defer c.Stop()
+	const n = 50
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		ids  []int
+	)
+	wg.Add(n)
+	c.Dispatcher.AddHandler(handlers.NewAnyUpdate(func(_ *ext.Context, u *ext.Update) error {
+		defer wg.Done()
+		lock.Lock()
+		ids = append(ids, u.EffectiveMessage.ID)
+		lock.Unlock()
+		return nil
+	}))
+	for i := 1; i <= n; i++ {
+		if err := c.Handle(textUpdate(i, "hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	// Updates of the same chat are processed by the same worker in order.
+	for i, id := range ids {
+		if id != i+1 {
+			t.Fatalf("updates of the same chat were processed out of order: %v", ids)
+		}
+	}
+}
+
+// recorder is a metrics.Recorder recording the updates, the handlers and the panics.
+type recorder struct {
+	metrics.Nop
+	lock     sync.Mutex
+	updates  []string
+	handlers []string
+	panics   []int
+}
+
+func (r *recorder) Update(_ context.Context, updateType string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.updates = append(r.updates, updateType)
+}
+
+func (r *recorder) Handler(_ context.Context, group int, _ time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.handlers = append(r.handlers, fmt.Sprintf("%d:%v", group, err))
+}
+
+func (r *recorder) Panic(_ context.Context, group int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.panics = append(r.panics, group)
+}
+
+func TestDispatcherMetrics(t *testing.T) {
+	p, _ := storage.NewPeerStorage(nil, true)
+	d := dispatcher.NewNativeDispatcher(false, false, nil, func(*ext.Context, *ext.Update, any, []byte) {}, p)
+	rec := &recorder{}
+	d.Metrics = rec
+	c := newClient(d, p)
+	var calls []string
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "a", dispatcher.ContinueGroups), 1)
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "b", errors.New("failed")), 2)
+	c.Dispatcher.AddHandlerToGroup(handlers.NewAnyUpdate(func(*ext.Context, *ext.Update) error {
+		panic("handler panicked")
+	}), 3)
+	_ = c.Handle(textUpdate(1, "hello"))
+	if len(rec.updates) != 1 || rec.updates[0] != "updateNewChannelMessage" {
+		t.Fatalf("unexpected updates %v", rec.updates)
+	}
+	if got := strings.Join(rec.handlers, " "); got != "1:<nil> 2:failed" {
+		t.Fatalf("unexpected handlers %q", got)
+	}
+	if len(rec.panics) != 1 || rec.panics[0] != 3 {
+		t.Fatalf("unexpected panics %v", rec.panics)
+	}
+}
+
+// trace returns a middleware which records its name before calling next.
+func trace(calls *[]string, name string) dispatcher.Middleware {
+	return func(next handlers.CallbackResponse) handlers.CallbackResponse {
+		return func(ctx *ext.Context, u *ext.Update) error {
+			*calls = append(*calls, name)
+			return next(ctx, u)
+		}
+	}
+}
+
+func TestDispatcherMiddleware(t *testing.T) {
+	var calls []string
+	c := newClient(nil, nil)
+	c.Dispatcher.Use(trace(&calls, "global"))
+	c.Dispatcher.UseInGroup(1, trace(&calls, "group1"))
+	// The middlewares aren't executed for handlers which don't handle the update.
+	c.Dispatcher.AddHandlerToGroup(handlers.NewCommand("start", func(*ext.Context, *ext.Update) error {
+		calls = append(calls, "start")
+		return nil
+	}), 1)
+	c.Dispatcher.AddHandlerToGroup(dispatcher.WithMiddleware(record(&calls, "a", nil), trace(&calls, "handler")), 1)
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "b", nil), 2)
+	_ = c.Handle(textUpdate(1, "hello"))
+	if got := strings.Join(calls, " "); got != "global group1 handler a global b" {
+		t.Fatalf("unexpected calls %q", got)
+	}
+}
+
+// customHandler is a handler which doesn't run its callback through ext.Context.RunCallback.
+type customHandler struct {
+	calls *[]string
+}
+
+func (h customHandler) CheckUpdate(*ext.Context, *ext.Update) error {
+	*h.calls = append(*h.calls, "custom")
+	return nil
+}
+
+func TestDispatcherMiddlewareCustomHandler(t *testing.T) {
+	var calls []string
+	c := newClient(nil, nil)
+	c.Dispatcher.Use(trace(&calls, "global"))
+	c.Dispatcher.AddHandler(customHandler{calls: &calls})
+	c.Dispatcher.AddHandlerToGroup(dispatcher.WithMiddleware(customHandler{calls: &calls}, trace(&calls, "handler")), 1)
+	_ = c.Handle(textUpdate(1, "hello"))
+	if got := strings.Join(calls, " "); got != "global custom global handler custom" {
+		t.Fatalf("unexpected calls %q", got)
+	}
+}
+
+func TestDispatcherMiddlewareShortCircuit(t *testing.T) {
+	var calls []string
+	c := newClient(nil, nil)
+	c.Dispatcher.UseInGroup(1, func(next handlers.CallbackResponse) handlers.CallbackResponse {
+		return func(ctx *ext.Context, u *ext.Update) error {
+			if u.EffectiveMessage.Text != "admin" {
+				return dispatcher.EndGroups
+			}
+			return next(ctx, u)
+		}
+	})
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "a", nil), 1)
+	c.Dispatcher.AddHandlerToGroup(record(&calls, "b", nil), 2)
+	_ = c.Handle(textUpdate(1, "hello"))
+	if len(calls) != 0 {
+		t.Fatalf("expected the middleware to end groups, got calls %v", calls)
+	}
+	_ = c.Handle(textUpdate(2, "admin"))
+	if got := strings.Join(calls, " "); got != "a b" {
+		t.Fatalf("unexpected calls %q", got)
+	}
+}
+
+func TestDispatcherShortMessage(t *testing.T) {
+	c := newClient(nil, nil)
+	c.Invoker.Reply(&tg.UsersGetUsersRequest{}, &tg.UserClassVector{Elems: []tg.UserClass{alice}})
+	var (
+		user  *tg.User
+		texts []string
+	)
+	c.Dispatcher.AddHandler(handlers.NewCommand("start", func(ctx *ext.Context, u *ext.Update) error {
+		user = u.EffectiveUser()
+		_, err := ctx.Reply(u, ext.ReplyTextString("hi"), nil)
+		return err
+	}))
+	c.Dispatcher.AddHandlerToGroup(handlers.NewAnyUpdate(func(_ *ext.Context, u *ext.Update) error {
+		texts = append(texts, u.EffectiveMessage.Text)
+		return nil
+	}), 1)
+	err := c.HandleUpdates(&tg.UpdateShortMessage{ID: 1, UserID: alice.ID, Message: "/start", Date: 1, Pts: 1, PtsCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.ID != alice.ID {
+		t.Fatalf("unexpected effective user %v", user)
+	}
+	reply := gotgprototest.ExpectRequest[*tg.MessagesSendMessageRequest](t, c.Invoker)
+	if peer, ok := reply.Peer.(*tg.InputPeerUser); !ok || peer.UserID != alice.ID {
+		t.Fatalf("unexpected reply peer %v", reply.Peer)
+	}
+	// The sender of outgoing messages is the client itself.
+	if err := c.HandleUpdates(&tg.UpdateShortMessage{ID: 2, Out: true, UserID: alice.ID, Message: "sent", Date: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(texts, " "); got != "/start sent" {
+		t.Fatalf("unexpected messages %q", got)
+	}
+}
+
+func TestDispatcherShortChatMessage(t *testing.T) {
+	c := newClient(nil, nil)
+	bob := gotgprototest.User(11, "Bob")
+	chat := gotgprototest.Chat(200, "Chat")
+	c.Invoker.Reply(&tg.MessagesGetChatsRequest{}, &tg.MessagesChats{Chats: []tg.ChatClass{chat}})
+	c.Invoker.Reply(&tg.MessagesGetMessagesRequest{}, &tg.MessagesMessages{Users: []tg.UserClass{bob}})
+	var (
+		sender *tg.User
+		msg    *tg.Message
+	)
+	c.Dispatcher.AddHandler(handlers.NewAnyUpdate(func(_ *ext.Context, u *ext.Update) error {
+		sender = u.EffectiveUser()
+		msg = u.EffectiveMessage.Message
+		return nil
+	}))
+	err := c.HandleUpdates(&tg.UpdateShortChatMessage{ID: 5, FromID: bob.ID, ChatID: chat.ID, Message: "hello", Date: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer, ok := msg.PeerID.(*tg.PeerChat); !ok || peer.ChatID != chat.ID {
+		t.Fatalf("unexpected message peer %v", msg.PeerID)
+	}
+	// Bob isn't in the peer storage, so he is fetched along with the message.
+	if sender == nil || sender.ID != bob.ID {
+		t.Fatalf("unexpected effective user %v", sender)
+	}
+	if req := gotgprototest.ExpectRequest[*tg.MessagesGetMessagesRequest](t, c.Invoker); len(req.ID) != 1 {
+		t.Fatalf("unexpected request %v", req)
+	}
+	if peer := c.PeerStorage.GetPeerById(bob.ID); peer.AccessHash != bob.AccessHash {
+		t.Fatalf("expected bob to be saved, got %v", peer)
+	}
+}
@@ -12,6 +12,15 @@ type Handler interface {
 	CheckUpdate(*ext.Context, *ext.Update) error
 }
 
+// CallbackRunner is implemented by the handlers which run their callbacks through ext.Context.RunCallback,
+// like all the handlers of the handlers package. Middlewares wrap only the callbacks of such handlers,
+// so that they are executed once the handler decided to handle the update, and the whole CheckUpdate of the others.
+type CallbackRunner interface {
+	Handler
+	// RunsCallback marks the handler, it is never called.
+	RunsCallback()
+}
+
 // AddHandler adds a new handler to the dispatcher. The dispatcher will call CheckUpdate() to see whether the handler
 // should be executed, and then execute it.
 func (dp *NativeDispatcher) AddHandler(h Handler) {
@@ -19,7 +28,10 @@ func (dp *NativeDispatcher) AddHandler(h Handler) {
 }
 
 // AddHandlerToGroup adds a handler to a specific group; lowest number will be processed first.
+// It is safe to add handlers while the dispatcher is handling updates.
 func (dp *NativeDispatcher) AddHandlerToGroup(h Handler, group int) {
+	dp.handlersLock.Lock()
+	defer dp.handlersLock.Unlock()
 	handlers, ok := dp.handlerMap[group]
 	if !ok {
 		dp.handlerGroups = append(dp.handlerGroups, group)
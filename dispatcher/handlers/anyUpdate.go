@@ -14,6 +14,9 @@ func NewAnyUpdate(response CallbackResponse) AnyUpdate {
 	return AnyUpdate{Callback: response}
 }
 
+// RunsCallback implements dispatcher.CallbackRunner.
+func (AnyUpdate) RunsCallback() {}
+
 func (au AnyUpdate) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
-	return au.Callback(ctx, u)
+	return ctx.RunCallback(au.Callback, u)
 }
@@ -21,6 +21,9 @@ func NewCallbackQuery(filters filters.CallbackQueryFilter, response CallbackResp
 	}
 }
 
+// RunsCallback implements dispatcher.CallbackRunner.
+func (CallbackQuery) RunsCallback() {}
+
 func (c CallbackQuery) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if u.CallbackQuery == nil {
 		return nil
@@ -31,5 +34,5 @@ func (c CallbackQuery) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if c.UpdateFilters != nil && !c.UpdateFilters(u) {
 		return nil
 	}
-	return c.Callback(ctx, u)
+	return ctx.RunCallback(c.Callback, u)
 }
@@ -19,6 +19,9 @@ func NewChatMemberUpdated(filters filters.ChatMemberUpdatedFilter, response Call
 	}
 }
 
+// RunsCallback implements dispatcher.CallbackRunner.
+func (ChatMemberUpdated) RunsCallback() {}
+
 func (cm ChatMemberUpdated) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if u.ChatParticipant == nil && u.ChannelParticipant == nil {
 		return nil
@@ -26,5 +29,5 @@ func (cm ChatMemberUpdated) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if cm.Filters != nil && !cm.Filters(u) {
 		return nil
 	}
-	return cm.Callback(ctx, u)
+	return ctx.RunCallback(cm.Callback, u)
 }
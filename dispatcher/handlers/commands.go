@@ -29,6 +29,9 @@ func NewCommand(name string, response CallbackResponse) Command {
 	}
 }
 
+// RunsCallback implements dispatcher.CallbackRunner.
+func (Command) RunsCallback() {}
+
 func (c Command) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	m := u.EffectiveMessage
 	if m == nil || m.Text == "" {
@@ -46,10 +49,10 @@ func (c Command) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	for _, prefix := range c.Prefix {
 		if arg[0] == byte(prefix) {
 			if arg[1:] == c.Name {
-				return c.Callback(ctx, u)
+				return ctx.RunCallback(c.Callback, u)
 			} else if split := strings.Split(arg[1:], "@"); split[0] == c.Name {
 				if split[1] == strings.ToLower(ctx.Self.Username) {
-					return c.Callback(ctx, u)
+					return ctx.RunCallback(c.Callback, u)
 				}
 			}
 		}
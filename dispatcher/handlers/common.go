@@ -5,4 +5,5 @@ import (
 )
 
 // CallbackResponse is the function which will be called on a handler's execution.
-type CallbackResponse func(*ext.Context, *ext.Update) error
+// It is an alias so that middlewares written with it can be passed to dispatcher.Middleware.
+type CallbackResponse = func(*ext.Context, *ext.Update) error
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers/conversation"
+	"github.com/celestix/gotgproto/ext"
+)
+
+// Conversation handler is used to build multi-step flows, where every step (state) has its own handlers.
+//
+// Handlers of a conversation move it to another state by returning NextConversationState, finish it by
+// returning EndConversation and keep it in its current state by returning StayInConversation through their callback functions.
+// A handler is considered to have responded to an update only if it returns one of them or any other error,
+// since handlers also return nil when their filters don't match the update.
+type Conversation struct {
+	// Name is used to prefix the conversation keys, it must be unique if multiple conversations share a storage.
+	Name string
+	// EntryPoints are the handlers which can start a new conversation.
+	EntryPoints []dispatcher.Handler
+	// States maps the name of every state to the handlers which are checked while the conversation is in that state.
+	States map[string][]dispatcher.Handler
+	// Fallbacks are checked if none of the handlers of the current state responded to the update.
+	Fallbacks []dispatcher.Handler
+	// AllowReEntry allows the entry points to restart an already running conversation.
+	// The handlers of the current state are checked if none of the entry points responded to the update.
+	AllowReEntry bool
+	// Timeout ends a conversation if it wasn't moved to another state within the provided duration.
+	// Timeouts are disabled if it is set to 0.
+	Timeout time.Duration
+	// KeyStrategy decides which updates belong to the same conversation.
+	KeyStrategy conversation.KeyStrategy
+	// Storage saves the states of running conversations.
+	Storage conversation.Storage
+}
+
+// ConversationOpts object contains optional parameters for NewConversation.
+type ConversationOpts struct {
+	// Name is used to prefix the conversation keys, it must be unique if multiple conversations share a storage.
+	Name string
+	// Fallbacks are checked if none of the handlers of the current state responded to the update.
+	Fallbacks []dispatcher.Handler
+	// AllowReEntry allows the entry points to restart an already running conversation.
+	AllowReEntry bool
+	// Timeout ends a conversation if it wasn't moved to another state within the provided duration.
+	Timeout time.Duration
+	// KeyStrategy decides which updates belong to the same conversation.
+	// conversation.KeyStrategySenderAndChat is used by default.
+	KeyStrategy conversation.KeyStrategy
+	// Storage saves the states of running conversations.
+	// conversation.NewInMemoryStorage is used by default.
+	Storage conversation.Storage
+}
+
+// NewConversation creates a new Conversation handler with provided entry points and states.
+func NewConversation(entryPoints []dispatcher.Handler, states map[string][]dispatcher.Handler, opts *ConversationOpts) Conversation {
+	if opts == nil {
+		opts = &ConversationOpts{}
+	}
+	if opts.Storage == nil {
+		opts.Storage = conversation.NewInMemoryStorage()
+	}
+	return Conversation{
+		Name:         opts.Name,
+		EntryPoints:  entryPoints,
+		States:       states,
+		Fallbacks:    opts.Fallbacks,
+		AllowReEntry: opts.AllowReEntry,
+		Timeout:      opts.Timeout,
+		KeyStrategy:  opts.KeyStrategy,
+		Storage:      opts.Storage,
+	}
+}
+
+// ConversationStateChange is returned through a handler callback function to change the state of a conversation.
+// The zero value keeps the conversation in its current state.
+type ConversationStateChange struct {
+	// NextState is the name of the state to move the conversation to.
+	NextState string
+	// End finishes the conversation.
+	End bool
+}
+
+func (c *ConversationStateChange) Error() string {
+	if c.End {
+		return "conversation ended"
+	}
+	if c.NextState == "" {
+		return "conversation stayed in its state"
+	}
+	return "conversation moved to state " + c.NextState
+}
+
+// NextConversationState moves the conversation to the provided state if returned through a handler callback function.
+func NextConversationState(name string) error {
+	return &ConversationStateChange{NextState: name}
+}
+
+// StayInConversation keeps the conversation in its current state if returned through a handler callback function.
+// It reports that the handler responded to the update, so that the fallbacks are not checked.
+func StayInConversation() error {
+	return &ConversationStateChange{}
+}
+
+// EndConversation finishes the conversation if returned through a handler callback function.
+func EndConversation() error {
+	return &ConversationStateChange{End: true}
+}
+
+// RunsCallback implements dispatcher.CallbackRunner.
+func (Conversation) RunsCallback() {}
+
+func (c Conversation) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
+	if c.Storage == nil {
+		return errors.New("conversation storage is nil")
+	}
+	key, err := c.KeyStrategy.Key(u)
+	if err != nil {
+		return nil
+	}
+	if c.Name != "" {
+		key = c.Name + ":" + key
+	}
+	state, err := c.getState(key)
+	if err != nil {
+		return err
+	}
+	if state == nil || c.AllowReEntry {
+		handled, err := c.checkHandlers(key, c.EntryPoints, ctx, u)
+		if handled || state == nil {
+			return err
+		}
+	}
+	handled, err := c.checkHandlers(key, c.States[state.Key], ctx, u)
+	if handled {
+		return err
+	}
+	_, err = c.checkHandlers(key, c.Fallbacks, ctx, u)
+	return err
+}
+
+func (c Conversation) getState(key string) (*conversation.State, error) {
+	state, err := c.Storage.Get(key)
+	if err != nil {
+		if errors.Is(err, conversation.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if c.Timeout > 0 && time.Since(state.UpdatedAt) > c.Timeout {
+		return nil, c.Storage.Delete(key)
+	}
+	return state, nil
+}
+
+// checkHandlers runs the provided handlers till one of them returns an error,
+// it reports whether any handler responded to the update, see Conversation.
+func (c Conversation) checkHandlers(key string, handlers []dispatcher.Handler, ctx *ext.Context, u *ext.Update) (bool, error) {
+	for _, handler := range handlers {
+		err := dispatcher.RunHandler(ctx, u, handler)
+		if err == nil {
+			continue
+		}
+		var change *ConversationStateChange
+		if errors.As(err, &change) {
+			return true, c.changeState(key, change)
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+func (c Conversation) changeState(key string, change *ConversationStateChange) error {
+	if change.End {
+		return c.Storage.Delete(key)
+	}
+	if change.NextState == "" {
+		return nil
+	}
+	if _, ok := c.States[change.NextState]; !ok {
+		return fmt.Errorf("conversation state %q does not exist", change.NextState)
+	}
+	return c.Storage.Set(key, conversation.State{
+		Key:       change.NextState,
+		UpdatedAt: time.Now(),
+	})
+}
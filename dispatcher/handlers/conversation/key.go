@@ -0,0 +1,45 @@
+package conversation
+
+import (
+	"errors"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+// ErrEmptyKey is returned when a conversation key can't be built for an update.
+var ErrEmptyKey = errors.New("conversation key is empty")
+
+// KeyStrategy decides which updates belong to the same conversation.
+type KeyStrategy int
+
+const (
+	// KeyStrategySenderAndChat keeps a separate conversation for every sender in every chat.
+	KeyStrategySenderAndChat KeyStrategy = iota
+	// KeyStrategySender keeps a single conversation for a sender across all chats.
+	KeyStrategySender
+	// KeyStrategyChat keeps a single conversation for all users of a chat.
+	KeyStrategyChat
+)
+
+// Key returns the conversation key of the provided update according to the strategy.
+// Keys are built from the Bot API-style marked IDs of the sender and the chat, so that peers of different types never share a conversation.
+func (k KeyStrategy) Key(u *ext.Update) (string, error) {
+	userId, chatId := u.SenderID(), u.ChatID()
+	switch k {
+	case KeyStrategySender:
+		if userId == 0 {
+			return "", ErrEmptyKey
+		}
+		return userId.String(), nil
+	case KeyStrategyChat:
+		if chatId == 0 {
+			return "", ErrEmptyKey
+		}
+		return chatId.String(), nil
+	default:
+		if userId == 0 || chatId == 0 {
+			return "", ErrEmptyKey
+		}
+		return userId.String() + ":" + chatId.String(), nil
+	}
+}
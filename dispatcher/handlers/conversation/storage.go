@@ -0,0 +1,109 @@
+package conversation
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/storage"
+)
+
+// ErrKeyNotFound is returned by a Storage if no conversation is running for the provided key.
+var ErrKeyNotFound = errors.New("conversation key not found")
+
+// State is the current state of a running conversation.
+type State struct {
+	// Key is the name of the current state.
+	Key string
+	// UpdatedAt is the time of the last state change.
+	UpdatedAt time.Time
+}
+
+// Storage is the interface used by the conversation handler to save states of running conversations.
+type Storage interface {
+	// Get returns the state of the provided conversation key, ErrKeyNotFound if it doesn't exist.
+	Get(key string) (*State, error)
+	// Set saves the state of the provided conversation key.
+	Set(key string, state State) error
+	// Delete removes the provided conversation key.
+	Delete(key string) error
+}
+
+// InMemoryStorage is a Storage which keeps conversation states in a map.
+//
+// Note: States are lost once the client is restarted.
+type InMemoryStorage struct {
+	states map[string]State
+	lock   *sync.RWMutex
+}
+
+// NewInMemoryStorage creates a new InMemoryStorage.
+func NewInMemoryStorage() *InMemoryStorage {
+	return &InMemoryStorage{
+		states: make(map[string]State),
+		lock:   new(sync.RWMutex),
+	}
+}
+
+func (s *InMemoryStorage) Get(key string) (*State, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &state, nil
+}
+
+func (s *InMemoryStorage) Set(key string, state State) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.states[key] = state
+	return nil
+}
+
+func (s *InMemoryStorage) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.states, key)
+	return nil
+}
+
+// DatabaseStorage is a Storage which saves conversation states in the database of storage.PeerStorage,
+// so that running conversations survive restarts.
+//
+// Note: It can't be used with an in-memory storage.PeerStorage.
+type DatabaseStorage struct {
+	peerStorage *storage.PeerStorage
+}
+
+// NewDatabaseStorage creates a new DatabaseStorage using the provided peer storage.
+func NewDatabaseStorage(p *storage.PeerStorage) *DatabaseStorage {
+	return &DatabaseStorage{peerStorage: p}
+}
+
+func (s *DatabaseStorage) Get(key string) (*State, error) {
+	state, err := s.peerStorage.GetConversationState(key)
+	if err != nil {
+		return nil, err
+	}
+	if state.Key == "" {
+		return nil, ErrKeyNotFound
+	}
+	return &State{
+		Key:       state.State,
+		UpdatedAt: state.UpdatedAt,
+	}, nil
+}
+
+func (s *DatabaseStorage) Set(key string, state State) error {
+	return s.peerStorage.SetConversationState(&storage.ConversationState{
+		Key:       key,
+		State:     state.Key,
+		UpdatedAt: state.UpdatedAt,
+	})
+}
+
+func (s *DatabaseStorage) Delete(key string) error {
+	return s.peerStorage.DeleteConversationState(key)
+}
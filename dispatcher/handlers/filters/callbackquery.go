@@ -22,7 +22,7 @@ func (*callbackQueryFilters) Prefix(prefix string) CallbackQueryFilter {
 // Suffix returns true if the tg.UpdateBotCallbackQuery's Data field contains provided suffix.
 func (*callbackQueryFilters) Suffix(suffix string) CallbackQueryFilter {
 	return func(cbq *tg.UpdateBotCallbackQuery) bool {
-		return strings.HasPrefix(string(cbq.Data), suffix)
+		return strings.HasSuffix(string(cbq.Data), suffix)
 	}
 }
 
@@ -0,0 +1,117 @@
+package filters_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+func document(attrs ...tg.DocumentAttributeClass) tg.MessageMediaClass {
+	return &tg.MessageMediaDocument{Document: &tg.Document{ID: 1, Attributes: attrs}}
+}
+
+func TestMessageFilters(t *testing.T) {
+	text := types.ConstructMessage(gotgprototest.TextMessage(1, nil, &tg.PeerUser{UserID: 10}, "hello world"))
+	photo := types.ConstructMessage(&tg.Message{PeerID: &tg.PeerChat{ChatID: 5}, Media: &tg.MessageMediaPhoto{}})
+	video := types.ConstructMessage(&tg.Message{PeerID: &tg.PeerChannel{ChannelID: 7}, Media: document(&tg.DocumentAttributeVideo{})})
+	sticker := types.ConstructMessage(&tg.Message{PeerID: &tg.PeerChannel{ChannelID: 7}, Media: document(&tg.DocumentAttributeSticker{})})
+	edited := types.ConstructMessage(&tg.Message{PeerID: &tg.PeerUser{UserID: 10}, Message: "x", EditDate: 1})
+	regex, err := filters.Message.Regex(`^hello\s`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter filters.MessageFilter
+		match  []*types.Message
+		miss   []*types.Message
+	}{
+		{name: "text", filter: filters.Message.Text, match: []*types.Message{text, edited}, miss: []*types.Message{photo}},
+		{name: "regex", filter: regex, match: []*types.Message{text}, miss: []*types.Message{edited}},
+		{name: "media", filter: filters.Message.Media, match: []*types.Message{photo, video}, miss: []*types.Message{text}},
+		{name: "photo", filter: filters.Message.Photo, match: []*types.Message{photo}, miss: []*types.Message{video}},
+		{name: "video", filter: filters.Message.Video, match: []*types.Message{video}, miss: []*types.Message{sticker, photo}},
+		{name: "sticker", filter: filters.Message.Sticker, match: []*types.Message{sticker}, miss: []*types.Message{video}},
+		{name: "edited", filter: filters.Message.Edited, match: []*types.Message{edited}, miss: []*types.Message{text}},
+		{name: "user chat", filter: filters.Message.ChatType(filters.ChatTypeUser), match: []*types.Message{text}, miss: []*types.Message{photo, video}},
+		{name: "chat", filter: filters.Message.ChatType(filters.ChatTypeChat), match: []*types.Message{photo}, miss: []*types.Message{text, video}},
+		{name: "channel", filter: filters.Message.ChatType(filters.ChatTypeChannel), match: []*types.Message{video}, miss: []*types.Message{text, photo}},
+		{name: "chat id", filter: filters.Message.Chat(7), match: []*types.Message{video, sticker}, miss: []*types.Message{text}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, m := range tt.match {
+				if !tt.filter(m) {
+					t.Errorf("filter didn't match %v", m.Message)
+				}
+			}
+			for _, m := range tt.miss {
+				if tt.filter(m) {
+					t.Errorf("filter matched %v", m.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestChatTypeFilters(t *testing.T) {
+	supergroup := gotgprototest.Channel(100, "Supergroup", true)
+	channel := gotgprototest.Channel(101, "Channel", false)
+	group := gotgprototest.Chat(102, "Group")
+	e := &tg.Entities{
+		Chats:    map[int64]*tg.Chat{group.ID: group},
+		Channels: map[int64]*tg.Channel{supergroup.ID: supergroup, channel.ID: channel},
+	}
+	update := func(peer tg.PeerClass) *ext.Update {
+		return ext.GetNewUpdate(context.Background(), nil, 1, e, gotgprototest.NewMessage(gotgprototest.TextMessage(1, nil, peer, "hi")))
+	}
+
+	tests := []struct {
+		name   string
+		update *ext.Update
+		want   [3]bool
+	}{
+		{name: "supergroup", update: update(&tg.PeerChannel{ChannelID: supergroup.ID}), want: [3]bool{true, false, false}},
+		{name: "channel", update: update(&tg.PeerChannel{ChannelID: channel.ID}), want: [3]bool{false, true, false}},
+		{name: "group", update: update(&tg.PeerChat{ChatID: group.ID}), want: [3]bool{false, false, true}},
+		{name: "private", update: update(&tg.PeerUser{UserID: 10}), want: [3]bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := [3]bool{filters.Supergroup(tt.update), filters.Channel(tt.update), filters.Group(tt.update)}
+			if got != tt.want {
+				t.Fatalf("got [supergroup channel group] %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbackQueryFilters(t *testing.T) {
+	cbq := gotgprototest.CallbackQuery(1, 10, &tg.PeerUser{UserID: 10}, 1, "page_2")
+	tests := []struct {
+		name   string
+		filter filters.CallbackQueryFilter
+		want   bool
+	}{
+		{name: "prefix", filter: filters.CallbackQuery.Prefix("page_"), want: true},
+		{name: "wrong prefix", filter: filters.CallbackQuery.Prefix("vote_"), want: false},
+		{name: "suffix", filter: filters.CallbackQuery.Suffix("_2"), want: true},
+		{name: "prefix as suffix", filter: filters.CallbackQuery.Suffix("page_"), want: false},
+		{name: "equal", filter: filters.CallbackQuery.Equal("page_2"), want: true},
+		{name: "from user", filter: filters.CallbackQuery.FromUserId(10), want: true},
+		{name: "from other user", filter: filters.CallbackQuery.FromUserId(11), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter(cbq); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package filters
 
 import (
 	"regexp"
+	"strings"
 
 	"github.com/celestix/gotgproto/functions"
 	"github.com/celestix/gotgproto/types"
@@ -53,6 +54,16 @@ func (*messageFilters) Text(m *types.Message) bool {
 	return m.Text != ""
 }
 
+// Command returns true if types.Message starts with a bot command, i.e. /start.
+func (*messageFilters) Command(m *types.Message) bool {
+	for _, e := range m.Entities {
+		if c, ok := e.(*tg.MessageEntityBotCommand); ok && c.Offset == 0 {
+			return true
+		}
+	}
+	return strings.HasPrefix(m.Text, "/")
+}
+
 // Regex returns true if the Message field of types.Message matches the regex filter
 func (*messageFilters) Regex(rString string) (MessageFilter, error) {
 	r, err := regexp.Compile(rString)
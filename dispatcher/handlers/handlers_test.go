@@ -0,0 +1,268 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/conversation"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/gotd/td/tg"
+)
+
+var (
+	alice = gotgprototest.User(10, "Alice")
+	bob   = gotgprototest.User(11, "Bob")
+	group = gotgprototest.Channel(100, "Group", true)
+)
+
+func newClient(hs ...dispatcher.Handler) *gotgprototest.Client {
+	c := gotgprototest.NewClient(nil)
+	c.AddUsers(alice, bob)
+	c.AddChats(group)
+	for _, h := range hs {
+		c.Dispatcher.AddHandler(h)
+	}
+	return c
+}
+
+func message(id int, from *tg.User, text string) *tg.Message {
+	return gotgprototest.TextMessage(id, &tg.PeerUser{UserID: from.ID}, &tg.PeerChannel{ChannelID: group.ID}, text)
+}
+
+// counter returns a callback which counts its calls.
+func counter(n *int) handlers.CallbackResponse {
+	return func(*ext.Context, *ext.Update) error {
+		*n++
+		return nil
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: "/start", want: true},
+		{text: "!start with args", want: true},
+		{text: "/START", want: true},
+		{text: "/start@test_bot", want: true},
+		{text: "/start@other_bot", want: false},
+		{text: "/started", want: false},
+		{text: "start", want: false},
+		{text: ".start", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var n int
+			c := newClient(handlers.NewCommand("start", counter(&n)))
+			_ = c.Handle(gotgprototest.NewMessage(message(1, alice, tt.text)))
+			if (n == 1) != tt.want {
+				t.Fatalf("command handled %d times, want %v", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOutgoing(t *testing.T) {
+	var n int
+	cmd := handlers.NewCommand("start", counter(&n))
+	cmd.Outgoing = false
+	c := newClient(cmd)
+	m := message(1, alice, "/start")
+	m.Out = true
+	_ = c.Handle(gotgprototest.NewMessage(m))
+	if n != 0 {
+		t.Fatal("outgoing command was handled")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	var n int
+	h := handlers.NewMessage(filters.Message.Text, counter(&n))
+	h.UpdateFilters = func(u *ext.Update) bool {
+		return u.EffectiveUser().ID == alice.ID
+	}
+	c := newClient(h)
+	_ = c.Handle(
+		gotgprototest.NewMessage(message(1, alice, "hello")),
+		gotgprototest.NewMessage(message(2, alice, "")),
+		gotgprototest.NewMessage(message(3, bob, "hello")),
+		gotgprototest.EditMessage(message(1, alice, "edited")),
+	)
+	if n != 2 {
+		t.Fatalf("message handled %d times, want 2", n)
+	}
+}
+
+func TestCallbackQuery(t *testing.T) {
+	var data string
+	h := handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("vote_"), func(_ *ext.Context, u *ext.Update) error {
+		data = string(u.CallbackQuery.Data)
+		return nil
+	})
+	c := newClient(h)
+	_ = c.Handle(
+		gotgprototest.CallbackQuery(1, alice.ID, &tg.PeerChannel{ChannelID: group.ID}, 5, "other"),
+		gotgprototest.CallbackQuery(2, alice.ID, &tg.PeerChannel{ChannelID: group.ID}, 5, "vote_yes"),
+	)
+	if data != "vote_yes" {
+		t.Fatalf("unexpected callback data %q", data)
+	}
+}
+
+func TestConversation(t *testing.T) {
+	var names []string
+	conv := handlers.NewConversation(
+		[]dispatcher.Handler{handlers.NewCommand("register", func(*ext.Context, *ext.Update) error {
+			return handlers.NextConversationState("name")
+		})},
+		map[string][]dispatcher.Handler{
+			"name": {handlers.NewMessage(filters.Message.Text, func(_ *ext.Context, u *ext.Update) error {
+				names = append(names, u.EffectiveMessage.Text)
+				return handlers.EndConversation()
+			})},
+		},
+		nil,
+	)
+	c := newClient(conv)
+	_ = c.Handle(
+		gotgprototest.NewMessage(message(1, bob, "not registering")),
+		gotgprototest.NewMessage(message(2, alice, "/register")),
+		gotgprototest.NewMessage(message(3, bob, "Bob")),
+		gotgprototest.NewMessage(message(4, alice, "Alice")),
+		gotgprototest.NewMessage(message(5, alice, "ended")),
+	)
+	if len(names) != 1 || names[0] != "Alice" {
+		t.Fatalf("unexpected names %v", names)
+	}
+}
+
+func TestConversationFallback(t *testing.T) {
+	var cancelled bool
+	conv := handlers.NewConversation(
+		[]dispatcher.Handler{handlers.NewCommand("start", func(*ext.Context, *ext.Update) error {
+			return handlers.NextConversationState("wait")
+		})},
+		map[string][]dispatcher.Handler{
+			"wait": {handlers.NewMessage(filters.Message.Photo, func(*ext.Context, *ext.Update) error {
+				return handlers.EndConversation()
+			})},
+		},
+		&handlers.ConversationOpts{
+			Fallbacks: []dispatcher.Handler{handlers.NewCommand("cancel", func(*ext.Context, *ext.Update) error {
+				cancelled = true
+				return handlers.EndConversation()
+			})},
+		},
+	)
+	c := newClient(conv)
+	_ = c.Handle(
+		gotgprototest.NewMessage(message(1, alice, "/start")),
+		gotgprototest.NewMessage(message(2, alice, "/cancel")),
+	)
+	if !cancelled {
+		t.Fatal("fallback was not handled")
+	}
+}
+
+func TestConversationStay(t *testing.T) {
+	var retries, fallbacks int
+	conv := handlers.NewConversation(
+		[]dispatcher.Handler{handlers.NewCommand("start", func(*ext.Context, *ext.Update) error {
+			return handlers.NextConversationState("age")
+		})},
+		map[string][]dispatcher.Handler{
+			"age": {handlers.NewMessage(filters.Message.Text, func(_ *ext.Context, u *ext.Update) error {
+				if u.EffectiveMessage.Text != "42" {
+					retries++
+					return handlers.StayInConversation()
+				}
+				return handlers.EndConversation()
+			})},
+		},
+		&handlers.ConversationOpts{
+			Fallbacks: []dispatcher.Handler{handlers.NewMessage(filters.Message.All, func(*ext.Context, *ext.Update) error {
+				fallbacks++
+				return handlers.StayInConversation()
+			})},
+		},
+	)
+	c := newClient(conv)
+	_ = c.Handle(
+		gotgprototest.NewMessage(message(1, alice, "/start")),
+		gotgprototest.NewMessage(message(2, alice, "old")),
+		gotgprototest.NewMessage(message(3, alice, "42")),
+	)
+	if retries != 1 || fallbacks != 0 {
+		t.Fatalf("unexpected retries %d and fallbacks %d", retries, fallbacks)
+	}
+	// The conversation ended, so the state handler is not checked anymore.
+	_ = c.Handle(gotgprototest.NewMessage(message(4, alice, "old")))
+	if retries != 1 {
+		t.Fatalf("state handler was checked after the conversation ended")
+	}
+}
+
+func TestConversationReEntry(t *testing.T) {
+	var starts, names int
+	conv := handlers.NewConversation(
+		[]dispatcher.Handler{handlers.NewMessage(filters.Message.Text, func(_ *ext.Context, u *ext.Update) error {
+			if u.EffectiveMessage.Text != "/start" {
+				// Not an entry point for this update.
+				return nil
+			}
+			starts++
+			return handlers.NextConversationState("name")
+		})},
+		map[string][]dispatcher.Handler{
+			"name": {handlers.NewMessage(filters.Message.Text, func(*ext.Context, *ext.Update) error {
+				names++
+				return handlers.StayInConversation()
+			})},
+		},
+		&handlers.ConversationOpts{AllowReEntry: true},
+	)
+	c := newClient(conv)
+	_ = c.Handle(
+		gotgprototest.NewMessage(message(1, alice, "/start")),
+		gotgprototest.NewMessage(message(2, alice, "Alice")),
+		gotgprototest.NewMessage(message(3, alice, "/start")),
+	)
+	if starts != 2 || names != 1 {
+		t.Fatalf("unexpected starts %d and names %d", starts, names)
+	}
+}
+
+func TestConversationMarkedKey(t *testing.T) {
+	var names int
+	conv := handlers.NewConversation(
+		[]dispatcher.Handler{handlers.NewCommand("start", func(*ext.Context, *ext.Update) error {
+			return handlers.NextConversationState("name")
+		})},
+		map[string][]dispatcher.Handler{
+			"name": {handlers.NewMessage(filters.Message.Text, func(*ext.Context, *ext.Update) error {
+				names++
+				return handlers.EndConversation()
+			})},
+		},
+		&handlers.ConversationOpts{KeyStrategy: conversation.KeyStrategyChat},
+	)
+	// A user with the same bare ID as the group.
+	namesake := gotgprototest.User(group.ID, "Namesake")
+	c := newClient(conv)
+	c.AddUsers(namesake)
+	_ = c.Handle(
+		gotgprototest.NewMessage(message(1, alice, "/start")),
+		gotgprototest.NewMessage(gotgprototest.TextMessage(2, nil, &tg.PeerUser{UserID: namesake.ID}, "Namesake")),
+	)
+	if names != 0 {
+		t.Fatal("private chat shared the conversation of the group with the same id")
+	}
+	_ = c.Handle(gotgprototest.NewMessage(message(3, alice, "Alice")))
+	if names != 1 {
+		t.Fatalf("unexpected names %d", names)
+	}
+}
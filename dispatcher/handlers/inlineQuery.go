@@ -21,6 +21,9 @@ func NewInlineQuery(filters filters.InlineQueryFilter, response CallbackResponse
 	}
 }
 
+// RunsCallback implements dispatcher.CallbackRunner.
+func (InlineQuery) RunsCallback() {}
+
 func (c InlineQuery) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if u.InlineQuery == nil {
 		return nil
@@ -31,5 +34,5 @@ func (c InlineQuery) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if c.UpdateFilters != nil && !c.UpdateFilters(u) {
 		return nil
 	}
-	return c.Callback(ctx, u)
+	return ctx.RunCallback(c.Callback, u)
 }
@@ -23,6 +23,9 @@ func NewMessage(filters filters.MessageFilter, response CallbackResponse) Messag
 	}
 }
 
+// RunsCallback implements dispatcher.CallbackRunner.
+func (Message) RunsCallback() {}
+
 func (m Message) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	msg := u.EffectiveMessage
 	if msg == nil {
@@ -37,5 +40,5 @@ func (m Message) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if m.UpdateFilters != nil && !m.UpdateFilters(u) {
 		return nil
 	}
-	return m.Callback(ctx, u)
+	return ctx.RunCallback(m.Callback, u)
 }
@@ -16,6 +16,9 @@ func NewChatJoinRequest(filters filters.PendingJoinRequestsFilter, response Call
 	return PendingJoinRequests{Callback: response, Filters: filters}
 }
 
+// RunsCallback implements dispatcher.CallbackRunner.
+func (PendingJoinRequests) RunsCallback() {}
+
 func (c PendingJoinRequests) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
 	if u.ChatJoinRequest == nil {
 		return nil
@@ -23,5 +26,5 @@ func (c PendingJoinRequests) CheckUpdate(ctx *ext.Context, u *ext.Update) error
 	if c.Filters != nil && !c.Filters(u.ChatJoinRequest) {
 		return nil
 	}
-	return c.Callback(ctx, u)
+	return ctx.RunCallback(c.Callback, u)
 }
@@ -0,0 +1,142 @@
+package throttle
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/storage"
+)
+
+// ErrKeyNotFound is returned by a Storage if no counter exists for the provided key.
+var ErrKeyNotFound = errors.New("throttle key not found")
+
+// Counter is the state of a limit for a key, i.e. a user.
+type Counter struct {
+	// Hits are the times of the events counted in the current window, oldest first.
+	Hits []time.Time
+	// BlockedUntil is the end of the cooldown of a key which exceeded its limit.
+	BlockedUntil time.Time
+	// Notified is true if the action was already taken during the current cooldown.
+	Notified bool
+}
+
+// Storage is the interface used by a Throttler to save counters.
+type Storage interface {
+	// Get returns the counter of the provided key, ErrKeyNotFound if it doesn't exist.
+	Get(key string) (*Counter, error)
+	// Set saves the counter of the provided key.
+	Set(key string, counter Counter) error
+	// Delete removes the provided key.
+	Delete(key string) error
+}
+
+// Sweeper is implemented by the storages which can remove the counters of the keys which stopped sending updates,
+// a Throttler sweeps them once per the longest window of its limits.
+type Sweeper interface {
+	// Sweep removes the counters whose last hit is older than before and whose cooldown ended.
+	Sweep(before time.Time) error
+}
+
+// InMemoryStorage is a Storage which keeps counters in a map.
+//
+// Note: Counters are lost once the client is restarted.
+type InMemoryStorage struct {
+	counters map[string]Counter
+	lock     *sync.RWMutex
+}
+
+// NewInMemoryStorage creates a new InMemoryStorage.
+func NewInMemoryStorage() *InMemoryStorage {
+	return &InMemoryStorage{
+		counters: make(map[string]Counter),
+		lock:     new(sync.RWMutex),
+	}
+}
+
+func (s *InMemoryStorage) Get(key string) (*Counter, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	counter, ok := s.counters[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &counter, nil
+}
+
+func (s *InMemoryStorage) Set(key string, counter Counter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.counters[key] = counter
+	return nil
+}
+
+func (s *InMemoryStorage) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+func (s *InMemoryStorage) Sweep(before time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, counter := range s.counters {
+		if counter.BlockedUntil.After(before) {
+			continue
+		}
+		if n := len(counter.Hits); n == 0 || !counter.Hits[n-1].After(before) {
+			delete(s.counters, key)
+		}
+	}
+	return nil
+}
+
+// DatabaseStorage is a Storage which saves counters in the database of storage.PeerStorage,
+// so that cooldowns and bans survive restarts.
+//
+// Note: It can't be used with an in-memory storage.PeerStorage.
+type DatabaseStorage struct {
+	peerStorage *storage.PeerStorage
+}
+
+// NewDatabaseStorage creates a new DatabaseStorage using the provided peer storage.
+func NewDatabaseStorage(p *storage.PeerStorage) *DatabaseStorage {
+	return &DatabaseStorage{peerStorage: p}
+}
+
+func (s *DatabaseStorage) Get(key string) (*Counter, error) {
+	counter, err := s.peerStorage.GetThrottleCounter(key)
+	if err != nil {
+		return nil, err
+	}
+	if counter.Key == "" {
+		return nil, ErrKeyNotFound
+	}
+	hits := make([]time.Time, len(counter.Hits))
+	for i, hit := range counter.Hits {
+		hits[i] = time.Unix(0, hit)
+	}
+	return &Counter{
+		Hits:         hits,
+		BlockedUntil: counter.BlockedUntil,
+		Notified:     counter.Notified,
+	}, nil
+}
+
+func (s *DatabaseStorage) Set(key string, counter Counter) error {
+	hits := make([]int64, len(counter.Hits))
+	for i, hit := range counter.Hits {
+		hits[i] = hit.UnixNano()
+	}
+	return s.peerStorage.SetThrottleCounter(&storage.ThrottleCounter{
+		Key:          key,
+		Hits:         hits,
+		BlockedUntil: counter.BlockedUntil,
+		Notified:     counter.Notified,
+	})
+}
+
+func (s *DatabaseStorage) Delete(key string) error {
+	return s.peerStorage.DeleteThrottleCounter(key)
+}
@@ -0,0 +1,331 @@
+// Package throttle limits how often users and chats can trigger handlers, i.e. to stop users from spamming commands.
+// Limits use sliding windows and their counters can be persisted through the storage layer.
+package throttle
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+// Limit allows Events events in any Window long period. The zero value doesn't limit anything.
+type Limit struct {
+	Events int
+	Window time.Duration
+}
+
+func (l Limit) enabled() bool {
+	return l.Events > 0 && l.Window > 0
+}
+
+// Action is what a Throttler does to the first update exceeding a limit,
+// the next updates are ignored until the end of the cooldown.
+type Action int
+
+const (
+	// ActionIgnore ignores the update.
+	ActionIgnore Action = iota
+	// ActionNotify replies to the update with Opts.Notice.
+	ActionNotify
+	// ActionBan bans the sender from the group or channel for Opts.BanDuration,
+	// updates of private chats are ignored.
+	ActionBan
+)
+
+// Opts contains optional parameters for New.
+type Opts struct {
+	// User limits the updates of a user across all the chats.
+	User Limit
+	// Chat limits the updates of a chat, from all of its users.
+	Chat Limit
+	// Commands limits the commands of a user, keyed by their names without the prefix, i.e. "start".
+	Commands map[string]Limit
+	// Prefixes are the prefixes of the commands.
+	//
+	// If not provided, handlers.DefaultPrefix will be used.
+	Prefixes []rune
+	// Action is what is done to the first update exceeding a limit, it is only taken by Middleware.
+	Action Action
+	// Notice is the reply of ActionNotify, every %s is replaced by the remaining cooldown and it is sent as is otherwise.
+	//
+	// If not provided, "You're sending too many requests, try again in %s." will be used.
+	Notice string
+	// BanDuration is the duration of the bans of ActionBan,
+	// Telegram considers bans shorter than 30 seconds or longer than 366 days permanent.
+	//
+	// If not provided, 1 hour will be used.
+	BanDuration time.Duration
+	// Storage saves the counters of the limits, i.e. a DatabaseStorage to keep them across restarts.
+	//
+	// If not provided, an InMemoryStorage will be used.
+	Storage Storage
+}
+
+// Throttler enforces the limits of its Opts, it is safe for concurrent use.
+type Throttler struct {
+	opts Opts
+	lock sync.Mutex
+	// counted are the results of the last updates counted, so that an update checked by several handlers,
+	// i.e. in different groups, is only counted once. order is used to forget the oldest ones.
+	counted     map[*ext.Update]checkResult
+	order       []*ext.Update
+	countedLock sync.Mutex
+	// window is the longest window of the limits and swept the last time the storage was swept, see Sweeper.
+	window time.Duration
+	swept  time.Time
+}
+
+// maxCounted is the number of updates whose results are remembered, it is larger than the number of updates
+// processed concurrently by the dispatcher.
+const maxCounted = 256
+
+// New creates a new Throttler with provided options, opts can be nil.
+func New(opts *Opts) *Throttler {
+	t := &Throttler{}
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.Prefixes == nil {
+		t.opts.Prefixes = handlers.DefaultPrefix
+	}
+	if t.opts.Notice == "" {
+		t.opts.Notice = "You're sending too many requests, try again in %s."
+	}
+	if t.opts.BanDuration <= 0 {
+		t.opts.BanDuration = time.Hour
+	}
+	if t.opts.Storage == nil {
+		t.opts.Storage = NewInMemoryStorage()
+	}
+	t.window = max(t.opts.User.Window, t.opts.Chat.Window)
+	for _, limit := range t.opts.Commands {
+		t.window = max(t.window, limit.Window)
+	}
+	t.counted = make(map[*ext.Update]checkResult)
+	return t
+}
+
+// Allow counts the update and reports whether it is within the limits, it can be used as a filters.UpdateFilter.
+// The update is allowed if its counters can't be loaded, and no Action is taken.
+// An update is counted only once, however many handlers check it.
+//
+// Note: Filters of handlers like handlers.Command are checked before the command, use Middleware
+// to count only the updates handled by a handler.
+func (t *Throttler) Allow(u *ext.Update) bool {
+	result, err := t.checkOnce(u)
+	return err != nil || !result.limited
+}
+
+// Middleware returns a dispatcher.Middleware which counts the updates handled by the handlers it wraps.
+// Updates exceeding a limit aren't passed to the handler and end the groups, the Action is taken for the first one.
+// An update is counted only once, however many handlers it wraps handle it.
+func (t *Throttler) Middleware() dispatcher.Middleware {
+	return func(next handlers.CallbackResponse) handlers.CallbackResponse {
+		return func(ctx *ext.Context, u *ext.Update) error {
+			result, err := t.checkOnce(u)
+			if err != nil {
+				return err
+			}
+			if !result.limited {
+				return next(ctx, u)
+			}
+			if result.act {
+				if err := t.act(ctx, u, result.cooldown); err != nil {
+					return err
+				}
+			}
+			return dispatcher.EndGroups
+		}
+	}
+}
+
+type checkResult struct {
+	limited bool
+	// cooldown is the time left until the update would be allowed.
+	cooldown time.Duration
+	// act is true if the update is the first one exceeding the limit.
+	act bool
+}
+
+type limitedKey struct {
+	key   string
+	limit Limit
+}
+
+// checkOnce returns the result of check for the update, counting it only the first time it is checked.
+// countedLock isn't held while the update is checked, as an update is only handled by one goroutine at a time.
+func (t *Throttler) checkOnce(u *ext.Update) (checkResult, error) {
+	t.countedLock.Lock()
+	result, ok := t.counted[u]
+	t.countedLock.Unlock()
+	if ok {
+		// The action was already taken for the update.
+		result.act = false
+		return result, nil
+	}
+	result, err := t.check(u)
+	if err != nil {
+		return result, err
+	}
+	t.countedLock.Lock()
+	defer t.countedLock.Unlock()
+	if len(t.order) == maxCounted {
+		delete(t.counted, t.order[0])
+		t.order = t.order[1:]
+	}
+	t.counted[u] = result
+	t.order = append(t.order, u)
+	return result, nil
+}
+
+// check counts the update in all of its counters if it is within all of its limits.
+func (t *Throttler) check(u *ext.Update) (checkResult, error) {
+	keys := t.keys(u)
+	if len(keys) == 0 {
+		return checkResult{}, nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	if err := t.sweep(now); err != nil {
+		return checkResult{}, err
+	}
+	counters := make([]Counter, len(keys))
+	var result checkResult
+	for i, k := range keys {
+		c, err := t.opts.Storage.Get(k.key)
+		if err == nil {
+			counters[i] = *c
+		} else if err != ErrKeyNotFound {
+			return checkResult{}, err
+		}
+		if cooldown := counters[i].update(k.limit, now); cooldown > 0 {
+			result.limited = true
+			result.cooldown = max(result.cooldown, cooldown)
+		}
+	}
+	for i, k := range keys {
+		c := &counters[i]
+		switch {
+		case !result.limited:
+			c.Hits = append(c.Hits, now)
+		case now.Before(c.BlockedUntil) && !c.Notified:
+			c.Notified = true
+			result.act = true
+		}
+		var err error
+		if len(c.Hits) == 0 && !now.Before(c.BlockedUntil) {
+			err = t.opts.Storage.Delete(k.key)
+		} else {
+			err = t.opts.Storage.Set(k.key, *c)
+		}
+		if err != nil {
+			return checkResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// sweep removes the counters of the keys which didn't send any update within the longest window,
+// at most once per window, if the storage is a Sweeper.
+func (t *Throttler) sweep(now time.Time) error {
+	s, ok := t.opts.Storage.(Sweeper)
+	if !ok || now.Sub(t.swept) < t.window {
+		return nil
+	}
+	t.swept = now
+	return s.Sweep(now.Add(-t.window))
+}
+
+// update drops the hits outside of the window and starts a cooldown if the limit is exceeded,
+// it returns the time left until the cooldown ends.
+func (c *Counter) update(limit Limit, now time.Time) time.Duration {
+	start := 0
+	for start < len(c.Hits) && !c.Hits[start].After(now.Add(-limit.Window)) {
+		start++
+	}
+	c.Hits = c.Hits[start:]
+	if now.Before(c.BlockedUntil) {
+		return c.BlockedUntil.Sub(now)
+	}
+	if len(c.Hits) < limit.Events {
+		return 0
+	}
+	// The update will be allowed once enough hits left the window.
+	c.BlockedUntil = c.Hits[len(c.Hits)-limit.Events].Add(limit.Window)
+	c.Notified = false
+	return c.BlockedUntil.Sub(now)
+}
+
+// keys returns the counters of the limits the update is subject to.
+func (t *Throttler) keys(u *ext.Update) []limitedKey {
+	if m := u.EffectiveMessage; m != nil && m.Out {
+		return nil
+	}
+	userId := u.SenderID()
+	chatId := u.ChatID()
+	var keys []limitedKey
+	if userId != 0 && t.opts.User.enabled() {
+		keys = append(keys, limitedKey{key: "user:" + userId.String(), limit: t.opts.User})
+	}
+	if chatId != 0 && t.opts.Chat.enabled() {
+		keys = append(keys, limitedKey{key: "chat:" + chatId.String(), limit: t.opts.Chat})
+	}
+	if userId != 0 {
+		if name := t.command(u); name != "" {
+			if limit := t.opts.Commands[name]; limit.enabled() {
+				keys = append(keys, limitedKey{key: "command:" + name + ":" + userId.String(), limit: limit})
+			}
+		}
+	}
+	return keys
+}
+
+// command returns the name of the command of the update, or an empty string if it isn't a command.
+func (t *Throttler) command(u *ext.Update) string {
+	m := u.EffectiveMessage
+	if m == nil || len(t.opts.Commands) == 0 {
+		return ""
+	}
+	fields := strings.Fields(m.Text)
+	if len(fields) == 0 {
+		return ""
+	}
+	arg := strings.ToLower(fields[0])
+	for _, prefix := range t.opts.Prefixes {
+		if name, ok := strings.CutPrefix(arg, string(prefix)); ok {
+			name, _, _ = strings.Cut(name, "@")
+			return name
+		}
+	}
+	return ""
+}
+
+func (t *Throttler) act(ctx *ext.Context, u *ext.Update, cooldown time.Duration) error {
+	switch t.opts.Action {
+	case ActionNotify:
+		if u.EffectiveMessage == nil {
+			return nil
+		}
+		notice := strings.ReplaceAll(t.opts.Notice, "%s", cooldown.Round(time.Second).String())
+		_, err := ctx.Reply(u, ext.ReplyTextString(notice), nil)
+		return err
+	case ActionBan:
+		userId := u.SenderID()
+		switch u.ChatPeer().(type) {
+		case *tg.PeerChat, *tg.PeerChannel:
+			if userId == 0 {
+				return nil
+			}
+			untilDate := int(time.Now().Add(t.opts.BanDuration).Unix())
+			_, err := ctx.BanChatMember(u.ChatID().BotAPI(), userId.BotAPI(), untilDate)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package throttle_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/throttle"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/tg"
+)
+
+var (
+	alice = gotgprototest.User(10, "Alice")
+	bob   = gotgprototest.User(11, "Bob")
+	group = gotgprototest.Channel(100, "Group", true)
+)
+
+func newClient(p *storage.PeerStorage, hs ...dispatcher.Handler) *gotgprototest.Client {
+	var d *dispatcher.NativeDispatcher
+	if p != nil {
+		d = dispatcher.NewNativeDispatcher(false, false, nil, nil, p)
+	}
+	c := gotgprototest.NewClient(&gotgprototest.ClientOpts{Dispatcher: d, PeerStorage: p})
+	c.AddUsers(alice, bob)
+	c.AddChats(group)
+	for _, h := range hs {
+		c.Dispatcher.AddHandler(h)
+	}
+	return c
+}
+
+func send(c *gotgprototest.Client, id int, from *tg.User, text string) {
+	_ = c.Handle(gotgprototest.NewMessage(gotgprototest.TextMessage(id, &tg.PeerUser{UserID: from.ID}, &tg.PeerChannel{ChannelID: group.ID}, text)))
+}
+
+// counter returns a callback which counts its calls per sender.
+func counter(calls map[int64]int) handlers.CallbackResponse {
+	return func(_ *ext.Context, u *ext.Update) error {
+		calls[u.EffectiveUser().ID]++
+		return nil
+	}
+}
+
+func TestUserLimitNotify(t *testing.T) {
+	th := throttle.New(&throttle.Opts{User: throttle.Limit{Events: 2, Window: time.Hour}, Action: throttle.ActionNotify})
+	calls := make(map[int64]int)
+	c := newClient(nil, dispatcher.WithMiddleware(handlers.NewAnyUpdate(counter(calls)), th.Middleware()))
+	for i := 1; i <= 4; i++ {
+		send(c, i, alice, "spam")
+	}
+	send(c, 5, bob, "hello")
+	if calls[alice.ID] != 2 || calls[bob.ID] != 1 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	sent := gotgprototest.RequestsOf[*tg.MessagesSendMessageRequest](c.Invoker)
+	if len(sent) != 1 {
+		t.Fatalf("expected a single cooldown notice, got %d messages", len(sent))
+	}
+	if sent[0].ReplyTo == nil {
+		t.Fatal("expected the notice to reply to the update")
+	}
+}
+
+func TestNotice(t *testing.T) {
+	tests := []struct {
+		notice string
+		want   string
+	}{
+		{notice: "", want: "You're sending too many requests, try again in 1h0m0s."},
+		{notice: "Slow down!", want: "Slow down!"},
+		{notice: "Wait %s, 100% sure", want: "Wait 1h0m0s, 100% sure"},
+	}
+	for _, tt := range tests {
+		th := throttle.New(&throttle.Opts{User: throttle.Limit{Events: 1, Window: time.Hour}, Action: throttle.ActionNotify, Notice: tt.notice})
+		c := newClient(nil, dispatcher.WithMiddleware(handlers.NewAnyUpdate(counter(make(map[int64]int))), th.Middleware()))
+		send(c, 1, alice, "spam")
+		send(c, 2, alice, "spam")
+		if sent := gotgprototest.ExpectRequest[*tg.MessagesSendMessageRequest](t, c.Invoker); sent.Message != tt.want {
+			t.Fatalf("notice %q: got %q, want %q", tt.notice, sent.Message, tt.want)
+		}
+	}
+}
+
+func TestCommandLimitFilter(t *testing.T) {
+	th := throttle.New(&throttle.Opts{Commands: map[string]throttle.Limit{"start": {Events: 1, Window: time.Hour}}})
+	calls := make(map[int64]int)
+	start := handlers.NewCommand("start", counter(calls))
+	start.UpdateFilters = th.Allow
+	c := newClient(nil, start)
+	send(c, 1, alice, "/start")
+	send(c, 2, alice, "/start@test_bot")
+	send(c, 3, alice, "/help")
+	send(c, 4, bob, "/start")
+	if calls[alice.ID] != 1 || calls[bob.ID] != 1 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestChatLimitWindow(t *testing.T) {
+	th := throttle.New(&throttle.Opts{Chat: throttle.Limit{Events: 1, Window: 50 * time.Millisecond}})
+	calls := make(map[int64]int)
+	c := newClient(nil, dispatcher.WithMiddleware(handlers.NewAnyUpdate(counter(calls)), th.Middleware()))
+	send(c, 1, alice, "hello")
+	send(c, 2, bob, "hello")
+	time.Sleep(60 * time.Millisecond)
+	send(c, 3, bob, "hello")
+	if calls[alice.ID] != 1 || calls[bob.ID] != 1 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestBan(t *testing.T) {
+	th := throttle.New(&throttle.Opts{User: throttle.Limit{Events: 1, Window: time.Hour}, Action: throttle.ActionBan})
+	calls := make(map[int64]int)
+	c := newClient(nil, dispatcher.WithMiddleware(handlers.NewAnyUpdate(counter(calls)), th.Middleware()))
+	c.Invoker.Reply(&tg.ChannelsEditBannedRequest{}, &tg.Updates{})
+	send(c, 1, alice, "hello")
+	send(c, 2, alice, "spam")
+	send(c, 3, alice, "spam")
+	bans := gotgprototest.RequestsOf[*tg.ChannelsEditBannedRequest](c.Invoker)
+	if len(bans) != 1 {
+		t.Fatalf("expected a single ban, got %d", len(bans))
+	}
+	if user, ok := bans[0].Participant.(*tg.InputPeerUser); !ok || user.UserID != alice.ID {
+		t.Fatalf("unexpected banned participant %v", bans[0].Participant)
+	}
+	if until := time.Unix(int64(bans[0].BannedRights.UntilDate), 0); time.Until(until) < 59*time.Minute {
+		t.Fatalf("unexpected ban end %s", until)
+	}
+}
+
+func TestDatabaseStorage(t *testing.T) {
+	p, err := storage.NewPeerStorage(sqlite.Open(filepath.Join(t.TempDir(), "test.session")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, _ := p.SqlSession.DB()
+		_ = db.Close()
+	})
+	opts := &throttle.Opts{User: throttle.Limit{Events: 1, Window: time.Hour}, Storage: throttle.NewDatabaseStorage(p)}
+	calls := make(map[int64]int)
+	c := newClient(p, dispatcher.WithMiddleware(handlers.NewAnyUpdate(counter(calls)), throttle.New(opts).Middleware()))
+	send(c, 1, alice, "hello")
+	// The counters of a restarted client are loaded from the database.
+	c = newClient(p, dispatcher.WithMiddleware(handlers.NewAnyUpdate(counter(calls)), throttle.New(opts).Middleware()))
+	send(c, 2, alice, "hello")
+	if calls[alice.ID] != 1 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestCountedOnce(t *testing.T) {
+	th := throttle.New(&throttle.Opts{User: throttle.Limit{Events: 2, Window: time.Hour}})
+	calls := make(map[int64]int)
+	c := newClient(nil)
+	c.Dispatcher.Use(th.Middleware())
+	c.Dispatcher.AddHandler(handlers.NewAnyUpdate(func(*ext.Context, *ext.Update) error {
+		return dispatcher.ContinueGroups
+	}))
+	c.Dispatcher.AddHandlerToGroup(handlers.NewAnyUpdate(counter(calls)), 1)
+	send(c, 1, alice, "hello")
+	send(c, 2, alice, "hello")
+	send(c, 3, alice, "spam")
+	if calls[alice.ID] != 2 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestInMemoryStorageSweep(t *testing.T) {
+	s := throttle.NewInMemoryStorage()
+	now := time.Now()
+	_ = s.Set("old", throttle.Counter{Hits: []time.Time{now.Add(-2 * time.Hour)}})
+	_ = s.Set("recent", throttle.Counter{Hits: []time.Time{now.Add(-2 * time.Hour), now}})
+	_ = s.Set("blocked", throttle.Counter{BlockedUntil: now.Add(time.Hour)})
+	if err := s.Sweep(now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("old"); err != throttle.ErrKeyNotFound {
+		t.Fatalf("expected the old counter to be swept, got %v", err)
+	}
+	for _, key := range []string{"recent", "blocked"} {
+		if _, err := s.Get(key); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package dispatcher
+
+import (
+	"github.com/celestix/gotgproto/ext"
+)
+
+// Middleware wraps the callbacks of handlers, i.e. to log the updates, check permissions or throttle users.
+// next is the handlers.CallbackResponse of the handler, or the next middleware of the chain.
+//
+// A middleware may return without calling next to stop the handler, the returned error is treated
+// as the one of the handler, i.e. EndGroups stops processing the update.
+// Middlewares are executed only once a CallbackRunner decided to handle the update,
+// they wrap CheckUpdate of the other handlers and are executed for every update those handlers check.
+type Middleware func(next func(*ext.Context, *ext.Update) error) func(*ext.Context, *ext.Update) error
+
+// chain composes the middlewares, the first one being the outermost, nil is returned if there are none.
+func chain(middlewares ...[]Middleware) Middleware {
+	var all []Middleware
+	for _, m := range middlewares {
+		all = append(all, m...)
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	return func(next func(*ext.Context, *ext.Update) error) func(*ext.Context, *ext.Update) error {
+		for i := len(all) - 1; i >= 0; i-- {
+			next = all[i](next)
+		}
+		return next
+	}
+}
+
+// Use adds middlewares wrapping the handlers of all the groups, they are executed before the ones of the groups.
+// Middlewares must be added before the client is started.
+func (dp *NativeDispatcher) Use(middlewares ...Middleware) {
+	dp.handlersLock.Lock()
+	defer dp.handlersLock.Unlock()
+	dp.middlewares = append(dp.middlewares, middlewares...)
+}
+
+// UseInGroup adds middlewares wrapping the handlers of a specific group.
+// Middlewares must be added before the client is started.
+func (dp *NativeDispatcher) UseInGroup(group int, middlewares ...Middleware) {
+	dp.handlersLock.Lock()
+	defer dp.handlersLock.Unlock()
+	if dp.groupMiddlewares == nil {
+		dp.groupMiddlewares = make(map[int][]Middleware)
+	}
+	dp.groupMiddlewares[group] = append(dp.groupMiddlewares[group], middlewares...)
+}
+
+// middlewareOf returns the chain of the middlewares of a group, handlersLock must be held.
+func (dp *NativeDispatcher) middlewareOf(group int) Middleware {
+	return chain(dp.middlewares, dp.groupMiddlewares[group])
+}
+
+// WithMiddleware returns a handler wrapping the callback of h with the provided middlewares,
+// they are executed after the ones of the dispatcher and of the group of the handler.
+func WithMiddleware(h Handler, middlewares ...Middleware) Handler {
+	return middlewareHandler{Handler: h, middleware: chain(middlewares)}
+}
+
+type middlewareHandler struct {
+	Handler
+	middleware Middleware
+}
+
+// RunsCallback implements CallbackRunner, the middlewares are applied to the wrapped handler by CheckUpdate.
+func (middlewareHandler) RunsCallback() {}
+
+func (h middlewareHandler) CheckUpdate(ctx *ext.Context, u *ext.Update) error {
+	if h.middleware == nil {
+		return RunHandler(ctx, u, h.Handler)
+	}
+	outer := ctx.CallbackMiddleware
+	ctx.CallbackMiddleware = h.middleware
+	if outer != nil {
+		ctx.CallbackMiddleware = func(next func(*ext.Context, *ext.Update) error) func(*ext.Context, *ext.Update) error {
+			return outer(h.middleware(next))
+		}
+	}
+	defer func() { ctx.CallbackMiddleware = outer }()
+	return RunHandler(ctx, u, h.Handler)
+}
+
+// RunHandler checks the update with the provided handler through the middlewares of ctx.CallbackMiddleware,
+// which wrap its callback if it is a CallbackRunner and its CheckUpdate otherwise.
+// It is used by the dispatcher and by the handlers checking other handlers, i.e. handlers.Conversation.
+func RunHandler(ctx *ext.Context, u *ext.Update, h Handler) error {
+	middleware := ctx.CallbackMiddleware
+	if _, ok := h.(CallbackRunner); ok || middleware == nil {
+		return h.CheckUpdate(ctx, u)
+	}
+	// The middlewares must not be executed again if the handler runs a callback through ext.Context.RunCallback.
+	ctx.CallbackMiddleware = nil
+	defer func() { ctx.CallbackMiddleware = middleware }()
+	return middleware(h.CheckUpdate)(ctx, u)
+}
@@ -0,0 +1,66 @@
+package dispatcher
+
+import (
+	"github.com/gotd/td/tg"
+)
+
+// shortMessageUpdate converts the short forms of new messages sent by Telegram to the UpdateNewMessage they stand for,
+// selfID is the sender of outgoing private messages.
+//
+// tg.UpdateShortSentMessage is only returned as the result of messages.sendMessage and lacks the peer and the text
+// of the message, it is converted through the request by the client before it reaches the updates manager.
+func shortMessageUpdate(selfID int64, u tg.UpdatesClass) (*tg.UpdateNewMessage, bool) {
+	var msg *tg.Message
+	switch u := u.(type) {
+	case *tg.UpdateShortMessage:
+		msg = &tg.Message{ID: u.ID, PeerID: &tg.PeerUser{UserID: u.UserID}, Message: u.Message, Date: u.Date}
+		msg.SetOut(u.Out)
+		msg.SetMentioned(u.Mentioned)
+		msg.SetMediaUnread(u.MediaUnread)
+		msg.SetSilent(u.Silent)
+		if u.Out {
+			msg.SetFromID(&tg.PeerUser{UserID: selfID})
+		} else {
+			msg.SetFromID(&tg.PeerUser{UserID: u.UserID})
+		}
+		setShortMessageFields(msg, u)
+		return &tg.UpdateNewMessage{Message: msg, Pts: u.Pts, PtsCount: u.PtsCount}, true
+	case *tg.UpdateShortChatMessage:
+		msg = &tg.Message{ID: u.ID, PeerID: &tg.PeerChat{ChatID: u.ChatID}, Message: u.Message, Date: u.Date}
+		msg.SetOut(u.Out)
+		msg.SetMentioned(u.Mentioned)
+		msg.SetMediaUnread(u.MediaUnread)
+		msg.SetSilent(u.Silent)
+		msg.SetFromID(&tg.PeerUser{UserID: u.FromID})
+		setShortMessageFields(msg, u)
+		return &tg.UpdateNewMessage{Message: msg, Pts: u.Pts, PtsCount: u.PtsCount}, true
+	}
+	return nil, false
+}
+
+// shortMessage is implemented by tg.UpdateShortMessage and tg.UpdateShortChatMessage.
+type shortMessage interface {
+	GetFwdFrom() (tg.MessageFwdHeader, bool)
+	GetViaBotID() (int64, bool)
+	GetReplyTo() (tg.MessageReplyHeaderClass, bool)
+	GetEntities() ([]tg.MessageEntityClass, bool)
+	GetTTLPeriod() (int, bool)
+}
+
+func setShortMessageFields(msg *tg.Message, u shortMessage) {
+	if v, ok := u.GetFwdFrom(); ok {
+		msg.SetFwdFrom(v)
+	}
+	if v, ok := u.GetViaBotID(); ok {
+		msg.SetViaBotID(v)
+	}
+	if v, ok := u.GetReplyTo(); ok {
+		msg.SetReplyTo(v)
+	}
+	if v, ok := u.GetEntities(); ok {
+		msg.SetEntities(v)
+	}
+	if v, ok := u.GetTTLPeriod(); ok {
+		msg.SetTTLPeriod(v)
+	}
+}
@@ -5,6 +5,10 @@ import "errors"
 var (
 	ErrClientAlreadyRunning = errors.New("client is already running")
 	ErrSessionUnauthorized  = errors.New("session is unauthorized")
+	ErrClientNotFound       = errors.New("client not found")
+	// ErrSessionRevoked is returned by a client whose session was revoked or expired while it was running,
+	// i.e. because it was terminated from another device.
+	ErrSessionRevoked = errors.New("session was revoked or expired, log in again")
 )
 
 var (
@@ -17,4 +21,10 @@ var (
 	ErrMessageNotExist  = errors.New("message not exist")
 	ErrReplyNotMessage  = errors.New("reply header is not a message")
 	ErrUnknownTypeMedia = errors.New("unknown type media")
+	ErrFileEmpty        = errors.New("file to upload was not provided")
+	// ErrFileReferenceExpired is returned by a download with an expired file reference
+	// if the message of the media wasn't provided to refresh it.
+	ErrFileReferenceExpired = errors.New("file reference expired, provide the message of the media to refresh it")
+	// ErrInviteNotJoined is returned when resolving the invite link of a chat the client isn't a member of.
+	ErrInviteNotJoined = errors.New("invite link is of a chat which was not joined")
 )
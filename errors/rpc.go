@@ -0,0 +1,151 @@
+package errors
+
+import (
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+// RPCErrorKind is a class of errors returned by Telegram which usually call for the same reaction.
+type RPCErrorKind int
+
+const (
+	// KindUnknown is the kind of errors which weren't returned by Telegram or aren't classified.
+	KindUnknown RPCErrorKind = iota
+	// KindFloodWait is the kind of the errors asking to wait before retrying a request,
+	// i.e. FLOOD_WAIT_X and SLOWMODE_WAIT_X.
+	KindFloodWait
+	// KindPeerInvalid is the kind of the errors of unknown or inaccessible peers, i.e. PEER_ID_INVALID.
+	KindPeerInvalid
+	// KindWriteForbidden is the kind of the errors of chats the client can't send messages to,
+	// i.e. CHAT_WRITE_FORBIDDEN.
+	KindWriteForbidden
+	// KindUserBlocked is the kind of the errors of users who blocked the client or were deleted,
+	// i.e. USER_IS_BLOCKED.
+	KindUserBlocked
+	// KindMessageNotModified is the kind of the errors of edits which don't change the message.
+	KindMessageNotModified
+	// KindMessageInvalid is the kind of the errors of messages which don't exist or were deleted,
+	// i.e. MESSAGE_ID_INVALID.
+	KindMessageInvalid
+	// KindUnauthorized is the kind of the errors of sessions which aren't authorized anymore,
+	// i.e. AUTH_KEY_UNREGISTERED.
+	KindUnauthorized
+)
+
+func (k RPCErrorKind) String() string {
+	switch k {
+	case KindFloodWait:
+		return "flood_wait"
+	case KindPeerInvalid:
+		return "peer_invalid"
+	case KindWriteForbidden:
+		return "write_forbidden"
+	case KindUserBlocked:
+		return "user_blocked"
+	case KindMessageNotModified:
+		return "message_not_modified"
+	case KindMessageInvalid:
+		return "message_invalid"
+	case KindUnauthorized:
+		return "unauthorized"
+	}
+	return "unknown"
+}
+
+// rpcErrorTypes contains the types of the errors of every kind but KindUnauthorized,
+// which is identified by the 401 code.
+var rpcErrorTypes = map[RPCErrorKind][]string{
+	KindFloodWait: {
+		tgerr.ErrFloodWait, tgerr.ErrPremiumFloodWait, "SLOWMODE_WAIT",
+	},
+	KindPeerInvalid: {
+		"PEER_ID_INVALID", "CHAT_ID_INVALID", "CHANNEL_INVALID", "USER_ID_INVALID",
+		"CHANNEL_PRIVATE", "INPUT_USER_DEACTIVATED", "USERNAME_NOT_OCCUPIED", "USERNAME_INVALID",
+	},
+	KindWriteForbidden: {
+		"CHAT_WRITE_FORBIDDEN", "CHAT_RESTRICTED", "CHAT_ADMIN_REQUIRED", "CHAT_SEND_PLAIN_FORBIDDEN",
+		"CHAT_SEND_MEDIA_FORBIDDEN", "USER_BANNED_IN_CHANNEL", "CHANNEL_PUBLIC_GROUP_NA",
+	},
+	KindUserBlocked: {
+		"USER_IS_BLOCKED", "USER_DEACTIVATED", "YOU_BLOCKED_USER",
+	},
+	KindMessageNotModified: {
+		"MESSAGE_NOT_MODIFIED",
+	},
+	KindMessageInvalid: {
+		"MESSAGE_ID_INVALID", "MESSAGE_EDIT_TIME_EXPIRED", "MESSAGE_DELETE_FORBIDDEN",
+	},
+}
+
+// RPCKind returns the kind of an error returned by Telegram, err may be wrapped.
+func RPCKind(err error) RPCErrorKind {
+	rpcErr, ok := tgerr.As(err)
+	if !ok {
+		return KindUnknown
+	}
+	if rpcErr.IsCode(401) {
+		return KindUnauthorized
+	}
+	for kind, types := range rpcErrorTypes {
+		if rpcErr.IsOneOf(types...) {
+			return kind
+		}
+	}
+	return KindUnknown
+}
+
+// IsRPCError reports whether err is an error returned by Telegram of one of the provided types,
+// i.e. IsRPCError(err, "CHAT_WRITE_FORBIDDEN"), or of any type if none are provided.
+func IsRPCError(err error, types ...string) bool {
+	rpcErr, ok := tgerr.As(err)
+	if !ok {
+		return false
+	}
+	return len(types) == 0 || rpcErr.IsOneOf(types...)
+}
+
+// IsFloodWait reports whether err asks to wait before retrying the request.
+func IsFloodWait(err error) bool {
+	return RPCKind(err) == KindFloodWait
+}
+
+// FloodWait returns the time to wait before retrying the request if err asks to wait.
+func FloodWait(err error) (time.Duration, bool) {
+	rpcErr, ok := tgerr.As(err)
+	if !ok || !rpcErr.IsOneOf(rpcErrorTypes[KindFloodWait]...) {
+		return 0, false
+	}
+	return time.Duration(rpcErr.Argument) * time.Second, true
+}
+
+// IsPeerInvalid reports whether err was returned because a peer is unknown or inaccessible.
+func IsPeerInvalid(err error) bool {
+	return RPCKind(err) == KindPeerInvalid
+}
+
+// IsWriteForbidden reports whether err was returned because the client can't send messages to a chat,
+// i.e. to leave the chat.
+func IsWriteForbidden(err error) bool {
+	return RPCKind(err) == KindWriteForbidden
+}
+
+// IsUserBlocked reports whether err was returned because a user blocked the client or was deleted.
+func IsUserBlocked(err error) bool {
+	return RPCKind(err) == KindUserBlocked
+}
+
+// IsMessageNotModified reports whether err was returned by an edit which doesn't change the message.
+func IsMessageNotModified(err error) bool {
+	return RPCKind(err) == KindMessageNotModified
+}
+
+// IsMessageInvalid reports whether err was returned because a message doesn't exist or can't be changed.
+func IsMessageInvalid(err error) bool {
+	return RPCKind(err) == KindMessageInvalid
+}
+
+// IsUnauthorized reports whether err was returned because the session isn't authorized anymore.
+func IsUnauthorized(err error) bool {
+	return RPCKind(err) == KindUnauthorized
+}
@@ -0,0 +1,58 @@
+package errors_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mtp_errors "github.com/celestix/gotgproto/errors"
+	"github.com/gotd/td/tgerr"
+)
+
+func TestRPCKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want mtp_errors.RPCErrorKind
+	}{
+		{err: nil, want: mtp_errors.KindUnknown},
+		{err: errors.New("connection reset"), want: mtp_errors.KindUnknown},
+		{err: tgerr.New(400, "BUTTON_DATA_INVALID"), want: mtp_errors.KindUnknown},
+		{err: tgerr.New(420, "FLOOD_WAIT_5"), want: mtp_errors.KindFloodWait},
+		{err: tgerr.New(420, "SLOWMODE_WAIT_10"), want: mtp_errors.KindFloodWait},
+		{err: tgerr.New(400, "PEER_ID_INVALID"), want: mtp_errors.KindPeerInvalid},
+		{err: fmt.Errorf("send: %w", tgerr.New(403, "CHAT_WRITE_FORBIDDEN")), want: mtp_errors.KindWriteForbidden},
+		{err: tgerr.New(400, "USER_IS_BLOCKED"), want: mtp_errors.KindUserBlocked},
+		{err: tgerr.New(400, "MESSAGE_NOT_MODIFIED"), want: mtp_errors.KindMessageNotModified},
+		{err: tgerr.New(400, "MESSAGE_ID_INVALID"), want: mtp_errors.KindMessageInvalid},
+		{err: tgerr.New(401, "AUTH_KEY_UNREGISTERED"), want: mtp_errors.KindUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := mtp_errors.RPCKind(tt.err); got != tt.want {
+			t.Errorf("RPCKind(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFloodWait(t *testing.T) {
+	err := fmt.Errorf("send: %w", tgerr.New(420, "FLOOD_WAIT_5"))
+	if !mtp_errors.IsFloodWait(err) {
+		t.Fatal("expected a flood wait")
+	}
+	if d, ok := mtp_errors.FloodWait(err); !ok || d != 5*time.Second {
+		t.Fatalf("unexpected wait %s", d)
+	}
+	if _, ok := mtp_errors.FloodWait(tgerr.New(400, "PEER_ID_INVALID")); ok {
+		t.Fatal("expected no wait")
+	}
+}
+
+func TestIsRPCError(t *testing.T) {
+	err := tgerr.New(400, "PEER_ID_INVALID")
+	if !mtp_errors.IsRPCError(err) || !mtp_errors.IsRPCError(err, "CHANNEL_INVALID", "PEER_ID_INVALID") {
+		t.Fatal("expected an rpc error")
+	}
+	if mtp_errors.IsRPCError(err, "CHANNEL_INVALID") || mtp_errors.IsRPCError(errors.New("PEER_ID_INVALID")) {
+		t.Fatal("expected no match")
+	}
+}
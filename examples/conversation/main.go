@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/conversation"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/sessionMaker"
+	"github.com/celestix/gotgproto/types"
+	"github.com/glebarez/sqlite"
+)
+
+const (
+	stateName = "name"
+	stateAge  = "age"
+)
+
+func main() {
+	client, err := gotgproto.NewClient(
+		// Get AppID from https://my.telegram.org/apps
+		123456,
+		// Get ApiHash from https://my.telegram.org/apps
+		"API_HASH_HERE",
+		// ClientType, as we defined above
+		gotgproto.ClientTypeBot("BOT_TOKEN_HERE"),
+		// Optional parameters of client
+		&gotgproto.ClientOpts{
+			Session: sessionMaker.SqlSession(sqlite.Open("conversation_bot")),
+		},
+	)
+	if err != nil {
+		log.Fatalln("failed to start client:", err)
+	}
+
+	clientDispatcher := client.Dispatcher
+
+	clientDispatcher.AddHandler(handlers.NewConversation(
+		[]dispatcher.Handler{handlers.NewCommand("start", start)},
+		map[string][]dispatcher.Handler{
+			stateName: {handlers.NewMessage(text, name)},
+			stateAge:  {handlers.NewMessage(text, age)},
+		},
+		&handlers.ConversationOpts{
+			Name:      "register",
+			Fallbacks: []dispatcher.Handler{handlers.NewCommand("cancel", cancel)},
+			Timeout:   10 * time.Minute,
+			// Save conversation states in the session database so that they survive restarts.
+			Storage: conversation.NewDatabaseStorage(client.PeerStorage),
+		},
+	))
+
+	fmt.Printf("client (@%s) has been started...\n", client.Self.Username)
+
+	err = client.Idle()
+	if err != nil {
+		log.Fatalln("failed to start client:", err)
+	}
+}
+
+// text matches the text messages which aren't commands, so that /cancel reaches the fallbacks.
+func text(m *types.Message) bool {
+	return filters.Message.Text(m) && !filters.Message.Command(m)
+}
+
+func start(ctx *ext.Context, update *ext.Update) error {
+	_, err := ctx.Reply(update, ext.ReplyTextString("Hi! What's your name?"), nil)
+	if err != nil {
+		return err
+	}
+	return handlers.NextConversationState(stateName)
+}
+
+func name(ctx *ext.Context, update *ext.Update) error {
+	_, err := ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Nice to meet you, %s! How old are you?", update.EffectiveMessage.Text)), nil)
+	if err != nil {
+		return err
+	}
+	return handlers.NextConversationState(stateAge)
+}
+
+func age(ctx *ext.Context, update *ext.Update) error {
+	if _, err := strconv.Atoi(update.EffectiveMessage.Text); err != nil {
+		_, err = ctx.Reply(update, ext.ReplyTextString("Please send a valid age."), nil)
+		if err != nil {
+			return err
+		}
+		return handlers.StayInConversation()
+	}
+	_, err := ctx.Reply(update, ext.ReplyTextString("Thanks, you have been registered."), nil)
+	if err != nil {
+		return err
+	}
+	return handlers.EndConversation()
+}
+
+func cancel(ctx *ext.Context, update *ext.Update) error {
+	_, err := ctx.Reply(update, ext.ReplyTextString("Registration cancelled."), nil)
+	if err != nil {
+		return err
+	}
+	return handlers.EndConversation()
+}
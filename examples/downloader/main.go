@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/dispatcher/handlers"
@@ -58,10 +60,32 @@ func download(ctx *ext.Context, update *ext.Update) error {
 		return errors.Wrap(err, "failed to get media file name")
 	}
 
-	_, err = ctx.DownloadMedia(
+	// Cancel the download if it takes more than an hour, the partial file is kept
+	// and the download will continue from it when the media is sent again.
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	lastReport := time.Now()
+	_, err = ctx.WithContext(timeoutCtx).DownloadMedia(
 		update.EffectiveMessage.Media,
 		ext.DownloadOutputPath(filename),
-		nil,
+		&ext.DownloadMediaOpts{
+			Resume: true,
+			// The message is fetched again to refresh the file reference if it expires.
+			ChatId:    update.ChatID().BotAPI(),
+			MessageId: update.EffectiveMessage.ID,
+			Progress: func(downloaded, total int64) {
+				if time.Since(lastReport) < time.Second && downloaded != total {
+					return
+				}
+				lastReport = time.Now()
+				if total > 0 {
+					fmt.Printf("%s: %d/%d bytes (%.1f%%)\n", filename, downloaded, total, float64(downloaded)*100/float64(total))
+				} else {
+					fmt.Printf("%s: %d bytes\n", filename, downloaded)
+				}
+			},
+		},
 	)
 	if err != nil {
 		return errors.Wrap(err, "failed to download media")
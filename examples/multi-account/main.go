@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/glebarez/sqlite"
+)
+
+func main() {
+	// All the accounts share a single database, the tables of every account are prefixed with its name.
+	store, err := storage.NewGormStore(sqlite.Open("accounts.session"))
+	if err != nil {
+		log.Fatalln("failed to open store:", err)
+	}
+	manager := gotgproto.NewManager(&gotgproto.ManagerOpts{
+		Store: store,
+	})
+
+	// Handlers are added once and attached to the clients of all the accounts.
+	manager.AddHandler(handlers.NewMessage(filters.Message.Text, echo))
+
+	bots := map[string]string{
+		"first":  "FIRST_BOT_TOKEN_HERE",
+		"second": "SECOND_BOT_TOKEN_HERE",
+	}
+	for name, token := range bots {
+		err := manager.Start(name, &gotgproto.AccountOpts{
+			// Get AppID from https://my.telegram.org/apps
+			AppId: 123456,
+			// Get ApiHash from https://my.telegram.org/apps
+			ApiHash:    "API_HASH_HERE",
+			ClientType: gotgproto.ClientTypeBot(token),
+			ClientOpts: &gotgproto.ClientOpts{DisableCopyright: true},
+		})
+		if err != nil {
+			log.Fatalf("failed to start %s: %v", name, err)
+		}
+	}
+
+	// Crashed clients are restarted by the manager, their state is exposed on a health endpoint.
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if !manager.Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		for name, health := range manager.Health() {
+			fmt.Fprintf(w, "%s: %s (restarts: %d, error: %v)\n", name, health.State, health.Restarts, health.Err)
+		}
+	})
+	go func() {
+		log.Println(http.ListenAndServe(":8080", nil))
+	}()
+
+	manager.Idle()
+}
+
+func echo(ctx *ext.Context, update *ext.Update) error {
+	_, err := ctx.Reply(update, ext.ReplyTextString(update.EffectiveMessage.Text), nil)
+	return err
+}
@@ -15,9 +15,9 @@ import (
 	"github.com/celestix/gotgproto/types"
 	"github.com/gotd/td/telegram/downloader"
 	"github.com/gotd/td/telegram/message"
-	"github.com/gotd/td/telegram/message/entity"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
+	"go.uber.org/multierr"
 )
 
 // Context consists of context.Context, tg.Client, Self etc.
@@ -36,6 +36,9 @@ type Context struct {
 	setReply    bool
 	random      *rand.Rand
 	PeerStorage *storage.PeerStorage
+	// CallbackMiddleware wraps the callbacks run through RunCallback, it is set by the dispatcher
+	// to the middlewares of the handler being executed.
+	CallbackMiddleware func(next func(*Context, *Update) error) func(*Context, *Update) error
 }
 
 // NewContext creates a new Context object with provided parameters.
@@ -108,8 +111,36 @@ func ReplyTextStyledTextArray(s []styling.StyledTextOption) ReplyTextType {
 	return &r
 }
 
+type ReplyTextTypeHTML string
+
+func (*ReplyTextTypeHTML) construct() {}
+
+func (r ReplyTextTypeHTML) get() string {
+	return string(r)
+}
+
+// ReplyTextHTML formats the provided Telegram-style HTML, check parsemode.HTML for the supported tags.
+func ReplyTextHTML(s string) ReplyTextType {
+	r := ReplyTextTypeHTML(s)
+	return &r
+}
+
+type ReplyTextTypeMarkdownV2 string
+
+func (*ReplyTextTypeMarkdownV2) construct() {}
+
+func (r ReplyTextTypeMarkdownV2) get() string {
+	return string(r)
+}
+
+// ReplyTextMarkdownV2 formats the provided text written in MarkdownV2, check parsemode.MarkdownV2 for the syntax.
+func ReplyTextMarkdownV2(s string) ReplyTextType {
+	r := ReplyTextTypeMarkdownV2(s)
+	return &r
+}
+
 // Reply uses given message update to create message for same chat and create a reply.
-// Parameter 'text' interface should be one from string or an array of styling.StyledTextOption.
+// Parameter 'text' interface should be one from string, HTML, MarkdownV2 or an array of styling.StyledTextOption.
 func (ctx *Context) Reply(upd *Update, text ReplyTextType, opts *ReplyOpts) (*types.Message, error) {
 	if text == nil {
 		return nil, mtp_errors.ErrTextEmpty
@@ -117,55 +148,62 @@ func (ctx *Context) Reply(upd *Update, text ReplyTextType, opts *ReplyOpts) (*ty
 	if opts == nil {
 		opts = &ReplyOpts{}
 	}
-	builder := ctx.Sender.Reply(*ctx.Entities, upd.UpdateClass.(message.AnswerableMessageUpdate))
-	if opts.NoWebpage {
-		builder = builder.NoWebpage()
+	replyTo := opts.ReplyToMessageId
+	if replyTo == 0 {
+		replyTo = upd.UpdateClass.(message.AnswerableMessageUpdate).GetMessage().GetID()
 	}
-	if opts.Markup != nil {
-		builder = builder.Markup(opts.Markup)
+	peer, err := ctx.ResolveInputPeer(upd.ChatID())
+	if err != nil {
+		return nil, err
 	}
-	if opts.ReplyToMessageId != 0 {
-		builder = builder.Reply(opts.ReplyToMessageId)
+	// The text is formatted once and sent with its entities as they are.
+	msgText, entities, err := ctx.formatText(text)
+	if err != nil {
+		return nil, err
 	}
-	var m = &tg.Message{}
-	switch text := (text).(type) {
-	case *ReplyTextTypeString:
-		m.Message = text.get()
-		u, err := builder.Text(ctx, text.get())
-		m, err = functions.ReturnNewMessageWithError(m, u, ctx.PeerStorage, err)
-		if err != nil {
-			return nil, err
-		}
-	case *ReplyTextTypeStyledText:
-		tb := entity.Builder{}
-		if err := styling.Perform(&tb, text.get()); err != nil {
-			return nil, err
-		}
-		m.Message, _ = tb.Complete()
-		u, err := builder.StyledText(ctx, text.get())
-		m, err = functions.ReturnNewMessageWithError(m, u, ctx.PeerStorage, err)
-		if err != nil {
-			return nil, err
-		}
-	case *ReplyTextTypeStyledTextArray:
-		tb := entity.Builder{}
-		if err := styling.Perform(&tb, text.get()...); err != nil {
-			return nil, err
-		}
-		m.Message, _ = tb.Complete()
-		u, err := builder.StyledText(ctx, text.get()...)
-		m, err = functions.ReturnNewMessageWithError(m, u, ctx.PeerStorage, err)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, mtp_errors.ErrTextInvalid
+	u, err := ctx.Raw.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		NoWebpage:   opts.NoWebpage,
+		Peer:        peer,
+		ReplyTo:     &tg.InputReplyToMessage{ReplyToMsgID: replyTo},
+		Message:     msgText,
+		RandomID:    ctx.generateRandomID(),
+		ReplyMarkup: opts.Markup,
+		Entities:    entities,
+	})
+	m, err := functions.ReturnNewMessageWithError(&tg.Message{Message: msgText}, u, ctx.PeerStorage, err)
+	if err != nil {
+		return nil, err
 	}
 	msg := types.ConstructMessage(m)
 	msg.ReplyToMessage = upd.EffectiveMessage
 	return msg, nil
 }
 
+// resolveInputUser returns the tg.InputUserClass of a user id, it returns an error if the user can't be resolved
+// since mentioning a user with a wrong access hash fails the whole request.
+func (ctx *Context) resolveInputUser(id int64) (tg.InputUserClass, error) {
+	peer, err := ctx.ResolveInputPeerById(id)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := functions.InputUserFromPeer(peer)
+	if !ok {
+		return nil, mtp_errors.ErrNotUser
+	}
+	return user, nil
+}
+
+// ResolveInputPeerById returns the tg.InputPeerClass of the provided bare or Bot API-style marked id, recovering
+// the access hash of peers which are missing from the peer storage, see functions.ResolveInputPeerById.
+func (ctx *Context) ResolveInputPeerById(id int64) (tg.InputPeerClass, error) {
+	return functions.ResolveInputPeerById(ctx, ctx.Raw, ctx.PeerStorage, id)
+}
+
+// ResolveInputPeer returns the tg.InputPeerClass of the provided peer, see ResolveInputPeerById.
+func (ctx *Context) ResolveInputPeer(id storage.PeerID) (tg.InputPeerClass, error) {
+	return functions.ResolveInputPeer(ctx, ctx.Raw, ctx.PeerStorage, id)
+}
+
 // SendMessage invokes method messages.sendMessage#d9d75a4 returning error if any.
 func (ctx *Context) SendMessage(chatId int64, request *tg.MessagesSendMessageRequest) (*types.Message, error) {
 	if request == nil {
@@ -173,7 +211,11 @@ func (ctx *Context) SendMessage(chatId int64, request *tg.MessagesSendMessageReq
 	}
 	request.RandomID = ctx.generateRandomID()
 	if request.Peer == nil {
-		request.Peer = functions.GetInputPeerClassFromId(ctx.PeerStorage, chatId)
+		peer, err := ctx.ResolveInputPeerById(chatId)
+		if err != nil {
+			return nil, err
+		}
+		request.Peer = peer
 	}
 	var m = &tg.Message{}
 	m.Message = request.Message
@@ -196,7 +238,11 @@ func (ctx *Context) SendMedia(chatId int64, request *tg.MessagesSendMediaRequest
 	}
 	request.RandomID = ctx.generateRandomID()
 	if request.Peer == nil {
-		request.Peer = functions.GetInputPeerClassFromId(ctx.PeerStorage, chatId)
+		peer, err := ctx.ResolveInputPeerById(chatId)
+		if err != nil {
+			return nil, err
+		}
+		request.Peer = peer
 	}
 	var m = &tg.Message{}
 	m.Message = request.Message
@@ -219,27 +265,19 @@ func (ctx *Context) SetInlineBotResult(request *tg.MessagesSetInlineBotResultsRe
 }
 
 func (ctx *Context) GetInlineBotResults(chatId int64, botUsername string, request *tg.MessagesGetInlineBotResultsRequest) (*tg.MessagesBotResults, error) {
-	bot := ctx.PeerStorage.GetPeerByUsername(botUsername)
-	if bot.ID == 0 {
-		c, err := ctx.ResolveUsername(botUsername)
-		if err != nil {
-			return nil, err
-		}
-		switch {
-		case c.IsAUser():
-			bot = &storage.Peer{
-				ID:         c.GetID(),
-				AccessHash: c.GetAccessHash(),
-			}
-		default:
-			return nil, errors.New("provided username was invalid for a bot")
-		}
+	bot, err := ctx.ResolvePeer(botUsername)
+	if err != nil {
+		return nil, err
 	}
-	request.Peer = ctx.PeerStorage.GetInputPeerById(chatId)
-	request.Bot = &tg.InputUser{
-		UserID:     bot.ID,
-		AccessHash: bot.AccessHash,
+	if !bot.IsAUser() {
+		return nil, errors.New("provided username was invalid for a bot")
 	}
+	peer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return nil, err
+	}
+	request.Peer = peer
+	request.Bot = bot.GetInputUser()
 	return ctx.Raw.MessagesGetInlineBotResults(ctx, request)
 }
 
@@ -252,7 +290,11 @@ func (ctx *Context) SendInlineBotResult(chatId int64, request *tg.MessagesSendIn
 	}
 	request.RandomID = ctx.generateRandomID()
 	if request.Peer == nil {
-		request.Peer = functions.GetInputPeerClassFromId(ctx.PeerStorage, chatId)
+		peer, err := ctx.ResolveInputPeerById(chatId)
+		if err != nil {
+			return nil, err
+		}
+		request.Peer = peer
 	}
 	return ctx.Raw.MessagesSendInlineBotResult(ctx, request)
 }
@@ -263,7 +305,11 @@ func (ctx *Context) SendReaction(chatId int64, request *tg.MessagesSendReactionR
 		request = &tg.MessagesSendReactionRequest{}
 	}
 	if request.Peer == nil {
-		request.Peer = functions.GetInputPeerClassFromId(ctx.PeerStorage, chatId)
+		peer, err := ctx.ResolveInputPeerById(chatId)
+		if err != nil {
+			return nil, err
+		}
+		request.Peer = peer
 	}
 	var m = &tg.Message{}
 	// m.Message = request.Reaction
@@ -285,7 +331,11 @@ func (ctx *Context) SendMultiMedia(chatId int64, request *tg.MessagesSendMultiMe
 		request = &tg.MessagesSendMultiMediaRequest{}
 	}
 	if request.Peer == nil {
-		request.Peer = functions.GetInputPeerClassFromId(ctx.PeerStorage, chatId)
+		peer, err := ctx.ResolveInputPeerById(chatId)
+		if err != nil {
+			return nil, err
+		}
+		request.Peer = peer
 	}
 	u, err := ctx.Raw.MessagesSendMultiMedia(ctx, request)
 	message, err := functions.ReturnNewMessageWithError(&tg.Message{}, u, ctx.PeerStorage, err)
@@ -313,7 +363,11 @@ func (ctx *Context) EditMessage(chatId int64, request *tg.MessagesEditMessageReq
 		request = &tg.MessagesEditMessageRequest{}
 	}
 	if request.Peer == nil {
-		request.Peer = functions.GetInputPeerClassFromId(ctx.PeerStorage, chatId)
+		peer, err := ctx.ResolveInputPeerById(chatId)
+		if err != nil {
+			return nil, err
+		}
+		request.Peer = peer
 	}
 	upds, err := ctx.Raw.MessagesEditMessage(ctx, request)
 	message, err := functions.ReturnEditMessageWithError(ctx.PeerStorage, upds, err)
@@ -329,22 +383,19 @@ func (ctx *Context) EditMessage(chatId int64, request *tg.MessagesEditMessageReq
 
 // GetChat returns tg.ChatFullClass of the provided chat id.
 func (ctx *Context) GetChat(chatId int64) (tg.ChatFullClass, error) {
-	peer := ctx.PeerStorage.GetPeerById(chatId)
-	if peer.ID == 0 {
-		return nil, mtp_errors.ErrPeerNotFound
-	}
-	switch storage.EntityType(peer.Type) {
-	case storage.TypeChannel:
-		channel, err := ctx.Raw.ChannelsGetFullChannel(ctx, &tg.InputChannel{
-			ChannelID:  peer.ID,
-			AccessHash: peer.AccessHash,
-		})
+	peer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return nil, err
+	}
+	if channel, ok := functions.InputChannelFromPeer(peer); ok {
+		channel, err := ctx.Raw.ChannelsGetFullChannel(ctx, channel)
 		if err != nil {
 			return nil, err
 		}
 		return channel.FullChat, nil
-	case storage.TypeChat:
-		chat, err := ctx.Raw.MessagesGetFullChat(ctx, chatId)
+	}
+	if c, ok := peer.(*tg.InputPeerChat); ok {
+		chat, err := ctx.Raw.MessagesGetFullChat(ctx, c.ChatID)
 		if err != nil {
 			return nil, err
 		}
@@ -355,22 +406,19 @@ func (ctx *Context) GetChat(chatId int64) (tg.ChatFullClass, error) {
 
 // GetUser returns tg.UserFull of the provided user id.
 func (ctx *Context) GetUser(userId int64) (*tg.UserFull, error) {
-	peer := ctx.PeerStorage.GetPeerById(userId)
-	if peer.ID == 0 {
-		return nil, mtp_errors.ErrPeerNotFound
-	}
-	if peer.Type == storage.TypeUser.GetInt() {
-		user, err := ctx.Raw.UsersGetFullUser(ctx, &tg.InputUser{
-			UserID:     peer.ID,
-			AccessHash: peer.AccessHash,
-		})
-		if err != nil {
-			return nil, err
-		}
-		return &user.FullUser, nil
-	} else {
+	peer, err := ctx.ResolveInputPeerById(userId)
+	if err != nil {
+		return nil, err
+	}
+	inputUser, ok := functions.InputUserFromPeer(peer)
+	if !ok {
 		return nil, mtp_errors.ErrNotUser
 	}
+	user, err := ctx.Raw.UsersGetFullUser(ctx, inputUser)
+	if err != nil {
+		return nil, err
+	}
+	return &user.FullUser, nil
 }
 
 // GetMessages is used to fetch messages from a PM (Private Chat).
@@ -380,93 +428,75 @@ func (ctx *Context) GetMessages(chatId int64, messageIds []tg.InputMessageClass)
 
 // BanChatMember is used to ban a user from a chat.
 func (ctx *Context) BanChatMember(chatId, userId int64, untilDate int) (tg.UpdatesClass, error) {
-	peerChatStorage := ctx.PeerStorage.GetPeerById(chatId)
-	if peerChatStorage.ID == 0 {
-		return nil, mtp_errors.ErrPeerNotFound
-	}
-	var chatPeer tg.InputPeerClass
-	switch storage.EntityType(peerChatStorage.Type) {
-	case storage.TypeChannel:
-		chatPeer = &tg.InputPeerChannel{
-			ChannelID:  peerChatStorage.ID,
-			AccessHash: peerChatStorage.AccessHash,
-		}
-	case storage.TypeChat:
-		chatPeer = &tg.InputPeerChat{
-			ChatID: peerChatStorage.ID,
-		}
+	chatPeer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return nil, err
 	}
-	peerUser := ctx.PeerStorage.GetPeerById(userId)
-	if peerUser.ID == 0 {
-		return nil, mtp_errors.ErrPeerNotFound
+	userPeer, err := ctx.ResolveInputPeerById(userId)
+	if err != nil {
+		return nil, err
 	}
-	return functions.BanChatMember(ctx, ctx.Raw, chatPeer, &tg.InputPeerUser{
-		UserID:     peerUser.ID,
-		AccessHash: peerUser.AccessHash,
-	}, untilDate)
+	return functions.BanChatMember(ctx, ctx.Raw, chatPeer, userPeer, untilDate)
 }
 
 // UnbanChatMember is used to unban a user from a chat.
 func (ctx *Context) UnbanChatMember(chatId, userId int64) (bool, error) {
-	peerChatStorage := ctx.PeerStorage.GetPeerById(chatId)
-	if peerChatStorage.ID == 0 {
-		return false, mtp_errors.ErrPeerNotFound
-	}
-	var chatPeer *tg.InputPeerChannel
-	switch storage.EntityType(peerChatStorage.Type) {
-	case storage.TypeChannel:
-		chatPeer = &tg.InputPeerChannel{
-			ChannelID:  peerChatStorage.ID,
-			AccessHash: peerChatStorage.AccessHash,
-		}
-	default:
+	chatPeer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := functions.InputChannelFromPeer(chatPeer); !ok {
 		return false, mtp_errors.ErrNotChannel
 	}
-	peerUser := ctx.PeerStorage.GetPeerById(userId)
-	if peerUser.ID == 0 {
-		return false, mtp_errors.ErrPeerNotFound
+	userPeer, err := ctx.ResolveInputPeerById(userId)
+	if err != nil {
+		return false, err
 	}
-	return functions.UnbanChatMember(ctx, ctx.Raw, chatPeer, &tg.InputPeerUser{
-		UserID:     peerUser.ID,
-		AccessHash: peerUser.AccessHash,
-	})
+	return functions.UnbanChatMember(ctx, ctx.Raw, chatPeer, userPeer)
 }
 
 // AddChatMembers is used to add members to a chat
 func (ctx *Context) AddChatMembers(chatId int64, userIds []int64, forwardLimit int) (bool, error) {
-	peerChatStorage := ctx.PeerStorage.GetPeerById(chatId)
-	if peerChatStorage.ID == 0 {
-		return false, mtp_errors.ErrPeerNotFound
-	}
-	var chatPeer tg.InputPeerClass
-	switch storage.EntityType(peerChatStorage.Type) {
-	case storage.TypeChannel:
-		chatPeer = &tg.InputPeerChannel{
-			ChannelID:  peerChatStorage.ID,
-			AccessHash: peerChatStorage.AccessHash,
-		}
-	case storage.TypeChat:
-		chatPeer = &tg.InputPeerChat{
-			ChatID: peerChatStorage.ID,
-		}
+	chatPeer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return false, err
+	}
+	switch chatPeer.(type) {
+	case *tg.InputPeerChannel, *tg.InputPeerChannelFromMessage, *tg.InputPeerChat:
 	default:
 		return false, mtp_errors.ErrNotChat
 	}
+	userPeers, err := ctx.resolveInputUsers(userIds)
+	if err != nil {
+		return false, err
+	}
+	return functions.AddChatMembers(ctx, ctx.Raw, chatPeer, userPeers, forwardLimit)
+}
+
+// resolveInputUsers returns the tg.InputUserClass of each of the provided user ids.
+func (ctx *Context) resolveInputUsers(userIds []int64) ([]tg.InputUserClass, error) {
 	userPeers := make([]tg.InputUserClass, len(userIds))
 	for i, uId := range userIds {
-		userPeer := ctx.PeerStorage.GetPeerById(uId)
-		if userPeer.ID == 0 {
-			return false, mtp_errors.ErrPeerNotFound
-		}
-		if userPeer.Type != int(storage.TypeUser) {
-			return false, mtp_errors.ErrNotUser
+		user, err := ctx.resolveInputUser(uId)
+		if err != nil {
+			return nil, err
 		}
-		userPeers[i] = &tg.InputUser{
-			UserID:     userPeer.ID,
-			AccessHash: userPeer.AccessHash,
+		userPeers[i] = user
+	}
+	return userPeers, nil
+}
+
+// resolveInputPeers returns the tg.InputPeerClass of each of the provided ids.
+func (ctx *Context) resolveInputPeers(ids []int64) ([]tg.InputPeerClass, error) {
+	peers := make([]tg.InputPeerClass, len(ids))
+	for i, id := range ids {
+		peer, err := ctx.ResolveInputPeerById(id)
+		if err != nil {
+			return nil, err
 		}
+		peers[i] = peer
 	}
-	return functions.AddChatMembers(ctx, ctx.Raw, chatPeer, userPeers, forwardLimit)
+	return peers, nil
 }
 
 // ArchiveChats invokes method folders.editPeerFolders#6847d0ab returning error if any.
@@ -475,28 +505,9 @@ func (ctx *Context) AddChatMembers(chatId int64, userIds []int64, forwardLimit i
 // Links:
 //  1. https://core.telegram.org/api/folders#peer-folders
 func (ctx *Context) ArchiveChats(chatIds []int64) (bool, error) {
-	chatPeers := make([]tg.InputPeerClass, len(chatIds))
-	for i, chatId := range chatIds {
-		peer := ctx.PeerStorage.GetPeerById(chatId)
-		if peer.ID == 0 {
-			return false, mtp_errors.ErrPeerNotFound
-		}
-		switch storage.EntityType(peer.Type) {
-		case storage.TypeChannel:
-			chatPeers[i] = &tg.InputPeerChannel{
-				ChannelID:  peer.ID,
-				AccessHash: peer.AccessHash,
-			}
-		case storage.TypeUser:
-			chatPeers[i] = &tg.InputPeerUser{
-				UserID:     peer.ID,
-				AccessHash: peer.AccessHash,
-			}
-		case storage.TypeChat:
-			chatPeers[i] = &tg.InputPeerChat{
-				ChatID: peer.ID,
-			}
-		}
+	chatPeers, err := ctx.resolveInputPeers(chatIds)
+	if err != nil {
+		return false, err
 	}
 	return functions.ArchiveChats(ctx, ctx.Raw, chatPeers)
 }
@@ -507,28 +518,9 @@ func (ctx *Context) ArchiveChats(chatIds []int64) (bool, error) {
 // Links:
 //  1. https://core.telegram.org/api/folders#peer-folders
 func (ctx *Context) UnarchiveChats(chatIds []int64) (bool, error) {
-	chatPeers := make([]tg.InputPeerClass, len(chatIds))
-	for i, chatId := range chatIds {
-		peer := ctx.PeerStorage.GetPeerById(chatId)
-		if peer.ID == 0 {
-			return false, mtp_errors.ErrPeerNotFound
-		}
-		switch storage.EntityType(peer.Type) {
-		case storage.TypeChannel:
-			chatPeers[i] = &tg.InputPeerChannel{
-				ChannelID:  peer.ID,
-				AccessHash: peer.AccessHash,
-			}
-		case storage.TypeUser:
-			chatPeers[i] = &tg.InputPeerUser{
-				UserID:     peer.ID,
-				AccessHash: peer.AccessHash,
-			}
-		case storage.TypeChat:
-			chatPeers[i] = &tg.InputPeerChat{
-				ChatID: peer.ID,
-			}
-		}
+	chatPeers, err := ctx.resolveInputPeers(chatIds)
+	if err != nil {
+		return false, err
 	}
 	return functions.UnarchiveChats(ctx, ctx.Raw, chatPeers)
 }
@@ -544,19 +536,9 @@ func (ctx *Context) CreateChannel(title, about string, broadcast bool) (*tg.Chan
 
 // CreateChat invokes method messages.createChat#9cb126e returning error if any. Creates a new chat.
 func (ctx *Context) CreateChat(title string, userIds []int64) (*tg.Chat, error) {
-	userPeers := make([]tg.InputUserClass, len(userIds))
-	for i, uId := range userIds {
-		userPeer := ctx.PeerStorage.GetPeerById(uId)
-		if userPeer.ID == 0 {
-			return nil, mtp_errors.ErrPeerNotFound
-		}
-		if userPeer.Type != int(storage.TypeUser) {
-			return nil, mtp_errors.ErrNotUser
-		}
-		userPeers[i] = &tg.InputUser{
-			UserID:     userPeer.ID,
-			AccessHash: userPeer.AccessHash,
-		}
+	userPeers, err := ctx.resolveInputUsers(userIds)
+	if err != nil {
+		return nil, err
 	}
 	return functions.CreateChat(ctx, ctx.Raw, ctx.PeerStorage, title, userPeers)
 }
@@ -564,27 +546,25 @@ func (ctx *Context) CreateChat(title string, userIds []int64) (*tg.Chat, error)
 // DeleteMessages shall be used to delete messages in a chat with chatId and messageIDs.
 // Returns error if failed to delete.
 func (ctx *Context) DeleteMessages(chatId int64, messageIDs []int) error {
-	peer := ctx.PeerStorage.GetPeerById(chatId)
-	if peer.ID == 0 {
-		return mtp_errors.ErrPeerNotFound
+	peer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return err
 	}
-	switch storage.EntityType(peer.Type) {
-	case storage.TypeChat:
+	if channel, ok := functions.InputChannelFromPeer(peer); ok {
+		_, err := ctx.Raw.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+			Channel: channel,
+			ID:      messageIDs,
+		})
+		return err
+	}
+	switch peer.(type) {
+	case *tg.InputPeerChat:
 		_, err := ctx.Raw.MessagesDeleteMessages(ctx, &tg.MessagesDeleteMessagesRequest{
 			Revoke: true,
 			ID:     messageIDs,
 		})
 		return err
-	case storage.TypeChannel:
-		_, err := ctx.Raw.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
-			Channel: &tg.InputChannel{
-				ChannelID:  peer.ID,
-				AccessHash: peer.AccessHash,
-			},
-			ID: messageIDs,
-		})
-		return err
-	case storage.TypeUser:
+	case *tg.InputPeerUser, *tg.InputPeerUserFromMessage:
 		return mtp_errors.ErrNotChat
 	default:
 		return mtp_errors.ErrPeerNotFound
@@ -602,13 +582,13 @@ func (ctx *Context) ForwardMessage(fromChatId, toChatId int64, request *tg.Messa
 // ForwardMessages shall be used to forward messages in a chat with chatId and messageIDs.
 // Returns updatesclass or an error if failed to delete.
 func (ctx *Context) ForwardMessages(fromChatId, toChatId int64, request *tg.MessagesForwardMessagesRequest) (tg.UpdatesClass, error) {
-	fromPeer := ctx.PeerStorage.GetInputPeerById(fromChatId)
-	if fromPeer.Zero() {
-		return nil, fmt.Errorf("fromChatId: %w", mtp_errors.ErrPeerNotFound)
+	fromPeer, err := ctx.ResolveInputPeerById(fromChatId)
+	if err != nil {
+		return nil, fmt.Errorf("fromChatId: %w", err)
 	}
-	toPeer := ctx.PeerStorage.GetInputPeerById(toChatId)
-	if toPeer.Zero() {
-		return nil, fmt.Errorf("toChatId: %w", mtp_errors.ErrPeerNotFound)
+	toPeer, err := ctx.ResolveInputPeerById(toChatId)
+	if err != nil {
+		return nil, fmt.Errorf("toChatId: %w", err)
 	}
 	if request == nil {
 		request = &tg.MessagesForwardMessagesRequest{}
@@ -642,34 +622,42 @@ type EditAdminOpts struct {
 
 // PromoteChatMember is used to promote a user in a chat.
 func (ctx *Context) PromoteChatMember(chatId, userId int64, opts *EditAdminOpts) (bool, error) {
-	peerChat := ctx.PeerStorage.GetPeerById(chatId)
-	if peerChat.ID == 0 {
-		return false, fmt.Errorf("chat: %w", mtp_errors.ErrPeerNotFound)
+	chatPeer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return false, fmt.Errorf("chat: %w", err)
 	}
-	peerUser := ctx.PeerStorage.GetPeerById(userId)
-	if peerUser.ID == 0 {
-		return false, fmt.Errorf("user: %w", mtp_errors.ErrPeerNotFound)
+	userPeer, err := ctx.ResolveInputPeerById(userId)
+	if err != nil {
+		return false, fmt.Errorf("user: %w", err)
+	}
+	user, ok := functions.InputUserFromPeer(userPeer)
+	if !ok {
+		return false, fmt.Errorf("user: %w", mtp_errors.ErrNotUser)
 	}
 	if opts == nil {
 		opts = &EditAdminOpts{}
 	}
-	return functions.PromoteChatMember(ctx, ctx.Raw, peerChat, peerUser, opts.AdminRights, opts.AdminTitle)
+	return functions.EditChatAdmin(ctx, ctx.Raw, chatPeer, user, opts.AdminRights, opts.AdminTitle, true)
 }
 
 // DemoteChatMember is used to demote a user in a chat.
 func (ctx *Context) DemoteChatMember(chatId, userId int64, opts *EditAdminOpts) (bool, error) {
-	peerChat := ctx.PeerStorage.GetPeerById(chatId)
-	if peerChat.ID == 0 {
-		return false, fmt.Errorf("chat: %w", mtp_errors.ErrPeerNotFound)
+	chatPeer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return false, fmt.Errorf("chat: %w", err)
+	}
+	userPeer, err := ctx.ResolveInputPeerById(userId)
+	if err != nil {
+		return false, fmt.Errorf("user: %w", err)
 	}
-	peerUser := ctx.PeerStorage.GetPeerById(userId)
-	if peerUser.ID == 0 {
-		return false, fmt.Errorf("user: %w", mtp_errors.ErrPeerNotFound)
+	user, ok := functions.InputUserFromPeer(userPeer)
+	if !ok {
+		return false, fmt.Errorf("user: %w", mtp_errors.ErrNotUser)
 	}
 	if opts == nil {
 		opts = &EditAdminOpts{}
 	}
-	return functions.DemoteChatMember(ctx, ctx.Raw, peerChat, peerUser, opts.AdminRights, opts.AdminTitle)
+	return functions.EditChatAdmin(ctx, ctx.Raw, chatPeer, user, opts.AdminRights, opts.AdminTitle, false)
 }
 
 // ResolveUsername invokes method contacts.resolveUsername#f93ccba3 returning error if any.
@@ -718,17 +706,18 @@ func (ctx *Context) extractContactResolvedPeer(p *tg.ContactsResolvedPeer, err e
 
 // GetUserProfilePhotos invokes method photos.getUserPhotos#91cd32a8 returning error if any. Returns the list of user photos.
 func (ctx *Context) GetUserProfilePhotos(userId int64, opts *tg.PhotosGetUserPhotosRequest) ([]tg.PhotoClass, error) {
-	peerUser := ctx.PeerStorage.GetPeerById(userId)
-	if peerUser.ID == 0 {
-		return nil, mtp_errors.ErrPeerNotFound
+	peer, err := ctx.ResolveInputPeerById(userId)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := functions.InputUserFromPeer(peer)
+	if !ok {
+		return nil, mtp_errors.ErrNotUser
 	}
 	if opts == nil {
 		opts = &tg.PhotosGetUserPhotosRequest{}
 	}
-	opts.UserID = &tg.InputUser{
-		UserID:     userId,
-		AccessHash: peerUser.AccessHash,
-	}
+	opts.UserID = user
 	p, err := ctx.Raw.PhotosGetUserPhotos(ctx, &tg.PhotosGetUserPhotosRequest{
 		UserID: opts.UserID,
 	})
@@ -786,13 +775,24 @@ type DownloadMediaOpts struct {
 	Verify *bool
 	// PartSize sets chunk size. Must be divisible by 4KB.
 	PartSize int
+	// Progress is called after every downloaded chunk.
+	Progress DownloadProgress
+	// Resume continues the download to a DownloadOutputPath from the already downloaded part of the file.
+	// A resumed download is made by a single goroutine whatever Threads is, so that the file only
+	// contains the parts which were downloaded in order and never ends with zeroed parts.
+	Resume bool
+	// ChatId and MessageId of the message containing the media, they are used to
+	// fetch the message again and refresh the file reference if it expires.
+	ChatId    int64
+	MessageId int
 }
 
 // DownloadMedia downloads media from the provided MessageMediaClass.
 // DownloadOutputClass can be one from DownloadOutputStream, DownloadOutputPath and DownloadOutputParallel.
 // DownloadMediaOpts can be used to set optional parameters.
+// The download can be cancelled by cancelling the context of ctx, check Context.WithContext.
 // Returns tg.StorageFileTypeClass and error if any.
-func (ctx *Context) DownloadMedia(media tg.MessageMediaClass, downloadOutput DownloadOutputClass, opts *DownloadMediaOpts) (tg.StorageFileTypeClass, error) {
+func (ctx *Context) DownloadMedia(media tg.MessageMediaClass, downloadOutput DownloadOutputClass, opts *DownloadMediaOpts) (_ tg.StorageFileTypeClass, err error) {
 	if opts == nil {
 		opts = &DownloadMediaOpts{}
 	}
@@ -804,9 +804,33 @@ func (ctx *Context) DownloadMedia(media tg.MessageMediaClass, downloadOutput Dow
 	if err != nil {
 		return nil, err
 	}
-	d := mediaDownloader.Download(ctx.Raw, inputFileLocation)
-	if opts.Threads > 0 {
-		d.WithThreads(opts.Threads)
+	client := &downloadClient{
+		Client:   ctx.Raw,
+		ctx:      ctx,
+		total:    mediaSize(media),
+		progress: opts.Progress,
+		chatId:   opts.ChatId,
+		msgId:    opts.MessageId,
+		location: inputFileLocation,
+	}
+	threads := opts.Threads
+	if path, ok := downloadOutput.(DownloadOutputPath); ok {
+		f, offset, err := path.open(opts.Resume)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			multierr.AppendInto(&err, f.Close())
+		}()
+		client.offset = offset
+		downloadOutput = DownloadOutputParallel{io.NewOffsetWriter(f, offset)}
+		if opts.Resume {
+			threads = 1
+		}
+	}
+	d := mediaDownloader.Download(client, inputFileLocation)
+	if threads > 0 {
+		d.WithThreads(threads)
 	}
 	if opts.Verify != nil {
 		d.WithVerify(*opts.Verify)
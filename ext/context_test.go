@@ -0,0 +1,423 @@
+package ext_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	mtp_errors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+var (
+	alice = gotgprototest.User(10, "Alice")
+	group = gotgprototest.Channel(100, "Group", true)
+)
+
+func newClient() *gotgprototest.Client {
+	c := gotgprototest.NewClient(nil)
+	c.AddUsers(alice)
+	c.AddChats(group)
+	return c
+}
+
+func TestContextReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     ext.ReplyTextType
+		message  string
+		entities int
+	}{
+		{name: "string", text: ext.ReplyTextString("pong"), message: "pong"},
+		{name: "html", text: ext.ReplyTextHTML("<b>pong</b> <i>!</i>"), message: "pong !", entities: 2},
+		{name: "markdown", text: ext.ReplyTextMarkdownV2("*pong* \\!"), message: "pong !", entities: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient()
+			var (
+				reply *ext.Update
+				sent  error
+			)
+			c.Dispatcher.AddHandler(handlers.NewCommand("ping", func(ctx *ext.Context, u *ext.Update) error {
+				reply = u
+				_, sent = ctx.Reply(u, tt.text, nil)
+				return nil
+			}))
+			_ = c.Handle(gotgprototest.NewMessage(gotgprototest.TextMessage(7, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, "/ping")))
+			if reply == nil {
+				t.Fatal("command was not handled")
+			}
+			if sent != nil {
+				t.Fatal(sent)
+			}
+			req := gotgprototest.ExpectRequest[*tg.MessagesSendMessageRequest](t, c.Invoker)
+			if req.Message != tt.message || len(req.Entities) != tt.entities {
+				t.Fatalf("unexpected message %q with entities %v", req.Message, req.Entities)
+			}
+			peer, ok := req.Peer.(*tg.InputPeerChannel)
+			if !ok || peer.ChannelID != group.ID || peer.AccessHash != group.AccessHash {
+				t.Fatalf("unexpected peer %v", req.Peer)
+			}
+			replyTo, ok := req.ReplyTo.(*tg.InputReplyToMessage)
+			if !ok || replyTo.ReplyToMsgID != 7 {
+				t.Fatalf("unexpected reply to %v", req.ReplyTo)
+			}
+		})
+	}
+}
+
+func TestContextReplyMention(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    ext.ReplyTextType
+		wantErr bool
+	}{
+		{name: "html", text: ext.ReplyTextHTML(`<a href="tg://user?id=10">Alice</a>`)},
+		{name: "markdown", text: ext.ReplyTextMarkdownV2("[Alice](tg://user?id=10)")},
+		{name: "unknown user", text: ext.ReplyTextMarkdownV2("[Nobody](tg://user?id=99)"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient()
+			var sent error
+			c.Dispatcher.AddHandler(handlers.NewCommand("ping", func(ctx *ext.Context, u *ext.Update) error {
+				_, sent = ctx.Reply(u, tt.text, nil)
+				return nil
+			}))
+			_ = c.Handle(gotgprototest.NewMessage(gotgprototest.TextMessage(7, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, "/ping")))
+			if (sent != nil) != tt.wantErr {
+				t.Fatalf("unexpected error %v", sent)
+			}
+			if tt.wantErr {
+				gotgprototest.ExpectNoRequest[*tg.MessagesSendMessageRequest](t, c.Invoker)
+				return
+			}
+			req := gotgprototest.ExpectRequest[*tg.MessagesSendMessageRequest](t, c.Invoker)
+			if len(req.Entities) != 1 {
+				t.Fatalf("unexpected entities %v", req.Entities)
+			}
+			mention, ok := req.Entities[0].(*tg.InputMessageEntityMentionName)
+			if !ok {
+				t.Fatalf("unexpected entity %v", req.Entities[0])
+			}
+			if user, ok := mention.UserID.(*tg.InputUser); !ok || user.UserID != alice.ID || user.AccessHash != alice.AccessHash {
+				t.Fatalf("unexpected mentioned user %v", mention.UserID)
+			}
+		})
+	}
+}
+
+func TestContextSendMessage(t *testing.T) {
+	c := newClient()
+	msg, err := c.Context().SendMessage(alice.ID, &tg.MessagesSendMessageRequest{Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID == 0 || msg.Text != "hi" {
+		t.Fatalf("unexpected message %v", msg.Message)
+	}
+	req := gotgprototest.ExpectRequest[*tg.MessagesSendMessageRequest](t, c.Invoker)
+	if peer, ok := req.Peer.(*tg.InputPeerUser); !ok || peer.UserID != alice.ID || peer.AccessHash != alice.AccessHash {
+		t.Fatalf("unexpected peer %v", req.Peer)
+	}
+}
+
+func TestContextSendMessageError(t *testing.T) {
+	c := newClient()
+	c.Invoker.Fail(&tg.MessagesSendMessageRequest{}, gotgprototest.RPCError(403, "CHAT_WRITE_FORBIDDEN"))
+	if _, err := c.Context().SendMessage(group.ID, &tg.MessagesSendMessageRequest{Message: "hi"}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestContextEditMessage(t *testing.T) {
+	c := newClient()
+	msg, err := c.Context().EditMessage(group.ID, &tg.MessagesEditMessageRequest{ID: 5, Message: "edited"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 5 || msg.Text != "edited" {
+		t.Fatalf("unexpected message %v", msg.Message)
+	}
+	req := gotgprototest.ExpectRequest[*tg.MessagesEditMessageRequest](t, c.Invoker)
+	if peer, ok := req.Peer.(*tg.InputPeerChannel); !ok || peer.ChannelID != group.ID {
+		t.Fatalf("unexpected peer %v", req.Peer)
+	}
+}
+
+func TestContextResolvePeerFromMessage(t *testing.T) {
+	c := newClient()
+	bob := gotgprototest.User(11, "Bob")
+	_ = c.Handle(gotgprototest.NewMessage(gotgprototest.TextMessage(7, &tg.PeerUser{UserID: bob.ID}, &tg.PeerChannel{ChannelID: group.ID}, "hi")))
+	c.Invoker.Reply(&tg.UsersGetUsersRequest{}, &tg.UserClassVector{Elems: []tg.UserClass{bob}})
+	c.Invoker.Reply(&tg.ChannelsEditBannedRequest{}, &tg.Updates{})
+	if _, err := c.Context().BanChatMember(group.ID, bob.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	getUsers := gotgprototest.ExpectRequest[*tg.UsersGetUsersRequest](t, c.Invoker)
+	if user, ok := getUsers.ID[0].(*tg.InputUserFromMessage); !ok || user.UserID != bob.ID || user.MsgID != 7 {
+		t.Fatalf("unexpected input user %v", getUsers.ID[0])
+	}
+	req := gotgprototest.ExpectRequest[*tg.ChannelsEditBannedRequest](t, c.Invoker)
+	if peer, ok := req.Participant.(*tg.InputPeerUser); !ok || peer.UserID != bob.ID || peer.AccessHash != bob.AccessHash {
+		t.Fatalf("unexpected participant %v", req.Participant)
+	}
+	if peer := c.PeerStorage.GetPeerById(bob.ID); peer.AccessHash != bob.AccessHash {
+		t.Fatalf("fetched user was not saved: %+v", peer)
+	}
+}
+
+func TestContextResolvePeerFromDifference(t *testing.T) {
+	c := newClient()
+	bob := gotgprototest.User(11, "Bob")
+	c.Invoker.Reply(&tg.UpdatesGetStateRequest{}, &tg.UpdatesState{Pts: 500, Date: 1})
+	c.Invoker.Reply(&tg.UpdatesGetDifferenceRequest{}, &tg.UpdatesDifference{
+		State: tg.UpdatesState{Pts: 500, Date: 1},
+		Users: []tg.UserClass{bob},
+	})
+	c.Invoker.Reply(&tg.UsersGetFullUserRequest{}, &tg.UsersUserFull{FullUser: tg.UserFull{ID: bob.ID}})
+	user, err := c.Context().GetUser(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != bob.ID {
+		t.Fatalf("unexpected user %v", user)
+	}
+	diff := gotgprototest.ExpectRequest[*tg.UpdatesGetDifferenceRequest](t, c.Invoker)
+	if diff.Pts != 400 {
+		t.Fatalf("unexpected pts %d", diff.Pts)
+	}
+	req := gotgprototest.ExpectRequest[*tg.UsersGetFullUserRequest](t, c.Invoker)
+	if input, ok := req.ID.(*tg.InputUser); !ok || input.AccessHash != bob.AccessHash {
+		t.Fatalf("unexpected input user %v", req.ID)
+	}
+}
+
+func TestContextResolvePeerNotFound(t *testing.T) {
+	c := newClient()
+	c.Invoker.Reply(&tg.UpdatesGetStateRequest{}, &tg.UpdatesState{Pts: 10, Date: 1})
+	c.Invoker.Reply(&tg.UpdatesGetDifferenceRequest{}, &tg.UpdatesDifferenceEmpty{Date: 1})
+	if _, err := c.Context().GetUser(11); !errors.Is(err, mtp_errors.ErrPeerNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestContextResolvePeerRateLimited(t *testing.T) {
+	c := newClient()
+	c.Invoker.Reply(&tg.UpdatesGetStateRequest{}, &tg.UpdatesState{Pts: 10, Date: 1})
+	c.Invoker.Reply(&tg.UpdatesGetDifferenceRequest{}, &tg.UpdatesDifferenceEmpty{Date: 1})
+	for _, id := range []int64{11, 12} {
+		if _, err := c.Context().GetUser(id); !errors.Is(err, mtp_errors.ErrPeerNotFound) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if diffs := gotgprototest.RequestsOf[*tg.UpdatesGetDifferenceRequest](c.Invoker); len(diffs) != 1 {
+		t.Fatalf("expected a single difference, got %d", len(diffs))
+	}
+}
+
+func TestContextResolveBasicGroup(t *testing.T) {
+	c := newClient()
+	peer, err := c.Context().ResolveInputPeerById(-42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat, ok := peer.(*tg.InputPeerChat); !ok || chat.ChatID != 42 {
+		t.Fatalf("unexpected peer %v", peer)
+	}
+	if len(c.Invoker.Requests()) != 0 {
+		t.Fatalf("unexpected requests %v", c.Invoker.Requests())
+	}
+}
+
+func TestContextResolvePeer(t *testing.T) {
+	c := newClient()
+	bot := gotgprototest.Bot(12, "gotgproto_bot")
+	c.AddUsers(bot)
+	ctx := c.Context()
+	for _, ref := range []string{"@gotgproto_bot", "https://t.me/gotgproto_bot", "tg://resolve?domain=gotgproto_bot", "12"} {
+		peer, err := ctx.ResolvePeer(ref)
+		if err != nil {
+			t.Fatalf("%s: %v", ref, err)
+		}
+		if !peer.IsAUser() || peer.GetID() != bot.ID || peer.GetAccessHash() != bot.AccessHash {
+			t.Fatalf("%s: unexpected peer %+v", ref, peer)
+		}
+	}
+	peer, err := ctx.ResolvePeer("-1000000000100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !peer.IsAChannel() || peer.GetID() != group.ID {
+		t.Fatalf("unexpected peer of a marked id %+v", peer)
+	}
+	if len(c.Invoker.Requests()) != 0 {
+		t.Fatalf("unexpected requests for saved peers %v", c.Invoker.Requests())
+	}
+
+	bob := gotgprototest.User(13, "Bob")
+	c.Invoker.Reply(&tg.ContactsResolvePhoneRequest{}, &tg.ContactsResolvedPeer{
+		Peer:  &tg.PeerUser{UserID: bob.ID},
+		Users: []tg.UserClass{bob},
+	})
+	if peer, err := ctx.ResolvePeer("+15551234567"); err != nil || peer.GetID() != bob.ID {
+		t.Fatalf("unexpected peer of a phone number %+v: %v", peer, err)
+	}
+	if req := gotgprototest.ExpectRequest[*tg.ContactsResolvePhoneRequest](t, c.Invoker); req.Phone != "15551234567" {
+		t.Fatalf("unexpected phone %q", req.Phone)
+	}
+
+	joined := gotgprototest.Channel(101, "Joined", true)
+	joined.Photo = &tg.ChatPhotoEmpty{}
+	c.Invoker.Reply(&tg.MessagesCheckChatInviteRequest{}, &tg.ChatInviteAlready{Chat: joined})
+	if peer, err := ctx.ResolvePeer("https://t.me/+AbCdEf123"); err != nil || peer.GetID() != joined.ID {
+		t.Fatalf("unexpected peer of an invite link %+v: %v", peer, err)
+	}
+	if saved := c.PeerStorage.GetPeerById(joined.ID); saved.AccessHash != joined.AccessHash {
+		t.Fatalf("chat of the invite link was not saved: %+v", saved)
+	}
+	c.Invoker.Reply(&tg.MessagesCheckChatInviteRequest{}, &tg.ChatInvite{Title: "Not joined", Photo: &tg.PhotoEmpty{}})
+	if _, err := ctx.ResolvePeer("t.me/joinchat/AbCdEf123"); !errors.Is(err, mtp_errors.ErrInviteNotJoined) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestContextMarkedID(t *testing.T) {
+	c := newClient()
+	// A user with the same bare ID as the group.
+	namesake := gotgprototest.User(group.ID, "Namesake")
+	c.AddUsers(namesake)
+	var chatID, senderID storage.PeerID
+	c.Dispatcher.AddHandler(handlers.NewCommand("id", func(ctx *ext.Context, u *ext.Update) error {
+		chatID, senderID = u.ChatID(), u.SenderID()
+		_, err := ctx.SendMessage(chatID.BotAPI(), &tg.MessagesSendMessageRequest{Message: "hi"})
+		return err
+	}))
+	_ = c.Handle(gotgprototest.NewMessage(gotgprototest.TextMessage(7, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, "/id")))
+	if chatID != storage.NewPeerID(group.ID, storage.TypeChannel) || chatID.BotAPI() != -1000000000100 || senderID.BotAPI() != alice.ID {
+		t.Fatalf("unexpected ids %s %s", chatID, senderID)
+	}
+	req := gotgprototest.ExpectRequest[*tg.MessagesSendMessageRequest](t, c.Invoker)
+	if peer, ok := req.Peer.(*tg.InputPeerChannel); !ok || peer.ChannelID != group.ID || peer.AccessHash != group.AccessHash {
+		t.Fatalf("unexpected peer of a marked id %v", req.Peer)
+	}
+	c.Invoker.Reset()
+	if _, err := c.Context().SendMessage(group.ID, &tg.MessagesSendMessageRequest{Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	req = gotgprototest.ExpectRequest[*tg.MessagesSendMessageRequest](t, c.Invoker)
+	if peer, ok := req.Peer.(*tg.InputPeerUser); !ok || peer.UserID != namesake.ID || peer.AccessHash != namesake.AccessHash {
+		t.Fatalf("unexpected peer of a bare id %v", req.Peer)
+	}
+}
+
+func TestContextDownloadMedia(t *testing.T) {
+	c := newClient()
+	content := []byte("file content")
+	c.Invoker.Reply(&tg.UploadGetFileRequest{}, &tg.UploadFile{Type: &tg.StorageFileJpeg{}, Bytes: content})
+	media := &tg.MessageMediaDocument{Document: &tg.Document{ID: 3, AccessHash: 4, FileReference: []byte{1}}}
+	var buf bytes.Buffer
+	typ, err := c.Context().DownloadMedia(media, ext.DownloadOutputStream{Writer: &buf}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := typ.(*tg.StorageFileJpeg); !ok {
+		t.Fatalf("unexpected file type %v", typ)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Fatalf("unexpected content %q", buf.Bytes())
+	}
+	req := gotgprototest.ExpectRequest[*tg.UploadGetFileRequest](t, c.Invoker)
+	if loc, ok := req.Location.(*tg.InputDocumentFileLocation); !ok || loc.ID != 3 || loc.AccessHash != 4 {
+		t.Fatalf("unexpected location %v", req.Location)
+	}
+}
+
+func TestContextGetMessageByLink(t *testing.T) {
+	c := newClient()
+	news := gotgprototest.Channel(102, "News", false)
+	news.Username = "news"
+	news.SetFlags()
+	c.AddChats(news)
+	ctx := c.Context()
+
+	post := gotgprototest.TextMessage(5, nil, &tg.PeerChannel{ChannelID: news.ID}, "post")
+	c.Invoker.Reply(&tg.ChannelsGetMessagesRequest{}, &tg.MessagesChannelMessages{Messages: []tg.MessageClass{post}})
+	msg, err := ctx.GetMessageByLink("https://t.me/news/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != post.ID || msg.Text != "post" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	req := gotgprototest.ExpectRequest[*tg.ChannelsGetMessagesRequest](t, c.Invoker)
+	if channel, ok := req.Channel.(*tg.InputChannel); !ok || channel.ChannelID != news.ID {
+		t.Fatalf("unexpected channel %v", req.Channel)
+	}
+
+	comment := gotgprototest.TextMessage(9, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, "comment")
+	c.Invoker.Reply(&tg.MessagesGetDiscussionMessageRequest{}, &tg.MessagesDiscussionMessage{
+		Messages: []tg.MessageClass{gotgprototest.TextMessage(3, nil, &tg.PeerChannel{ChannelID: group.ID}, "post")},
+	})
+	c.Invoker.Reply(&tg.ChannelsGetMessagesRequest{}, &tg.MessagesChannelMessages{Messages: []tg.MessageClass{comment}})
+	if msg, err = ctx.GetMessageByLink("https://t.me/news/5?comment=9"); err != nil || msg.ID != comment.ID {
+		t.Fatalf("unexpected comment %+v: %v", msg, err)
+	}
+	if req := gotgprototest.ExpectRequest[*tg.MessagesGetDiscussionMessageRequest](t, c.Invoker); req.MsgID != 5 {
+		t.Fatalf("unexpected post id %d", req.MsgID)
+	}
+	req = gotgprototest.ExpectRequest[*tg.ChannelsGetMessagesRequest](t, c.Invoker)
+	if channel, ok := req.Channel.(*tg.InputChannel); !ok || channel.ChannelID != group.ID {
+		t.Fatalf("unexpected discussion group %v", req.Channel)
+	}
+
+	c.Invoker.Reply(&tg.ChannelsGetMessagesRequest{}, &tg.MessagesChannelMessages{
+		Messages: []tg.MessageClass{&tg.MessageEmpty{ID: 7}},
+	})
+	if _, err := ctx.GetMessageByLink("https://t.me/c/100/7"); !errors.Is(err, mtp_errors.ErrMessageNotExist) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestContextGetMessageLink(t *testing.T) {
+	c := newClient()
+	news := gotgprototest.Channel(102, "News", false)
+	news.Username = "news"
+	news.SetFlags()
+	c.AddChats(news)
+	ctx := c.Context()
+
+	post := types.ConstructMessage(gotgprototest.TextMessage(5, nil, &tg.PeerChannel{ChannelID: news.ID}, "post"))
+	if link, err := ctx.GetMessageLink(post); err != nil || link != "https://t.me/news/5" {
+		t.Fatalf("unexpected link %q: %v", link, err)
+	}
+	topic := types.ConstructMessage(gotgprototest.TextMessage(8, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, "hi"))
+	topic.ReplyTo = &tg.MessageReplyHeader{ForumTopic: true, ReplyToMsgID: 2}
+	if link, err := ctx.GetMessageLink(topic); err != nil || link != "https://t.me/c/100/2/8" {
+		t.Fatalf("unexpected link %q: %v", link, err)
+	}
+	private := types.ConstructMessage(gotgprototest.TextMessage(8, nil, &tg.PeerUser{UserID: alice.ID}, "hi"))
+	if _, err := ctx.GetMessageLink(private); !errors.Is(err, mtp_errors.ErrNotChannel) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(c.Invoker.Requests()) != 0 {
+		t.Fatalf("unexpected requests for local links %v", c.Invoker.Requests())
+	}
+
+	comment := types.ConstructMessage(gotgprototest.TextMessage(9, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, "comment"))
+	comment.ReplyTo = &tg.MessageReplyHeader{ReplyToMsgID: 3, ReplyToTopID: 3}
+	c.Invoker.Reply(&tg.ChannelsExportMessageLinkRequest{}, &tg.ExportedMessageLink{Link: "https://t.me/news/5?comment=9"})
+	if link, err := ctx.GetMessageLink(comment); err != nil || link != "https://t.me/news/5?comment=9" {
+		t.Fatalf("unexpected link %q: %v", link, err)
+	}
+	req := gotgprototest.ExpectRequest[*tg.ChannelsExportMessageLinkRequest](t, c.Invoker)
+	if channel, ok := req.Channel.(*tg.InputChannel); !ok || channel.ChannelID != group.ID || req.ID != 9 || !req.Thread {
+		t.Fatalf("unexpected request %+v", req)
+	}
+}
@@ -0,0 +1,189 @@
+package ext
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	mtp_errors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/functions"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// resumeAlign is the alignment of the offset a download is resumed from.
+// Precise chunks of upload.getFile can't cross 1MB boundaries, and file hashes cover 128KB ranges.
+const resumeAlign = 1024 * 1024
+
+// DownloadProgress is called after every downloaded chunk with the number of downloaded bytes
+// and the total size of the file, which is 0 if unknown.
+// Downloaded bytes include the part of the file which was already downloaded by a resumed download.
+type DownloadProgress func(downloaded, total int64)
+
+// WithContext returns a copy of the ext.Context which uses the provided context.Context,
+// it can be used to cancel or set a deadline on a request like a download.
+func (ctx *Context) WithContext(c context.Context) *Context {
+	newCtx := *ctx
+	newCtx.Context = c
+	return &newCtx
+}
+
+// open opens the output file, keeping the already downloaded part of it if resume is true.
+// Returns the offset the download should continue from.
+func (d DownloadOutputPath) open(resume bool) (*os.File, int64, error) {
+	path := filepath.Clean(string(d))
+	if !resume {
+		f, err := os.Create(path)
+		return f, 0, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	offset := stat.Size() - stat.Size()%resumeAlign
+	if err := f.Truncate(offset); err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return f, offset, nil
+}
+
+// downloadClient wraps the raw client used by a download to shift its requests by the offset
+// the download was resumed from, to report its progress and to refresh expired file references.
+type downloadClient struct {
+	*tg.Client
+	ctx      *Context
+	offset   int64
+	total    int64
+	progress DownloadProgress
+	chatId   int64
+	msgId    int
+
+	lock       sync.Mutex
+	location   tg.InputFileLocationClass
+	generation int
+	downloaded int64
+}
+
+func (c *downloadClient) currentLocation() (tg.InputFileLocationClass, int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.location, c.generation
+}
+
+// refreshLocation fetches the message of the media again to get a new file reference.
+// The location is fetched only once if multiple requests failed with the same location.
+func (c *downloadClient) refreshLocation(ctx context.Context, generation int) error {
+	if c.chatId == 0 || c.msgId == 0 {
+		return mtp_errors.ErrFileReferenceExpired
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation != generation {
+		return nil
+	}
+	msgs, err := functions.GetMessages(ctx, c.Client, c.ctx.PeerStorage, c.chatId, []tg.InputMessageClass{&tg.InputMessageID{ID: c.msgId}})
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return mtp_errors.ErrMessageNotExist
+	}
+	msg, ok := msgs[0].(*tg.Message)
+	if !ok {
+		return mtp_errors.ErrMessageNotExist
+	}
+	location, err := functions.GetInputFileLocation(msg.Media)
+	if err != nil {
+		return err
+	}
+	c.location = location
+	c.generation++
+	return nil
+}
+
+func (c *downloadClient) UploadGetFile(ctx context.Context, request *tg.UploadGetFileRequest) (tg.UploadFileClass, error) {
+	req := *request
+	req.Offset += c.offset
+	for refreshed := false; ; refreshed = true {
+		location, generation := c.currentLocation()
+		req.Location = location
+		file, err := c.Client.UploadGetFile(ctx, &req)
+		if tgerr.Is(err, "FILE_REFERENCE_EXPIRED") && !refreshed {
+			if err := c.refreshLocation(ctx, generation); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := file.(*tg.UploadFile); ok && c.progress != nil {
+			c.lock.Lock()
+			c.downloaded += int64(len(f.Bytes))
+			c.progress(c.offset+c.downloaded, c.total)
+			c.lock.Unlock()
+		}
+		return file, nil
+	}
+}
+
+func (c *downloadClient) UploadGetFileHashes(ctx context.Context, request *tg.UploadGetFileHashesRequest) ([]tg.FileHash, error) {
+	req := *request
+	req.Offset += c.offset
+	for refreshed := false; ; refreshed = true {
+		location, generation := c.currentLocation()
+		req.Location = location
+		hashes, err := c.Client.UploadGetFileHashes(ctx, &req)
+		if tgerr.Is(err, "FILE_REFERENCE_EXPIRED") && !refreshed {
+			if err := c.refreshLocation(ctx, generation); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range hashes {
+			hashes[i].Offset -= c.offset
+		}
+		return hashes, nil
+	}
+}
+
+// mediaSize returns the size of the file downloaded from the provided media, 0 if unknown.
+func mediaSize(media tg.MessageMediaClass) int64 {
+	switch v := media.(type) {
+	case *tg.MessageMediaPhoto:
+		f, ok := v.Photo.AsNotEmpty()
+		if !ok || len(f.Sizes) == 0 {
+			return 0
+		}
+		switch size := f.Sizes[len(f.Sizes)-1].(type) {
+		case *tg.PhotoSize:
+			return int64(size.Size)
+		case *tg.PhotoSizeProgressive:
+			if len(size.Sizes) > 0 {
+				return int64(size.Sizes[len(size.Sizes)-1])
+			}
+		}
+	case *tg.MessageMediaDocument:
+		if f, ok := v.Document.AsNotEmpty(); ok {
+			return f.Size
+		}
+	case *tg.MessageMediaStory:
+		if f, ok := v.Story.(*tg.StoryItem); ok {
+			return mediaSize(f.Media)
+		}
+	}
+	return 0
+}
+
+var _ downloader.Client = (*downloadClient)(nil)
@@ -0,0 +1,167 @@
+package ext_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+)
+
+const mb = 1024 * 1024
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+// serveFile scripts upload.getFile to serve the provided content if the file reference is valid.
+func serveFile(c *gotgprototest.Client, content []byte, reference []byte) {
+	c.Invoker.On(&tg.UploadGetFileRequest{}, func(_ context.Context, req gotgprototest.Request) (bin.Encoder, error) {
+		r := req.(*tg.UploadGetFileRequest)
+		if loc := r.Location.(*tg.InputDocumentFileLocation); !bytes.Equal(loc.FileReference, reference) {
+			return nil, gotgprototest.RPCError(400, "FILE_REFERENCE_EXPIRED")
+		}
+		end := min(r.Offset+int64(r.Limit), int64(len(content)))
+		start := min(r.Offset, end)
+		return &tg.UploadFile{Type: &tg.StorageFilePartial{}, Bytes: content[start:end]}, nil
+	})
+}
+
+func documentMedia(size int, reference []byte) *tg.MessageMediaDocument {
+	return &tg.MessageMediaDocument{Document: &tg.Document{ID: 3, AccessHash: 4, FileReference: reference, Size: int64(size)}}
+}
+
+func TestDownloadMediaProgress(t *testing.T) {
+	c := newClient()
+	content := testContent(mb + 100)
+	serveFile(c, content, []byte{1})
+	var downloaded, total int64
+	var buf bytes.Buffer
+	_, err := c.Context().DownloadMedia(documentMedia(len(content), []byte{1}), ext.DownloadOutputStream{Writer: &buf}, &ext.DownloadMediaOpts{
+		Progress: func(d, t int64) { downloaded, total = d, t },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Fatal("unexpected content")
+	}
+	if downloaded != int64(len(content)) || total != int64(len(content)) {
+		t.Fatalf("unexpected progress %d/%d", downloaded, total)
+	}
+}
+
+func TestDownloadMediaResume(t *testing.T) {
+	c := newClient()
+	content := testContent(2*mb + 100)
+	serveFile(c, content, []byte{1})
+	path := filepath.Join(t.TempDir(), "file")
+	// A partial file with a broken tail, the download continues from its last complete megabyte.
+	partial := append(append([]byte(nil), content[:mb]...), 0, 0, 0)
+	if err := os.WriteFile(path, partial, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var downloaded int64
+	_, err := c.Context().DownloadMedia(documentMedia(len(content), []byte{1}), ext.DownloadOutputPath(path), &ext.DownloadMediaOpts{
+		Resume:   true,
+		Progress: func(d, _ int64) { downloaded = d },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("unexpected content of %d bytes", len(got))
+	}
+	if downloaded != int64(len(content)) {
+		t.Fatalf("unexpected progress %d", downloaded)
+	}
+	for _, req := range gotgprototest.RequestsOf[*tg.UploadGetFileRequest](c.Invoker) {
+		if req.Offset < mb {
+			t.Fatalf("resumed download requested offset %d", req.Offset)
+		}
+	}
+}
+
+func TestDownloadMediaResumeThreads(t *testing.T) {
+	c := newClient()
+	content := testContent(4 * mb)
+	// The third megabyte fails, the parts after it must not be written with threads.
+	c.Invoker.On(&tg.UploadGetFileRequest{}, func(_ context.Context, req gotgprototest.Request) (bin.Encoder, error) {
+		r := req.(*tg.UploadGetFileRequest)
+		if r.Offset >= 2*mb && r.Offset < 3*mb {
+			// The other threads download the next parts meanwhile.
+			time.Sleep(50 * time.Millisecond)
+			return nil, gotgprototest.RPCError(400, "FILE_ID_INVALID")
+		}
+		return &tg.UploadFile{Type: &tg.StorageFilePartial{}, Bytes: content[r.Offset:min(r.Offset+int64(r.Limit), int64(len(content)))]}, nil
+	})
+	path := filepath.Join(t.TempDir(), "file")
+	_, err := c.Context().DownloadMedia(documentMedia(len(content), []byte{1}), ext.DownloadOutputPath(path), &ext.DownloadMediaOpts{
+		Resume:  true,
+		Threads: 4,
+	})
+	if err == nil {
+		t.Fatal("expected the download to fail")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 2*mb || !bytes.Equal(got, content[:len(got)]) {
+		t.Fatalf("unexpected content of %d bytes", len(got))
+	}
+}
+
+func TestDownloadMediaRefreshReference(t *testing.T) {
+	c := newClient()
+	content := testContent(100)
+	serveFile(c, content, []byte{2})
+	c.Invoker.Reply(&tg.ChannelsGetMessagesRequest{}, &tg.MessagesChannelMessages{
+		Messages: []tg.MessageClass{&tg.Message{ID: 5, PeerID: &tg.PeerChannel{ChannelID: group.ID}, Media: documentMedia(len(content), []byte{2})}},
+	})
+	media := documentMedia(len(content), []byte{1})
+	var buf bytes.Buffer
+	if _, err := c.Context().DownloadMedia(media, ext.DownloadOutputStream{Writer: &buf}, nil); err == nil {
+		t.Fatal("expected an error without the message of the media")
+	}
+	_, err := c.Context().DownloadMedia(media, ext.DownloadOutputStream{Writer: &buf}, &ext.DownloadMediaOpts{
+		ChatId:    group.ID,
+		MessageId: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Fatal("unexpected content")
+	}
+	req := gotgprototest.ExpectRequest[*tg.ChannelsGetMessagesRequest](t, c.Invoker)
+	if id, ok := req.ID[0].(*tg.InputMessageID); !ok || id.ID != 5 {
+		t.Fatalf("unexpected message id %v", req.ID)
+	}
+}
+
+func TestDownloadMediaCancel(t *testing.T) {
+	c := newClient()
+	serveFile(c, testContent(100), []byte{1})
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	_, err := c.Context().WithContext(cancelled).DownloadMedia(documentMedia(100, []byte{1}), ext.DownloadOutputStream{Writer: &buf}, nil)
+	if err == nil {
+		t.Fatal("expected a cancelled download to fail")
+	}
+}
@@ -0,0 +1,351 @@
+package ext
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/functions"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// defaultMediaChunkSize is the default size of the chunks fetched by a MediaReader,
+// it divides 1MB so that a chunk never crosses a 1MB boundary of the file.
+const defaultMediaChunkSize = 512 * 1024
+
+// MediaReaderOpts object contains optional parameters for Context.OpenMedia.
+// If not provided, default values will be used.
+type MediaReaderOpts struct {
+	// ChunkSize is the size of the chunks fetched from Telegram, 512KB by default.
+	// It must be divisible by 4KB and divide 1MB.
+	ChunkSize int
+	// CacheChunks is the maximum number of chunks kept in memory, 8 by default.
+	CacheChunks int
+	// Prefetch is the number of chunks fetched ahead of a sequential read, 1 by default.
+	// Negative values disable prefetching.
+	Prefetch int
+	// DC returns an invoker connected to the provided DC, it is used if the file is stored in another DC
+	// than the one of the client, i.e. `func(ctx context.Context, dc int) (tg.Invoker, error) { return client.DC(ctx, dc, 1) }`.
+	// The invoker is closed with the reader if it implements io.Closer.
+	//
+	// It is only needed if the raw client of the context isn't backed by a telegram.Client, i.e. a custom tg.Invoker,
+	// since telegram.Client already retries the requests of files stored in another DC through its DC pool.
+	DC func(ctx context.Context, dc int) (tg.Invoker, error)
+}
+
+// MediaReader reads a file stored in Telegram on demand, it implements io.ReadSeeker, io.ReaderAt and io.Closer.
+// The file is fetched in aligned chunks which are kept in a bounded LRU cache.
+// ReadAt is safe for concurrent use, Read and Seek share a position and are not.
+type MediaReader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	opts     MediaReaderOpts
+	location tg.InputFileLocationClass
+	size     int64
+	mimeType string
+	name     string
+
+	lock     sync.Mutex
+	client   *tg.Client
+	invoker  tg.Invoker
+	chunks   map[int64]*mediaChunk
+	lru      *list.List
+	lastRead int64
+
+	pos int64
+}
+
+type mediaChunk struct {
+	index int64
+	done  chan struct{}
+	data  []byte
+	err   error
+	elem  *list.Element
+}
+
+// OpenMedia returns a MediaReader of the file of the provided media.
+// The reader uses the context of ctx, and it must be closed to stop prefetching.
+//
+// Files stored in another DC are read through the DC pool of the telegram.Client of ctx.Raw, which handles FILE_MIGRATE;
+// MediaReaderOpts.DC must be provided if ctx.Raw uses another tg.Invoker.
+// Files are never fetched from CDN DCs: the requests don't set cdn_supported, so Telegram serves CDN files
+// from the DC of the file instead of redirecting the reader.
+func (ctx *Context) OpenMedia(media tg.MessageMediaClass, opts *MediaReaderOpts) (*MediaReader, error) {
+	location, err := functions.GetInputFileLocation(media)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &MediaReaderOpts{}
+	}
+	o := *opts
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = defaultMediaChunkSize
+	}
+	if o.ChunkSize%4096 != 0 || resumeAlign%o.ChunkSize != 0 {
+		return nil, fmt.Errorf("invalid chunk size %d: it must be divisible by 4KB and divide 1MB", o.ChunkSize)
+	}
+	if o.CacheChunks <= 0 {
+		o.CacheChunks = 8
+	}
+	if o.Prefetch == 0 {
+		o.Prefetch = 1
+	}
+	c, cancel := context.WithCancel(ctx.Context)
+	r := &MediaReader{
+		ctx:      c,
+		cancel:   cancel,
+		opts:     o,
+		location: location,
+		size:     mediaSize(media),
+		client:   ctx.Raw,
+		chunks:   make(map[int64]*mediaChunk),
+		lru:      list.New(),
+		lastRead: -1,
+	}
+	r.name, _ = functions.GetMediaFileName(media)
+	if doc, ok := media.(*tg.MessageMediaDocument); ok {
+		if d, ok := doc.Document.AsNotEmpty(); ok {
+			r.mimeType = d.MimeType
+		}
+	}
+	return r, nil
+}
+
+// Size returns the size of the file, 0 if unknown.
+func (r *MediaReader) Size() int64 {
+	return r.size
+}
+
+// MimeType returns the MIME type of a document, it is empty for other media.
+func (r *MediaReader) MimeType() string {
+	return r.mimeType
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *MediaReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.readAt(r.ctx, p, off)
+}
+
+// readAt reads like ReadAt, it also stops waiting for the chunks once ctx is done.
+// The chunks are still fetched with the context of the reader, as they are shared by all the reads.
+func (r *MediaReader) readAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if r.size > 0 && off >= r.size {
+			return n, io.EOF
+		}
+		index := off / int64(r.opts.ChunkSize)
+		data, err := r.chunk(ctx, index)
+		if err != nil {
+			return n, err
+		}
+		start := off - index*int64(r.opts.ChunkSize)
+		if start >= int64(len(data)) {
+			return n, io.EOF
+		}
+		copied := copy(p[n:], data[start:])
+		n += copied
+		off += int64(copied)
+		if len(data) < r.opts.ChunkSize && n < len(p) {
+			// A short chunk is the last one of the file.
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *MediaReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker, seeking relative to the end requires the size of the file.
+func (r *MediaReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		if r.size <= 0 {
+			return 0, errors.New("can't seek relative to the end of a file of unknown size")
+		}
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close stops prefetching and closes the invoker of another DC if any.
+func (r *MediaReader) Close() error {
+	r.cancel()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if closer, ok := r.invoker.(io.Closer); ok {
+		r.invoker = nil
+		return closer.Close()
+	}
+	return nil
+}
+
+// chunk returns the data of the chunk with the provided index, fetching it if it's not cached.
+// The next chunks are prefetched if the chunks are read sequentially.
+func (r *MediaReader) chunk(ctx context.Context, index int64) ([]byte, error) {
+	r.lock.Lock()
+	c := r.startFetch(index)
+	sequential := index == r.lastRead+1
+	r.lastRead = index
+	if sequential {
+		for i := int64(1); i <= int64(r.opts.Prefetch); i++ {
+			if next := index + i; r.size <= 0 || next*int64(r.opts.ChunkSize) < r.size {
+				r.startFetch(next)
+			}
+		}
+	}
+	r.lock.Unlock()
+	select {
+	case <-c.done:
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c.data, c.err
+}
+
+// startFetch returns the cached chunk or starts fetching it, r.lock must be held.
+func (r *MediaReader) startFetch(index int64) *mediaChunk {
+	if c, ok := r.chunks[index]; ok {
+		r.lru.MoveToFront(c.elem)
+		return c
+	}
+	c := &mediaChunk{index: index, done: make(chan struct{})}
+	c.elem = r.lru.PushFront(c)
+	r.chunks[index] = c
+	for r.lru.Len() > r.opts.CacheChunks {
+		oldest := r.lru.Back().Value.(*mediaChunk)
+		r.lru.Remove(oldest.elem)
+		delete(r.chunks, oldest.index)
+	}
+	go func() {
+		c.data, c.err = r.fetch(index)
+		if c.err != nil {
+			// Failed chunks are fetched again by the next read.
+			r.lock.Lock()
+			if r.chunks[index] == c {
+				r.lru.Remove(c.elem)
+				delete(r.chunks, index)
+			}
+			r.lock.Unlock()
+		}
+		close(c.done)
+	}()
+	return c
+}
+
+func (r *MediaReader) fetch(index int64) ([]byte, error) {
+	req := &tg.UploadGetFileRequest{
+		Precise:  true,
+		Location: r.location,
+		Offset:   index * int64(r.opts.ChunkSize),
+		Limit:    r.opts.ChunkSize,
+	}
+	for migrated := false; ; migrated = true {
+		r.lock.Lock()
+		client := r.client
+		r.lock.Unlock()
+		res, err := client.UploadGetFile(r.ctx, req)
+		if rpcErr, ok := tgerr.As(err); ok && rpcErr.Type == "FILE_MIGRATE" && !migrated {
+			if err := r.migrate(rpcErr.Argument); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch f := res.(type) {
+		case *tg.UploadFile:
+			return f.Bytes, nil
+		case *tg.UploadFileCDNRedirect:
+			// Telegram only redirects the requests which set cdn_supported.
+			return nil, fmt.Errorf("unexpected redirect to CDN DC %d", f.DCID)
+		default:
+			return nil, fmt.Errorf("unexpected response %T", res)
+		}
+	}
+}
+
+// migrate switches the reader to the provided DC.
+func (r *MediaReader) migrate(dc int) error {
+	if r.opts.DC == nil {
+		return fmt.Errorf("file is stored in DC %d and the client doesn't handle FILE_MIGRATE, provide MediaReaderOpts.DC to read it", dc)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.invoker != nil {
+		return nil
+	}
+	invoker, err := r.opts.DC(r.ctx, dc)
+	if err != nil {
+		return err
+	}
+	r.invoker = invoker
+	r.client = tg.NewClient(invoker)
+	return nil
+}
+
+// MediaHandler returns an http.Handler serving the file of the provided media with Range support.
+// The file is read through a single MediaReader which is closed when the context of ctx is done,
+// a response stops waiting for the file once its request is cancelled.
+// See OpenMedia for how files stored in other DCs are read.
+func (ctx *Context) MediaHandler(media tg.MessageMediaClass, opts *MediaReaderOpts) (http.Handler, error) {
+	r, err := ctx.OpenMedia(media, opts)
+	if err != nil {
+		return nil, err
+	}
+	if r.size <= 0 {
+		_ = r.Close()
+		return nil, errors.New("can't serve a file of unknown size")
+	}
+	go func() {
+		<-r.ctx.Done()
+		_ = r.Close()
+	}()
+	modTime := time.Now()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.mimeType != "" {
+			w.Header().Set("Content-Type", r.mimeType)
+		}
+		http.ServeContent(w, req, r.name, modTime, io.NewSectionReader(requestReader{r: r, ctx: req.Context()}, 0, r.size))
+	}), nil
+}
+
+// requestReader reads a MediaReader for an http request, until the request is cancelled.
+type requestReader struct {
+	r   *MediaReader
+	ctx context.Context
+}
+
+func (rr requestReader) ReadAt(p []byte, off int64) (int, error) {
+	return rr.r.readAt(rr.ctx, p, off)
+}
@@ -0,0 +1,149 @@
+package ext_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+)
+
+func TestMediaReader(t *testing.T) {
+	c := newClient()
+	content := testContent(5*4096 + 10)
+	serveFile(c, content, []byte{1})
+	r, err := c.Context().OpenMedia(documentMedia(len(content), []byte{1}), &ext.MediaReaderOpts{ChunkSize: 4096, CacheChunks: 2, Prefetch: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, 100)
+	if n, err := r.ReadAt(buf, 4096-50); err != nil || n != 100 || string(buf) != string(content[4096-50:4096+50]) {
+		t.Fatalf("unexpected read across chunks: %d, %v", n, err)
+	}
+	if n, err := r.ReadAt(buf, int64(len(content))-10); err != io.EOF || n != 10 || string(buf[:n]) != string(content[len(content)-10:]) {
+		t.Fatalf("unexpected read at the end: %d, %v", n, err)
+	}
+	requests := len(gotgprototest.RequestsOf[*tg.UploadGetFileRequest](c.Invoker))
+	if _, err := r.ReadAt(buf, 4096); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(gotgprototest.RequestsOf[*tg.UploadGetFileRequest](c.Invoker)); got != requests {
+		t.Fatal("cached chunk was fetched again")
+	}
+	// The first chunk was evicted by the last two.
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(gotgprototest.RequestsOf[*tg.UploadGetFileRequest](c.Invoker)); got != requests+1 {
+		t.Fatalf("expected the evicted chunk to be fetched again, got %d requests", got-requests)
+	}
+	for _, req := range gotgprototest.RequestsOf[*tg.UploadGetFileRequest](c.Invoker) {
+		if req.Offset%4096 != 0 || req.Limit != 4096 {
+			t.Fatalf("unaligned request %d+%d", req.Offset, req.Limit)
+		}
+	}
+
+	if _, err := r.Seek(-20, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if tail, err := io.ReadAll(r); err != nil || string(tail) != string(content[len(content)-20:]) {
+		t.Fatalf("unexpected tail %d bytes, %v", len(tail), err)
+	}
+}
+
+func TestMediaReaderMigrate(t *testing.T) {
+	c := newClient()
+	c.Invoker.Fail(&tg.UploadGetFileRequest{}, gotgprototest.RPCError(303, "FILE_MIGRATE_4"))
+	content := testContent(100)
+	dc := gotgprototest.NewInvoker()
+	dc.On(&tg.UploadGetFileRequest{}, func(context.Context, gotgprototest.Request) (bin.Encoder, error) {
+		return &tg.UploadFile{Type: &tg.StorageFilePartial{}, Bytes: content}, nil
+	})
+	var migratedTo int
+	r, err := c.Context().OpenMedia(documentMedia(len(content), []byte{1}), &ext.MediaReaderOpts{
+		DC: func(_ context.Context, id int) (tg.Invoker, error) {
+			migratedTo = id
+			return dc, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != nil || string(got) != string(content) {
+		t.Fatalf("unexpected content %d bytes, %v", len(got), err)
+	}
+	if migratedTo != 4 {
+		t.Fatalf("unexpected dc %d", migratedTo)
+	}
+}
+
+func TestMediaReaderMigrateWithoutDC(t *testing.T) {
+	c := newClient()
+	c.Invoker.Fail(&tg.UploadGetFileRequest{}, gotgprototest.RPCError(303, "FILE_MIGRATE_4"))
+	r, err := c.Context().OpenMedia(documentMedia(100, []byte{1}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "MediaReaderOpts.DC") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestMediaHandler(t *testing.T) {
+	c := newClient()
+	content := testContent(3 * 4096)
+	serveFile(c, content, []byte{1})
+	media := documentMedia(len(content), []byte{1})
+	media.Document.(*tg.Document).MimeType = "video/mp4"
+	h, err := c.Context().MediaHandler(media, &ext.MediaReaderOpts{ChunkSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set("Range", "bytes=4000-4199")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Type") != "video/mp4" {
+		t.Fatalf("unexpected response %d %v", rec.Code, rec.Header())
+	}
+	if body := rec.Body.String(); body != string(content[4000:4200]) {
+		t.Fatalf("unexpected body of %d bytes", len(body))
+	}
+}
+
+func TestMediaHandlerCancelledRequest(t *testing.T) {
+	c := newClient()
+	release := make(chan struct{})
+	defer close(release)
+	c.Invoker.On(&tg.UploadGetFileRequest{}, func(context.Context, gotgprototest.Request) (bin.Encoder, error) {
+		<-release
+		return &tg.UploadFile{Type: &tg.StorageFilePartial{}}, nil
+	})
+	h, err := c.Context().MediaHandler(documentMedia(4096, []byte{1}), &ext.MediaReaderOpts{ChunkSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	served := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/file", nil).WithContext(ctx))
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the response kept waiting for the file after its request was cancelled")
+	}
+}
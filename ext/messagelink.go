@@ -0,0 +1,127 @@
+package ext
+
+import (
+	mtp_errors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+// GetMessageByLink returns the message of the provided link, see functions.ParseMessageLink.
+// The comments of the links to comments are fetched from the discussion group of the channel.
+//
+// The chats of private t.me/c/ links must be in the peer storage or recoverable by ResolveInputPeerById.
+func (ctx *Context) GetMessageByLink(link string) (*types.Message, error) {
+	l, err := functions.ParseMessageLink(link)
+	if err != nil {
+		return nil, err
+	}
+	chatId := storage.NewPeerID(l.ChannelID, storage.TypeChannel).BotAPI()
+	if l.Username != "" {
+		chat, err := ctx.ResolvePeer(l.Username)
+		if err != nil {
+			return nil, err
+		}
+		if !chat.IsAChannel() {
+			return nil, mtp_errors.ErrNotChannel
+		}
+		chatId = storage.NewPeerID(chat.GetID(), storage.TypeChannel).BotAPI()
+	}
+	msgId := l.MessageID
+	if l.CommentID != 0 {
+		chatId, err = ctx.discussionChat(chatId, l.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		msgId = l.CommentID
+	}
+	msgs, err := ctx.GetMessages(chatId, []tg.InputMessageClass{&tg.InputMessageID{ID: msgId}})
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, mtp_errors.ErrMessageNotExist
+	}
+	if _, ok := msgs[0].(*tg.MessageEmpty); ok {
+		return nil, mtp_errors.ErrMessageNotExist
+	}
+	return types.ConstructMessage(msgs[0]), nil
+}
+
+// discussionChat returns the marked id of the discussion group of the comments to the provided channel post.
+func (ctx *Context) discussionChat(chatId int64, msgId int) (int64, error) {
+	peer, err := ctx.ResolveInputPeerById(chatId)
+	if err != nil {
+		return 0, err
+	}
+	discussion, err := ctx.Raw.MessagesGetDiscussionMessage(ctx, &tg.MessagesGetDiscussionMessageRequest{
+		Peer:  peer,
+		MsgID: msgId,
+	})
+	if err != nil {
+		return 0, err
+	}
+	functions.SavePeersFromClassArray(ctx.PeerStorage, discussion.Chats, discussion.Users)
+	if len(discussion.Messages) == 0 {
+		return 0, mtp_errors.ErrMessageNotExist
+	}
+	msg, ok := discussion.Messages[0].(*tg.Message)
+	if !ok {
+		return 0, mtp_errors.ErrMessageNotExist
+	}
+	return storage.PeerIDFromPeer(msg.PeerID).BotAPI(), nil
+}
+
+// GetMessageLink returns a shareable link to the provided message of a channel or a supergroup.
+// The link is public if the chat has a username saved in the peer storage and private otherwise.
+// The links to the messages of threads of replies, like comments, are exported with channels.exportMessageLink,
+// the others are built locally.
+func (ctx *Context) GetMessageLink(msg *types.Message) (string, error) {
+	if msg == nil || msg.Message == nil {
+		return "", mtp_errors.ErrMessageNotExist
+	}
+	channel, ok := msg.PeerID.(*tg.PeerChannel)
+	if !ok {
+		return "", mtp_errors.ErrNotChannel
+	}
+	link := functions.MessageLink{MessageID: msg.ID}
+	if reply, ok := msg.ReplyTo.(*tg.MessageReplyHeader); ok {
+		switch {
+		case reply.ForumTopic:
+			// Messages which aren't replies only have the topic as ReplyToMsgID.
+			link.TopicID = reply.ReplyToTopID
+			if link.TopicID == 0 {
+				link.TopicID = reply.ReplyToMsgID
+			}
+		case reply.ReplyToTopID != 0:
+			return ctx.exportMessageLink(channel.ChannelID, msg.ID)
+		}
+	}
+	if peer := ctx.PeerStorage.GetPeer(storage.NewPeerID(channel.ChannelID, storage.TypeChannel)); peer.Username != "" {
+		link.Username = peer.Username
+	} else {
+		link.ChannelID = channel.ChannelID
+	}
+	return link.String(), nil
+}
+
+func (ctx *Context) exportMessageLink(channelId int64, msgId int) (string, error) {
+	peer, err := ctx.ResolveInputPeer(storage.NewPeerID(channelId, storage.TypeChannel))
+	if err != nil {
+		return "", err
+	}
+	channel, ok := functions.InputChannelFromPeer(peer)
+	if !ok {
+		return "", mtp_errors.ErrNotChannel
+	}
+	link, err := ctx.Raw.ChannelsExportMessageLink(ctx, &tg.ChannelsExportMessageLinkRequest{
+		Channel: channel,
+		ID:      msgId,
+		Thread:  true,
+	})
+	if err != nil {
+		return "", err
+	}
+	return link.Link, nil
+}
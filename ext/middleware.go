@@ -0,0 +1,11 @@
+package ext
+
+// RunCallback runs the callback of a handler through the middlewares of the dispatcher.
+// Handlers which run their callbacks through it once they decided to handle the update implement
+// dispatcher.CallbackRunner, so that the middlewares are only executed for the updates the handler handles.
+func (ctx *Context) RunCallback(callback func(*Context, *Update) error, u *Update) error {
+	if ctx.CallbackMiddleware == nil {
+		return callback(ctx, u)
+	}
+	return ctx.CallbackMiddleware(callback)(ctx, u)
+}
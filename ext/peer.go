@@ -0,0 +1,110 @@
+package ext
+
+import (
+	"errors"
+
+	mtp_errors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+// ResolvePeer returns the peer referenced by ref, which may be a username, a t.me or tg:// link,
+// an invite link, a phone number or a Bot API-style marked ID, see functions.ParsePeerRef.
+// The peer storage is looked up first, the peer is resolved through Telegram only if it's missing.
+//
+// The invite links of chats which were not joined return errors.ErrInviteNotJoined.
+func (ctx *Context) ResolvePeer(ref string) (types.EffectiveChat, error) {
+	r, err := functions.ParsePeerRef(ref)
+	if err != nil {
+		return &types.EmptyUC{}, err
+	}
+	switch r.Type {
+	case functions.PeerRefUsername:
+		if peer := ctx.PeerStorage.GetPeerByUsername(r.Value); peer.ID != 0 && !peer.IsMin {
+			return effectiveChatFromPeer(peer), nil
+		}
+		return ctx.ResolveUsername(r.Value)
+	case functions.PeerRefPhone:
+		if peer := ctx.PeerStorage.GetPeerByPhone(r.Value); peer.ID != 0 && !peer.IsMin {
+			return effectiveChatFromPeer(peer), nil
+		}
+		return ctx.extractContactResolvedPeer(ctx.Raw.ContactsResolvePhone(ctx, r.Value))
+	case functions.PeerRefInvite:
+		return ctx.resolveInvite(r.Value)
+	default:
+		return ctx.ResolvePeerById(r.ID)
+	}
+}
+
+// ResolvePeerById returns the peer of the provided bare or Bot API-style marked ID,
+// recovering it if it's missing from the peer storage, see ResolveInputPeerById.
+func (ctx *Context) ResolvePeerById(id int64) (types.EffectiveChat, error) {
+	inputPeer, err := ctx.ResolveInputPeerById(id)
+	if err != nil {
+		return &types.EmptyUC{}, err
+	}
+	switch inputPeer.(type) {
+	case *tg.InputPeerUser, *tg.InputPeerChat, *tg.InputPeerChannel:
+		return effectiveChatFromPeer(ctx.PeerStorage.GetPeer(storage.PeerIDFromInputPeer(inputPeer))), nil
+	}
+	// The peer could only be recovered through the message it was seen in.
+	return &types.EmptyUC{}, mtp_errors.ErrPeerNotFound
+}
+
+func (ctx *Context) resolveInvite(hash string) (types.EffectiveChat, error) {
+	invite, err := ctx.Raw.MessagesCheckChatInvite(ctx, hash)
+	if err != nil {
+		return &types.EmptyUC{}, err
+	}
+	var chat tg.ChatClass
+	switch invite := invite.(type) {
+	case *tg.ChatInviteAlready:
+		chat = invite.Chat
+	case *tg.ChatInvitePeek:
+		chat = invite.Chat
+	default:
+		return &types.EmptyUC{}, mtp_errors.ErrInviteNotJoined
+	}
+	functions.SavePeersFromClassArray(ctx.PeerStorage, []tg.ChatClass{chat}, nil)
+	switch chat := chat.(type) {
+	case *tg.Channel:
+		c := types.Channel(*chat)
+		return &c, nil
+	case *tg.Chat:
+		c := types.Chat(*chat)
+		return &c, nil
+	}
+	return &types.EmptyUC{}, errors.New("peer could not be resolved because the chat is forbidden")
+}
+
+// effectiveChatFromPeer returns the types.EffectiveChat of a peer saved in the peer storage,
+// only the details saved in the storage are set.
+func effectiveChatFromPeer(peer *storage.Peer) types.EffectiveChat {
+	switch storage.EntityType(peer.Type) {
+	case storage.TypeUser:
+		return &types.User{
+			ID:         peer.ID,
+			AccessHash: peer.AccessHash,
+			FirstName:  peer.FirstName,
+			LastName:   peer.LastName,
+			Username:   peer.Username,
+			Phone:      peer.Phone,
+			Bot:        peer.IsBot,
+		}
+	case storage.TypeChat:
+		return &types.Chat{
+			ID:    peer.ID,
+			Title: peer.Title,
+		}
+	case storage.TypeChannel:
+		return &types.Channel{
+			ID:         peer.ID,
+			AccessHash: peer.AccessHash,
+			Title:      peer.Title,
+			Username:   peer.Username,
+		}
+	}
+	return &types.EmptyUC{}
+}
@@ -0,0 +1,262 @@
+package ext
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+	"go.uber.org/multierr"
+)
+
+// EntityResolution decides when the entities missing from an update are fetched.
+type EntityResolution int
+
+const (
+	// ResolveLazy fetches the missing users, chats and channels of an update only when
+	// they are requested through its methods, i.e. Update.EffectiveUser or Update.EffectiveChat.
+	ResolveLazy EntityResolution = iota
+	// ResolveEager fetches the missing users, chats and channels of an update before it is passed to the handlers.
+	ResolveEager
+)
+
+// resolveDelay is the time a lookup waits for other lookups to be batched with.
+const resolveDelay = 10 * time.Millisecond
+
+// EntityResolver fetches the users, chats and channels which are missing from the entities of an update.
+// Lookups made at the same time, even from different updates, are coalesced into batched requests.
+type EntityResolver struct {
+	// Mode decides when the missing entities of an update are fetched.
+	Mode EntityResolution
+
+	ctx         context.Context
+	client      *tg.Client
+	peerStorage *storage.PeerStorage
+	lock        sync.Mutex
+	batch       *resolveBatch
+}
+
+type resolveBatch struct {
+	users    map[int64]struct{}
+	chats    map[int64]struct{}
+	channels map[int64]struct{}
+	// messages are the IDs of private and group messages mentioning the users, which are fetched
+	// to get the users missing from the peer storage.
+	messages map[int]struct{}
+	done     chan struct{}
+	result   tg.Entities
+	err      error
+}
+
+// NewEntityResolver creates a new EntityResolver with provided parameters.
+// The provided context is used for all the requests made by the resolver.
+func NewEntityResolver(ctx context.Context, client *tg.Client, p *storage.PeerStorage, mode EntityResolution) *EntityResolver {
+	return &EntityResolver{
+		Mode:        mode,
+		ctx:         ctx,
+		client:      client,
+		peerStorage: p,
+	}
+}
+
+// Resolve fetches the provided peers which are not present in the entities and adds them to it.
+// Users and channels are only fetched if they are saved in the peer storage with a full access hash
+// or were seen in a message, see storage.PeerStorage.SaveMessageContext.
+// Min users and channels of the entities are fetched again if their full version can be.
+func (r *EntityResolver) Resolve(ctx context.Context, e *tg.Entities, peers ...tg.PeerClass) error {
+	return r.resolve(ctx, e, nil, peers...)
+}
+
+// ResolveMessage fetches the provided peers of a message which are not present in the entities and adds them to it.
+// Users missing from the peer storage are fetched along with the message if it isn't a channel message,
+// i.e. the senders of short messages.
+func (r *EntityResolver) ResolveMessage(ctx context.Context, e *tg.Entities, msg *tg.Message, peers ...tg.PeerClass) error {
+	return r.resolve(ctx, e, msg, peers...)
+}
+
+func (r *EntityResolver) resolve(ctx context.Context, e *tg.Entities, msg *tg.Message, peers ...tg.PeerClass) error {
+	initEntities(e)
+	r.lock.Lock()
+	b := r.batch
+	added := false
+	for _, peer := range peers {
+		if b == nil {
+			b = &resolveBatch{
+				users:    make(map[int64]struct{}),
+				chats:    make(map[int64]struct{}),
+				channels: make(map[int64]struct{}),
+				messages: make(map[int]struct{}),
+				done:     make(chan struct{}),
+			}
+		}
+		switch peer := peer.(type) {
+		case *tg.PeerUser:
+			if u, ok := e.Users[peer.UserID]; ok && !(u.Min && r.canFetch(storage.NewPeerID(peer.UserID, storage.TypeUser))) {
+				continue
+			}
+			b.users[peer.UserID] = struct{}{}
+			added = true
+			if msg != nil && !isChannelMessage(msg) {
+				b.messages[msg.ID] = struct{}{}
+			}
+		case *tg.PeerChat:
+			if _, ok := e.Chats[peer.ChatID]; !ok {
+				b.chats[peer.ChatID] = struct{}{}
+				added = true
+			}
+		case *tg.PeerChannel:
+			if c, ok := e.Channels[peer.ChannelID]; ok && !(c.Min && r.canFetch(storage.NewPeerID(peer.ChannelID, storage.TypeChannel))) {
+				continue
+			}
+			b.channels[peer.ChannelID] = struct{}{}
+			added = true
+		}
+	}
+	if !added {
+		r.lock.Unlock()
+		return nil
+	}
+	if r.batch == nil {
+		r.batch = b
+		go r.flush(b)
+	}
+	r.lock.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for id, user := range b.result.Users {
+		e.Users[id] = user
+	}
+	for id, chat := range b.result.Chats {
+		e.Chats[id] = chat
+	}
+	for id, channel := range b.result.Channels {
+		e.Channels[id] = channel
+	}
+	return b.err
+}
+
+func (r *EntityResolver) flush(b *resolveBatch) {
+	defer close(b.done)
+	time.Sleep(resolveDelay)
+	r.lock.Lock()
+	r.batch = nil
+	r.lock.Unlock()
+
+	var (
+		users tg.UserClassArray
+		chats tg.ChatClassArray
+	)
+	if len(b.users) > 0 {
+		inputUsers := make([]tg.InputUserClass, 0, len(b.users))
+		missing := false
+		for id := range b.users {
+			input, ok := functions.InputUserFromPeer(r.inputPeer(storage.NewPeerID(id, storage.TypeUser)))
+			if !ok {
+				missing = true
+				continue
+			}
+			inputUsers = append(inputUsers, input)
+		}
+		if len(inputUsers) > 0 {
+			u, err := r.client.UsersGetUsers(r.ctx, inputUsers)
+			multierr.AppendInto(&b.err, err)
+			users = append(users, u...)
+		}
+		if missing && len(b.messages) > 0 {
+			u, err := r.messageUsers(b.messages)
+			multierr.AppendInto(&b.err, err)
+			users = append(users, u...)
+		}
+	}
+	if len(b.chats) > 0 {
+		ids := make([]int64, 0, len(b.chats))
+		for id := range b.chats {
+			ids = append(ids, id)
+		}
+		c, err := r.client.MessagesGetChats(r.ctx, ids)
+		multierr.AppendInto(&b.err, err)
+		if c != nil {
+			chats = append(chats, c.GetChats()...)
+		}
+	}
+	if len(b.channels) > 0 {
+		inputChannels := make([]tg.InputChannelClass, 0, len(b.channels))
+		for id := range b.channels {
+			if input, ok := functions.InputChannelFromPeer(r.inputPeer(storage.NewPeerID(id, storage.TypeChannel))); ok {
+				inputChannels = append(inputChannels, input)
+			}
+		}
+		if len(inputChannels) > 0 {
+			c, err := r.client.ChannelsGetChannels(r.ctx, inputChannels)
+			multierr.AppendInto(&b.err, err)
+			if c != nil {
+				chats = append(chats, c.GetChats()...)
+			}
+		}
+	}
+
+	functions.SavePeersFromClassArray(r.peerStorage, chats, users)
+	b.result.Users = users.NotEmptyToMap()
+	b.result.Chats = chats.ChatToMap()
+	b.result.Channels = chats.ChannelToMap()
+}
+
+// inputPeer returns the input peer through which the provided user or channel can be fetched,
+// nil if it is missing from the peer storage or only has a min access hash and wasn't seen in a message.
+func (r *EntityResolver) inputPeer(id storage.PeerID) tg.InputPeerClass {
+	switch input := r.peerStorage.GetInputPeer(id).(type) {
+	case *tg.InputPeerUserFromMessage, *tg.InputPeerChannelFromMessage:
+		return input
+	default:
+		if peer := r.peerStorage.GetPeer(id); peer.ID == 0 || peer.IsMin {
+			return nil
+		}
+		return input
+	}
+}
+
+// canFetch reports whether the full version of the provided min user or channel can be fetched.
+func (r *EntityResolver) canFetch(id storage.PeerID) bool {
+	return r.inputPeer(id) != nil
+}
+
+// messageUsers fetches the private and group messages with the provided IDs to get the users they mention.
+func (r *EntityResolver) messageUsers(ids map[int]struct{}) ([]tg.UserClass, error) {
+	inputMessages := make([]tg.InputMessageClass, 0, len(ids))
+	for id := range ids {
+		inputMessages = append(inputMessages, &tg.InputMessageID{ID: id})
+	}
+	m, err := r.client.MessagesGetMessages(r.ctx, inputMessages)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := m.AsModified(); ok {
+		return m.GetUsers(), nil
+	}
+	return nil, nil
+}
+
+// isChannelMessage reports whether the message belongs to a channel or a supergroup,
+// whose messages can't be fetched by their IDs alone.
+func isChannelMessage(msg *tg.Message) bool {
+	_, ok := msg.PeerID.(*tg.PeerChannel)
+	return ok
+}
+
+func initEntities(e *tg.Entities) {
+	if e.Users == nil {
+		e.Users = make(map[int64]*tg.User)
+	}
+	if e.Chats == nil {
+		e.Chats = make(map[int64]*tg.Chat)
+	}
+	if e.Channels == nil {
+		e.Channels = make(map[int64]*tg.Channel)
+	}
+}
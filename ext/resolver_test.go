@@ -0,0 +1,215 @@
+package ext_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+var (
+	bob     = gotgprototest.User(11, "Bob")
+	news    = gotgprototest.Channel(101, "News", false)
+	minUser = &tg.User{ID: 12, AccessHash: 1, FirstName: "Min", Min: true}
+)
+
+// newResolver creates an EntityResolver over a fresh Invoker with alice, bob, group and news saved in its peer storage.
+func newResolver(mode ext.EntityResolution) (*ext.EntityResolver, *gotgprototest.Invoker, *storage.PeerStorage) {
+	i := gotgprototest.NewInvoker()
+	i.Reply(&tg.UsersGetUsersRequest{}, &tg.UserClassVector{Elems: []tg.UserClass{alice, bob}})
+	i.Reply(&tg.ChannelsGetChannelsRequest{}, &tg.MessagesChats{Chats: []tg.ChatClass{group, news}})
+	p, _ := storage.NewPeerStorage(nil, true)
+	functions.SavePeersFromClassArray(p, []tg.ChatClass{group, news}, []tg.UserClass{alice, bob, minUser})
+	return ext.NewEntityResolver(context.Background(), tg.NewClient(i), p, mode), i, p
+}
+
+func TestEntityResolverBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// entities are the entities each lookup starts with.
+		entities func() *tg.Entities
+		// lookups are made concurrently, so that they are batched together.
+		lookups  [][]tg.PeerClass
+		script   func(*gotgprototest.Invoker)
+		users    int
+		channels int
+		wantErr  bool
+	}{
+		{
+			name:    "users",
+			lookups: [][]tg.PeerClass{{&tg.PeerUser{UserID: alice.ID}}, {&tg.PeerUser{UserID: bob.ID}}, {&tg.PeerUser{UserID: alice.ID}}},
+			users:   1,
+		},
+		{
+			name:     "channels",
+			lookups:  [][]tg.PeerClass{{&tg.PeerChannel{ChannelID: group.ID}}, {&tg.PeerChannel{ChannelID: news.ID}}},
+			channels: 1,
+		},
+		{
+			name:     "users and channels",
+			lookups:  [][]tg.PeerClass{{&tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}}, {&tg.PeerUser{UserID: bob.ID}, &tg.PeerChannel{ChannelID: news.ID}}},
+			users:    1,
+			channels: 1,
+		},
+		{
+			name: "present",
+			entities: func() *tg.Entities {
+				return &tg.Entities{Users: map[int64]*tg.User{alice.ID: alice}, Channels: map[int64]*tg.Channel{group.ID: group}}
+			},
+			lookups: [][]tg.PeerClass{{&tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}}},
+		},
+		{
+			name: "min",
+			entities: func() *tg.Entities {
+				min := *alice
+				min.Min = true
+				return &tg.Entities{Users: map[int64]*tg.User{alice.ID: &min}}
+			},
+			lookups: [][]tg.PeerClass{{&tg.PeerUser{UserID: alice.ID}}},
+			users:   1,
+		},
+		{
+			name: "min without full access hash",
+			entities: func() *tg.Entities {
+				return &tg.Entities{Users: map[int64]*tg.User{minUser.ID: minUser}}
+			},
+			lookups: [][]tg.PeerClass{{&tg.PeerUser{UserID: minUser.ID}}},
+		},
+		{
+			name:    "unknown",
+			lookups: [][]tg.PeerClass{{&tg.PeerUser{UserID: 99}, &tg.PeerChannel{ChannelID: 99}}},
+		},
+		{
+			name:    "error",
+			lookups: [][]tg.PeerClass{{&tg.PeerUser{UserID: alice.ID}}, {&tg.PeerUser{UserID: bob.ID}}},
+			script: func(i *gotgprototest.Invoker) {
+				i.Fail(&tg.UsersGetUsersRequest{}, gotgprototest.RPCError(400, "USER_ID_INVALID"))
+			},
+			users:   1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, i, _ := newResolver(ext.ResolveLazy)
+			if tt.script != nil {
+				tt.script(i)
+			}
+			var wg sync.WaitGroup
+			errs := make([]error, len(tt.lookups))
+			entities := make([]*tg.Entities, len(tt.lookups))
+			for n, peers := range tt.lookups {
+				entities[n] = &tg.Entities{}
+				if tt.entities != nil {
+					entities[n] = tt.entities()
+				}
+				wg.Add(1)
+				go func(n int, peers []tg.PeerClass) {
+					defer wg.Done()
+					errs[n] = r.Resolve(context.Background(), entities[n], peers...)
+				}(n, peers)
+			}
+			wg.Wait()
+
+			if n := len(gotgprototest.RequestsOf[*tg.UsersGetUsersRequest](i)); n != tt.users {
+				t.Fatalf("users.getUsers called %d times, want %d", n, tt.users)
+			}
+			if n := len(gotgprototest.RequestsOf[*tg.ChannelsGetChannelsRequest](i)); n != tt.channels {
+				t.Fatalf("channels.getChannels called %d times, want %d", n, tt.channels)
+			}
+			for n, peers := range tt.lookups {
+				if (errs[n] != nil) != tt.wantErr {
+					t.Fatalf("lookup %d: unexpected error %v", n, errs[n])
+				}
+				if tt.wantErr {
+					continue
+				}
+				for _, peer := range peers {
+					switch peer := peer.(type) {
+					case *tg.PeerUser:
+						u, ok := entities[n].Users[peer.UserID]
+						if tt.users > 0 && (!ok || u.Min) {
+							t.Fatalf("lookup %d: user %d was not resolved", n, peer.UserID)
+						}
+					case *tg.PeerChannel:
+						if _, ok := entities[n].Channels[peer.ChannelID]; tt.channels > 0 && !ok {
+							t.Fatalf("lookup %d: channel %d was not resolved", n, peer.ChannelID)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEntityResolverBatchInputs(t *testing.T) {
+	r, i, _ := newResolver(ext.ResolveLazy)
+	var wg sync.WaitGroup
+	for _, id := range []int64{alice.ID, bob.ID} {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			_ = r.Resolve(context.Background(), &tg.Entities{}, &tg.PeerUser{UserID: id})
+		}(id)
+	}
+	wg.Wait()
+	req := gotgprototest.ExpectRequest[*tg.UsersGetUsersRequest](t, i)
+	if len(req.ID) != 2 {
+		t.Fatalf("unexpected users %v", req.ID)
+	}
+	for _, input := range req.ID {
+		input, ok := input.(*tg.InputUser)
+		if !ok || input.AccessHash != gotgprototest.AccessHash(input.UserID) {
+			t.Fatalf("unexpected input user %v", input)
+		}
+	}
+}
+
+func TestEntityResolverMessageContext(t *testing.T) {
+	r, i, p := newResolver(ext.ResolveLazy)
+	// The min user is fetched through the message it was seen in.
+	p.SaveMessageContext(gotgprototest.TextMessage(7, &tg.PeerUser{UserID: minUser.ID}, &tg.PeerChannel{ChannelID: group.ID}, "hi"))
+	e := &tg.Entities{Users: map[int64]*tg.User{minUser.ID: minUser}}
+	_ = r.Resolve(context.Background(), e, &tg.PeerUser{UserID: minUser.ID})
+	req := gotgprototest.ExpectRequest[*tg.UsersGetUsersRequest](t, i)
+	if len(req.ID) != 1 {
+		t.Fatalf("unexpected users %v", req.ID)
+	}
+	if _, ok := req.ID[0].(*tg.InputUserFromMessage); !ok {
+		t.Fatalf("min user was fetched through %T", req.ID[0])
+	}
+}
+
+func TestEntityResolverMode(t *testing.T) {
+	tests := []struct {
+		mode ext.EntityResolution
+		// eager reports whether the user is fetched before EffectiveUser is called.
+		eager bool
+	}{
+		{mode: ext.ResolveLazy, eager: false},
+		{mode: ext.ResolveEager, eager: true},
+	}
+	for _, tt := range tests {
+		r, i, _ := newResolver(tt.mode)
+		e := &tg.Entities{Channels: map[int64]*tg.Channel{group.ID: group}}
+		m := gotgprototest.TextMessage(7, &tg.PeerUser{UserID: alice.ID}, &tg.PeerChannel{ChannelID: group.ID}, "hi")
+		u := ext.GetNewUpdate(context.Background(), r, 1, e, gotgprototest.NewMessage(m))
+		if n := len(gotgprototest.RequestsOf[*tg.UsersGetUsersRequest](i)); (n == 1) != tt.eager {
+			t.Fatalf("mode %d: users.getUsers called %d times before EffectiveUser", tt.mode, n)
+		}
+		if user := u.EffectiveUser(); user == nil || user.ID != alice.ID {
+			t.Fatalf("mode %d: unexpected effective user %v", tt.mode, user)
+		}
+		if n := len(gotgprototest.RequestsOf[*tg.UsersGetUsersRequest](i)); n != 1 {
+			t.Fatalf("mode %d: users.getUsers called %d times, want 1", tt.mode, n)
+		}
+		if len(gotgprototest.RequestsOf[*tg.ChannelsGetChannelsRequest](i)) != 0 {
+			t.Fatalf("mode %d: present channel was fetched", tt.mode)
+		}
+	}
+}
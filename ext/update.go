@@ -3,7 +3,6 @@ package ext
 import (
 	"context"
 	"strings"
-	"time"
 
 	"github.com/celestix/gotgproto/storage"
 	"github.com/celestix/gotgproto/types"
@@ -31,50 +30,27 @@ type Update struct {
 	Entities *tg.Entities
 	// User id of the user responsible for the update.
 	userId int64
+	// Peer of the user or channel which sent the message of the update.
+	sender tg.PeerClass
+
+	ctx      context.Context
+	resolver *EntityResolver
 }
 
 // GetNewUpdate creates a new Update with provided parameters.
-func GetNewUpdate(ctx context.Context, client *tg.Client, selfUserId int64, p *storage.PeerStorage, e *tg.Entities, update tg.UpdateClass) *Update {
+// Entities missing from the update are fetched through the provided EntityResolver according to its mode.
+func GetNewUpdate(ctx context.Context, r *EntityResolver, selfUserId int64, e *tg.Entities, update tg.UpdateClass) *Update {
 	u := &Update{
 		UpdateClass: update,
 		Entities:    e,
+		ctx:         ctx,
+		resolver:    r,
 	}
 	switch update := update.(type) {
-	case *tg.UpdateNewMessage:
-		m := update.GetMessage()
-		u.EffectiveMessage = types.ConstructMessage(m)
-		diff, err := client.UpdatesGetDifference(ctx, &tg.UpdatesGetDifferenceRequest{
-			Pts:  update.Pts - 1,
-			Date: int(time.Now().Unix()),
-		})
-		// Silently add catched entities to *tg.Entities
-		if err == nil {
-			if value, ok := diff.(*tg.UpdatesDifference); ok {
-				for _, vu := range value.Chats {
-					switch chat := vu.(type) {
-					case *tg.Chat:
-						p.AddPeer(chat.ID, storage.DefaultAccessHash, storage.TypeChat, storage.DefaultUsername)
-						e.Chats[chat.ID] = chat
-					case *tg.Channel:
-						p.AddPeer(chat.ID, chat.AccessHash, storage.TypeChannel, chat.Username)
-						e.Channels[chat.ID] = chat
-					}
-				}
-				for _, vu := range value.Users {
-					user, ok := vu.AsNotEmpty()
-					if !ok {
-						continue
-					}
-					p.AddPeer(user.ID, user.AccessHash, storage.TypeUser, user.Username)
-					e.Users[user.ID] = user
-				}
-			}
-		}
-		u.fillUserIdFromMessage(selfUserId)
 	case message.AnswerableMessageUpdate:
 		m := update.GetMessage()
 		u.EffectiveMessage = types.ConstructMessage(m)
-		u.fillUserIdFromMessage(selfUserId)
+		u.fillSenderFromMessage(selfUserId)
 	case *tg.UpdateBotCallbackQuery:
 		u.CallbackQuery = update
 		u.userId = update.UserID
@@ -90,9 +66,46 @@ func GetNewUpdate(ctx context.Context, client *tg.Client, selfUserId int64, p *s
 		u.ChannelParticipant = update
 		u.userId = update.UserID
 	}
+	if r != nil && r.Mode == ResolveEager {
+		_ = u.resolve(u.peers()...)
+	}
 	return u
 }
 
+// peers returns all the peers which are referred by the update.
+func (u *Update) peers() []tg.PeerClass {
+	peers := make([]tg.PeerClass, 0, 3)
+	if u.userId != 0 {
+		peers = append(peers, &tg.PeerUser{UserID: u.userId})
+	}
+	if peer := u.ChatPeer(); peer != nil {
+		peers = append(peers, peer)
+	}
+	if u.sender != nil {
+		peers = append(peers, u.sender)
+	}
+	if m := u.EffectiveMessage; m != nil {
+		if m.ViaBotID != 0 {
+			peers = append(peers, &tg.PeerUser{UserID: m.ViaBotID})
+		}
+		if m.FwdFrom.FromID != nil {
+			peers = append(peers, m.FwdFrom.FromID)
+		}
+	}
+	return peers
+}
+
+// resolve fetches the provided peers if they are missing from the entities of the update.
+func (u *Update) resolve(peers ...tg.PeerClass) error {
+	if u.resolver == nil || u.Entities == nil {
+		return nil
+	}
+	if m := u.EffectiveMessage; m != nil && m.Message != nil && m.ID != 0 && m.PeerID != nil {
+		return u.resolver.ResolveMessage(u.ctx, u.Entities, m.Message, peers...)
+	}
+	return u.resolver.Resolve(u.ctx, u.Entities, peers...)
+}
+
 func (u *Update) Args() []string {
 	switch {
 	case u.EffectiveMessage != nil:
@@ -108,41 +121,126 @@ func (u *Update) Args() []string {
 
 // EffectiveUser returns the tg.User who is responsible for the update.
 func (u *Update) EffectiveUser() *tg.User {
-	if u.Entities == nil {
+	if u.userId == 0 {
 		return nil
 	}
-	if u.userId == 0 {
+	return u.getUser(&tg.PeerUser{UserID: u.userId})
+}
+
+// SenderPeer returns the peer of the user or channel responsible for the update, nil if it is unknown.
+func (u *Update) SenderPeer() tg.PeerClass {
+	if u.sender == nil && u.userId != 0 {
+		return &tg.PeerUser{UserID: u.userId}
+	}
+	return u.sender
+}
+
+// EffectiveSender returns the user or channel responsible for the update, which is a types.Channel for channel posts
+// and messages sent on behalf of a chat (i.e. by anonymous admins) and a types.User otherwise.
+// It returns types.EmptyUC if the sender is unknown.
+func (u *Update) EffectiveSender() types.EffectiveChat {
+	peer := u.SenderPeer()
+	if peer == nil {
+		return &types.EmptyUC{}
+	}
+	return u.getEffectiveChat(peer)
+}
+
+// SenderChat returns the tg.Channel on behalf of which the message of the update was sent,
+// i.e. for channel posts and messages of anonymous group admins.
+func (u *Update) SenderChat() *tg.Channel {
+	peer, ok := u.sender.(*tg.PeerChannel)
+	if !ok {
 		return nil
 	}
-	return u.Entities.Users[u.userId]
+	return u.getChannel(peer)
 }
 
-// GetChat returns the responsible tg.Chat for the current update.
-func (u *Update) GetChat() *tg.Chat {
-	if u.Entities == nil {
+// ViaBot returns the tg.User of the bot through which the message of the update was sent via inline mode.
+func (u *Update) ViaBot() *tg.User {
+	if u.EffectiveMessage == nil || u.EffectiveMessage.ViaBotID == 0 {
 		return nil
 	}
-	var (
-		peer tg.PeerClass
-	)
+	return u.getUser(&tg.PeerUser{UserID: u.EffectiveMessage.ViaBotID})
+}
+
+// ForwardOrigin contains the details about the origin of a forwarded message.
+type ForwardOrigin struct {
+	// Sender is the original sender of the message, types.EmptyUC if the sender hid their account.
+	Sender types.EffectiveChat
+	// SenderName is the name of the original sender if they hid their account.
+	SenderName string
+	// PostAuthor is the signature of the author of the original channel post.
+	PostAuthor string
+	// MessageID is the id of the original message in its channel.
+	MessageID int
+	// Date is the unix time when the original message was sent.
+	Date int
+	// Header is the raw forward header of the message.
+	Header *tg.MessageFwdHeader
+}
+
+// ForwardOrigin returns the origin of the message of the update, nil if it was not forwarded.
+func (u *Update) ForwardOrigin() *ForwardOrigin {
+	if u.EffectiveMessage == nil {
+		return nil
+	}
+	header, ok := u.EffectiveMessage.GetFwdFrom()
+	if !ok {
+		return nil
+	}
+	origin := &ForwardOrigin{
+		Sender:     &types.EmptyUC{},
+		SenderName: header.FromName,
+		PostAuthor: header.PostAuthor,
+		MessageID:  header.ChannelPost,
+		Date:       header.Date,
+		Header:     &header,
+	}
+	if header.FromID != nil {
+		origin.Sender = u.getEffectiveChat(header.FromID)
+	}
+	return origin
+}
+
+// ChatPeer returns the peer of the chat where the update took place, nil if the update doesn't belong to a chat.
+func (u *Update) ChatPeer() tg.PeerClass {
 	switch {
 	case u.EffectiveMessage != nil:
-		peer = u.EffectiveMessage.PeerID
+		return u.EffectiveMessage.PeerID
 	case u.CallbackQuery != nil:
-		peer = u.CallbackQuery.Peer
+		return u.CallbackQuery.Peer
 	case u.ChatJoinRequest != nil:
-		peer = u.ChatJoinRequest.Peer
+		return u.ChatJoinRequest.Peer
 	case u.ChatParticipant != nil:
-		peer = &tg.PeerChat{ChatID: u.ChatParticipant.ChatID}
+		return &tg.PeerChat{ChatID: u.ChatParticipant.ChatID}
+	case u.ChannelParticipant != nil:
+		return &tg.PeerChannel{ChannelID: u.ChannelParticipant.ChannelID}
 	}
-	if peer == nil {
+	return nil
+}
+
+// ChatID returns the PeerID of the chat where the update took place, 0 if the update doesn't belong to a chat.
+// Its Bot API-style marked ID can be passed to the methods of Context taking a chat id.
+func (u *Update) ChatID() storage.PeerID {
+	return storage.PeerIDFromPeer(u.ChatPeer())
+}
+
+// SenderID returns the PeerID of the user or channel responsible for the update, 0 if it is unknown.
+func (u *Update) SenderID() storage.PeerID {
+	return storage.PeerIDFromPeer(u.SenderPeer())
+}
+
+// GetChat returns the responsible tg.Chat for the current update.
+func (u *Update) GetChat() *tg.Chat {
+	if u.Entities == nil {
 		return nil
 	}
-	c, ok := peer.(*tg.PeerChat)
+	c, ok := u.ChatPeer().(*tg.PeerChat)
 	if !ok {
 		return nil
 	}
-	return u.Entities.Chats[c.ChatID]
+	return u.getChat(c)
 }
 
 // GetChannel returns the responsible tg.Channel for the current update.
@@ -150,55 +248,84 @@ func (u *Update) GetChannel() *tg.Channel {
 	if u.Entities == nil {
 		return nil
 	}
-	var (
-		peer tg.PeerClass
-	)
-	switch {
-	case u.EffectiveMessage != nil:
-		peer = u.EffectiveMessage.PeerID
-	case u.CallbackQuery != nil:
-		peer = u.CallbackQuery.Peer
-	case u.ChatJoinRequest != nil:
-		peer = u.ChatJoinRequest.Peer
-	case u.ChannelParticipant != nil:
-		peer = &tg.PeerChannel{ChannelID: u.ChannelParticipant.ChannelID}
+	c, ok := u.ChatPeer().(*tg.PeerChannel)
+	if !ok {
+		return nil
 	}
-	if peer == nil {
+	return u.getChannel(c)
+}
+
+// GetUserChat returns the responsible tg.User for the current update.
+func (u *Update) GetUserChat() *tg.User {
+	if u.Entities == nil {
 		return nil
 	}
-	c, ok := peer.(*tg.PeerChannel)
+	c, ok := u.ChatPeer().(*tg.PeerUser)
 	if !ok {
 		return nil
 	}
-	return u.Entities.Channels[c.ChannelID]
+	return u.getUser(c)
 }
 
-// GetUserChat returns the responsible tg.User for the current update.
-func (u *Update) GetUserChat() *tg.User {
+// getUser returns the tg.User of the provided peer from the entities of the update, fetching it if missing.
+func (u *Update) getUser(peer *tg.PeerUser) *tg.User {
 	if u.Entities == nil {
 		return nil
 	}
-	var (
-		peer tg.PeerClass
-	)
-	switch {
-	case u.EffectiveMessage != nil:
-		peer = u.EffectiveMessage.PeerID
-	case u.CallbackQuery != nil:
-		peer = u.CallbackQuery.Peer
-	case u.ChatJoinRequest != nil:
-		peer = u.ChatJoinRequest.Peer
-	case u.ChatParticipant != nil:
-		peer = &tg.PeerChat{ChatID: u.ChatParticipant.ChatID}
+	user, ok := u.Entities.Users[peer.UserID]
+	if !ok {
+		_ = u.resolve(peer)
+		user = u.Entities.Users[peer.UserID]
 	}
-	if peer == nil {
+	return user
+}
+
+// getChannel returns the tg.Channel of the provided peer from the entities of the update, fetching it if missing.
+func (u *Update) getChannel(peer *tg.PeerChannel) *tg.Channel {
+	if u.Entities == nil {
 		return nil
 	}
-	c, ok := peer.(*tg.PeerUser)
+	channel, ok := u.Entities.Channels[peer.ChannelID]
 	if !ok {
+		_ = u.resolve(peer)
+		channel = u.Entities.Channels[peer.ChannelID]
+	}
+	return channel
+}
+
+// getChat returns the tg.Chat of the provided peer from the entities of the update, fetching it if missing.
+func (u *Update) getChat(peer *tg.PeerChat) *tg.Chat {
+	if u.Entities == nil {
 		return nil
 	}
-	return u.Entities.Users[c.UserID]
+	chat, ok := u.Entities.Chats[peer.ChatID]
+	if !ok {
+		_ = u.resolve(peer)
+		chat = u.Entities.Chats[peer.ChatID]
+	}
+	return chat
+}
+
+// getEffectiveChat returns the EffectiveChat of the provided peer, types.EmptyUC if it is unknown.
+func (u *Update) getEffectiveChat(peer tg.PeerClass) types.EffectiveChat {
+	switch peer := peer.(type) {
+	case *tg.PeerUser:
+		if c := u.getUser(peer); c != nil {
+			cn := types.User(*c)
+			return &cn
+		}
+	case *tg.PeerChat:
+		if c := u.getChat(peer); c != nil {
+			cn := types.Chat(*c)
+			return &cn
+		}
+	case *tg.PeerChannel:
+		if c := u.getChannel(peer); c != nil {
+			cn := types.Channel(*c)
+			return &cn
+		}
+	}
+	return &types.EmptyUC{}
 }
 
 // EffectiveChat returns the responsible EffectiveChat for the current update.
@@ -216,32 +343,29 @@ func (u *Update) EffectiveChat() types.EffectiveChat {
 	return &types.EmptyUC{}
 }
 
-func (u *Update) fillUserIdFromMessage(selfUserId int64) {
-	if u.Entities != nil && u.Entities.Users != nil {
-		for uId := range u.Entities.Users {
-			if uId == selfUserId {
-				continue
+// fillSenderFromMessage finds the sender of the message, which can be a user or a channel
+// in case of channel posts and anonymous group admins.
+func (u *Update) fillSenderFromMessage(selfUserId int64) {
+	m := u.EffectiveMessage
+	switch {
+	case m.FromID != nil:
+		u.sender = m.FromID
+	case m.PeerID == nil:
+		return
+	default:
+		// Messages of private chats and channel posts may not have a FromID.
+		switch peer := m.PeerID.(type) {
+		case *tg.PeerUser:
+			if m.Out {
+				u.sender = &tg.PeerUser{UserID: selfUserId}
+			} else {
+				u.sender = peer
 			}
-			u.userId = uId
-			break
+		case *tg.PeerChannel:
+			u.sender = peer
 		}
 	}
-	// var userPeer tg.PeerClass
-	// switch _m := m.(type) {
-	// case *tg.Message:
-	// 	userPeer = _m.FromID
-	// case *tg.MessageService:
-	// 	userPeer = _m.FromID
-	// }
-	// uId, ok := userPeer.(*tg.PeerUser)
-	// if !ok {
-	// 	if u.Entities != nil && u.Entities.Users != nil {
-	// 		for uId := range u.Entities.Users {
-	// 			u.userId = uId
-	// 			break
-	// 		}
-	// 	}
-	// } else {
-	// 	u.userId = uId.UserID
-	// }
+	if peer, ok := u.sender.(*tg.PeerUser); ok {
+		u.userId = peer.UserID
+	}
 }
@@ -0,0 +1,153 @@
+package ext_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+func TestUpdateSender(t *testing.T) {
+	var (
+		self    = gotgprototest.Bot(1, "test_bot")
+		inline  = gotgprototest.Bot(20, "inline_bot")
+		private = &tg.PeerUser{UserID: alice.ID}
+		chat    = &tg.PeerChannel{ChannelID: group.ID}
+		channel = &tg.PeerChannel{ChannelID: news.ID}
+	)
+	message := func(from, chat tg.PeerClass, opts ...func(*tg.Message)) *tg.Message {
+		m := gotgprototest.TextMessage(7, from, chat, "hi")
+		for _, opt := range opts {
+			opt(m)
+		}
+		return m
+	}
+	out := func(m *tg.Message) { m.Out = true }
+	viaBot := func(m *tg.Message) { m.SetViaBotID(inline.ID) }
+	forwarded := func(header tg.MessageFwdHeader) func(*tg.Message) {
+		return func(m *tg.Message) { m.SetFwdFrom(header) }
+	}
+
+	type origin struct {
+		sender     int64
+		senderName string
+		postAuthor string
+		messageID  int
+	}
+	tests := []struct {
+		name   string
+		msg    *tg.Message
+		sender storage.PeerID
+		// user, senderChat and viaBot are the ids of the returned users and channel, 0 if nil is expected.
+		user       int64
+		senderChat int64
+		viaBot     int64
+		origin     *origin
+	}{
+		{
+			name:   "user",
+			msg:    message(private, chat),
+			sender: storage.NewPeerID(alice.ID, storage.TypeUser),
+			user:   alice.ID,
+		},
+		{
+			name:   "private",
+			msg:    message(nil, private),
+			sender: storage.NewPeerID(alice.ID, storage.TypeUser),
+			user:   alice.ID,
+		},
+		{
+			name:   "private outgoing",
+			msg:    message(nil, private, out),
+			sender: storage.NewPeerID(self.ID, storage.TypeUser),
+			user:   self.ID,
+		},
+		{
+			name:       "channel post",
+			msg:        message(nil, channel),
+			sender:     storage.NewPeerID(news.ID, storage.TypeChannel),
+			senderChat: news.ID,
+		},
+		{
+			name:       "anonymous admin",
+			msg:        message(chat, chat),
+			sender:     storage.NewPeerID(group.ID, storage.TypeChannel),
+			senderChat: group.ID,
+		},
+		{
+			name:   "via bot",
+			msg:    message(private, chat, viaBot),
+			sender: storage.NewPeerID(alice.ID, storage.TypeUser),
+			user:   alice.ID,
+			viaBot: inline.ID,
+		},
+		{
+			name:   "forwarded from user",
+			msg:    message(private, chat, forwarded(tg.MessageFwdHeader{FromID: &tg.PeerUser{UserID: bob.ID}})),
+			sender: storage.NewPeerID(alice.ID, storage.TypeUser),
+			user:   alice.ID,
+			origin: &origin{sender: bob.ID},
+		},
+		{
+			name:   "forwarded from hidden user",
+			msg:    message(private, chat, forwarded(tg.MessageFwdHeader{FromName: "Hidden"})),
+			sender: storage.NewPeerID(alice.ID, storage.TypeUser),
+			user:   alice.ID,
+			origin: &origin{senderName: "Hidden"},
+		},
+		{
+			name:   "forwarded channel post",
+			msg:    message(private, chat, forwarded(tg.MessageFwdHeader{FromID: channel, ChannelPost: 5, PostAuthor: "Editor"})),
+			sender: storage.NewPeerID(alice.ID, storage.TypeUser),
+			user:   alice.ID,
+			origin: &origin{sender: news.ID, postAuthor: "Editor", messageID: 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &tg.Entities{
+				Users:    map[int64]*tg.User{self.ID: self, alice.ID: alice, bob.ID: bob, inline.ID: inline},
+				Channels: map[int64]*tg.Channel{group.ID: group, news.ID: news},
+			}
+			u := ext.GetNewUpdate(context.Background(), nil, self.ID, e, gotgprototest.NewMessage(tt.msg))
+
+			if u.SenderID() != tt.sender {
+				t.Fatalf("unexpected sender id %d, want %d", u.SenderID(), tt.sender)
+			}
+			if sender := u.EffectiveSender(); sender.GetID() != tt.sender.ID() || sender.IsAChannel() != (tt.sender.Type() == storage.TypeChannel) {
+				t.Fatalf("unexpected effective sender %d", sender.GetID())
+			}
+			if user := u.EffectiveUser(); id(user) != tt.user {
+				t.Fatalf("unexpected effective user %d, want %d", id(user), tt.user)
+			}
+			if c := u.SenderChat(); c == nil && tt.senderChat != 0 || c != nil && c.ID != tt.senderChat {
+				t.Fatalf("unexpected sender chat %v, want %d", c, tt.senderChat)
+			}
+			if bot := u.ViaBot(); id(bot) != tt.viaBot {
+				t.Fatalf("unexpected via bot %d, want %d", id(bot), tt.viaBot)
+			}
+
+			o := u.ForwardOrigin()
+			if (o != nil) != (tt.origin != nil) {
+				t.Fatalf("unexpected forward origin %v", o)
+			}
+			if o == nil {
+				return
+			}
+			got := origin{sender: o.Sender.GetID(), senderName: o.SenderName, postAuthor: o.PostAuthor, messageID: o.MessageID}
+			if got != *tt.origin {
+				t.Fatalf("unexpected forward origin %+v, want %+v", got, *tt.origin)
+			}
+		})
+	}
+}
+
+func id(u *tg.User) int64 {
+	if u == nil {
+		return 0
+	}
+	return u.ID
+}
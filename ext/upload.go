@@ -0,0 +1,330 @@
+package ext
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mtp_errors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/parsemode"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+)
+
+// sniffLen is the number of bytes used to detect the MIME type of a file, see http.DetectContentType.
+const sniffLen = 512
+
+// UploadInputClass is an interface which is used to upload media.
+// It can be one from UploadInputPath, UploadInputReader and UploadInputBytes.
+type UploadInputClass interface {
+	fileName() string
+	// open returns the content of the file, its size (-1 if unknown) and the first bytes of it to detect its MIME type.
+	open() (r io.Reader, size int64, head []byte, closeFn func() error, err error)
+}
+
+// UploadInputPath is used to upload a file from the local file system.
+type UploadInputPath string
+
+func (u UploadInputPath) fileName() string {
+	return filepath.Base(string(u))
+}
+
+func (u UploadInputPath) open() (io.Reader, int64, []byte, func() error, error) {
+	f, err := os.Open(string(u))
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, nil, nil, err
+	}
+	br := bufio.NewReaderSize(f, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	return br, stat.Size(), head, f.Close, nil
+}
+
+// UploadInputReader is used to upload a file from an io.Reader.
+// Size is optional, the file is uploaded as a big file if it isn't provided.
+type UploadInputReader struct {
+	io.Reader
+	// Name is the file name sent to telegram, it is also used to detect the MIME type.
+	Name string
+	// Size is the total size of the content in bytes, 0 if unknown.
+	Size int64
+}
+
+func (u UploadInputReader) fileName() string {
+	return u.Name
+}
+
+func (u UploadInputReader) open() (io.Reader, int64, []byte, func() error, error) {
+	br := bufio.NewReaderSize(u.Reader, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	size := u.Size
+	if size <= 0 {
+		size = -1
+	}
+	return br, size, head, nil, nil
+}
+
+// UploadInputBytes is used to upload a file from memory.
+type UploadInputBytes struct {
+	// Name is the file name sent to telegram, it is also used to detect the MIME type.
+	Name string
+	Data []byte
+}
+
+func (u UploadInputBytes) fileName() string {
+	return u.Name
+}
+
+func (u UploadInputBytes) open() (io.Reader, int64, []byte, func() error, error) {
+	head := u.Data
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	return bytes.NewReader(u.Data), int64(len(u.Data)), head, nil, nil
+}
+
+// SendMediaOpts object contains optional parameters for Context.SendDocument, Context.SendPhoto,
+// Context.SendVideo and Context.SendAudio.
+type SendMediaOpts struct {
+	// Caption of the media, it can be one from string, HTML, MarkdownV2 or styled text.
+	Caption ReplyTextType
+	// FileName overrides the name of the uploaded file.
+	FileName string
+	// MimeType overrides the detected MIME type of the uploaded file.
+	MimeType string
+	// Thumb is the thumbnail of a document, it should be a JPEG not bigger than 320x320.
+	Thumb UploadInputClass
+	// Spoiler hides the media behind a spoiler.
+	Spoiler bool
+	// Silent sends the message without a notification.
+	Silent bool
+	// Reply markup of a message, i.e. inline keyboard buttons etc.
+	Markup           tg.ReplyMarkupClass
+	ReplyToMessageId int
+	// Threads sets uploading goroutines limit for big files.
+	Threads int
+	// PartSize sets chunk size. Must be divisible by 1KB and not bigger than 512KB.
+	PartSize int
+	// Progress is notified about every uploaded chunk.
+	Progress uploader.Progress
+}
+
+// SendVideoOpts object contains optional parameters for Context.SendVideo.
+type SendVideoOpts struct {
+	SendMediaOpts
+	Duration          time.Duration
+	Width             int
+	Height            int
+	SupportsStreaming bool
+	// RoundMessage sends the video as a round video message.
+	RoundMessage bool
+}
+
+// SendAudioOpts object contains optional parameters for Context.SendAudio.
+type SendAudioOpts struct {
+	SendMediaOpts
+	Duration  time.Duration
+	Title     string
+	Performer string
+	// Voice sends the audio as a voice message.
+	Voice bool
+}
+
+// SendDocument uploads the provided file and sends it as a document.
+// The MIME type is detected from the file name and content unless SendMediaOpts.MimeType is set.
+func (ctx *Context) SendDocument(chatId int64, file UploadInputClass, opts *SendMediaOpts) (*types.Message, error) {
+	if opts == nil {
+		opts = &SendMediaOpts{}
+	}
+	doc, err := ctx.uploadDocument(file, opts, "")
+	if err != nil {
+		return nil, err
+	}
+	doc.ForceFile = true
+	return ctx.sendUploadedMedia(chatId, doc, opts)
+}
+
+// SendPhoto uploads the provided image and sends it as a photo.
+func (ctx *Context) SendPhoto(chatId int64, file UploadInputClass, opts *SendMediaOpts) (*types.Message, error) {
+	if opts == nil {
+		opts = &SendMediaOpts{}
+	}
+	f, _, err := ctx.uploadFile(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.sendUploadedMedia(chatId, &tg.InputMediaUploadedPhoto{File: f, Spoiler: opts.Spoiler}, opts)
+}
+
+// SendVideo uploads the provided file and sends it as a video.
+func (ctx *Context) SendVideo(chatId int64, file UploadInputClass, opts *SendVideoOpts) (*types.Message, error) {
+	if opts == nil {
+		opts = &SendVideoOpts{}
+	}
+	doc, err := ctx.uploadDocument(file, &opts.SendMediaOpts, "video/mp4")
+	if err != nil {
+		return nil, err
+	}
+	doc.Attributes = append(doc.Attributes, &tg.DocumentAttributeVideo{
+		Duration:          opts.Duration.Seconds(),
+		W:                 opts.Width,
+		H:                 opts.Height,
+		SupportsStreaming: opts.SupportsStreaming,
+		RoundMessage:      opts.RoundMessage,
+	})
+	return ctx.sendUploadedMedia(chatId, doc, &opts.SendMediaOpts)
+}
+
+// SendAudio uploads the provided file and sends it as an audio or as a voice message.
+func (ctx *Context) SendAudio(chatId int64, file UploadInputClass, opts *SendAudioOpts) (*types.Message, error) {
+	if opts == nil {
+		opts = &SendAudioOpts{}
+	}
+	defaultMime := "audio/mpeg"
+	if opts.Voice {
+		defaultMime = "audio/ogg"
+	}
+	doc, err := ctx.uploadDocument(file, &opts.SendMediaOpts, defaultMime)
+	if err != nil {
+		return nil, err
+	}
+	doc.Attributes = append(doc.Attributes, &tg.DocumentAttributeAudio{
+		Duration:  int(opts.Duration.Seconds()),
+		Title:     opts.Title,
+		Performer: opts.Performer,
+		Voice:     opts.Voice,
+	})
+	return ctx.sendUploadedMedia(chatId, doc, &opts.SendMediaOpts)
+}
+
+// uploadDocument uploads the file and thumbnail of a document.
+// defaultMime is used if the detected MIME type of the file isn't of the same kind as it, i.e. not a video for "video/mp4".
+func (ctx *Context) uploadDocument(file UploadInputClass, opts *SendMediaOpts, defaultMime string) (*tg.InputMediaUploadedDocument, error) {
+	f, mimeType, err := ctx.uploadFile(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MimeType == "" && defaultMime != "" {
+		if major, _, _ := strings.Cut(defaultMime, "/"); !strings.HasPrefix(mimeType, major+"/") {
+			mimeType = defaultMime
+		}
+	}
+	doc := &tg.InputMediaUploadedDocument{
+		File:     f,
+		MimeType: mimeType,
+		Spoiler:  opts.Spoiler,
+	}
+	if name := uploadFileName(file, opts); name != "" {
+		doc.Attributes = append(doc.Attributes, &tg.DocumentAttributeFilename{FileName: name})
+	}
+	if opts.Thumb != nil {
+		thumb, _, err := ctx.uploadFile(opts.Thumb, &SendMediaOpts{Threads: opts.Threads, PartSize: opts.PartSize})
+		if err != nil {
+			return nil, err
+		}
+		doc.Thumb = thumb
+	}
+	return doc, nil
+}
+
+// uploadFile uploads the provided file and returns it with its MIME type.
+func (ctx *Context) uploadFile(file UploadInputClass, opts *SendMediaOpts) (tg.InputFileClass, string, error) {
+	if file == nil {
+		return nil, "", mtp_errors.ErrFileEmpty
+	}
+	r, size, head, closeFn, err := file.open()
+	if err != nil {
+		return nil, "", err
+	}
+	if closeFn != nil {
+		defer closeFn()
+	}
+	name := uploadFileName(file, opts)
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = detectMimeType(name, head)
+	}
+	u := uploader.NewUploader(ctx.Raw).WithThreads(opts.Threads)
+	if opts.PartSize > 0 {
+		u = u.WithPartSize(opts.PartSize)
+	}
+	if opts.Progress != nil {
+		u = u.WithProgress(opts.Progress)
+	}
+	f, err := u.Upload(ctx, uploader.NewUpload(name, r, size))
+	return f, mimeType, err
+}
+
+func uploadFileName(file UploadInputClass, opts *SendMediaOpts) string {
+	if opts.FileName != "" {
+		return opts.FileName
+	}
+	return file.fileName()
+}
+
+// detectMimeType detects the MIME type of a file from its extension, and from its content if the extension is unknown.
+func detectMimeType(name string, head []byte) string {
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(head)
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	return mimeType
+}
+
+func (ctx *Context) sendUploadedMedia(chatId int64, media tg.InputMediaClass, opts *SendMediaOpts) (*types.Message, error) {
+	request := &tg.MessagesSendMediaRequest{
+		Media:       media,
+		Silent:      opts.Silent,
+		ReplyMarkup: opts.Markup,
+	}
+	if opts.ReplyToMessageId != 0 {
+		request.ReplyTo = &tg.InputReplyToMessage{ReplyToMsgID: opts.ReplyToMessageId}
+	}
+	if opts.Caption != nil {
+		var err error
+		request.Message, request.Entities, err = ctx.formatText(opts.Caption)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ctx.SendMedia(chatId, request)
+}
+
+// formatText returns the text and entities of the provided ReplyTextType.
+func (ctx *Context) formatText(text ReplyTextType) (string, []tg.MessageEntityClass, error) {
+	var opts []styling.StyledTextOption
+	switch text := text.(type) {
+	case *ReplyTextTypeString:
+		return text.get(), nil, nil
+	case *ReplyTextTypeStyledText:
+		opts = []styling.StyledTextOption{text.get()}
+	case *ReplyTextTypeStyledTextArray:
+		opts = text.get()
+	case *ReplyTextTypeHTML:
+		opts = []styling.StyledTextOption{parsemode.HTML(text.get(), ctx.resolveInputUser)}
+	case *ReplyTextTypeMarkdownV2:
+		opts = []styling.StyledTextOption{parsemode.MarkdownV2(text.get(), ctx.resolveInputUser)}
+	default:
+		return "", nil, mtp_errors.ErrTextInvalid
+	}
+	tb := entity.Builder{}
+	if err := styling.Perform(&tb, opts...); err != nil {
+		return "", nil, err
+	}
+	msg, entities := tb.Complete()
+	return msg, entities, nil
+}
@@ -0,0 +1,140 @@
+package ext_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/generic"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+)
+
+type countProgress struct{ uploaded atomic.Int64 }
+
+func (p *countProgress) Chunk(_ context.Context, state uploader.ProgressState) error {
+	p.uploaded.Store(state.Uploaded)
+	return nil
+}
+
+func uploadedParts(t *testing.T, c *gotgprototest.Client) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	for _, req := range gotgprototest.RequestsOf[*tg.UploadSaveFilePartRequest](c.Invoker) {
+		b.Write(req.Bytes)
+	}
+	return b.Bytes()
+}
+
+func documentAttribute[T tg.DocumentAttributeClass](t *testing.T, doc *tg.InputMediaUploadedDocument) T {
+	t.Helper()
+	for _, attr := range doc.Attributes {
+		if a, ok := attr.(T); ok {
+			return a
+		}
+	}
+	var zero T
+	t.Fatalf("attribute %T not found in %v", zero, doc.Attributes)
+	return zero
+}
+
+func TestSendDocument(t *testing.T) {
+	c := newClient()
+	path := filepath.Join(t.TempDir(), "report.pdf")
+	content := []byte("%PDF-1.4 document")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	progress := &countProgress{}
+	msg, err := c.Context().SendDocument(group.ID, ext.UploadInputPath(path), &ext.SendMediaOpts{
+		Caption:          ext.ReplyTextHTML("<b>report</b>"),
+		ReplyToMessageId: 3,
+		Thumb:            ext.UploadInputBytes{Name: "thumb.jpg", Data: []byte{0xff, 0xd8, 0xff}},
+		Progress:         progress,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "report" {
+		t.Fatalf("unexpected message %v", msg.Message)
+	}
+	if !bytes.HasPrefix(uploadedParts(t, c), content) || progress.uploaded.Load() == 0 {
+		t.Fatal("file was not uploaded")
+	}
+	req := gotgprototest.ExpectRequest[*tg.MessagesSendMediaRequest](t, c.Invoker)
+	doc, ok := req.Media.(*tg.InputMediaUploadedDocument)
+	if !ok {
+		t.Fatalf("unexpected media %v", req.Media)
+	}
+	if doc.MimeType != "application/pdf" || !doc.ForceFile || doc.Thumb == nil {
+		t.Fatalf("unexpected document %v", doc)
+	}
+	if name := documentAttribute[*tg.DocumentAttributeFilename](t, doc); name.FileName != "report.pdf" {
+		t.Fatalf("unexpected file name %q", name.FileName)
+	}
+	if req.Message != "report" || len(req.Entities) != 1 {
+		t.Fatalf("unexpected caption %q %v", req.Message, req.Entities)
+	}
+	if replyTo, ok := req.ReplyTo.(*tg.InputReplyToMessage); !ok || replyTo.ReplyToMsgID != 3 {
+		t.Fatalf("unexpected reply to %v", req.ReplyTo)
+	}
+}
+
+func TestSendPhoto(t *testing.T) {
+	c := newClient()
+	_, err := generic.SendPhoto(c.Context(), group.ID, ext.UploadInputReader{Reader: strings.NewReader("image"), Name: "a.jpg"}, &ext.SendMediaOpts{Spoiler: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := gotgprototest.ExpectRequest[*tg.MessagesSendMediaRequest](t, c.Invoker)
+	if photo, ok := req.Media.(*tg.InputMediaUploadedPhoto); !ok || !photo.Spoiler {
+		t.Fatalf("unexpected media %v", req.Media)
+	}
+	if big := gotgprototest.RequestsOf[*tg.UploadSaveBigFilePartRequest](c.Invoker); len(big) == 0 {
+		t.Fatal("expected a reader of unknown size to be uploaded as a big file")
+	}
+}
+
+func TestSendVideoAndAudio(t *testing.T) {
+	c := newClient()
+	_, err := c.Context().SendVideo(alice.ID, ext.UploadInputBytes{Name: "clip", Data: []byte("video")}, &ext.SendVideoOpts{
+		Duration: 90 * time.Second, Width: 640, Height: 360, SupportsStreaming: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := gotgprototest.ExpectRequest[*tg.MessagesSendMediaRequest](t, c.Invoker)
+	doc := req.Media.(*tg.InputMediaUploadedDocument)
+	video := documentAttribute[*tg.DocumentAttributeVideo](t, doc)
+	if doc.MimeType != "video/mp4" || video.Duration != 90 || video.W != 640 || !video.SupportsStreaming {
+		t.Fatalf("unexpected video %v %v", doc.MimeType, video)
+	}
+
+	c.Invoker.Reset()
+	_, err = c.Context().SendAudio(alice.ID, ext.UploadInputBytes{Name: "song.mp3", Data: []byte("ID3")}, &ext.SendAudioOpts{
+		Duration: 3 * time.Minute, Title: "Song", Performer: "Band",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = gotgprototest.ExpectRequest[*tg.MessagesSendMediaRequest](t, c.Invoker)
+	doc = req.Media.(*tg.InputMediaUploadedDocument)
+	audio := documentAttribute[*tg.DocumentAttributeAudio](t, doc)
+	if doc.MimeType != "audio/mpeg" || audio.Duration != 180 || audio.Title != "Song" || audio.Performer != "Band" {
+		t.Fatalf("unexpected audio %v %v", doc.MimeType, audio)
+	}
+}
+
+func TestSendDocumentEmpty(t *testing.T) {
+	c := newClient()
+	if _, err := c.Context().SendDocument(alice.ID, nil, nil); err == nil {
+		t.Fatal("expected an error sending a nil file")
+	}
+}
@@ -0,0 +1,192 @@
+package flood
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+)
+
+// maxBuckets is the number of buckets after which the idle ones are dropped.
+const maxBuckets = 4096
+
+type bucketKind uint8
+
+const (
+	kindMethod bucketKind = iota
+	kindGlobal
+	kindChat
+	kindGroup
+)
+
+type bucketKey struct {
+	kind   bucketKind
+	method string
+	// peerType is the type of the InputPeer of a chat, peerID is its ID.
+	peerType uint32
+	peerID   int64
+}
+
+// limitedKey is a bucket a request goes through along with the limit of the bucket.
+type limitedKey struct {
+	key   bucketKey
+	limit Limit
+}
+
+// bucket is a token bucket, which may also be blocked by a wait requested by Telegram.
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+	until  time.Time
+}
+
+// reserve takes a token from the bucket, returning the delay after which it may be used.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	var d time.Duration
+	if b.limit.enabled() {
+		b.refill(now)
+		b.tokens--
+		if b.tokens < 0 {
+			d = time.Duration(-b.tokens * float64(b.limit.Interval) / float64(b.limit.Events))
+		}
+	}
+	if wait := b.until.Sub(now); wait > d {
+		d = wait
+	}
+	return d
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	b.last = now
+	b.tokens = min(float64(b.limit.Events), b.tokens+float64(elapsed)*float64(b.limit.Events)/float64(b.limit.Interval))
+}
+
+// idle reports whether the bucket is in its initial state, so that it can be dropped.
+func (b *bucket) idle(now time.Time) bool {
+	if now.Before(b.until) {
+		return false
+	}
+	if !b.limit.enabled() {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Events)
+}
+
+// keys returns the buckets the request goes through, the one of its method is always included
+// so that it can be blocked by a flood wait.
+func (c *Controller) keys(input bin.Encoder, method string) []limitedKey {
+	keys := []limitedKey{{key: bucketKey{kind: kindMethod, method: method}, limit: c.opts.Methods[method]}}
+	peer, ok := sentTo(input)
+	if !ok {
+		return keys
+	}
+	if c.opts.Global.enabled() {
+		keys = append(keys, limitedKey{key: bucketKey{kind: kindGlobal}, limit: c.opts.Global})
+	}
+	peerType, peerID, group := peerOf(peer)
+	keys = append(keys, limitedKey{key: bucketKey{kind: kindChat, peerType: peerType, peerID: peerID}, limit: c.opts.Chat})
+	if group && c.opts.Group.enabled() {
+		keys = append(keys, limitedKey{key: bucketKey{kind: kindGroup, peerType: peerType, peerID: peerID}, limit: c.opts.Group})
+	}
+	return keys
+}
+
+// limit waits till the request is allowed by all of its buckets.
+func (c *Controller) limit(ctx context.Context, method string, keys []limitedKey) error {
+	now := time.Now()
+	var delay time.Duration
+	c.lock.Lock()
+	for _, k := range keys {
+		delay = max(delay, c.bucket(k, now).reserve(now))
+	}
+	if len(c.buckets) > maxBuckets {
+		for key, b := range c.buckets {
+			if b.idle(now) {
+				delete(c.buckets, key)
+			}
+		}
+	}
+	c.lock.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	err := c.wait(ctx, Wait{Reason: WaitRateLimit, Method: method, Duration: delay})
+	if err != nil {
+		// Give back the tokens of the request which won't be sent.
+		c.lock.Lock()
+		for _, k := range keys {
+			if b, ok := c.buckets[k.key]; ok && b.limit.enabled() {
+				b.tokens++
+			}
+		}
+		c.lock.Unlock()
+	}
+	return err
+}
+
+// block delays the next requests to the chat of the request, or of its method if it wasn't sent to a chat.
+func (c *Controller) block(keys []limitedKey, d time.Duration) {
+	k := keys[0]
+	for _, key := range keys {
+		if key.key.kind == kindChat {
+			k = key
+		}
+	}
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	b := c.bucket(k, now)
+	if until := now.Add(d); until.After(b.until) {
+		b.until = until
+	}
+}
+
+// bucket returns the bucket of the key, creating it if it doesn't exist. c.lock must be held.
+func (c *Controller) bucket(k limitedKey, now time.Time) *bucket {
+	b, ok := c.buckets[k.key]
+	if !ok {
+		b = &bucket{limit: k.limit, tokens: float64(k.limit.Events), last: now}
+		c.buckets[k.key] = b
+	}
+	return b
+}
+
+// sentTo returns the chat a message is sent to by the request, if it sends messages.
+func sentTo(input bin.Encoder) (tg.InputPeerClass, bool) {
+	switch r := input.(type) {
+	case *tg.MessagesSendMessageRequest:
+		return r.Peer, true
+	case *tg.MessagesSendMediaRequest:
+		return r.Peer, true
+	case *tg.MessagesSendMultiMediaRequest:
+		return r.Peer, true
+	case *tg.MessagesForwardMessagesRequest:
+		return r.ToPeer, true
+	case *tg.MessagesSendInlineBotResultRequest:
+		return r.Peer, true
+	}
+	return nil, false
+}
+
+// peerOf returns the type and ID of the peer, group is true for chats and channels.
+func peerOf(peer tg.InputPeerClass) (peerType uint32, id int64, group bool) {
+	switch p := peer.(type) {
+	case *tg.InputPeerUser:
+		return tg.InputPeerUserTypeID, p.UserID, false
+	case *tg.InputPeerUserFromMessage:
+		return tg.InputPeerUserTypeID, p.UserID, false
+	case *tg.InputPeerChat:
+		return tg.InputPeerChatTypeID, p.ChatID, true
+	case *tg.InputPeerChannel:
+		return tg.InputPeerChannelTypeID, p.ChannelID, true
+	case *tg.InputPeerChannelFromMessage:
+		return tg.InputPeerChannelTypeID, p.ChannelID, true
+	case nil:
+		return 0, 0, false
+	}
+	return peer.TypeID(), 0, false
+}
@@ -0,0 +1,213 @@
+// Package flood implements the flood control of gotgproto clients: requests failing with FLOOD_WAIT
+// or SLOWMODE_WAIT are retried after the requested delay, and sent messages are rate limited
+// to stay within the limits of Telegram before they are hit.
+package flood
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// Limit allows Events events per Interval, with bursts of up to Events events.
+// The zero value doesn't limit anything.
+type Limit struct {
+	Events   int
+	Interval time.Duration
+}
+
+func (l Limit) enabled() bool {
+	return l.Events > 0 && l.Interval > 0
+}
+
+// Opts contains optional parameters for New.
+type Opts struct {
+	// MaxWait is the longest FLOOD_WAIT or SLOWMODE_WAIT which is waited out before retrying the request,
+	// the error of longer waits is returned right away.
+	//
+	// If not provided, 1 minute will be used.
+	MaxWait time.Duration
+	// MaxRetries is the number of times a request is retried after a wait.
+	//
+	// If not provided, 3 will be used.
+	MaxRetries int
+	// Global limits the messages sent to all the chats.
+	Global Limit
+	// Chat limits the messages sent to a single chat.
+	Chat Limit
+	// Group limits the messages sent to a single group or channel, in addition to Chat.
+	Group Limit
+	// Methods limits the requests of the provided methods, keyed by their TL names, i.e. "messages.sendMessage".
+	Methods map[string]Limit
+	// OnWait is called before every wait, including the ones of the rate limits.
+	OnWait func(ctx context.Context, wait Wait)
+}
+
+// BotOpts returns the options matching the limits of Telegram for bots:
+// 30 messages per second overall, 1 message per second in a chat and 20 messages per minute in a group.
+func BotOpts() *Opts {
+	return &Opts{
+		Global: Limit{Events: 30, Interval: time.Second},
+		Chat:   Limit{Events: 1, Interval: time.Second},
+		Group:  Limit{Events: 20, Interval: time.Minute},
+	}
+}
+
+// WaitReason is the reason of a Wait.
+type WaitReason int
+
+const (
+	// WaitFloodWait is a wait requested by Telegram through a FLOOD_WAIT error.
+	WaitFloodWait WaitReason = iota
+	// WaitSlowMode is a wait requested by Telegram through a SLOWMODE_WAIT error.
+	WaitSlowMode
+	// WaitRateLimit is a wait of a rate limit of Opts.
+	WaitRateLimit
+)
+
+func (r WaitReason) String() string {
+	switch r {
+	case WaitFloodWait:
+		return "flood_wait"
+	case WaitSlowMode:
+		return "slowmode_wait"
+	case WaitRateLimit:
+		return "rate_limit"
+	}
+	return "unknown"
+}
+
+// Wait describes a request delayed by the flood control.
+type Wait struct {
+	Reason WaitReason
+	// Method is the TL name of the delayed request, i.e. "messages.sendMessage".
+	Method   string
+	Duration time.Duration
+	// Attempt is the number of the retry for Telegram waits, it is 0 for rate limits.
+	Attempt int
+}
+
+// Stats contains the counters of a Controller.
+type Stats struct {
+	FloodWaits    int64
+	SlowModeWaits int64
+	RateLimited   int64
+	// Exceeded is the number of waits longer than Opts.MaxWait whose error was returned.
+	Exceeded int64
+	// WaitTime is the total time requests were delayed.
+	WaitTime time.Duration
+}
+
+// Controller is a telegram.Middleware implementing the flood control described by its Opts.
+// It is safe for concurrent use.
+type Controller struct {
+	opts Opts
+
+	lock    sync.Mutex
+	buckets map[bucketKey]*bucket
+
+	floodWaits    atomic.Int64
+	slowModeWaits atomic.Int64
+	rateLimited   atomic.Int64
+	exceeded      atomic.Int64
+	waitTime      atomic.Int64
+}
+
+// New creates a new Controller with provided options, opts can be nil.
+func New(opts *Opts) *Controller {
+	c := &Controller{buckets: make(map[bucketKey]*bucket)}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.MaxWait <= 0 {
+		c.opts.MaxWait = time.Minute
+	}
+	if c.opts.MaxRetries <= 0 {
+		c.opts.MaxRetries = 3
+	}
+	return c
+}
+
+// Stats returns the counters of the controller.
+func (c *Controller) Stats() Stats {
+	return Stats{
+		FloodWaits:    c.floodWaits.Load(),
+		SlowModeWaits: c.slowModeWaits.Load(),
+		RateLimited:   c.rateLimited.Load(),
+		Exceeded:      c.exceeded.Load(),
+		WaitTime:      time.Duration(c.waitTime.Load()),
+	}
+}
+
+// Handle implements telegram.Middleware.
+func (c *Controller) Handle(next tg.Invoker) telegram.InvokeFunc {
+	return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		method := methodName(input)
+		keys := c.keys(input, method)
+		for attempt := 0; ; attempt++ {
+			if err := c.limit(ctx, method, keys); err != nil {
+				return err
+			}
+			err := next.Invoke(ctx, input, output)
+			reason, d, ok := waitOf(err)
+			if !ok {
+				return err
+			}
+			if d > c.opts.MaxWait || attempt >= c.opts.MaxRetries {
+				c.exceeded.Add(1)
+				return err
+			}
+			c.block(keys, d)
+			if err := c.wait(ctx, Wait{Reason: reason, Method: method, Duration: d, Attempt: attempt + 1}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// waitOf returns the wait requested by Telegram through err.
+func waitOf(err error) (WaitReason, time.Duration, bool) {
+	if d, ok := tgerr.AsFloodWait(err); ok {
+		return WaitFloodWait, d, true
+	}
+	if rpcErr, ok := tgerr.AsType(err, "SLOWMODE_WAIT"); ok {
+		return WaitSlowMode, time.Duration(rpcErr.Argument) * time.Second, true
+	}
+	return 0, 0, false
+}
+
+func (c *Controller) wait(ctx context.Context, w Wait) error {
+	switch w.Reason {
+	case WaitFloodWait:
+		c.floodWaits.Add(1)
+	case WaitSlowMode:
+		c.slowModeWaits.Add(1)
+	case WaitRateLimit:
+		c.rateLimited.Add(1)
+	}
+	c.waitTime.Add(int64(w.Duration))
+	if c.opts.OnWait != nil {
+		c.opts.OnWait(ctx, w)
+	}
+	timer := time.NewTimer(w.Duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func methodName(input bin.Encoder) string {
+	if t, ok := input.(interface{ TypeName() string }); ok {
+		return t.TypeName()
+	}
+	return ""
+}
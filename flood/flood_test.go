@@ -0,0 +1,138 @@
+package flood_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/flood"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// failing scripts the invoker to fail the first sent messages with the provided errors.
+func failing(errs ...error) *gotgprototest.Invoker {
+	var calls atomic.Int32
+	return gotgprototest.NewInvoker().On(&tg.MessagesSendMessageRequest{}, func(context.Context, gotgprototest.Request) (bin.Encoder, error) {
+		if n := int(calls.Add(1)); n <= len(errs) {
+			return nil, errs[n-1]
+		}
+		return &tg.Updates{}, nil
+	})
+}
+
+func send(ctx context.Context, api *tg.Client, peer tg.InputPeerClass) error {
+	_, err := api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{Peer: peer, Message: "hi", RandomID: 1})
+	return err
+}
+
+func TestFloodWaitRetry(t *testing.T) {
+	invoker := failing(tgerr.New(420, "FLOOD_WAIT_0"), tgerr.New(420, "SLOWMODE_WAIT_0"))
+	var waits []flood.Wait
+	c := flood.New(&flood.Opts{OnWait: func(_ context.Context, w flood.Wait) { waits = append(waits, w) }})
+	api := tg.NewClient(c.Handle(invoker))
+	if err := send(context.Background(), api, &tg.InputPeerUser{UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(gotgprototest.RequestsOf[*tg.MessagesSendMessageRequest](invoker)) != 3 {
+		t.Fatal("expected the request to be retried twice")
+	}
+	if len(waits) != 2 || waits[0].Reason != flood.WaitFloodWait || waits[1].Reason != flood.WaitSlowMode || waits[1].Attempt != 2 {
+		t.Fatalf("unexpected waits %+v", waits)
+	}
+	if waits[0].Method != "messages.sendMessage" {
+		t.Fatalf("unexpected method %q", waits[0].Method)
+	}
+	if s := c.Stats(); s.FloodWaits != 1 || s.SlowModeWaits != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestFloodWaitExceeded(t *testing.T) {
+	invoker := failing(tgerr.New(420, "FLOOD_WAIT_120"))
+	c := flood.New(&flood.Opts{MaxWait: time.Minute})
+	api := tg.NewClient(c.Handle(invoker))
+	err := send(context.Background(), api, &tg.InputPeerUser{UserID: 1})
+	if d, ok := tgerr.AsFloodWait(err); !ok || d != 2*time.Minute {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s := c.Stats(); s.Exceeded != 1 || s.FloodWaits != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestFloodWaitMaxRetries(t *testing.T) {
+	wait := tgerr.New(420, "FLOOD_WAIT_0")
+	invoker := failing(wait, wait, wait)
+	c := flood.New(&flood.Opts{MaxRetries: 2})
+	api := tg.NewClient(c.Handle(invoker))
+	if err := send(context.Background(), api, &tg.InputPeerUser{UserID: 1}); !tgerr.Is(err, "FLOOD_WAIT") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n := len(gotgprototest.RequestsOf[*tg.MessagesSendMessageRequest](invoker)); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestChatLimit(t *testing.T) {
+	invoker := failing()
+	var limited atomic.Int32
+	c := flood.New(&flood.Opts{
+		Chat: flood.Limit{Events: 2, Interval: 200 * time.Millisecond},
+		OnWait: func(_ context.Context, w flood.Wait) {
+			if w.Reason == flood.WaitRateLimit {
+				limited.Add(1)
+			}
+		},
+	})
+	api := tg.NewClient(c.Handle(invoker))
+	alice, bob := &tg.InputPeerUser{UserID: 1}, &tg.InputPeerUser{UserID: 2}
+	start := time.Now()
+	for _, peer := range []tg.InputPeerClass{alice, alice, bob} {
+		if err := send(context.Background(), api, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if limited.Load() != 0 {
+		t.Fatal("expected the burst not to be limited")
+	}
+	if err := send(context.Background(), api, alice); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || limited.Load() != 1 {
+		t.Fatalf("expected the third message to a chat to be delayed, took %s", elapsed)
+	}
+	// Requests which don't send messages aren't limited.
+	invoker.Reply(&tg.HelpGetConfigRequest{}, &tg.Config{})
+	if _, err := api.HelpGetConfig(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if limited.Load() != 1 {
+		t.Fatal("expected other requests not to be limited")
+	}
+}
+
+func TestGroupLimitCancel(t *testing.T) {
+	invoker := failing()
+	c := flood.New(&flood.Opts{Group: flood.Limit{Events: 1, Interval: time.Hour}})
+	api := tg.NewClient(c.Handle(invoker))
+	group := &tg.InputPeerChannel{ChannelID: 1}
+	if err := send(context.Background(), api, group); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := send(ctx, api, group); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := send(context.Background(), api, &tg.InputPeerUser{UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gotgprototest.RequestsOf[*tg.MessagesSendMessageRequest](invoker)); n != 2 {
+		t.Fatalf("expected the limited message not to be sent, got %d requests", n)
+	}
+}
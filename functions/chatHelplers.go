@@ -3,6 +3,7 @@ package functions
 import (
 	"context"
 
+	"github.com/celestix/gotgproto/errors"
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/tg"
 )
@@ -79,14 +80,10 @@ func CreateChat(context context.Context, client *tg.Client, p *storage.PeerStora
 	return chats[0].(*tg.Chat), nil
 }
 
-func BanChatMember(context context.Context, client *tg.Client, chatPeer tg.InputPeerClass, userPeer *tg.InputPeerUser, untilDate int) (tg.UpdatesClass, error) {
-	switch c := chatPeer.(type) {
-	case *tg.InputPeerChannel:
+func BanChatMember(context context.Context, client *tg.Client, chatPeer tg.InputPeerClass, userPeer tg.InputPeerClass, untilDate int) (tg.UpdatesClass, error) {
+	if channel, ok := InputChannelFromPeer(chatPeer); ok {
 		return client.ChannelsEditBanned(context, &tg.ChannelsEditBannedRequest{
-			Channel: &tg.InputChannel{
-				ChannelID:  c.ChannelID,
-				AccessHash: c.AccessHash,
-			},
+			Channel:     channel,
 			Participant: userPeer,
 			BannedRights: tg.ChatBannedRights{
 				UntilDate:    untilDate,
@@ -100,17 +97,19 @@ func BanChatMember(context context.Context, client *tg.Client, chatPeer tg.Input
 				EmbedLinks:   true,
 			},
 		})
-	case *tg.InputPeerChat:
-		return client.MessagesDeleteChatUser(context, &tg.MessagesDeleteChatUserRequest{
-			ChatID: c.ChatID,
-			UserID: &tg.InputUser{
-				UserID:     userPeer.UserID,
-				AccessHash: userPeer.AccessHash,
-			},
-		})
-	default:
+	}
+	c, ok := chatPeer.(*tg.InputPeerChat)
+	if !ok {
 		return &tg.Updates{}, nil
 	}
+	user, ok := InputUserFromPeer(userPeer)
+	if !ok {
+		return nil, errors.ErrNotUser
+	}
+	return client.MessagesDeleteChatUser(context, &tg.MessagesDeleteChatUserRequest{
+		ChatID: c.ChatID,
+		UserID: user,
+	})
 }
 
 func UnarchiveChats(context context.Context, client *tg.Client, peers []tg.InputPeerClass) (bool, error) {
@@ -125,12 +124,13 @@ func UnarchiveChats(context context.Context, client *tg.Client, peers []tg.Input
 	return err == nil, err
 }
 
-func UnbanChatMember(context context.Context, client *tg.Client, chatPeer *tg.InputPeerChannel, userPeer *tg.InputPeerUser) (bool, error) {
+func UnbanChatMember(context context.Context, client *tg.Client, chatPeer tg.InputPeerClass, userPeer tg.InputPeerClass) (bool, error) {
+	channel, ok := InputChannelFromPeer(chatPeer)
+	if !ok {
+		return false, errors.ErrNotChannel
+	}
 	_, err := client.ChannelsEditBanned(context, &tg.ChannelsEditBannedRequest{
-		Channel: &tg.InputChannel{
-			ChannelID:  chatPeer.ChannelID,
-			AccessHash: chatPeer.AccessHash,
-		},
+		Channel:     channel,
 		Participant: userPeer,
 		BannedRights: tg.ChatBannedRights{
 			UntilDate: 0,
@@ -196,3 +196,28 @@ func DemoteChatMember(ctx context.Context, client *tg.Client, chat, user *storag
 		return err == nil, err
 	}
 }
+
+// EditChatAdmin promotes or demotes the provided user in a channel or a basic group.
+// The rights and the title are ignored in basic groups.
+func EditChatAdmin(ctx context.Context, client *tg.Client, chat tg.InputPeerClass, user tg.InputUserClass, rights tg.ChatAdminRights, title string, promote bool) (bool, error) {
+	rights.Other = promote
+	if channel, ok := InputChannelFromPeer(chat); ok {
+		_, err := client.ChannelsEditAdmin(ctx, &tg.ChannelsEditAdminRequest{
+			Channel:     channel,
+			UserID:      user,
+			AdminRights: rights,
+			Rank:        title,
+		})
+		return err == nil, err
+	}
+	c, ok := chat.(*tg.InputPeerChat)
+	if !ok {
+		return false, errors.ErrNotChat
+	}
+	_, err := client.MessagesEditChatAdmin(ctx, &tg.MessagesEditChatAdminRequest{
+		ChatID:  c.ChatID,
+		UserID:  user,
+		IsAdmin: promote,
+	})
+	return err == nil, err
+}
@@ -3,25 +3,19 @@ package functions
 import (
 	"context"
 
-	"github.com/celestix/gotgproto/errors"
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/tg"
 )
 
 func GetMessages(ctx context.Context, raw *tg.Client, p *storage.PeerStorage, chatId int64, mids []tg.InputMessageClass) (tg.MessageClassArray, error) {
-	peer := p.GetPeerById(chatId)
-	if peer.ID == 0 {
-		return nil, errors.ErrPeerNotFound
+	peer, err := ResolveInputPeerById(ctx, raw, p, chatId)
+	if err != nil {
+		return nil, err
 	}
-	switch storage.EntityType(peer.Type) {
-	case storage.TypeChannel:
-		return GetChannelMessages(ctx, raw, p, &tg.InputChannel{
-			ChannelID:  peer.ID,
-			AccessHash: peer.AccessHash,
-		}, mids)
-	default:
-		return GetChatMessages(ctx, raw, p, mids)
+	if channel, ok := InputChannelFromPeer(peer); ok {
+		return GetChannelMessages(ctx, raw, p, channel, mids)
 	}
+	return GetChatMessages(ctx, raw, p, mids)
 }
 
 func GetChannelMessages(context context.Context, client *tg.Client, p *storage.PeerStorage, peer tg.InputChannelClass, messageIds []tg.InputMessageClass) (tg.MessageClassArray, error) {
@@ -0,0 +1,159 @@
+package functions
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MessageLink is a link to a message of a channel or a supergroup, parsed by ParseMessageLink.
+type MessageLink struct {
+	// Username is the username of the chat of a public link, it is empty for a private link.
+	Username string
+	// ChannelID is the bare ID of the chat of a private t.me/c/ link, it is 0 for a public link.
+	ChannelID int64
+	// MessageID is the ID of the message.
+	MessageID int
+	// TopicID is the ID of the forum topic of the message, 0 if the link isn't to a topic.
+	TopicID int
+	// ThreadID is the ID of the message starting the thread of replies the message belongs to, 0 if it isn't provided.
+	ThreadID int
+	// CommentID is the ID of a comment to the message, which is in the discussion group of the channel,
+	// 0 if the link isn't to a comment.
+	CommentID int
+}
+
+// ParseMessageLink parses a link to a message, which may be any of:
+//   - a public link, like https://t.me/username/123 or tg://resolve?domain=username&post=123,
+//   - a private link, like https://t.me/c/1234567890/123 or tg://privatepost?channel=1234567890&post=123,
+//   - a link to a message of a forum topic, like https://t.me/username/5/123 or https://t.me/c/1234567890/5/123,
+//   - a link to a message of a thread of replies, like https://t.me/username/123?thread=100,
+//   - a link to a comment, like https://t.me/username/123?comment=456.
+func ParseMessageLink(link string) (MessageLink, error) {
+	link = strings.TrimSpace(link)
+	if strings.HasPrefix(link, "tg:") {
+		return parseMessageDeepLink(link)
+	}
+	raw := link
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return MessageLink{}, fmt.Errorf("invalid message link %q: %w", link, err)
+	}
+	if !telegramHosts[strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")] {
+		return MessageLink{}, fmt.Errorf("invalid message link %q: not a t.me link", link)
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if path[0] == "s" {
+		// Preview of a public channel, t.me/s/username/123.
+		path = path[1:]
+	}
+	var l MessageLink
+	switch {
+	case len(path) > 1 && path[0] == "c":
+		l.ChannelID, err = strconv.ParseInt(path[1], 10, 64)
+		if err != nil || l.ChannelID <= 0 {
+			return MessageLink{}, fmt.Errorf("invalid chat id in message link %q", link)
+		}
+		path = path[2:]
+	case len(path) > 0 && usernameRegex.MatchString(path[0]):
+		l.Username = path[0]
+		path = path[1:]
+	default:
+		return MessageLink{}, fmt.Errorf("invalid chat in message link %q", link)
+	}
+	var ids []int
+	for _, p := range path {
+		id, err := strconv.Atoi(p)
+		if err != nil || id <= 0 {
+			return MessageLink{}, fmt.Errorf("invalid message id in message link %q", link)
+		}
+		ids = append(ids, id)
+	}
+	switch len(ids) {
+	case 1:
+		l.MessageID = ids[0]
+	case 2:
+		l.TopicID, l.MessageID = ids[0], ids[1]
+	default:
+		return MessageLink{}, fmt.Errorf("invalid message link %q: no message id", link)
+	}
+	return l, l.parseQuery(link, u.Query())
+}
+
+// parseMessageDeepLink parses a tg://resolve or tg://privatepost link.
+func parseMessageDeepLink(link string) (MessageLink, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return MessageLink{}, fmt.Errorf("invalid message link %q: %w", link, err)
+	}
+	q := u.Query()
+	var l MessageLink
+	switch deepLinkAction(u) {
+	case "resolve":
+		l.Username = q.Get("domain")
+		if !usernameRegex.MatchString(l.Username) {
+			return MessageLink{}, fmt.Errorf("invalid chat in message link %q", link)
+		}
+	case "privatepost":
+		l.ChannelID, err = strconv.ParseInt(q.Get("channel"), 10, 64)
+		if err != nil || l.ChannelID <= 0 {
+			return MessageLink{}, fmt.Errorf("invalid chat id in message link %q", link)
+		}
+	default:
+		return MessageLink{}, fmt.Errorf("unsupported message link %q", link)
+	}
+	l.MessageID, err = strconv.Atoi(q.Get("post"))
+	if err != nil || l.MessageID <= 0 {
+		return MessageLink{}, fmt.Errorf("invalid message id in message link %q", link)
+	}
+	return l, l.parseQuery(link, q)
+}
+
+// parseQuery parses the topic, thread and comment parameters of a message link.
+func (l *MessageLink) parseQuery(link string, q url.Values) error {
+	for _, param := range []struct {
+		name string
+		id   *int
+	}{{"topic", &l.TopicID}, {"thread", &l.ThreadID}, {"comment", &l.CommentID}} {
+		value := q.Get(param.name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid %s in message link %q", param.name, link)
+		}
+		*param.id = id
+	}
+	return nil
+}
+
+// String returns the t.me link of the message, which is private if the username of the chat isn't set.
+func (l MessageLink) String() string {
+	var b strings.Builder
+	b.WriteString("https://t.me/")
+	if l.Username != "" {
+		b.WriteString(l.Username)
+	} else {
+		b.WriteString("c/" + strconv.FormatInt(l.ChannelID, 10))
+	}
+	if l.TopicID != 0 {
+		b.WriteString("/" + strconv.Itoa(l.TopicID))
+	}
+	b.WriteString("/" + strconv.Itoa(l.MessageID))
+	q := url.Values{}
+	if l.ThreadID != 0 {
+		q.Set("thread", strconv.Itoa(l.ThreadID))
+	}
+	if l.CommentID != 0 {
+		q.Set("comment", strconv.Itoa(l.CommentID))
+	}
+	if len(q) > 0 {
+		b.WriteString("?" + q.Encode())
+	}
+	return b.String()
+}
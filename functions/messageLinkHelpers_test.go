@@ -0,0 +1,44 @@
+package functions
+
+import "testing"
+
+func TestParseMessageLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want MessageLink
+	}{
+		{link: "https://t.me/gotgproto/123", want: MessageLink{Username: "gotgproto", MessageID: 123}},
+		{link: "t.me/gotgproto/123?single", want: MessageLink{Username: "gotgproto", MessageID: 123}},
+		{link: "https://t.me/s/gotgproto/123", want: MessageLink{Username: "gotgproto", MessageID: 123}},
+		{link: "https://t.me/c/1234567890/123", want: MessageLink{ChannelID: 1234567890, MessageID: 123}},
+		{link: "https://t.me/gotgproto/5/123", want: MessageLink{Username: "gotgproto", TopicID: 5, MessageID: 123}},
+		{link: "https://t.me/c/1234567890/5/123", want: MessageLink{ChannelID: 1234567890, TopicID: 5, MessageID: 123}},
+		{link: "https://t.me/c/1234567890/123?topic=5", want: MessageLink{ChannelID: 1234567890, TopicID: 5, MessageID: 123}},
+		{link: "https://t.me/gotgproto/123?thread=100", want: MessageLink{Username: "gotgproto", ThreadID: 100, MessageID: 123}},
+		{link: "https://t.me/gotgproto/123?comment=456", want: MessageLink{Username: "gotgproto", MessageID: 123, CommentID: 456}},
+		{link: "tg://resolve?domain=gotgproto&post=123&comment=456", want: MessageLink{Username: "gotgproto", MessageID: 123, CommentID: 456}},
+		{link: "tg://privatepost?channel=1234567890&post=123&thread=100", want: MessageLink{ChannelID: 1234567890, ThreadID: 100, MessageID: 123}},
+	}
+	for _, tt := range tests {
+		got, err := ParseMessageLink(tt.link)
+		if err != nil {
+			t.Errorf("ParseMessageLink(%q): %v", tt.link, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMessageLink(%q) = %+v, want %+v", tt.link, got, tt.want)
+		}
+		if parsed, err := ParseMessageLink(got.String()); err != nil || parsed != got {
+			t.Errorf("ParseMessageLink(%q) = %+v, %v, want %+v", got.String(), parsed, err, got)
+		}
+	}
+	for _, link := range []string{
+		"", "https://t.me/gotgproto", "https://example.com/gotgproto/123", "https://t.me/c/abc/123",
+		"https://t.me/gotgproto/abc", "https://t.me/gotgproto/1/2/3", "https://t.me/gotgproto/123?comment=x",
+		"tg://resolve?domain=gotgproto", "tg://privatepost?post=123",
+	} {
+		if got, err := ParseMessageLink(link); err == nil {
+			t.Errorf("ParseMessageLink(%q) = %+v, expected an error", link, got)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package functions
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/celestix/gotgproto/errors"
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/tg"
 )
@@ -44,44 +46,170 @@ func GetChatFromPeer(ctx context.Context, client *tg.Client, peer tg.PeerClass)
 }
 
 // GetInputPeerClassFromId finds provided user id in the session storage and returns it if found.
+// Peers missing from the storage are returned through the message they were last seen in if any,
+// use ResolveInputPeerById to also recover them from the server.
+// Bot API-style marked IDs, like -1001234567890, are accepted too.
 func GetInputPeerClassFromId(p *storage.PeerStorage, iD int64) tg.InputPeerClass {
-	peer := p.GetPeerById(iD)
-	if peer.ID == 0 {
+	peer := p.GetInputPeerById(iD)
+	if _, ok := peer.(*tg.InputPeerEmpty); ok {
 		return nil
 	}
-	switch storage.EntityType(peer.Type) {
-	case storage.TypeUser:
-		return &tg.InputPeerUser{
-			UserID:     peer.ID,
-			AccessHash: peer.AccessHash,
+	return peer
+}
+
+// ResolveInputPeerById returns the tg.InputPeerClass of the provided bare or Bot API-style marked id,
+// see storage.PeerStorage.PeerIDOf and ResolveInputPeer.
+func ResolveInputPeerById(ctx context.Context, client *tg.Client, p *storage.PeerStorage, iD int64) (tg.InputPeerClass, error) {
+	id := p.PeerIDOf(iD)
+	peer, err := ResolveInputPeer(ctx, client, p, id)
+	if err != nil && iD > 0 {
+		// The bare id may be of a channel or a chat learned from the updates fetched while resolving it.
+		if learned := p.PeerIDOf(iD); learned != id {
+			return ResolveInputPeer(ctx, client, p, learned)
 		}
-	case storage.TypeChat:
-		return &tg.InputPeerChat{
-			ChatID: peer.ID,
+	}
+	return peer, err
+}
+
+// ResolveInputPeer returns the tg.InputPeerClass of the provided peer. If the peer is missing from
+// the peer storage or only has a min access hash, it is recovered, in order, through:
+//   - the message the peer was last seen in, see storage.PeerStorage.SaveMessageContext,
+//   - users.getUsers or channels.getChannels using that message, which returns the full access hash,
+//   - the recent updates returned by updates.getDifference, which are fetched through storage.PeerStorage.Recover
+//     so that concurrent misses share a single request and misses are rate limited.
+//
+// Everything learned along the way is saved in the peer storage. Basic groups are returned directly since they have no access hash.
+// errors.ErrPeerNotFound is returned if the peer can't be recovered.
+func ResolveInputPeer(ctx context.Context, client *tg.Client, p *storage.PeerStorage, id storage.PeerID) (tg.InputPeerClass, error) {
+	if id.Type() == storage.TypeChat {
+		return &tg.InputPeerChat{ChatID: id.ID()}, nil
+	}
+	if peer := p.GetPeer(id); peer.ID != 0 && !peer.IsMin {
+		return p.GetInputPeer(id), nil
+	}
+	if peer, ok := fromMessagePeer(ctx, client, p, id); ok {
+		return peer, nil
+	}
+	err := p.Recover(func() error {
+		return fetchDifference(ctx, client, p)
+	})
+	if peer := p.GetPeer(id); peer.ID != 0 && !peer.IsMin {
+		return p.GetInputPeer(id), nil
+	}
+	if peer, ok := fromMessagePeer(ctx, client, p, id); ok {
+		return peer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errors.ErrPeerNotFound, err)
+	}
+	return nil, errors.ErrPeerNotFound
+}
+
+// fromMessagePeer returns the peer through the message it was last seen in,
+// upgrading it to the full peer with users.getUsers or channels.getChannels when possible.
+func fromMessagePeer(ctx context.Context, client *tg.Client, p *storage.PeerStorage, id storage.PeerID) (tg.InputPeerClass, bool) {
+	switch peer := p.GetInputPeer(id).(type) {
+	case *tg.InputPeerUserFromMessage:
+		users, err := client.UsersGetUsers(ctx, []tg.InputUserClass{&tg.InputUserFromMessage{
+			Peer:   peer.Peer,
+			MsgID:  peer.MsgID,
+			UserID: peer.UserID,
+		}})
+		if err == nil {
+			SavePeersFromClassArray(p, nil, users)
 		}
-	case storage.TypeChannel:
-		return &tg.InputPeerChannel{
-			ChannelID:  peer.ID,
-			AccessHash: peer.AccessHash,
+	case *tg.InputPeerChannelFromMessage:
+		chats, err := client.ChannelsGetChannels(ctx, []tg.InputChannelClass{&tg.InputChannelFromMessage{
+			Peer:      peer.Peer,
+			MsgID:     peer.MsgID,
+			ChannelID: peer.ChannelID,
+		}})
+		if err == nil {
+			SavePeersFromClassArray(p, chats.GetChats(), nil)
+		}
+	default:
+		return nil, false
+	}
+	// Whether the full peer was fetched or not, the storage now returns the best peer available.
+	return p.GetInputPeer(id), true
+}
+
+// fetchDifference saves the peers and the message contexts of the updates received recently,
+// which may contain a peer that was seen in an update the client missed.
+func fetchDifference(ctx context.Context, client *tg.Client, p *storage.PeerStorage) error {
+	state, err := client.UpdatesGetState(ctx)
+	if err != nil {
+		return err
+	}
+	diff, err := client.UpdatesGetDifference(ctx, &tg.UpdatesGetDifferenceRequest{
+		Pts:  max(1, state.Pts-recentUpdates),
+		Date: state.Date,
+		Qts:  state.Qts,
+	})
+	if err != nil {
+		return err
+	}
+	var (
+		messages []tg.MessageClass
+		chats    []tg.ChatClass
+		users    []tg.UserClass
+	)
+	switch d := diff.(type) {
+	case *tg.UpdatesDifference:
+		messages, chats, users = d.NewMessages, d.Chats, d.Users
+	case *tg.UpdatesDifferenceSlice:
+		messages, chats, users = d.NewMessages, d.Chats, d.Users
+	}
+	SavePeersFromClassArray(p, chats, users)
+	for _, m := range messages {
+		if m, ok := m.(*tg.Message); ok {
+			p.SaveMessageContext(m)
 		}
 	}
 	return nil
 }
 
+// recentUpdates is the number of events fetched by ResolveInputPeerById with updates.getDifference.
+const recentUpdates = 100
+
+// InputUserFromPeer converts the provided input peer of a user to a tg.InputUserClass.
+func InputUserFromPeer(peer tg.InputPeerClass) (tg.InputUserClass, bool) {
+	switch peer := peer.(type) {
+	case *tg.InputPeerUser:
+		return &tg.InputUser{UserID: peer.UserID, AccessHash: peer.AccessHash}, true
+	case *tg.InputPeerUserFromMessage:
+		return &tg.InputUserFromMessage{Peer: peer.Peer, MsgID: peer.MsgID, UserID: peer.UserID}, true
+	case *tg.InputPeerSelf:
+		return &tg.InputUserSelf{}, true
+	}
+	return nil, false
+}
+
+// InputChannelFromPeer converts the provided input peer of a channel to a tg.InputChannelClass.
+func InputChannelFromPeer(peer tg.InputPeerClass) (tg.InputChannelClass, bool) {
+	switch peer := peer.(type) {
+	case *tg.InputPeerChannel:
+		return &tg.InputChannel{ChannelID: peer.ChannelID, AccessHash: peer.AccessHash}, true
+	case *tg.InputPeerChannelFromMessage:
+		return &tg.InputChannelFromMessage{Peer: peer.Peer, MsgID: peer.MsgID, ChannelID: peer.ChannelID}, true
+	}
+	return nil, false
+}
+
 func SavePeersFromClassArray(p *storage.PeerStorage, cs []tg.ChatClass, us []tg.UserClass) {
 	for _, u := range us {
 		u, ok := u.(*tg.User)
 		if !ok {
 			continue
 		}
-		p.AddPeer(u.ID, u.AccessHash, storage.TypeUser, u.Username)
+		p.SavePeer(storage.PeerFromUser(u))
 	}
 	for _, c := range cs {
 		switch c := c.(type) {
 		case *tg.Channel:
-			p.AddPeer(c.ID, c.AccessHash, storage.TypeChannel, c.Username)
+			p.SavePeer(storage.PeerFromChannel(c))
 		case *tg.Chat:
-			p.AddPeer(c.ID, storage.DefaultAccessHash, storage.TypeChat, storage.DefaultUsername)
+			p.SavePeer(storage.PeerFromChat(c))
 		}
 	}
 }
@@ -0,0 +1,181 @@
+package functions
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/celestix/gotgproto/storage"
+)
+
+// PeerRefType is the type of a reference to a peer parsed by ParsePeerRef.
+type PeerRefType int
+
+const (
+	// PeerRefUsername is a username, with or without @, or a t.me/username or tg://resolve?domain= link.
+	PeerRefUsername PeerRefType = iota + 1
+	// PeerRefInvite is a t.me/+hash, t.me/joinchat/hash or tg://join?invite= link.
+	PeerRefInvite
+	// PeerRefPhone is a phone number starting with +, or a t.me/+phone or tg://resolve?phone= link.
+	PeerRefPhone
+	// PeerRefID is a peer ID, which may be a Bot API-style marked ID, a tg://user?id= link or a t.me/c/ link to a message.
+	PeerRefID
+)
+
+// PeerRef is a reference to a peer parsed by ParsePeerRef.
+type PeerRef struct {
+	Type PeerRefType
+	// Value is the username, the invite hash or the phone number, without +, of the reference.
+	Value string
+	// ID is the ID of the peer if Type is PeerRefID, it is negative for a Bot API-style marked chat ID.
+	ID int64
+}
+
+// telegramHosts are the hosts of the links to Telegram peers.
+var telegramHosts = map[string]bool{
+	"t.me":         true,
+	"telegram.me":  true,
+	"telegram.dog": true,
+}
+
+var (
+	usernameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{2,31}$`)
+	phoneRegex    = regexp.MustCompile(`^[0-9]{5,15}$`)
+)
+
+// ParsePeerRef parses a reference to a peer, which may be any of:
+//   - a username, like @username or username,
+//   - a link to a username, like https://t.me/username, username.t.me or tg://resolve?domain=username,
+//   - an invite link, like https://t.me/+hash, https://t.me/joinchat/hash or tg://join?invite=hash,
+//   - a phone number, like +15551234567, https://t.me/+15551234567 or tg://resolve?phone=15551234567,
+//   - an ID, like 1234567890, -1001234567890 or tg://user?id=1234567890.
+//
+// Links to messages, like https://t.me/username/123 or https://t.me/c/1234567890/123, are parsed
+// as references to their chats, see ParseMessageLink.
+func ParsePeerRef(ref string) (PeerRef, error) {
+	ref = strings.TrimSpace(ref)
+	if phone, ok := strings.CutPrefix(ref, "+"); ok {
+		return parsePhone(ref, phone)
+	}
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil && id != 0 {
+		return PeerRef{Type: PeerRefID, ID: id}, nil
+	}
+	if strings.HasPrefix(ref, "tg:") {
+		return parseDeepLink(ref)
+	}
+	if username, ok := strings.CutPrefix(ref, "@"); ok {
+		return parseUsername(ref, username)
+	}
+	if r, ok, err := parseLink(ref); ok {
+		return r, err
+	}
+	return parseUsername(ref, ref)
+}
+
+// parseLink parses a t.me link, ok is false if ref isn't a t.me link.
+func parseLink(ref string) (_ PeerRef, ok bool, _ error) {
+	link := ref
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return PeerRef{}, false, nil
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if username, domain, found := strings.Cut(host, "."); found && telegramHosts[domain] {
+		// username.t.me
+		r, err := parseUsername(ref, username)
+		return r, true, err
+	}
+	if !telegramHosts[host] {
+		return PeerRef{}, false, nil
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case strings.HasPrefix(path[0], "+"):
+		hash := strings.TrimPrefix(path[0], "+")
+		if phoneRegex.MatchString(hash) {
+			return PeerRef{Type: PeerRefPhone, Value: hash}, true, nil
+		}
+		r, err := parseInvite(ref, hash)
+		return r, true, err
+	case path[0] == "joinchat" && len(path) > 1:
+		r, err := parseInvite(ref, path[1])
+		return r, true, err
+	case path[0] == "s" && len(path) > 1:
+		// Preview of a public channel, t.me/s/username.
+		r, err := parseUsername(ref, path[1])
+		return r, true, err
+	case path[0] == "c" || len(path) > 1:
+		l, err := ParseMessageLink(ref)
+		if err != nil {
+			return PeerRef{}, true, err
+		}
+		if l.ChannelID != 0 {
+			return PeerRef{Type: PeerRefID, ID: storage.NewPeerID(l.ChannelID, storage.TypeChannel).BotAPI()}, true, nil
+		}
+		return PeerRef{Type: PeerRefUsername, Value: l.Username}, true, nil
+	}
+	r, err := parseUsername(ref, path[0])
+	return r, true, err
+}
+
+// parseDeepLink parses a tg:// link.
+func parseDeepLink(ref string) (PeerRef, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return PeerRef{}, fmt.Errorf("invalid peer reference %q: %w", ref, err)
+	}
+	q := u.Query()
+	switch deepLinkAction(u) {
+	case "resolve":
+		if phone := q.Get("phone"); phone != "" {
+			return parsePhone(ref, phone)
+		}
+		return parseUsername(ref, q.Get("domain"))
+	case "join":
+		return parseInvite(ref, q.Get("invite"))
+	case "user":
+		id, err := strconv.ParseInt(q.Get("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return PeerRef{}, fmt.Errorf("invalid user id in peer reference %q", ref)
+		}
+		return PeerRef{Type: PeerRefID, ID: id}, nil
+	}
+	return PeerRef{}, fmt.Errorf("unsupported peer reference %q", ref)
+}
+
+// deepLinkAction returns the action of a tg:// link, i.e. resolve.
+func deepLinkAction(u *url.URL) string {
+	// Both tg://resolve?domain= and tg:resolve?domain= are valid.
+	if u.Host != "" {
+		return u.Host
+	}
+	action, _, _ := strings.Cut(u.Opaque, "?")
+	return action
+}
+
+func parseUsername(ref, username string) (PeerRef, error) {
+	if !usernameRegex.MatchString(username) {
+		return PeerRef{}, fmt.Errorf("invalid username in peer reference %q", ref)
+	}
+	return PeerRef{Type: PeerRefUsername, Value: username}, nil
+}
+
+func parseInvite(ref, hash string) (PeerRef, error) {
+	if hash == "" {
+		return PeerRef{}, fmt.Errorf("invalid invite hash in peer reference %q", ref)
+	}
+	return PeerRef{Type: PeerRefInvite, Value: hash}, nil
+}
+
+func parsePhone(ref, phone string) (PeerRef, error) {
+	phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimPrefix(phone, "+"))
+	if !phoneRegex.MatchString(phone) {
+		return PeerRef{}, fmt.Errorf("invalid phone number in peer reference %q", ref)
+	}
+	return PeerRef{Type: PeerRefPhone, Value: phone}, nil
+}
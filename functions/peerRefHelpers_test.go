@@ -0,0 +1,45 @@
+package functions
+
+import "testing"
+
+func TestParsePeerRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want PeerRef
+	}{
+		{ref: "@gotgproto", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "gotgproto", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "https://t.me/gotgproto", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "t.me/gotgproto/123", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "https://t.me/gotgproto/5/123", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "https://t.me/c/1234567890/123", want: PeerRef{Type: PeerRefID, ID: -1001234567890}},
+		{ref: "https://telegram.me/s/gotgproto", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "gotgproto.t.me", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "tg://resolve?domain=gotgproto", want: PeerRef{Type: PeerRefUsername, Value: "gotgproto"}},
+		{ref: "https://t.me/+AbCdEf123", want: PeerRef{Type: PeerRefInvite, Value: "AbCdEf123"}},
+		{ref: "t.me/joinchat/AbCdEf123", want: PeerRef{Type: PeerRefInvite, Value: "AbCdEf123"}},
+		{ref: "tg://join?invite=AbCdEf123", want: PeerRef{Type: PeerRefInvite, Value: "AbCdEf123"}},
+		{ref: "+15551234567", want: PeerRef{Type: PeerRefPhone, Value: "15551234567"}},
+		{ref: "+1 (555) 123-4567", want: PeerRef{Type: PeerRefPhone, Value: "15551234567"}},
+		{ref: "https://t.me/+15551234567", want: PeerRef{Type: PeerRefPhone, Value: "15551234567"}},
+		{ref: "tg://resolve?phone=15551234567", want: PeerRef{Type: PeerRefPhone, Value: "15551234567"}},
+		{ref: "1234567890", want: PeerRef{Type: PeerRefID, ID: 1234567890}},
+		{ref: "-1001234567890", want: PeerRef{Type: PeerRefID, ID: -1001234567890}},
+		{ref: "tg://user?id=1234567890", want: PeerRef{Type: PeerRefID, ID: 1234567890}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePeerRef(tt.ref)
+		if err != nil {
+			t.Errorf("ParsePeerRef(%q): %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePeerRef(%q) = %+v, want %+v", tt.ref, got, tt.want)
+		}
+	}
+	for _, ref := range []string{"", "@a", "https://example.com/gotgproto", "tg://user?id=abc", "tg://settings", "+12", "t.me/c/abc/123", "t.me/gotgproto/abc"} {
+		if got, err := ParsePeerRef(ref); err == nil {
+			t.Errorf("ParsePeerRef(%q) = %+v, expected an error", ref, got)
+		}
+	}
+}
@@ -13,25 +13,36 @@ import (
 var helperFuncsCUTempl = template.Must(template.New("cuHelpers").Parse(helperFuncsCU))
 
 var hardCodedReplacements = map[string]string{
-	"EditAdminOpts": "ext.EditAdminOpts",
+	"EditAdminOpts":    "ext.EditAdminOpts",
+	"UploadInputClass": "ext.UploadInputClass",
+	"SendMediaOpts":    "ext.SendMediaOpts",
+	"SendVideoOpts":    "ext.SendVideoOpts",
+	"SendAudioOpts":    "ext.SendAudioOpts",
 }
 
-func readContextFile() []byte {
-	b, err := os.ReadFile("ext/context.go")
-	if err != nil {
-		panic("failed to read context file: " + err.Error())
+// contextFiles are the files of ext package containing the methods of ext.Context.
+var contextFiles = []string{"ext/context.go", "ext/upload.go"}
+
+func readContextFiles() []byte {
+	var b []byte
+	for _, name := range contextFiles {
+		fmt.Printf("Reading %s\n", name)
+		f, err := os.ReadFile(name)
+		if err != nil {
+			panic("failed to read context file: " + err.Error())
+		}
+		b = append(append(b, f...), '\n', '\n')
 	}
 	return b
 }
 
 func generateCUHelpers() {
-	fmt.Println("Reading context.go")
-	ctxFile := readContextFile()
+	ctxFile := readContextFiles()
 	builder := strings.Builder{}
 	builder.WriteString(predefinedCU)
 	fmt.Println("Parsing all context methods...")
 	for _, method := range parser.ParseMethods(string(ctxFile)) {
-		if strings.ToLower(string(method.Name[0])) == string(method.Name[0]) {
+		if method.Owner != "Context" || strings.ToLower(string(method.Name[0])) == string(method.Name[0]) {
 			continue
 		}
 		params := method.Params
@@ -109,10 +120,13 @@ package generic
 
 import (
 	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
 	"github.com/celestix/gotgproto/types"
 	"github.com/gotd/td/tg"
 )
 
+// ChatUnion is a reference to a peer, either its ID, which may be a Bot API-style marked ID,
+// or any string accepted by ext.Context.ResolvePeer, like a @username, a t.me link or a phone number.
 type ChatUnion interface {
 	int | int64 | string
 }
@@ -120,13 +134,12 @@ type ChatUnion interface {
 func getIdByUnion[chatUnion ChatUnion](ctx *ext.Context, chat chatUnion) (int64, error) {
 	switch val := any(chat).(type) {
 	case string:
-		username := val
-		peer := ctx.PeerStorage.GetPeerByUsername(username)
-		if peer.ID != 0 {
-			return peer.ID, nil
+		chat, err := ctx.ResolvePeer(val)
+		if err != nil {
+			return 0, err
 		}
-		chat, err := ctx.ResolveUsername(username)
-		return chat.GetID(), err
+		// The marked ID keeps the type of the resolved peer, its bare ID could also belong to a peer of another type.
+		return storage.PeerIDFromInputPeer(chat.GetInputPeer()).BotAPI(), nil
 	case int64:
 		return val, nil
 	case int:
@@ -5,10 +5,13 @@ package generic
 
 import (
 	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
 	"github.com/celestix/gotgproto/types"
 	"github.com/gotd/td/tg"
 )
 
+// ChatUnion is a reference to a peer, either its ID, which may be a Bot API-style marked ID,
+// or any string accepted by ext.Context.ResolvePeer, like a @username, a t.me link or a phone number.
 type ChatUnion interface {
 	int | int64 | string
 }
@@ -16,13 +19,12 @@ type ChatUnion interface {
 func getIdByUnion[chatUnion ChatUnion](ctx *ext.Context, chat chatUnion) (int64, error) {
 	switch val := any(chat).(type) {
 	case string:
-		username := val
-		peer := ctx.PeerStorage.GetPeerByUsername(username)
-		if peer.ID != 0 {
-			return peer.ID, nil
+		chat, err := ctx.ResolvePeer(val)
+		if err != nil {
+			return 0, err
 		}
-		chat, err := ctx.ResolveUsername(username)
-		return chat.GetID(), err
+		// The marked ID keeps the type of the resolved peer, its bare ID could also belong to a peer of another type.
+		return storage.PeerIDFromInputPeer(chat.GetInputPeer()).BotAPI(), nil
 	case int64:
 		return val, nil
 	case int:
@@ -238,3 +240,47 @@ func GetUserProfilePhotos[chatUnion ChatUnion](ctx *ext.Context, user chatUnion,
 
 	return ctx.GetUserProfilePhotos(userId, opts)
 }
+
+// SendDocument is a generic helper for ext.Context.SendDocument method.
+func SendDocument[chatUnion ChatUnion](ctx *ext.Context, chat chatUnion, file ext.UploadInputClass, opts *ext.SendMediaOpts) (*types.Message, error) {
+
+	chatId, err := getIdByUnion(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.SendDocument(chatId, file, opts)
+}
+
+// SendPhoto is a generic helper for ext.Context.SendPhoto method.
+func SendPhoto[chatUnion ChatUnion](ctx *ext.Context, chat chatUnion, file ext.UploadInputClass, opts *ext.SendMediaOpts) (*types.Message, error) {
+
+	chatId, err := getIdByUnion(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.SendPhoto(chatId, file, opts)
+}
+
+// SendVideo is a generic helper for ext.Context.SendVideo method.
+func SendVideo[chatUnion ChatUnion](ctx *ext.Context, chat chatUnion, file ext.UploadInputClass, opts *ext.SendVideoOpts) (*types.Message, error) {
+
+	chatId, err := getIdByUnion(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.SendVideo(chatId, file, opts)
+}
+
+// SendAudio is a generic helper for ext.Context.SendAudio method.
+func SendAudio[chatUnion ChatUnion](ctx *ext.Context, chat chatUnion, file ext.UploadInputClass, opts *ext.SendAudioOpts) (*types.Message, error) {
+
+	chatId, err := getIdByUnion(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.SendAudio(chatId, file, opts)
+}
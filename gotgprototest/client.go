@@ -0,0 +1,220 @@
+package gotgprototest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/tg"
+)
+
+// ClientOpts contains optional parameters for NewClient.
+type ClientOpts struct {
+	// Self is the user the client is authorized as, a bot is used if it is nil.
+	Self *tg.User
+	// Dispatcher is the dispatcher which handles the updates, a NativeDispatcher without
+	// reply fetching is used if it is nil. It must be created with the same PeerStorage as the client.
+	Dispatcher *dispatcher.NativeDispatcher
+	// PeerStorage is the peer storage used by the client, an in-memory one is used if it is nil.
+	PeerStorage *storage.PeerStorage
+}
+
+// Client runs a NativeDispatcher over a fake Invoker, updates passed to Handle are processed
+// by the handlers of the dispatcher exactly like the ones received from Telegram.
+//
+// Sending and editing messages are scripted by default to respond with the sent message
+// and uploaded file parts are accepted, the rest of the requests need to be scripted through the Invoker.
+type Client struct {
+	// Invoker records the requests made by the client and replies with the scripted responses.
+	Invoker *Invoker
+	// API is the raw tg client which makes requests through the Invoker.
+	API *tg.Client
+	// Dispatcher handles the updates passed to the client.
+	Dispatcher *dispatcher.NativeDispatcher
+	// PeerStorage is the peer storage used by the client.
+	PeerStorage *storage.PeerStorage
+	// Self is the user the client is authorized as.
+	Self *tg.User
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	lock   sync.Mutex
+	users  map[int64]tg.UserClass
+	chats  map[int64]tg.ChatClass
+	nextID int
+}
+
+// NewClient creates a new Client with provided options, opts can be nil.
+func NewClient(opts *ClientOpts) *Client {
+	if opts == nil {
+		opts = &ClientOpts{}
+	}
+	c := &Client{
+		Invoker:     NewInvoker(),
+		Self:        opts.Self,
+		Dispatcher:  opts.Dispatcher,
+		PeerStorage: opts.PeerStorage,
+		users:       make(map[int64]tg.UserClass),
+		chats:       make(map[int64]tg.ChatClass),
+	}
+	if c.Self == nil {
+		c.Self = Bot(1, "test_bot")
+	}
+	if c.PeerStorage == nil {
+		// An in-memory peer storage never fails.
+		c.PeerStorage, _ = storage.NewPeerStorage(nil, true)
+	}
+	if c.Dispatcher == nil {
+		c.Dispatcher = dispatcher.NewNativeDispatcher(false, false, nil, nil, c.PeerStorage)
+	}
+	c.API = tg.NewClient(c.Invoker)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.AddUsers(c.Self)
+	c.scriptMessages()
+	c.Dispatcher.InitializeRaw(c.ctx, c.cancel, c.API, c.Self)
+	return c
+}
+
+// AddUsers saves the provided users in the peer storage and includes them in the updates passed to Handle.
+func (c *Client) AddUsers(users ...*tg.User) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, u := range users {
+		c.users[u.ID] = u
+		c.PeerStorage.SavePeer(storage.PeerFromUser(u))
+	}
+}
+
+// AddChats saves the provided chats and channels in the peer storage and includes them in the updates passed to Handle.
+func (c *Client) AddChats(chats ...tg.ChatClass) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, chat := range chats {
+		c.chats[chat.GetID()] = chat
+	}
+	functions.SavePeersFromClassArray(c.PeerStorage, chats, nil)
+}
+
+// Handle dispatches the provided updates along with the added users and chats.
+func (c *Client) Handle(updates ...tg.UpdateClass) error {
+	c.lock.Lock()
+	users := make([]tg.UserClass, 0, len(c.users))
+	for _, u := range c.users {
+		users = append(users, u)
+	}
+	chats := make([]tg.ChatClass, 0, len(c.chats))
+	for _, chat := range c.chats {
+		chats = append(chats, chat)
+	}
+	c.lock.Unlock()
+	return c.HandleUpdates(Updates(users, chats, updates...))
+}
+
+// HandleUpdates dispatches the provided updates as they are.
+func (c *Client) HandleUpdates(updates tg.UpdatesClass) error {
+	return c.Dispatcher.Handle(c.ctx, updates)
+}
+
+// Context creates a new ext.Context of the client, it can be used to test the methods of ext.Context directly.
+func (c *Client) Context() *ext.Context {
+	c.lock.Lock()
+	e := tg.Entities{
+		Users:    make(map[int64]*tg.User),
+		Chats:    make(map[int64]*tg.Chat),
+		Channels: make(map[int64]*tg.Channel),
+	}
+	for id, u := range c.users {
+		if u, ok := u.(*tg.User); ok {
+			e.Users[id] = u
+		}
+	}
+	for id, chat := range c.chats {
+		switch chat := chat.(type) {
+		case *tg.Chat:
+			e.Chats[id] = chat
+		case *tg.Channel:
+			e.Channels[id] = chat
+		}
+	}
+	c.lock.Unlock()
+	return ext.NewContext(c.ctx, c.API, c.PeerStorage, c.Self, message.NewSender(c.API), &e, false)
+}
+
+// Stopped returns true if the client was stopped, i.e. by a handler returning dispatcher.StopClient.
+func (c *Client) Stopped() bool {
+	return c.ctx.Err() != nil
+}
+
+// Stop stops the client.
+func (c *Client) Stop() {
+	c.cancel()
+}
+
+// scriptMessages scripts the requests sending and editing messages to respond with the resulting message,
+// and the requests uploading file parts to succeed.
+func (c *Client) scriptMessages() {
+	c.Invoker.Reply(&tg.UploadSaveFilePartRequest{}, &tg.BoolTrue{})
+	c.Invoker.Reply(&tg.UploadSaveBigFilePartRequest{}, &tg.BoolTrue{})
+	c.Invoker.On(&tg.MessagesSendMessageRequest{}, func(_ context.Context, req Request) (bin.Encoder, error) {
+		r := req.(*tg.MessagesSendMessageRequest)
+		return c.sent(r.Peer, r.Message, r.Entities, nil), nil
+	})
+	c.Invoker.On(&tg.MessagesSendMediaRequest{}, func(_ context.Context, req Request) (bin.Encoder, error) {
+		r := req.(*tg.MessagesSendMediaRequest)
+		return c.sent(r.Peer, r.Message, r.Entities, &tg.MessageMediaEmpty{}), nil
+	})
+	c.Invoker.On(&tg.MessagesEditMessageRequest{}, func(_ context.Context, req Request) (bin.Encoder, error) {
+		r := req.(*tg.MessagesEditMessageRequest)
+		m := &tg.Message{
+			ID:       r.ID,
+			Out:      true,
+			PeerID:   c.peer(r.Peer),
+			Message:  r.Message,
+			Entities: r.Entities,
+			Date:     int(time.Now().Unix()),
+		}
+		m.SetEditDate(m.Date)
+		return Updates(nil, nil, EditMessage(m)), nil
+	})
+}
+
+func (c *Client) sent(peer tg.InputPeerClass, text string, entities []tg.MessageEntityClass, media tg.MessageMediaClass) *tg.Updates {
+	c.lock.Lock()
+	c.nextID++
+	id := c.nextID
+	c.lock.Unlock()
+	m := &tg.Message{
+		ID:       id,
+		Out:      true,
+		PeerID:   c.peer(peer),
+		Message:  text,
+		Entities: entities,
+		Date:     int(time.Now().Unix()),
+	}
+	m.SetFromID(&tg.PeerUser{UserID: c.Self.ID})
+	if media != nil {
+		m.SetMedia(media)
+	}
+	return Updates(nil, nil, NewMessage(m))
+}
+
+// peer returns the tg.PeerClass of the provided input peer.
+func (c *Client) peer(peer tg.InputPeerClass) tg.PeerClass {
+	switch peer := peer.(type) {
+	case *tg.InputPeerUser:
+		return &tg.PeerUser{UserID: peer.UserID}
+	case *tg.InputPeerChat:
+		return &tg.PeerChat{ChatID: peer.ChatID}
+	case *tg.InputPeerChannel:
+		return &tg.PeerChannel{ChannelID: peer.ChannelID}
+	case *tg.InputPeerSelf:
+		return &tg.PeerUser{UserID: c.Self.ID}
+	}
+	return &tg.PeerUser{}
+}
@@ -0,0 +1,165 @@
+// Package gotgprototest provides utilities to test bots built on gotgproto without connecting to Telegram.
+//
+// Invoker is a fake tg.Invoker which records every request and replies with scripted responses,
+// Client runs a NativeDispatcher over it and the rest of the helpers synthesize updates for it.
+package gotgprototest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tgerr"
+)
+
+// ErrNoResponse is returned by Invoker for the requests which don't have a scripted response.
+var ErrNoResponse = errors.New("no response scripted for request")
+
+// Request is a Telegram API request, i.e. *tg.MessagesSendMessageRequest.
+type Request interface {
+	bin.Encoder
+	TypeID() uint32
+}
+
+// Responder returns the response for a request made through Invoker.
+type Responder func(ctx context.Context, req Request) (bin.Encoder, error)
+
+// Invoker is a fake tg.Invoker which records all the requests and replies to them with scripted responses.
+// It is safe for concurrent use.
+type Invoker struct {
+	mu         sync.Mutex
+	requests   []Request
+	responders map[uint32]Responder
+	fallback   Responder
+}
+
+// NewInvoker creates a new Invoker without any scripted responses.
+func NewInvoker() *Invoker {
+	return &Invoker{
+		responders: make(map[uint32]Responder),
+	}
+}
+
+// On scripts the provided Responder for all the requests of the same type as req, replacing the previous one.
+func (i *Invoker) On(req Request, r Responder) *Invoker {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.responders[req.TypeID()] = r
+	return i
+}
+
+// Reply scripts the provided response for all the requests of the same type as req.
+func (i *Invoker) Reply(req Request, resp bin.Encoder) *Invoker {
+	return i.On(req, func(context.Context, Request) (bin.Encoder, error) {
+		return resp, nil
+	})
+}
+
+// Fail scripts the provided error for all the requests of the same type as req.
+// RPCError can be used to create errors returned by Telegram.
+func (i *Invoker) Fail(req Request, err error) *Invoker {
+	return i.On(req, func(context.Context, Request) (bin.Encoder, error) {
+		return nil, err
+	})
+}
+
+// Fallback sets the Responder used for the requests which don't have a scripted response.
+func (i *Invoker) Fallback(r Responder) *Invoker {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.fallback = r
+	return i
+}
+
+// Invoke records the request and decodes its scripted response into output.
+// It fails without recording the request if ctx is done, like a real connection.
+func (i *Invoker) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	req, ok := input.(Request)
+	if !ok {
+		return fmt.Errorf("unexpected request %T", input)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.requests = append(i.requests, req)
+	r, ok := i.responders[req.TypeID()]
+	if !ok {
+		r = i.fallback
+	}
+	i.mu.Unlock()
+	if r == nil {
+		return fmt.Errorf("%w: %T", ErrNoResponse, req)
+	}
+	resp, err := r(ctx, req)
+	if err != nil {
+		return err
+	}
+	var buf bin.Buffer
+	if err := resp.Encode(&buf); err != nil {
+		return err
+	}
+	return output.Decode(&buf)
+}
+
+// Requests returns all the requests recorded by the invoker in order.
+func (i *Invoker) Requests() []Request {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]Request(nil), i.requests...)
+}
+
+// Reset forgets all the recorded requests, the scripted responses are kept.
+func (i *Invoker) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.requests = nil
+}
+
+// RequestsOf returns the recorded requests of type T in order, i.e. RequestsOf[*tg.MessagesSendMessageRequest](i).
+func RequestsOf[T Request](i *Invoker) []T {
+	var requests []T
+	for _, req := range i.Requests() {
+		if r, ok := req.(T); ok {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// LastRequest returns the last recorded request of type T.
+func LastRequest[T Request](i *Invoker) (T, bool) {
+	requests := RequestsOf[T](i)
+	if len(requests) == 0 {
+		var zero T
+		return zero, false
+	}
+	return requests[len(requests)-1], true
+}
+
+// ExpectRequest returns the last recorded request of type T, failing the test if there is none.
+func ExpectRequest[T Request](tb testing.TB, i *Invoker) T {
+	tb.Helper()
+	req, ok := LastRequest[T](i)
+	if !ok {
+		var zero T
+		tb.Fatalf("expected a %T request, got %d other requests", zero, len(i.Requests()))
+	}
+	return req
+}
+
+// ExpectNoRequest fails the test if a request of type T was recorded.
+func ExpectNoRequest[T Request](tb testing.TB, i *Invoker) {
+	tb.Helper()
+	if req, ok := LastRequest[T](i); ok {
+		tb.Fatalf("unexpected request %v", req)
+	}
+}
+
+// RPCError creates an error as returned by Telegram, i.e. RPCError(420, "FLOOD_WAIT_3").
+func RPCError(code int, message string) error {
+	return tgerr.New(code, message)
+}
@@ -0,0 +1,58 @@
+package gotgprototest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+func TestInvokerReply(t *testing.T) {
+	i := NewInvoker().Reply(&tg.UsersGetUsersRequest{}, &tg.UserClassVector{Elems: []tg.UserClass{User(10, "Alice")}})
+	api := tg.NewClient(i)
+	users, err := api.UsersGetUsers(context.Background(), []tg.InputUserClass{&tg.InputUser{UserID: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].(*tg.User).FirstName != "Alice" {
+		t.Fatalf("unexpected users %v", users)
+	}
+	req := ExpectRequest[*tg.UsersGetUsersRequest](t, i)
+	if req.ID[0].(*tg.InputUser).UserID != 10 {
+		t.Fatalf("unexpected request %v", req)
+	}
+	ExpectNoRequest[*tg.MessagesSendMessageRequest](t, i)
+}
+
+func TestInvokerFail(t *testing.T) {
+	i := NewInvoker().Fail(&tg.MessagesSendMessageRequest{}, RPCError(420, "FLOOD_WAIT_3"))
+	_, err := tg.NewClient(i).MessagesSendMessage(context.Background(), &tg.MessagesSendMessageRequest{Peer: &tg.InputPeerSelf{}})
+	if d, ok := tgerr.AsFloodWait(err); !ok || d.Seconds() != 3 {
+		t.Fatalf("expected flood wait error, got %v", err)
+	}
+}
+
+func TestInvokerNoResponse(t *testing.T) {
+	i := NewInvoker()
+	_, err := tg.NewClient(i).HelpGetConfig(context.Background())
+	if !errors.Is(err, ErrNoResponse) {
+		t.Fatalf("expected ErrNoResponse, got %v", err)
+	}
+	i.Fallback(func(context.Context, Request) (bin.Encoder, error) {
+		return &tg.Config{ThisDC: 2}, nil
+	})
+	cfg, err := tg.NewClient(i).HelpGetConfig(context.Background())
+	if err != nil || cfg.ThisDC != 2 {
+		t.Fatalf("unexpected response %v, %v", cfg, err)
+	}
+	if n := len(RequestsOf[*tg.HelpGetConfigRequest](i)); n != 2 {
+		t.Fatalf("expected 2 recorded requests, got %d", n)
+	}
+	i.Reset()
+	if n := len(i.Requests()); n != 0 {
+		t.Fatalf("expected no requests after reset, got %d", n)
+	}
+}
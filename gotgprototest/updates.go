@@ -0,0 +1,130 @@
+package gotgprototest
+
+import (
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// AccessHash returns the fake access hash used by the helpers for the provided peer id.
+func AccessHash(id int64) int64 {
+	return id * 31
+}
+
+// User creates a *tg.User with provided id and first name.
+func User(id int64, firstName string) *tg.User {
+	u := &tg.User{
+		ID:         id,
+		AccessHash: AccessHash(id),
+		FirstName:  firstName,
+	}
+	u.SetFlags()
+	return u
+}
+
+// Bot creates a *tg.User of a bot with provided id and username.
+func Bot(id int64, username string) *tg.User {
+	u := &tg.User{
+		ID:         id,
+		AccessHash: AccessHash(id),
+		FirstName:  username,
+		Username:   username,
+		Bot:        true,
+	}
+	u.SetFlags()
+	return u
+}
+
+// Chat creates a *tg.Chat of a normal group with provided id and title.
+func Chat(id int64, title string) *tg.Chat {
+	return &tg.Chat{
+		ID:    id,
+		Title: title,
+		Photo: &tg.ChatPhotoEmpty{},
+		Date:  int(time.Now().Unix()),
+	}
+}
+
+// Channel creates a *tg.Channel with provided id and title, it is a supergroup if megagroup is true.
+func Channel(id int64, title string, megagroup bool) *tg.Channel {
+	c := &tg.Channel{
+		ID:         id,
+		AccessHash: AccessHash(id),
+		Title:      title,
+		Megagroup:  megagroup,
+		Broadcast:  !megagroup,
+		Photo:      &tg.ChatPhotoEmpty{},
+		Date:       int(time.Now().Unix()),
+	}
+	c.SetFlags()
+	return c
+}
+
+// TextMessage creates an incoming *tg.Message with provided id and text, sent by the from peer in the chat peer.
+// The from peer is ignored for private chats and channel posts if it is nil.
+func TextMessage(id int, from, chat tg.PeerClass, text string) *tg.Message {
+	m := &tg.Message{
+		ID:      id,
+		PeerID:  chat,
+		Message: text,
+		Date:    int(time.Now().Unix()),
+	}
+	if from != nil {
+		m.SetFromID(from)
+	}
+	m.SetFlags()
+	return m
+}
+
+// NewMessage wraps the provided message in a tg.UpdateNewChannelMessage if it belongs to a channel
+// and in a tg.UpdateNewMessage otherwise.
+func NewMessage(m *tg.Message) tg.UpdateClass {
+	if _, ok := m.PeerID.(*tg.PeerChannel); ok {
+		return &tg.UpdateNewChannelMessage{Message: m}
+	}
+	return &tg.UpdateNewMessage{Message: m}
+}
+
+// EditMessage wraps the provided message in a tg.UpdateEditChannelMessage if it belongs to a channel
+// and in a tg.UpdateEditMessage otherwise.
+func EditMessage(m *tg.Message) tg.UpdateClass {
+	if m.EditDate == 0 {
+		m.SetEditDate(int(time.Now().Unix()))
+	}
+	if _, ok := m.PeerID.(*tg.PeerChannel); ok {
+		return &tg.UpdateEditChannelMessage{Message: m}
+	}
+	return &tg.UpdateEditMessage{Message: m}
+}
+
+// CallbackQuery creates a *tg.UpdateBotCallbackQuery sent by the user from a button of the message in the chat peer.
+func CallbackQuery(queryID, userID int64, chat tg.PeerClass, msgID int, data string) *tg.UpdateBotCallbackQuery {
+	u := &tg.UpdateBotCallbackQuery{
+		QueryID: queryID,
+		UserID:  userID,
+		Peer:    chat,
+		MsgID:   msgID,
+		Data:    []byte(data),
+	}
+	u.SetFlags()
+	return u
+}
+
+// InlineQuery creates a *tg.UpdateBotInlineQuery sent by the user.
+func InlineQuery(queryID, userID int64, query string) *tg.UpdateBotInlineQuery {
+	return &tg.UpdateBotInlineQuery{
+		QueryID: queryID,
+		UserID:  userID,
+		Query:   query,
+	}
+}
+
+// Updates wraps the provided updates in *tg.Updates along with the users and chats they refer to.
+func Updates(users []tg.UserClass, chats []tg.ChatClass, updates ...tg.UpdateClass) *tg.Updates {
+	return &tg.Updates{
+		Updates: updates,
+		Users:   users,
+		Chats:   chats,
+		Date:    int(time.Now().Unix()),
+	}
+}
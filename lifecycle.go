@@ -0,0 +1,128 @@
+package gotgproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	intErrors "github.com/celestix/gotgproto/errors"
+	"github.com/gotd/td/telegram/auth"
+	"go.uber.org/zap"
+)
+
+// ReconnectOpts configures how a client reconnects after it stopped running with an error.
+type ReconnectOpts struct {
+	// MinBackoff is the delay before the first reconnect attempt.
+	// The delay is doubled after every failed attempt and reset once the client is running again.
+	//
+	// If not provided, 1 second will be used.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between reconnect attempts.
+	//
+	// If not provided, 5 minutes will be used.
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of consecutive failed attempts after which the client stops
+	// with the last error. The client reconnects until it is stopped if it is 0.
+	MaxAttempts int
+}
+
+func (r *ReconnectOpts) withDefaults() ReconnectOpts {
+	var o ReconnectOpts
+	if r != nil {
+		o = *r
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 5 * time.Minute
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	return o
+}
+
+// run runs the client until ctx is done, reconnecting with a backoff whenever it stops running with an error.
+// started is closed once the client is running for the first time, the errors before it aren't retried.
+// Returns nil if the client was stopped.
+func (c *Client) run(ctx context.Context, opts *ClientOpts, started chan struct{}) error {
+	reconnect := opts.Reconnect.withDefaults()
+	backoff := reconnect.MinBackoff
+	attempts := 0
+	connected := false
+	var startOnce sync.Once
+	for {
+		running := false
+		onStart := func() {
+			running = true
+			c.setState(StateRunning)
+			if !connected {
+				startOnce.Do(func() { close(started) })
+				if opts.OnConnected != nil {
+					opts.OnConnected(c)
+				}
+			} else if opts.OnReconnected != nil {
+				opts.OnReconnected(c)
+			}
+		}
+		err := c.runOnce(ctx, opts, connected, onStart)
+		connected = connected || running
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			// The client stopped by itself.
+			return nil
+		}
+		if connected && (auth.IsUnauthorized(err) || errors.Is(err, intErrors.ErrSessionRevoked)) {
+			if !errors.Is(err, intErrors.ErrSessionRevoked) {
+				err = fmt.Errorf("%w: %w", intErrors.ErrSessionRevoked, err)
+			}
+			if opts.OnAuthLost != nil {
+				opts.OnAuthLost(c, err)
+			}
+			return err
+		}
+		if !connected || opts.DisableReconnect {
+			return err
+		}
+		if running {
+			attempts, backoff = 0, reconnect.MinBackoff
+			if opts.OnDisconnected != nil {
+				opts.OnDisconnected(c, err)
+			}
+		}
+		attempts++
+		if reconnect.MaxAttempts > 0 && attempts > reconnect.MaxAttempts {
+			return err
+		}
+		c.setState(StateReconnecting)
+		if c.Logger != nil {
+			c.Logger.Warn("client stopped running, reconnecting",
+				zap.Int("attempt", attempts), zap.Duration("backoff", backoff), zap.Error(err))
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff = min(2*backoff, reconnect.MaxBackoff)
+	}
+}
+
+// runOnce runs a new telegram client through the RunMiddleware of opts if it is set,
+// onStart is called once the client is logged in and receiving updates.
+func (c *Client) runOnce(ctx context.Context, opts *ClientOpts, reconnect bool, onStart func()) error {
+	if c.runner != nil {
+		return c.runner(ctx, reconnect, onStart)
+	}
+	c.initTelegramClient(opts.Device, opts.Middlewares)
+	f := c.initialize(reconnect, onStart)
+	if opts.RunMiddleware == nil {
+		return c.Run(ctx, f)
+	}
+	return opts.RunMiddleware(c.Run, ctx, f)
+}
@@ -0,0 +1,375 @@
+package gotgproto
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	intErrors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/sessionMaker"
+	"github.com/celestix/gotgproto/storage"
+	"go.uber.org/zap"
+)
+
+// ClientHealth is the health status of a client run by a Manager.
+type ClientHealth struct {
+	State ClientState
+	// Err is the last error the client crashed or failed to start with.
+	Err error
+	// Restarts is the number of times the client was restarted after a crash.
+	Restarts int
+	// Since is the time the client entered its current state.
+	Since time.Time
+}
+
+// ManagerOpts contains optional parameters for NewManager.
+type ManagerOpts struct {
+	// Store is the storage backend shared by all the clients, every client saves its session
+	// and peers in the store of its account name. storage.GormStore is the only store which
+	// implements storage.AccountStore, a MemoryStore or FileStore can only be used per account
+	// through ClientOpts.Session.
+	//
+	// If not provided, the Session of the ClientOpts of every account is used.
+	Store storage.AccountStore
+	// MinBackoff is the delay before a crashed client is restarted for the first time.
+	// The delay is doubled after every restart which fails or crashes again.
+	//
+	// If not provided, 1 second will be used.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay before a crashed client is restarted.
+	// The delay is reset to MinBackoff if a client was running for longer than MaxBackoff.
+	//
+	// If not provided, 5 minutes will be used.
+	MaxBackoff time.Duration
+	// Logger is instance of zap.Logger. No logs by default.
+	Logger *zap.Logger
+}
+
+// AccountOpts contains the parameters the client of an account is created with by a Manager.
+type AccountOpts struct {
+	AppId      int
+	ApiHash    string
+	ClientType clientType
+	// ClientOpts are the options of the client, ClientOpts.Session is replaced with
+	// the store of the account if ManagerOpts.Store is set.
+	ClientOpts *ClientOpts
+}
+
+// Manager runs the clients of multiple accounts, identified by their names.
+// Clients which stop running with an error, i.e. after exhausting their reconnect attempts,
+// are restarted with an exponential backoff unless their session was revoked.
+// Handlers added to the manager are attached to every client it runs.
+type Manager struct {
+	opts     ManagerOpts
+	lock     sync.Mutex
+	accounts map[string]*managedAccount
+	handlers []managedHandler
+}
+
+type managedHandler struct {
+	handler dispatcher.Handler
+	group   int
+}
+
+type managedAccount struct {
+	name   string
+	opts   AccountOpts
+	store  storage.Store
+	ctx    context.Context
+	cancel context.CancelFunc
+	// exited is closed when the account is stopped for good.
+	exited chan struct{}
+	client *Client
+	health ClientHealth
+}
+
+// NewManager creates a new Manager with provided options, opts can be nil.
+func NewManager(opts *ManagerOpts) *Manager {
+	m := &Manager{accounts: make(map[string]*managedAccount)}
+	if opts != nil {
+		m.opts = *opts
+	}
+	if m.opts.MinBackoff <= 0 {
+		m.opts.MinBackoff = time.Second
+	}
+	if m.opts.MaxBackoff <= 0 {
+		m.opts.MaxBackoff = 5 * time.Minute
+	}
+	if m.opts.MaxBackoff < m.opts.MinBackoff {
+		m.opts.MaxBackoff = m.opts.MinBackoff
+	}
+	if m.opts.Logger == nil {
+		m.opts.Logger = zap.NewNop()
+	}
+	return m
+}
+
+// AddHandler adds a handler to every client of the manager, including the ones started later.
+func (m *Manager) AddHandler(h dispatcher.Handler) {
+	m.AddHandlerToGroup(h, 0)
+}
+
+// AddHandlerToGroup adds a handler to a specific group of every client of the manager, including the ones started later.
+func (m *Manager) AddHandlerToGroup(h dispatcher.Handler, group int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.handlers = append(m.handlers, managedHandler{handler: h, group: group})
+	for _, a := range m.accounts {
+		if a.client != nil {
+			a.client.Dispatcher.AddHandlerToGroup(h, group)
+		}
+	}
+}
+
+// Start creates the client of the account with the provided name and waits till it starts,
+// the client is restarted by the manager if it crashes until it is stopped through Manager.Stop.
+// It returns errors.ErrClientAlreadyRunning if the account is already run by the manager, opts can be nil.
+func (m *Manager) Start(name string, opts *AccountOpts) error {
+	if opts == nil {
+		opts = &AccountOpts{}
+	}
+	m.lock.Lock()
+	if a, ok := m.accounts[name]; ok && a.health.State != StateStopped {
+		m.lock.Unlock()
+		return intErrors.ErrClientAlreadyRunning
+	}
+	parent := context.Background()
+	if opts.ClientOpts != nil && opts.ClientOpts.Context != nil {
+		parent = opts.ClientOpts.Context
+	}
+	a := &managedAccount{
+		name:   name,
+		opts:   *opts,
+		exited: make(chan struct{}),
+		health: ClientHealth{State: StateStarting, Since: time.Now()},
+	}
+	a.ctx, a.cancel = context.WithCancel(parent)
+	m.accounts[name] = a
+	m.lock.Unlock()
+
+	c, err := m.newClient(a)
+	if err != nil {
+		a.cancel()
+		m.setState(a, StateStopped, err)
+		close(a.exited)
+		return err
+	}
+	go m.supervise(a, c)
+	return nil
+}
+
+// Stop stops the client of the account with the provided name and waits till it stops.
+// It returns errors.ErrClientNotFound if the account isn't run by the manager.
+func (m *Manager) Stop(name string) error {
+	m.lock.Lock()
+	a, ok := m.accounts[name]
+	m.lock.Unlock()
+	if !ok {
+		return intErrors.ErrClientNotFound
+	}
+	a.cancel()
+	<-a.exited
+	return nil
+}
+
+// StopAll stops the clients of all the accounts and waits till they stop.
+func (m *Manager) StopAll() {
+	accounts := m.snapshot()
+	for _, a := range accounts {
+		a.cancel()
+	}
+	for _, a := range accounts {
+		<-a.exited
+	}
+}
+
+// Idle keeps the current goroutine blocked until the clients of all the accounts are stopped.
+func (m *Manager) Idle() {
+	for {
+		accounts := m.snapshot()
+		for _, a := range accounts {
+			<-a.exited
+		}
+		// Accounts may have been started again in the meantime.
+		if m.stopped(accounts) {
+			return
+		}
+	}
+}
+
+// Client returns the current client of the account with the provided name.
+// The client is replaced with a new one whenever it is restarted.
+func (m *Manager) Client(name string) (*Client, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	a, ok := m.accounts[name]
+	if !ok || a.client == nil {
+		return nil, false
+	}
+	return a.client, true
+}
+
+// Health returns the health status of the clients of all the accounts, keyed by their names.
+func (m *Manager) Health() map[string]ClientHealth {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	health := make(map[string]ClientHealth, len(m.accounts))
+	for name, a := range m.accounts {
+		h := a.health
+		if h.State == StateRunning && a.client != nil {
+			// A running client may be reconnecting by itself.
+			h.State = a.client.State()
+		}
+		health[name] = h
+	}
+	return health
+}
+
+// Healthy reports whether the clients of all the accounts are running,
+// accounts which were stopped without an error are ignored.
+func (m *Manager) Healthy() bool {
+	for _, h := range m.Health() {
+		if h.State == StateStopped && h.Err == nil {
+			continue
+		}
+		if h.State != StateRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// supervise restarts the client of the account whenever it crashes until the account is stopped.
+func (m *Manager) supervise(a *managedAccount, c *Client) {
+	defer close(a.exited)
+	backoff := m.opts.MinBackoff
+	for {
+		startedAt := time.Now()
+		c.lock.Lock()
+		done := c.done
+		c.lock.Unlock()
+		<-done
+		err := c.runErr()
+		if err == nil || a.ctx.Err() != nil {
+			// Stopped through Manager.Stop or by the client itself, i.e. a handler returning dispatcher.StopClient.
+			a.cancel()
+			m.setState(a, StateStopped, nil)
+			return
+		}
+		if isTerminal(err) {
+			a.cancel()
+			m.setState(a, StateStopped, err)
+			return
+		}
+		if time.Since(startedAt) > m.opts.MaxBackoff {
+			backoff = m.opts.MinBackoff
+		}
+		for {
+			m.opts.Logger.Warn("client crashed, restarting",
+				zap.String("account", a.name), zap.Duration("backoff", backoff), zap.Error(err))
+			m.setState(a, StateRestarting, err)
+			select {
+			case <-time.After(backoff):
+			case <-a.ctx.Done():
+				m.setState(a, StateStopped, nil)
+				return
+			}
+			backoff = min(2*backoff, m.opts.MaxBackoff)
+			m.lock.Lock()
+			a.health.Restarts++
+			m.lock.Unlock()
+			m.setState(a, StateStarting, err)
+			c, err = m.newClient(a)
+			if err == nil {
+				break
+			}
+			if isTerminal(err) {
+				a.cancel()
+				m.setState(a, StateStopped, err)
+				return
+			}
+		}
+	}
+}
+
+// isTerminal reports whether a client which failed with err can't be restarted without logging in again.
+func isTerminal(err error) bool {
+	return errors.Is(err, intErrors.ErrSessionRevoked) || errors.Is(err, intErrors.ErrSessionUnauthorized)
+}
+
+// newClient creates and starts a new client of the account, attaching the handlers of the manager to it.
+func (m *Manager) newClient(a *managedAccount) (*Client, error) {
+	opts := ClientOpts{
+		SystemLangCode: "en",
+		ClientLangCode: "en",
+	}
+	if a.opts.ClientOpts != nil {
+		opts = *a.opts.ClientOpts
+	}
+	opts.Context = a.ctx
+	if m.opts.Store != nil {
+		if a.store == nil {
+			store, err := m.opts.Store.Account(a.name)
+			if err != nil {
+				return nil, err
+			}
+			a.store = store
+		}
+		opts.Session = sessionMaker.CustomSession(a.store)
+		opts.InMemory = false
+	}
+	c, err := newClient(a.opts.AppId, a.opts.ApiHash, a.opts.ClientType, &opts, func(c *Client) {
+		m.attach(a, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.setState(a, StateRunning, nil)
+	return c, nil
+}
+
+// attach adds the handlers of the manager to the dispatcher of the provided client and sets it as the client of the account.
+// Both are done under the lock, so that a handler added meanwhile reaches the client exactly once.
+func (m *Manager) attach(a *managedAccount, c *Client) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, h := range m.handlers {
+		c.Dispatcher.AddHandlerToGroup(h.handler, h.group)
+	}
+	a.client = c
+}
+
+func (m *Manager) setState(a *managedAccount, state ClientState, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	a.health.State = state
+	a.health.Err = err
+	a.health.Since = time.Now()
+}
+
+func (m *Manager) snapshot() []*managedAccount {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	accounts := make([]*managedAccount, 0, len(m.accounts))
+	for _, a := range m.accounts {
+		accounts = append(accounts, a)
+	}
+	return accounts
+}
+
+// stopped reports whether the provided accounts are still the ones of the manager.
+func (m *Manager) stopped(accounts []*managedAccount) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(accounts) != len(m.accounts) {
+		return false
+	}
+	for _, a := range accounts {
+		if m.accounts[a.name] != a {
+			return false
+		}
+	}
+	return true
+}
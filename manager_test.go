@@ -0,0 +1,92 @@
+package gotgproto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	intErrors "github.com/celestix/gotgproto/errors"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+func TestManagerStopUnknown(t *testing.T) {
+	m := NewManager(nil)
+	if err := m.Stop("missing"); !errors.Is(err, intErrors.ErrClientNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := m.Client("missing"); ok {
+		t.Fatal("expected no client")
+	}
+}
+
+func TestManagerHealthy(t *testing.T) {
+	m := NewManager(nil)
+	m.accounts["a"] = &managedAccount{name: "a", health: ClientHealth{State: StateRunning}}
+	m.accounts["b"] = &managedAccount{name: "b", health: ClientHealth{State: StateStopped}}
+	if !m.Healthy() {
+		t.Fatal("expected the manager to be healthy")
+	}
+	m.accounts["c"] = &managedAccount{name: "c", health: ClientHealth{State: StateRestarting, Err: errors.New("crash")}}
+	if m.Healthy() {
+		t.Fatal("expected a restarting client to be unhealthy")
+	}
+	if h := m.Health()["c"]; h.State.String() != "restarting" || h.Err == nil {
+		t.Fatalf("unexpected health %+v", h)
+	}
+}
+
+func TestManagerAddHandler(t *testing.T) {
+	m := NewManager(nil)
+	running := gotgprototest.NewClient(nil)
+	m.accounts["a"] = &managedAccount{name: "a", client: &Client{Dispatcher: running.Dispatcher}}
+	calls := 0
+	m.AddHandler(handlers.NewMessage(nil, func(*ext.Context, *ext.Update) error {
+		calls++
+		return nil
+	}))
+
+	// Handlers are attached to the running clients and to the ones started later.
+	started := gotgprototest.NewClient(nil)
+	b := &managedAccount{name: "b"}
+	m.accounts["b"] = b
+	m.attach(b, &Client{Dispatcher: started.Dispatcher})
+	if b.client == nil {
+		t.Fatal("attached client was not set as the client of the account")
+	}
+	// A handler added once the client is attached is added to it only once.
+	later := 0
+	m.AddHandler(handlers.NewMessage(nil, func(*ext.Context, *ext.Update) error {
+		later++
+		return nil
+	}))
+	alice := gotgprototest.User(10, "Alice")
+	for _, c := range []*gotgprototest.Client{running, started} {
+		c.AddUsers(alice)
+		msg := gotgprototest.TextMessage(1, nil, &tg.PeerUser{UserID: alice.ID}, "hi")
+		if err := c.Handle(gotgprototest.NewMessage(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 || later != 2 {
+		t.Fatalf("expected the handlers to be called once by both clients, got %d and %d calls", calls, later)
+	}
+}
+
+type failingAccountStore struct{}
+
+func (failingAccountStore) Account(string) (storage.Store, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestManagerStartNilOpts(t *testing.T) {
+	m := NewManager(&ManagerOpts{Store: failingAccountStore{}})
+	if err := m.Start("a", nil); err == nil || err.Error() != "unavailable" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if h := m.Health()["a"]; h.State != StateStopped {
+		t.Fatalf("unexpected health %+v", h)
+	}
+}
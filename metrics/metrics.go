@@ -0,0 +1,83 @@
+// Package metrics defines the Recorder through which gotgproto reports the requests made to Telegram
+// and the updates processed by the dispatcher.
+//
+// Recorders exporting the measurements to Prometheus and OpenTelemetry are implemented by
+// the metrics/prommetrics and metrics/otelmetrics packages. They are separate modules, so that their
+// dependencies are only required by the programs which use them:
+//
+//	go get github.com/celestix/gotgproto/metrics/prommetrics
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// Recorder receives the measurements of a client, implementations must be safe for concurrent use.
+type Recorder interface {
+	// RPC records a request made to Telegram with the TL name of its method, i.e. "messages.sendMessage".
+	RPC(ctx context.Context, method string, duration time.Duration, err error)
+	// Update records an update received by the dispatcher with the TL name of its type, i.e. "updateNewMessage".
+	Update(ctx context.Context, updateType string)
+	// Handler records the execution of a handler of the provided group.
+	// err is nil for the errors used to control the dispatcher, i.e. dispatcher.EndGroups.
+	Handler(ctx context.Context, group int, duration time.Duration, err error)
+	// Panic records a panic recovered from a handler of the provided group.
+	Panic(ctx context.Context, group int)
+	// QueueDepth records the number of updates waiting to be processed by the workers of the dispatcher.
+	QueueDepth(ctx context.Context, depth int)
+	// Dropped records an update dropped because the queue of the dispatcher was full.
+	Dropped(ctx context.Context, updateType string)
+}
+
+// Nop is a Recorder which discards all the measurements, it can be embedded to implement only a part of Recorder.
+type Nop struct{}
+
+func (Nop) RPC(context.Context, string, time.Duration, error)  {}
+func (Nop) Update(context.Context, string)                     {}
+func (Nop) Handler(context.Context, int, time.Duration, error) {}
+func (Nop) Panic(context.Context, int)                         {}
+func (Nop) QueueDepth(context.Context, int)                    {}
+func (Nop) Dropped(context.Context, string)                    {}
+
+// Middleware returns a telegram.Middleware which records every request made through it.
+func Middleware(r Recorder) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			start := time.Now()
+			err := next.Invoke(ctx, input, output)
+			r.RPC(ctx, MethodName(input), time.Since(start), err)
+			return err
+		}
+	})
+}
+
+// MethodName returns the TL name of the method of a request, or an empty string if it's unknown.
+func MethodName(input bin.Encoder) string {
+	if t, ok := input.(interface{ TypeName() string }); ok {
+		return t.TypeName()
+	}
+	return ""
+}
+
+// ErrorType returns a low cardinality label of an error: the type of an error returned by Telegram,
+// i.e. "FLOOD_WAIT", "canceled" for canceled requests and "internal" for the rest.
+// It returns an empty string for a nil error.
+func ErrorType(err error) string {
+	if err == nil {
+		return ""
+	}
+	if rpcErr, ok := tgerr.As(err); ok {
+		return rpcErr.Type
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "canceled"
+	}
+	return "internal"
+}
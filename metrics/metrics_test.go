@@ -0,0 +1,65 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/gotgprototest"
+	"github.com/celestix/gotgproto/metrics"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+type rpc struct {
+	method string
+	err    error
+}
+
+type recorder struct {
+	metrics.Nop
+	rpcs []rpc
+}
+
+func (r *recorder) RPC(_ context.Context, method string, _ time.Duration, err error) {
+	r.rpcs = append(r.rpcs, rpc{method: method, err: err})
+}
+
+func TestMiddleware(t *testing.T) {
+	invoker := gotgprototest.NewInvoker().
+		Reply(&tg.HelpGetConfigRequest{}, &tg.Config{}).
+		Fail(&tg.MessagesSendMessageRequest{}, tgerr.New(400, "PEER_ID_INVALID"))
+	rec := &recorder{}
+	api := tg.NewClient(metrics.Middleware(rec).Handle(invoker))
+	if _, err := api.HelpGetConfig(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = api.MessagesSendMessage(context.Background(), &tg.MessagesSendMessageRequest{Peer: &tg.InputPeerEmpty{}, Message: "hi"})
+	if len(rec.rpcs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(rec.rpcs))
+	}
+	if rec.rpcs[0].method != "help.getConfig" || rec.rpcs[0].err != nil {
+		t.Fatalf("unexpected request %+v", rec.rpcs[0])
+	}
+	if rec.rpcs[1].method != "messages.sendMessage" || metrics.ErrorType(rec.rpcs[1].err) != "PEER_ID_INVALID" {
+		t.Fatalf("unexpected request %+v", rec.rpcs[1])
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: nil, want: ""},
+		{err: tgerr.New(420, "FLOOD_WAIT_10"), want: "FLOOD_WAIT"},
+		{err: context.Canceled, want: "canceled"},
+		{err: errors.New("connection reset"), want: "internal"},
+	}
+	for _, tt := range tests {
+		if got := metrics.ErrorType(tt.err); got != tt.want {
+			t.Errorf("ErrorType(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Package otelmetrics implements a metrics.Recorder exporting the measurements of gotgproto through OpenTelemetry.
+package otelmetrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/celestix/gotgproto/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Recorder is a metrics.Recorder recording the measurements with the instruments of a metric.Meter.
+type Recorder struct {
+	rpcDuration     metric.Float64Histogram
+	rpcErrors       metric.Int64Counter
+	updates         metric.Int64Counter
+	handlerDuration metric.Float64Histogram
+	handlerErrors   metric.Int64Counter
+	panics          metric.Int64Counter
+	queueDepth      metric.Int64Gauge
+	dropped         metric.Int64Counter
+}
+
+// New creates a new Recorder with the instruments of the provided meter,
+// i.e. otel.Meter("github.com/celestix/gotgproto").
+func New(meter metric.Meter) (*Recorder, error) {
+	var (
+		r   Recorder
+		err error
+	)
+	if r.rpcDuration, err = meter.Float64Histogram("gotgproto.rpc.duration",
+		metric.WithDescription("Duration of the requests made to Telegram."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if r.rpcErrors, err = meter.Int64Counter("gotgproto.rpc.errors",
+		metric.WithDescription("Number of failed requests made to Telegram.")); err != nil {
+		return nil, err
+	}
+	if r.updates, err = meter.Int64Counter("gotgproto.updates",
+		metric.WithDescription("Number of updates processed by the dispatcher.")); err != nil {
+		return nil, err
+	}
+	if r.handlerDuration, err = meter.Float64Histogram("gotgproto.handler.duration",
+		metric.WithDescription("Duration of the executions of the handlers."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if r.handlerErrors, err = meter.Int64Counter("gotgproto.handler.errors",
+		metric.WithDescription("Number of errors returned by the handlers.")); err != nil {
+		return nil, err
+	}
+	if r.panics, err = meter.Int64Counter("gotgproto.handler.panics",
+		metric.WithDescription("Number of panics recovered from the handlers.")); err != nil {
+		return nil, err
+	}
+	if r.queueDepth, err = meter.Int64Gauge("gotgproto.dispatcher.queue_depth",
+		metric.WithDescription("Number of updates waiting to be processed by the workers of the dispatcher.")); err != nil {
+		return nil, err
+	}
+	if r.dropped, err = meter.Int64Counter("gotgproto.dispatcher.dropped",
+		metric.WithDescription("Number of updates dropped because the queue of the dispatcher was full.")); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *Recorder) RPC(ctx context.Context, method string, duration time.Duration, err error) {
+	attr := attribute.String("method", method)
+	r.rpcDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attr))
+	if err != nil {
+		r.rpcErrors.Add(ctx, 1, metric.WithAttributes(attr, attribute.String("error", metrics.ErrorType(err))))
+	}
+}
+
+func (r *Recorder) Update(ctx context.Context, updateType string) {
+	r.updates.Add(ctx, 1, metric.WithAttributes(attribute.String("type", updateType)))
+}
+
+func (r *Recorder) Handler(ctx context.Context, group int, duration time.Duration, err error) {
+	attrs := metric.WithAttributes(attribute.Int("group", group))
+	r.handlerDuration.Record(ctx, duration.Seconds(), attrs)
+	if err != nil {
+		r.handlerErrors.Add(ctx, 1, attrs)
+	}
+}
+
+func (r *Recorder) Panic(ctx context.Context, group int) {
+	r.panics.Add(ctx, 1, metric.WithAttributes(attribute.Int("group", group)))
+}
+
+func (r *Recorder) QueueDepth(ctx context.Context, depth int) {
+	r.queueDepth.Record(ctx, int64(depth))
+}
+
+func (r *Recorder) Dropped(ctx context.Context, updateType string) {
+	r.dropped.Add(ctx, 1, metric.WithAttributes(attribute.String("type", updateType)))
+}
+
+var _ metrics.Recorder = (*Recorder)(nil)
@@ -0,0 +1,53 @@
+package otelmetrics_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/metrics/otelmetrics"
+	"github.com/gotd/td/tgerr"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRecorder(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	r, err := otelmetrics.New(provider.Meter("gotgproto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	r.RPC(ctx, "messages.sendMessage", time.Millisecond, tgerr.New(420, "FLOOD_WAIT_3"))
+	r.Update(ctx, "updateNewMessage")
+	r.Update(ctx, "updateNewMessage")
+	r.Handler(ctx, 1, time.Millisecond, nil)
+	r.QueueDepth(ctx, 3)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+	if updates, ok := got["gotgproto.updates"].(metricdata.Sum[int64]); !ok || updates.DataPoints[0].Value != 2 {
+		t.Fatalf("unexpected updates %+v", got["gotgproto.updates"])
+	}
+	if errs, ok := got["gotgproto.rpc.errors"].(metricdata.Sum[int64]); !ok || errs.DataPoints[0].Value != 1 {
+		t.Fatalf("unexpected rpc errors %+v", got["gotgproto.rpc.errors"])
+	}
+	if depth, ok := got["gotgproto.dispatcher.queue_depth"].(metricdata.Gauge[int64]); !ok || depth.DataPoints[0].Value != 3 {
+		t.Fatalf("unexpected queue depth %+v", got["gotgproto.dispatcher.queue_depth"])
+	}
+	if durations, ok := got["gotgproto.handler.duration"].(metricdata.Histogram[float64]); !ok || durations.DataPoints[0].Count != 1 {
+		t.Fatalf("unexpected handler durations %+v", got["gotgproto.handler.duration"])
+	}
+	if _, ok := got["gotgproto.handler.errors"]; ok {
+		t.Fatal("expected no handler errors")
+	}
+}
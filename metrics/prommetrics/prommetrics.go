@@ -0,0 +1,161 @@
+// Package prommetrics implements a metrics.Recorder exporting the measurements of gotgproto to Prometheus.
+package prommetrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/celestix/gotgproto/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Opts contains optional parameters for New.
+type Opts struct {
+	// Namespace is the prefix of the names of the metrics.
+	//
+	// If not provided, "gotgproto" will be used.
+	Namespace string
+	// ConstLabels are added to all the metrics, i.e. to tell apart the clients of multiple accounts.
+	ConstLabels prometheus.Labels
+	// Buckets are the buckets of the duration histograms in seconds.
+	//
+	// If not provided, prometheus.DefBuckets will be used.
+	Buckets []float64
+}
+
+// Recorder is a metrics.Recorder which is also a prometheus.Collector, it must be registered
+// to a prometheus.Registerer to export the metrics, i.e. prometheus.MustRegister(recorder).
+type Recorder struct {
+	rpcDuration     *prometheus.HistogramVec
+	rpcErrors       *prometheus.CounterVec
+	updates         *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	handlerErrors   *prometheus.CounterVec
+	panics          *prometheus.CounterVec
+	queueDepth      prometheus.Gauge
+	dropped         *prometheus.CounterVec
+}
+
+// New creates a new Recorder with provided options, opts can be nil.
+func New(opts *Opts) *Recorder {
+	o := Opts{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Namespace == "" {
+		o.Namespace = "gotgproto"
+	}
+	if o.Buckets == nil {
+		o.Buckets = prometheus.DefBuckets
+	}
+	return &Recorder{
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Name:        "rpc_duration_seconds",
+			Help:        "Duration of the requests made to Telegram by method.",
+			ConstLabels: o.ConstLabels,
+			Buckets:     o.Buckets,
+		}, []string{"method"}),
+		rpcErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "rpc_errors_total",
+			Help:        "Number of failed requests made to Telegram by method and error type.",
+			ConstLabels: o.ConstLabels,
+		}, []string{"method", "error"}),
+		updates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "updates_total",
+			Help:        "Number of updates processed by the dispatcher by type.",
+			ConstLabels: o.ConstLabels,
+		}, []string{"type"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Name:        "handler_duration_seconds",
+			Help:        "Duration of the executions of the handlers by group.",
+			ConstLabels: o.ConstLabels,
+			Buckets:     o.Buckets,
+		}, []string{"group"}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "handler_errors_total",
+			Help:        "Number of errors returned by the handlers by group.",
+			ConstLabels: o.ConstLabels,
+		}, []string{"group"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "handler_panics_total",
+			Help:        "Number of panics recovered from the handlers by group.",
+			ConstLabels: o.ConstLabels,
+		}, []string{"group"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   o.Namespace,
+			Name:        "dispatcher_queue_depth",
+			Help:        "Number of updates waiting to be processed by the workers of the dispatcher.",
+			ConstLabels: o.ConstLabels,
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "dropped_updates_total",
+			Help:        "Number of updates dropped because the queue of the dispatcher was full by type.",
+			ConstLabels: o.ConstLabels,
+		}, []string{"type"}),
+	}
+}
+
+func (r *Recorder) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		r.rpcDuration, r.rpcErrors, r.updates, r.handlerDuration,
+		r.handlerErrors, r.panics, r.queueDepth, r.dropped,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (r *Recorder) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range r.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (r *Recorder) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range r.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (r *Recorder) RPC(_ context.Context, method string, duration time.Duration, err error) {
+	r.rpcDuration.WithLabelValues(method).Observe(duration.Seconds())
+	if err != nil {
+		r.rpcErrors.WithLabelValues(method, metrics.ErrorType(err)).Inc()
+	}
+}
+
+func (r *Recorder) Update(_ context.Context, updateType string) {
+	r.updates.WithLabelValues(updateType).Inc()
+}
+
+func (r *Recorder) Handler(_ context.Context, group int, duration time.Duration, err error) {
+	g := strconv.Itoa(group)
+	r.handlerDuration.WithLabelValues(g).Observe(duration.Seconds())
+	if err != nil {
+		r.handlerErrors.WithLabelValues(g).Inc()
+	}
+}
+
+func (r *Recorder) Panic(_ context.Context, group int) {
+	r.panics.WithLabelValues(strconv.Itoa(group)).Inc()
+}
+
+func (r *Recorder) QueueDepth(_ context.Context, depth int) {
+	r.queueDepth.Set(float64(depth))
+}
+
+func (r *Recorder) Dropped(_ context.Context, updateType string) {
+	r.dropped.WithLabelValues(updateType).Inc()
+}
+
+var (
+	_ metrics.Recorder     = (*Recorder)(nil)
+	_ prometheus.Collector = (*Recorder)(nil)
+)
@@ -0,0 +1,49 @@
+package prommetrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/metrics/prommetrics"
+	"github.com/gotd/td/tgerr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecorder(t *testing.T) {
+	r := prommetrics.New(&prommetrics.Opts{ConstLabels: prometheus.Labels{"account": "bot"}})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(r)
+	ctx := context.Background()
+	r.RPC(ctx, "messages.sendMessage", time.Millisecond, nil)
+	r.RPC(ctx, "messages.sendMessage", time.Millisecond, tgerr.New(420, "FLOOD_WAIT_3"))
+	r.Update(ctx, "updateNewMessage")
+	r.Handler(ctx, 1, time.Millisecond, errors.New("failed"))
+	r.Panic(ctx, 1)
+	r.QueueDepth(ctx, 7)
+	r.Dropped(ctx, "updateNewMessage")
+
+	want := `
+# HELP gotgproto_rpc_errors_total Number of failed requests made to Telegram by method and error type.
+# TYPE gotgproto_rpc_errors_total counter
+gotgproto_rpc_errors_total{account="bot",error="FLOOD_WAIT",method="messages.sendMessage"} 1
+# HELP gotgproto_handler_panics_total Number of panics recovered from the handlers by group.
+# TYPE gotgproto_handler_panics_total counter
+gotgproto_handler_panics_total{account="bot",group="1"} 1
+# HELP gotgproto_dispatcher_queue_depth Number of updates waiting to be processed by the workers of the dispatcher.
+# TYPE gotgproto_dispatcher_queue_depth gauge
+gotgproto_dispatcher_queue_depth{account="bot"} 7
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"gotgproto_rpc_errors_total", "gotgproto_handler_panics_total", "gotgproto_dispatcher_queue_depth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(r, "gotgproto_rpc_duration_seconds", "gotgproto_updates_total",
+		"gotgproto_handler_duration_seconds", "gotgproto_handler_errors_total", "gotgproto_dropped_updates_total"); n != 5 {
+		t.Fatalf("expected 5 series, got %d", n)
+	}
+}
@@ -0,0 +1,188 @@
+package parsemode
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/telegram/message/entity"
+	tghtml "github.com/gotd/td/telegram/message/html"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+	xhtml "golang.org/x/net/html"
+)
+
+// UserResolver returns the tg.InputUserClass of a user id, it is used to create mentions of users.
+// Users are mentioned with only their id if it is nil, which is fine for bots but not for user accounts.
+type UserResolver func(id int64) (tg.InputUserClass, error)
+
+// HTML returns a styling.StyledTextOption which formats the provided Telegram-style HTML.
+//
+// Following tags are supported (same as Bot API):
+// <b>, <strong>, <i>, <em>, <u>, <ins>, <s>, <strike>, <del>, <tg-spoiler>, <span class="tg-spoiler">,
+// <code>, <pre>, <pre><code class="language-...">, <pre language="...">, <a href="...">, <a href="tg://user?id=...">,
+// <blockquote>, <blockquote expandable> and <tg-emoji emoji-id="...">.
+func HTML(s string, resolver UserResolver) styling.StyledTextOption {
+	return styling.Custom(func(eb *entity.Builder) error {
+		return tghtml.HTML(strings.NewReader(preLanguages(s)), eb, tghtml.Options{
+			UserResolver: entity.UserResolver(resolver),
+		})
+	})
+}
+
+// preLanguages rewrites the <pre language="..."> tags to <pre><code class="language-...">,
+// which is the only form understood by the HTML parser of gotd.
+func preLanguages(s string) string {
+	if !strings.Contains(strings.ToLower(s), "language=") {
+		return s
+	}
+	var (
+		b strings.Builder
+		// rewritten tells for each open <pre> whether its closing tag needs to close the added <code>.
+		rewritten []bool
+	)
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			return b.String()
+		}
+		// TagName lowercases the raw token in place.
+		raw := string(z.Raw())
+		switch tt {
+		case xhtml.StartTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "pre" {
+				break
+			}
+			var lang string
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "language" {
+					lang = string(val)
+				}
+			}
+			rewritten = append(rewritten, lang != "")
+			if lang != "" {
+				fmt.Fprintf(&b, `<pre><code class="language-%s">`, html.EscapeString(lang))
+				continue
+			}
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) != "pre" || len(rewritten) == 0 {
+				break
+			}
+			last := rewritten[len(rewritten)-1]
+			rewritten = rewritten[:len(rewritten)-1]
+			if last {
+				b.WriteString("</code></pre>")
+				continue
+			}
+		}
+		b.WriteString(raw)
+	}
+}
+
+// ParseHTML parses the provided Telegram-style HTML and returns the plain text with its entities.
+// Check HTML for the list of supported tags.
+func ParseHTML(s string, resolver UserResolver) (string, []tg.MessageEntityClass, error) {
+	eb := entity.Builder{}
+	if err := styling.Perform(&eb, HTML(s, resolver)); err != nil {
+		return "", nil, err
+	}
+	text, entities := eb.Complete()
+	return text, entities, nil
+}
+
+// UnparseHTML renders the provided text and its entities as Telegram-style HTML.
+// It can be used with the Message and Entities fields of a tg.Message.
+func UnparseHTML(text string, entities []tg.MessageEntityClass) string {
+	return unparse(text, entities, htmlUnparser{})
+}
+
+type htmlUnparser struct{}
+
+func (htmlUnparser) supported(e tg.MessageEntityClass) bool {
+	switch e.(type) {
+	case *tg.MessageEntityBold, *tg.MessageEntityItalic, *tg.MessageEntityUnderline, *tg.MessageEntityStrike,
+		*tg.MessageEntitySpoiler, *tg.MessageEntityCode, *tg.MessageEntityPre, *tg.MessageEntityTextURL,
+		*tg.MessageEntityMentionName, *tg.InputMessageEntityMentionName, *tg.MessageEntityCustomEmoji,
+		*tg.MessageEntityBlockquote:
+		return true
+	}
+	return false
+}
+
+func (htmlUnparser) open(e tg.MessageEntityClass) string {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		return "<b>"
+	case *tg.MessageEntityItalic:
+		return "<i>"
+	case *tg.MessageEntityUnderline:
+		return "<u>"
+	case *tg.MessageEntityStrike:
+		return "<s>"
+	case *tg.MessageEntitySpoiler:
+		return "<tg-spoiler>"
+	case *tg.MessageEntityCode:
+		return "<code>"
+	case *tg.MessageEntityPre:
+		if e.Language != "" {
+			return fmt.Sprintf(`<pre><code class="language-%s">`, html.EscapeString(e.Language))
+		}
+		return "<pre>"
+	case *tg.MessageEntityTextURL:
+		return fmt.Sprintf(`<a href="%s">`, html.EscapeString(e.URL))
+	case *tg.MessageEntityMentionName:
+		return fmt.Sprintf(`<a href="tg://user?id=%d">`, e.UserID)
+	case *tg.InputMessageEntityMentionName:
+		if user, ok := e.UserID.(*tg.InputUser); ok {
+			return fmt.Sprintf(`<a href="tg://user?id=%d">`, user.UserID)
+		}
+		return "<a>"
+	case *tg.MessageEntityCustomEmoji:
+		return `<tg-emoji emoji-id="` + strconv.FormatInt(e.DocumentID, 10) + `">`
+	case *tg.MessageEntityBlockquote:
+		if e.Collapsed {
+			return "<blockquote expandable>"
+		}
+		return "<blockquote>"
+	}
+	return ""
+}
+
+func (htmlUnparser) close(e tg.MessageEntityClass) string {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		return "</b>"
+	case *tg.MessageEntityItalic:
+		return "</i>"
+	case *tg.MessageEntityUnderline:
+		return "</u>"
+	case *tg.MessageEntityStrike:
+		return "</s>"
+	case *tg.MessageEntitySpoiler:
+		return "</tg-spoiler>"
+	case *tg.MessageEntityCode:
+		return "</code>"
+	case *tg.MessageEntityPre:
+		if e.Language != "" {
+			return "</code></pre>"
+		}
+		return "</pre>"
+	case *tg.MessageEntityTextURL, *tg.MessageEntityMentionName, *tg.InputMessageEntityMentionName:
+		return "</a>"
+	case *tg.MessageEntityCustomEmoji:
+		return "</tg-emoji>"
+	case *tg.MessageEntityBlockquote:
+		return "</blockquote>"
+	}
+	return ""
+}
+
+func (htmlUnparser) escape(s string, _ []tg.MessageEntityClass) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -25,6 +25,9 @@ var stylingMap = map[string]func(string) styling.StyledTextOption{
 	"plain":   styling.Plain,
 }
 
+// StylizeText converts the provided text with simple markdown markers into styled text options.
+//
+// Deprecated: StylizeText doesn't support nesting, escaping and most of the entities, use MarkdownV2 instead.
 func StylizeText(s string) []styling.StyledTextOption {
 	var a []styling.StyledTextOption
 	var trigger string
@@ -0,0 +1,572 @@
+package parsemode
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// reservedMarkdownV2 contains the characters which must be escaped with a preceding '\' in MarkdownV2.
+const reservedMarkdownV2 = "_*[]()~`>#+-=|{}.!"
+
+// MarkdownV2Error is returned when the provided MarkdownV2 text is malformed.
+type MarkdownV2Error struct {
+	// Offset is the byte offset in the text where the error occurred.
+	Offset int
+	// Reason describes the error.
+	Reason string
+}
+
+func (e *MarkdownV2Error) Error() string {
+	return fmt.Sprintf("can't parse markdown at byte offset %d: %s", e.Offset, e.Reason)
+}
+
+// MarkdownV2 returns a styling.StyledTextOption which formats the provided text written in MarkdownV2.
+//
+// The syntax and escaping rules are the same as the MarkdownV2 parse mode of Bot API:
+// *bold*, _italic_, __underline__, ~strike~, ||spoiler||, `code`, ```language\npre```, [text](url),
+// [mention](tg://user?id=123), ![👍](tg://emoji?id=123), lines starting with > for blockquotes and
+// **> for expandable blockquotes ending with ||.
+func MarkdownV2(s string, resolver UserResolver) styling.StyledTextOption {
+	return styling.Custom(func(eb *entity.Builder) error {
+		return parseMarkdownV2(s, eb, resolver)
+	})
+}
+
+// ParseMarkdownV2 parses the provided MarkdownV2 text and returns the plain text with its entities.
+// A *MarkdownV2Error is returned if the text is malformed.
+func ParseMarkdownV2(s string, resolver UserResolver) (string, []tg.MessageEntityClass, error) {
+	eb := entity.Builder{}
+	if err := parseMarkdownV2(s, &eb, resolver); err != nil {
+		return "", nil, err
+	}
+	text, entities := eb.Complete()
+	return text, entities, nil
+}
+
+type mdEntityType int
+
+const (
+	mdBold mdEntityType = iota
+	mdItalic
+	mdUnderline
+	mdStrike
+	mdSpoiler
+	mdTextURL
+	mdCustomEmoji
+	mdBlockquote
+	mdExpandableBlockquote
+)
+
+var mdEntityNames = map[mdEntityType]string{
+	mdBold:                 "bold",
+	mdItalic:               "italic",
+	mdUnderline:            "underline",
+	mdStrike:               "strikethrough",
+	mdSpoiler:              "spoiler",
+	mdTextURL:              "text URL",
+	mdCustomEmoji:          "custom emoji",
+	mdBlockquote:           "blockquote",
+	mdExpandableBlockquote: "expandable blockquote",
+}
+
+type mdEntity struct {
+	typ    mdEntityType
+	offset int
+	token  entity.Token
+}
+
+type mdParser struct {
+	s        string
+	eb       *entity.Builder
+	resolver UserResolver
+	text     strings.Builder
+	stack    []mdEntity
+}
+
+func parseMarkdownV2(s string, eb *entity.Builder, resolver UserResolver) error {
+	if resolver == nil {
+		resolver = func(id int64) (tg.InputUserClass, error) {
+			return &tg.InputUser{UserID: id}, nil
+		}
+	}
+	p := &mdParser{s: s, eb: eb, resolver: resolver}
+	return p.parse()
+}
+
+func (p *mdParser) errorf(offset int, format string, args ...any) error {
+	return &MarkdownV2Error{Offset: offset, Reason: fmt.Sprintf(format, args...)}
+}
+
+// flush writes the pending plain text to the builder.
+func (p *mdParser) flush() {
+	if p.text.Len() > 0 {
+		p.eb.Plain(p.text.String())
+		p.text.Reset()
+	}
+}
+
+func (p *mdParser) open(typ mdEntityType, offset int) error {
+	for _, e := range p.stack {
+		if e.typ == typ || (isBlockquote(e.typ) && isBlockquote(typ)) {
+			return p.errorf(offset, "%s entity can't be nested in another %s entity", mdEntityNames[typ], mdEntityNames[e.typ])
+		}
+	}
+	p.flush()
+	p.stack = append(p.stack, mdEntity{typ: typ, offset: offset, token: p.eb.Token()})
+	return nil
+}
+
+func (p *mdParser) top() (mdEntity, bool) {
+	if len(p.stack) == 0 {
+		return mdEntity{}, false
+	}
+	return p.stack[len(p.stack)-1], true
+}
+
+func (p *mdParser) isOpen(typ mdEntityType) bool {
+	for _, e := range p.stack {
+		if e.typ == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// close ends the innermost entity, which must be of the provided type.
+func (p *mdParser) close(typ mdEntityType, offset int, formats ...entity.Formatter) error {
+	e, ok := p.top()
+	if !ok {
+		return p.errorf(offset, "can't find start of %s entity", mdEntityNames[typ])
+	}
+	if e.typ != typ {
+		return p.errorf(offset, "can't close %s entity before the %s entity opened at byte offset %d", mdEntityNames[typ], mdEntityNames[e.typ], e.offset)
+	}
+	p.flush()
+	p.stack = p.stack[:len(p.stack)-1]
+	if e.token.UTF16Length(p.eb) > 0 && len(formats) > 0 {
+		e.token.Apply(p.eb, formats...)
+	}
+	return nil
+}
+
+// toggle opens the entity of the provided type or closes it if it is already open.
+func (p *mdParser) toggle(typ mdEntityType, offset int, format entity.Formatter) error {
+	if p.isOpen(typ) {
+		return p.close(typ, offset, format)
+	}
+	return p.open(typ, offset)
+}
+
+func (p *mdParser) parse() error {
+	s := p.s
+	for i := 0; i < len(s); {
+		if i == 0 || s[i-1] == '\n' {
+			n, err := p.lineStart(i)
+			if err != nil {
+				return err
+			}
+			i += n
+			if i >= len(s) {
+				break
+			}
+		}
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(s) || s[i+1] == 0 || s[i+1] > 126 {
+				return p.errorf(i, "character '\\' must be followed by an ASCII character to escape")
+			}
+			p.text.WriteByte(s[i+1])
+			i += 2
+		case c == '\r':
+			// Carriage returns are removed by Telegram, they can be used to separate italic and underline markers.
+			i++
+		case c == '\n':
+			if e, ok := p.top(); ok && isBlockquote(e.typ) && !strings.HasPrefix(s[i+1:], ">") {
+				if err := p.close(e.typ, i, entity.Blockquote(e.typ == mdExpandableBlockquote)); err != nil {
+					return err
+				}
+			}
+			p.text.WriteByte(c)
+			i++
+		case c == '*':
+			if err := p.toggle(mdBold, i, entity.Bold()); err != nil {
+				return err
+			}
+			i++
+		case c == '_':
+			if strings.HasPrefix(s[i:], "__") {
+				if err := p.toggle(mdUnderline, i, entity.Underline()); err != nil {
+					return err
+				}
+				i += 2
+				break
+			}
+			if err := p.toggle(mdItalic, i, entity.Italic()); err != nil {
+				return err
+			}
+			i++
+		case c == '~':
+			if err := p.toggle(mdStrike, i, entity.Strike()); err != nil {
+				return err
+			}
+			i++
+		case c == '|':
+			if !strings.HasPrefix(s[i:], "||") {
+				return p.errorf(i, "character '|' is reserved and must be escaped with the preceding '\\'")
+			}
+			if e, ok := p.top(); ok && e.typ == mdExpandableBlockquote && (i+2 == len(s) || s[i+2] == '\n') {
+				if err := p.close(mdExpandableBlockquote, i, entity.Blockquote(true)); err != nil {
+					return err
+				}
+				i += 2
+				break
+			}
+			if err := p.toggle(mdSpoiler, i, entity.Spoiler()); err != nil {
+				return err
+			}
+			i += 2
+		case c == '`':
+			n, err := p.code(i)
+			if err != nil {
+				return err
+			}
+			i += n
+		case c == '[':
+			if err := p.open(mdTextURL, i); err != nil {
+				return err
+			}
+			i++
+		case c == '!' && strings.HasPrefix(s[i:], "!["):
+			if err := p.open(mdCustomEmoji, i); err != nil {
+				return err
+			}
+			i += 2
+		case c == ']':
+			n, err := p.link(i)
+			if err != nil {
+				return err
+			}
+			i += n
+		case strings.IndexByte(reservedMarkdownV2, c) != -1:
+			return p.errorf(i, "character '%c' is reserved and must be escaped with the preceding '\\'", c)
+		default:
+			_, size := utf8.DecodeRuneInString(s[i:])
+			p.text.WriteString(s[i : i+size])
+			i += size
+		}
+	}
+	if e, ok := p.top(); ok && isBlockquote(e.typ) {
+		if err := p.close(e.typ, len(s), entity.Blockquote(e.typ == mdExpandableBlockquote)); err != nil {
+			return err
+		}
+	}
+	if e, ok := p.top(); ok {
+		return p.errorf(e.offset, "can't find end of %s entity", mdEntityNames[e.typ])
+	}
+	p.flush()
+	return nil
+}
+
+// lineStart handles the blockquote markers at the start of the line at offset i and returns their length.
+func (p *mdParser) lineStart(i int) (int, error) {
+	s := p.s[i:]
+	switch {
+	case strings.HasPrefix(s, "**>"):
+		if p.isOpen(mdBlockquote) || p.isOpen(mdExpandableBlockquote) {
+			return 3, nil
+		}
+		return 3, p.open(mdExpandableBlockquote, i)
+	case strings.HasPrefix(s, ">"):
+		if p.isOpen(mdBlockquote) || p.isOpen(mdExpandableBlockquote) {
+			return 1, nil
+		}
+		return 1, p.open(mdBlockquote, i)
+	}
+	return 0, nil
+}
+
+// code parses the code or pre entity at offset i and returns its length.
+func (p *mdParser) code(i int) (int, error) {
+	s := p.s
+	start := i
+	pre := strings.HasPrefix(s[i:], "```")
+	var language string
+	if pre {
+		i += 3
+		// The language is specified on the same line as the opening marker,
+		// the newline ending that line is never part of the code.
+		if end := strings.IndexAny(s[i:], " \t\n`"); end >= 0 && s[i+end] == '\n' {
+			language = s[i : i+end]
+			i += end + 1
+		}
+	} else {
+		i++
+	}
+	var b strings.Builder
+	for {
+		if i >= len(s) {
+			if pre {
+				return 0, p.errorf(start, "can't find end of pre entity")
+			}
+			return 0, p.errorf(start, "can't find end of code entity")
+		}
+		c := s[i]
+		if c == '\\' {
+			if i+1 >= len(s) || s[i+1] == 0 || s[i+1] > 126 {
+				return 0, p.errorf(i, "character '\\' must be followed by an ASCII character to escape")
+			}
+			b.WriteByte(s[i+1])
+			i += 2
+			continue
+		}
+		if c == '`' {
+			if !pre {
+				i++
+				break
+			}
+			if strings.HasPrefix(s[i:], "```") {
+				i += 3
+				break
+			}
+			return 0, p.errorf(i, "character '`' must be escaped with the preceding '\\' inside pre entity")
+		}
+		b.WriteByte(c)
+		i++
+	}
+	p.flush()
+	if pre {
+		p.eb.Format(b.String(), entity.Pre(language))
+	} else {
+		p.eb.Format(b.String(), entity.Code())
+	}
+	return i - start, nil
+}
+
+// link closes the text URL or custom emoji entity ended at offset i and returns the length of its ending.
+func (p *mdParser) link(i int) (int, error) {
+	s := p.s
+	e, ok := p.top()
+	if !ok || (e.typ != mdTextURL && e.typ != mdCustomEmoji) {
+		return 0, p.errorf(i, "character ']' is reserved and must be escaped with the preceding '\\'")
+	}
+	start := i
+	i++
+	var (
+		u       strings.Builder
+		withURL bool
+	)
+	if strings.HasPrefix(s[i:], "(") {
+		withURL = true
+		i++
+		for {
+			if i >= len(s) {
+				return 0, p.errorf(start+1, "can't find end of URL")
+			}
+			c := s[i]
+			if c == ')' {
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(s) && s[i+1] > 0 && s[i+1] <= 126 {
+				u.WriteByte(s[i+1])
+				i += 2
+				continue
+			}
+			u.WriteByte(c)
+			i++
+		}
+	}
+	if e.typ == mdCustomEmoji {
+		id, ok := customEmojiID(u.String())
+		if !withURL || !ok {
+			return 0, p.errorf(start, "custom emoji entity must have a URL in format tg://emoji?id=<id>")
+		}
+		return i - start, p.close(mdCustomEmoji, start, entity.CustomEmoji(id))
+	}
+	link := u.String()
+	if !withURL {
+		// The text of a link without URL is used as its URL.
+		p.flush()
+		link = e.token.Text(p.eb)
+	}
+	format, err := p.urlFormatter(link)
+	if err != nil {
+		return 0, p.errorf(start, "%s", err)
+	}
+	if format == nil {
+		return i - start, p.close(mdTextURL, start)
+	}
+	return i - start, p.close(mdTextURL, start, format)
+}
+
+// urlFormatter returns the formatter of the provided link, nil if the link is empty.
+func (p *mdParser) urlFormatter(link string) (entity.Formatter, error) {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return entity.TextURL(link), nil
+	}
+	if u.Scheme == "tg" && u.Host == "user" {
+		id, err := strconv.ParseInt(u.Query().Get("id"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user id in URL %q", link)
+		}
+		user, err := p.resolver(id)
+		if err != nil {
+			return nil, fmt.Errorf("can't resolve user %d: %w", id, err)
+		}
+		return entity.MentionName(user), nil
+	}
+	return entity.TextURL(link), nil
+}
+
+func customEmojiID(link string) (int64, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "tg" || u.Host != "emoji" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(u.Query().Get("id"), 10, 64)
+	return id, err == nil
+}
+
+func isBlockquote(typ mdEntityType) bool {
+	return typ == mdBlockquote || typ == mdExpandableBlockquote
+}
+
+// UnparseMarkdownV2 renders the provided text and its entities as MarkdownV2, escaping all the reserved characters.
+// It can be used with the Message and Entities fields of a tg.Message.
+func UnparseMarkdownV2(text string, entities []tg.MessageEntityClass) string {
+	s, markups := unparseMarkup(text, entities, markdownV2Unparser{})
+	// A carriage return is added after the italic markers followed by another underscore marker,
+	// since "___" is always read as underline followed by italic. Escaped text never starts with an underscore.
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, m := range markups {
+		if _, ok := m.entity.(*tg.MessageEntityItalic); !ok || m.end >= len(s) || s[m.end] != '_' {
+			continue
+		}
+		b.WriteString(s[last:m.end])
+		b.WriteByte('\r')
+		last = m.end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+type markdownV2Unparser struct{}
+
+func (markdownV2Unparser) supported(e tg.MessageEntityClass) bool {
+	switch e.(type) {
+	case *tg.MessageEntityBold, *tg.MessageEntityItalic, *tg.MessageEntityUnderline, *tg.MessageEntityStrike,
+		*tg.MessageEntitySpoiler, *tg.MessageEntityCode, *tg.MessageEntityPre, *tg.MessageEntityTextURL,
+		*tg.MessageEntityMentionName, *tg.InputMessageEntityMentionName, *tg.MessageEntityCustomEmoji,
+		*tg.MessageEntityBlockquote:
+		return true
+	}
+	return false
+}
+
+func (markdownV2Unparser) open(e tg.MessageEntityClass) string {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		return "*"
+	case *tg.MessageEntityItalic:
+		return "_"
+	case *tg.MessageEntityUnderline:
+		return "__"
+	case *tg.MessageEntityStrike:
+		return "~"
+	case *tg.MessageEntitySpoiler:
+		return "||"
+	case *tg.MessageEntityCode:
+		return "`"
+	case *tg.MessageEntityPre:
+		return "```" + e.Language + "\n"
+	case *tg.MessageEntityTextURL, *tg.MessageEntityMentionName, *tg.InputMessageEntityMentionName:
+		return "["
+	case *tg.MessageEntityCustomEmoji:
+		return "!["
+	case *tg.MessageEntityBlockquote:
+		if e.Collapsed {
+			return "**>"
+		}
+		return ">"
+	}
+	return ""
+}
+
+func (markdownV2Unparser) close(e tg.MessageEntityClass) string {
+	escapeURL := strings.NewReplacer(`\`, `\\`, ")", `\)`).Replace
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		return "*"
+	case *tg.MessageEntityItalic:
+		return "_"
+	case *tg.MessageEntityUnderline:
+		return "__"
+	case *tg.MessageEntityStrike:
+		return "~"
+	case *tg.MessageEntitySpoiler:
+		return "||"
+	case *tg.MessageEntityCode:
+		return "`"
+	case *tg.MessageEntityPre:
+		return "```"
+	case *tg.MessageEntityTextURL:
+		return "](" + escapeURL(e.URL) + ")"
+	case *tg.MessageEntityMentionName:
+		return "](tg://user?id=" + strconv.FormatInt(e.UserID, 10) + ")"
+	case *tg.InputMessageEntityMentionName:
+		if user, ok := e.UserID.(*tg.InputUser); ok {
+			return "](tg://user?id=" + strconv.FormatInt(user.UserID, 10) + ")"
+		}
+		return "]"
+	case *tg.MessageEntityCustomEmoji:
+		return "](tg://emoji?id=" + strconv.FormatInt(e.DocumentID, 10) + ")"
+	case *tg.MessageEntityBlockquote:
+		if e.Collapsed {
+			return "||"
+		}
+		return ""
+	}
+	return ""
+}
+
+func (markdownV2Unparser) escape(s string, stack []tg.MessageEntityClass) string {
+	var (
+		quote bool
+		b     strings.Builder
+	)
+	for _, e := range stack {
+		switch e.(type) {
+		case *tg.MessageEntityCode, *tg.MessageEntityPre:
+			return strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(s)
+		case *tg.MessageEntityBlockquote:
+			quote = true
+		}
+	}
+	for _, c := range s {
+		if strings.ContainsRune(reservedMarkdownV2, c) || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+		// Every line of a blockquote must start with the quote marker.
+		if c == '\n' && quote {
+			b.WriteByte('>')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,221 @@
+package parsemode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestParseMarkdownV2(t *testing.T) {
+	tests := []struct {
+		input    string
+		text     string
+		entities []tg.MessageEntityClass
+	}{
+		{
+			input: "*bold _italic_* plain\\.",
+			text:  "bold italic plain.",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 11},
+				&tg.MessageEntityItalic{Offset: 5, Length: 6},
+			},
+		},
+		{
+			input:    "__under__ ~strike~ ||spoiler||",
+			text:     "under strike spoiler",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityUnderline{Offset: 0, Length: 5}, &tg.MessageEntityStrike{Offset: 6, Length: 6}, &tg.MessageEntitySpoiler{Offset: 13, Length: 7}},
+		},
+		{
+			input:    "😀 *x*",
+			text:     "😀 x",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 3, Length: 1}},
+		},
+		{
+			input:    "[link](https://example.com/a\\)b) `co\\`de`",
+			text:     "link co`de",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://example.com/a)b"}, &tg.MessageEntityCode{Offset: 5, Length: 5}},
+		},
+		{
+			input:    "```go\nfmt.Println()```",
+			text:     "fmt.Println()",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 13, Language: "go"}},
+		},
+		{
+			input:    "```\ncode```",
+			text:     "code",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 4}},
+		},
+		{
+			input:    "[Bob](tg://user?id=42) ![👍](tg://emoji?id=5)",
+			text:     "Bob 👍",
+			entities: []tg.MessageEntityClass{&tg.InputMessageEntityMentionName{Offset: 0, Length: 3, UserID: &tg.InputUser{UserID: 42}}, &tg.MessageEntityCustomEmoji{Offset: 4, Length: 2, DocumentID: 5}},
+		},
+		{
+			input:    ">quote\n>line\nplain",
+			text:     "quote\nline\nplain",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBlockquote{Offset: 0, Length: 10}},
+		},
+		{
+			input:    "**>hidden\n>quote||\nplain",
+			text:     "hidden\nquote\nplain",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBlockquote{Collapsed: true, Offset: 0, Length: 12}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			text, entities, err := ParseMarkdownV2(tt.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.text {
+				t.Fatalf("got text %q, want %q", text, tt.text)
+			}
+			assertEntities(t, entities, tt.entities)
+		})
+	}
+}
+
+func TestParseMarkdownV2Errors(t *testing.T) {
+	tests := []struct {
+		input  string
+		offset int
+	}{
+		{input: "end.", offset: 3},
+		{input: "*unclosed", offset: 0},
+		{input: "*a _b* c_", offset: 5},
+		{input: "`code", offset: 0},
+		{input: "a | b", offset: 2},
+		{input: "trailing \\", offset: 9},
+		{input: "![x](https://example.com)", offset: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, _, err := ParseMarkdownV2(tt.input, nil)
+			var mdErr *MarkdownV2Error
+			if !errors.As(err, &mdErr) {
+				t.Fatalf("expected a MarkdownV2Error, got %v", err)
+			}
+			if mdErr.Offset != tt.offset {
+				t.Fatalf("got error %q, want offset %d", err, tt.offset)
+			}
+		})
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+	text, entities, err := ParseHTML(`<b>bold <i>both</i></b> <a href="tg://user?id=42">Bob</a> <pre><code class="language-go">x</code></pre>`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "bold both Bob x" {
+		t.Fatalf("unexpected text %q", text)
+	}
+	assertEntities(t, entities, []tg.MessageEntityClass{
+		&tg.MessageEntityBold{Offset: 0, Length: 9},
+		&tg.MessageEntityItalic{Offset: 5, Length: 4},
+		&tg.InputMessageEntityMentionName{Offset: 10, Length: 3, UserID: &tg.InputUser{UserID: 42}},
+		&tg.MessageEntityPre{Offset: 14, Length: 1, Language: "go"},
+	})
+}
+
+func TestParseHTMLPreLanguage(t *testing.T) {
+	tests := []struct {
+		html     string
+		text     string
+		language string
+	}{
+		{html: `<pre language="go">x := 1</pre>`, text: "x := 1", language: "go"},
+		{html: `<PRE LANGUAGE="c++">a &lt; b</PRE> tail`, text: "a < b tail", language: "c++"},
+		{html: `<pre language="">x</pre>`, text: "x"},
+		{html: `<pre>x</pre>`, text: "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.html, func(t *testing.T) {
+			text, entities, err := ParseHTML(tt.html, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.text {
+				t.Fatalf("unexpected text %q", text)
+			}
+			if len(entities) != 1 {
+				t.Fatalf("unexpected entities %v", entities)
+			}
+			if pre, ok := entities[0].(*tg.MessageEntityPre); !ok || pre.Language != tt.language {
+				t.Fatalf("unexpected entity %v", entities[0])
+			}
+		})
+	}
+}
+
+func TestUnparse(t *testing.T) {
+	text := "bold 😀 <tag> x_y"
+	entities := []tg.MessageEntityClass{
+		&tg.MessageEntityBold{Offset: 0, Length: 7},
+		&tg.MessageEntityItalic{Offset: 5, Length: 8},
+		&tg.MessageEntityTextURL{Offset: 14, Length: 3, URL: "https://example.com"},
+	}
+	if got, want := UnparseHTML(text, entities), `<b>bold <i>😀</i></b><i> &lt;tag&gt;</i> <a href="https://example.com">x_y</a>`; got != want {
+		t.Fatalf("got html %q, want %q", got, want)
+	}
+	md := UnparseMarkdownV2(text, entities)
+	if want := "*bold _😀_*_ <tag\\>_ [x\\_y](https://example.com)"; md != want {
+		t.Fatalf("got markdown %q, want %q", md, want)
+	}
+	// Unparsed text must be valid MarkdownV2.
+	for _, s := range []string{md, UnparseMarkdownV2("under italic", []tg.MessageEntityClass{
+		&tg.MessageEntityItalic{Offset: 0, Length: 12},
+		&tg.MessageEntityUnderline{Offset: 0, Length: 5},
+	})} {
+		if _, _, err := ParseMarkdownV2(s, nil); err != nil {
+			t.Fatalf("can't parse unparsed markdown %q: %v", s, err)
+		}
+	}
+}
+
+func TestUnparseMarkdownV2RoundTrip(t *testing.T) {
+	tests := []struct {
+		text     string
+		entities []tg.MessageEntityClass
+	}{
+		{text: "code", entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 4}}},
+		{text: "fmt.Println()", entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 13, Language: "go"}}},
+		{text: "under italic", entities: []tg.MessageEntityClass{
+			&tg.MessageEntityItalic{Offset: 0, Length: 12},
+			&tg.MessageEntityUnderline{Offset: 0, Length: 5},
+		}},
+		{text: "a\x00b c", entities: []tg.MessageEntityClass{&tg.MessageEntityItalic{Offset: 0, Length: 3}}},
+	}
+	for _, tt := range tests {
+		md := UnparseMarkdownV2(tt.text, tt.entities)
+		text, entities, err := ParseMarkdownV2(md, nil)
+		if err != nil {
+			t.Fatalf("can't parse unparsed markdown %q: %v", md, err)
+		}
+		if text != tt.text {
+			t.Fatalf("got text %q from %q, want %q", text, md, tt.text)
+		}
+		assertEntities(t, entities, tt.entities)
+	}
+}
+
+func assertEntities(t *testing.T, got, want []tg.MessageEntityClass) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got entities %v, want %v", got, want)
+	}
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			if reflect.DeepEqual(g, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("entity %v not found in %v", w, got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package parsemode
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+)
+
+// unparser renders message entities in a particular markup.
+type unparser interface {
+	// open returns the markup which starts the entity.
+	open(e tg.MessageEntityClass) string
+	// close returns the markup which ends the entity.
+	close(e tg.MessageEntityClass) string
+	// escape escapes the text placed inside the provided stack of entities.
+	escape(s string, stack []tg.MessageEntityClass) string
+	// supported reports whether the entity can be represented by the markup.
+	supported(e tg.MessageEntityClass) bool
+}
+
+// unparse renders the text with its entities using the provided unparser.
+// Entities which overlap without being nested are closed and reopened around each other.
+func unparse(text string, entities []tg.MessageEntityClass, u unparser) string {
+	s, _ := unparseMarkup(text, entities, u)
+	return s
+}
+
+// markup is the opening or closing markup of an entity written by unparseMarkup.
+type markup struct {
+	entity tg.MessageEntityClass
+	// end is the byte offset in the rendered text right after the markup.
+	end int
+}
+
+// unparseMarkup is unparse which also returns all the markup it has written, in the order it was written.
+func unparseMarkup(text string, entities []tg.MessageEntityClass, u unparser) (string, []markup) {
+	units := utf16.Encode([]rune(text))
+	sorted := make([]tg.MessageEntityClass, 0, len(entities))
+	for _, e := range entities {
+		if e.GetLength() <= 0 || e.GetOffset() < 0 || e.GetOffset() >= len(units) || !u.supported(e) {
+			continue
+		}
+		sorted = append(sorted, e)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].GetOffset() != sorted[j].GetOffset() {
+			return sorted[i].GetOffset() < sorted[j].GetOffset()
+		}
+		return sorted[i].GetLength() > sorted[j].GetLength()
+	})
+	end := func(e tg.MessageEntityClass) int {
+		if n := e.GetOffset() + e.GetLength(); n < len(units) {
+			return n
+		}
+		return len(units)
+	}
+
+	boundaries := []int{0, len(units)}
+	for _, e := range sorted {
+		boundaries = append(boundaries, e.GetOffset(), end(e))
+	}
+	sort.Ints(boundaries)
+	uniq := boundaries[:1]
+	for _, pos := range boundaries[1:] {
+		if pos != uniq[len(uniq)-1] {
+			uniq = append(uniq, pos)
+		}
+	}
+	boundaries = uniq
+
+	var (
+		b       strings.Builder
+		stack   []tg.MessageEntityClass
+		next    int
+		markups []markup
+	)
+	write := func(e tg.MessageEntityClass, s string) {
+		b.WriteString(s)
+		markups = append(markups, markup{entity: e, end: b.Len()})
+	}
+	for i, pos := range boundaries {
+		// Close the entities ending here, reopening the ones which were opened after them but end later.
+		var reopen []tg.MessageEntityClass
+		for hasEnding(stack, pos, end) {
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			write(e, u.close(e))
+			if end(e) != pos {
+				reopen = append(reopen, e)
+			}
+		}
+		for j := len(reopen) - 1; j >= 0; j-- {
+			write(reopen[j], u.open(reopen[j]))
+			stack = append(stack, reopen[j])
+		}
+		for ; next < len(sorted) && sorted[next].GetOffset() == pos; next++ {
+			write(sorted[next], u.open(sorted[next]))
+			stack = append(stack, sorted[next])
+		}
+		if i+1 < len(boundaries) {
+			b.WriteString(u.escape(string(utf16.Decode(units[pos:boundaries[i+1]])), stack))
+		}
+	}
+	return b.String(), markups
+}
+
+func hasEnding(stack []tg.MessageEntityClass, pos int, end func(tg.MessageEntityClass) int) bool {
+	for _, e := range stack {
+		if end(e) == pos {
+			return true
+		}
+	}
+	return false
+}
@@ -25,6 +25,12 @@ type sessionNameDialector struct {
 
 func (sessionNameDialector) getType() string { return "dialector" }
 
+type sessionNameStore struct {
+	store storage.Store
+}
+
+func (sessionNameStore) getType() string { return "store" }
+
 type SessionConstructor interface {
 	loadSession() (sessionName, []byte, error)
 }
@@ -52,6 +58,21 @@ func (s *SqlSessionConstructor) loadSession() (sessionName, []byte, error) {
 	return &sessionNameDialector{s.dialector}, nil, nil
 }
 
+// CustomSessionConstructor uses a custom storage.Store to save the session and peers.
+type CustomSessionConstructor struct {
+	store storage.Store
+}
+
+// CustomSession creates a session constructor backed by the provided store,
+// e.g. a shared key-value store of multiple replicas or storage.NewFileStore.
+func CustomSession(store storage.Store) *CustomSessionConstructor {
+	return &CustomSessionConstructor{store: store}
+}
+
+func (s *CustomSessionConstructor) loadSession() (sessionName, []byte, error) {
+	return &sessionNameStore{s.store}, nil, nil
+}
+
 type PyrogramSessionConstructor struct {
 	name, value string
 }
@@ -16,7 +16,17 @@ func NewSessionStorage(ctx context.Context, sessionType SessionConstructor, inMe
 		return nil, nil, err
 	}
 	if sessDialect, ok := name.(*sessionNameDialector); ok {
-		peerStorage := storage.NewPeerStorage(sessDialect.dialector, false)
+		peerStorage, err := storage.NewPeerStorage(sessDialect.dialector, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		return peerStorage, &SessionStorage{
+			data:        peerStorage.GetSession().Data,
+			peerStorage: peerStorage,
+		}, nil
+	}
+	if sessStore, ok := name.(*sessionNameStore); ok {
+		peerStorage := storage.NewPeerStorageWithStore(sessStore.store)
 		return peerStorage, &SessionStorage{
 			data:        peerStorage.GetSession().Data,
 			peerStorage: peerStorage,
@@ -25,7 +35,10 @@ func NewSessionStorage(ctx context.Context, sessionType SessionConstructor, inMe
 	if name.(sessionNameString) == "" {
 		name = sessionNameString("gotgproto")
 	}
-	peerStorage := storage.NewPeerStorage(sqlite.Open(fmt.Sprintf("%s.session", name)), inMemory)
+	peerStorage, err := storage.NewPeerStorage(sqlite.Open(fmt.Sprintf("%s.session", name)), inMemory)
+	if err != nil {
+		return nil, nil, err
+	}
 	if inMemory {
 		s := session.StorageMemory{}
 		err := s.StoreSession(ctx, data)
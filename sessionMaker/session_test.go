@@ -0,0 +1,97 @@
+package sessionMaker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/storage"
+)
+
+func testKey() []byte {
+	key := make([]byte, 256)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func decodeJSON(t *testing.T, data []byte) jsonData {
+	t.Helper()
+	var d jsonData
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestPyrogramSession(t *testing.T) {
+	// '>BI?256sQ?': dc id, app id, test mode, auth key, user id, is bot.
+	var b bytes.Buffer
+	b.WriteByte(2)
+	_ = binary.Write(&b, binary.BigEndian, uint32(12345))
+	b.WriteByte(1)
+	b.Write(testKey())
+	_ = binary.Write(&b, binary.BigEndian, uint64(777))
+	b.WriteByte(0)
+	value := base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(b.Bytes())
+
+	_, data, err := PyrogramSession(value).Name("pyro").loadSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := decodeJSON(t, data)
+	if d.Version != storage.LatestVersion || d.Data.DC != 2 || !d.Data.Config.TestMode {
+		t.Fatalf("unexpected session %+v", d)
+	}
+	if !bytes.Equal(d.Data.AuthKey, testKey()) || len(d.Data.AuthKeyID) != 8 {
+		t.Fatalf("unexpected auth key %x (id %x)", d.Data.AuthKey, d.Data.AuthKeyID)
+	}
+}
+
+func TestPyrogramSessionInvalid(t *testing.T) {
+	for _, value := range []string{"", "c2hvcnQ"} {
+		if _, err := DecodePyrogramSession(value); err == nil {
+			t.Fatalf("expected an error decoding %q", value)
+		}
+	}
+}
+
+func TestTelethonSession(t *testing.T) {
+	// Version prefix followed by dc id, IPv4 address, port and auth key.
+	var b bytes.Buffer
+	b.WriteByte(4)
+	b.Write([]byte{149, 154, 167, 91})
+	_ = binary.Write(&b, binary.BigEndian, uint16(443))
+	b.Write(testKey())
+	value := "1" + base64.URLEncoding.EncodeToString(b.Bytes())
+
+	_, data, err := TelethonSession(value).loadSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := decodeJSON(t, data)
+	if d.Data.DC != 4 || d.Data.Addr != "149.154.167.91:443" || !bytes.Equal(d.Data.AuthKey, testKey()) {
+		t.Fatalf("unexpected session %+v", d.Data)
+	}
+}
+
+func TestStringSession(t *testing.T) {
+	value, err := functions.EncodeSessionToString(&storage.Session{Version: storage.LatestVersion, Data: []byte(`{"Version":1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, data, err := StringSession(value).Name("str").loadSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name.(sessionNameString) != "str" || string(data) != `{"Version":1}` {
+		t.Fatalf("unexpected session %q: %s", name, data)
+	}
+	if _, _, err := StringSession("not a session").loadSession(); err == nil {
+		t.Fatal("expected an error decoding an invalid string session")
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ConversationState is the database model of a conversation state saved by a conversation storage.
+type ConversationState struct {
+	Key       string `gorm:"primary_key"`
+	State     string
+	UpdatedAt time.Time
+}
+
+var errInMemoryConversation = errors.New("conversation states can only be saved in a peer storage backed by a GormStore")
+
+// SetConversationState saves the provided conversation state in the database.
+func (p *PeerStorage) SetConversationState(state *ConversationState) error {
+	if p.SqlSession == nil {
+		return errInMemoryConversation
+	}
+	return p.conversations().Save(state).Error
+}
+
+// GetConversationState finds the conversation state of the provided key in the database.
+// Returned state has an empty Key if it was not found.
+func (p *PeerStorage) GetConversationState(key string) (*ConversationState, error) {
+	if p.SqlSession == nil {
+		return nil, errInMemoryConversation
+	}
+	state := ConversationState{}
+	return &state, p.conversations().Where(&ConversationState{Key: key}).Find(&state).Error
+}
+
+// DeleteConversationState removes the conversation state of the provided key from the database.
+func (p *PeerStorage) DeleteConversationState(key string) error {
+	if p.SqlSession == nil {
+		return errInMemoryConversation
+	}
+	return p.conversations().Delete(&ConversationState{Key: key}).Error
+}
+
+// conversations returns a session of the database using the conversation states table of the account of the storage.
+func (p *PeerStorage) conversations() *gorm.DB {
+	if p.tablePrefix == "" {
+		return p.SqlSession
+	}
+	return p.SqlSession.Table(p.tablePrefix + "conversation_states")
+}
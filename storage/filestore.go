@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileStore is an embedded key-value Store which keeps its data in memory
+// and persists every change to an append-only log file.
+// The log is compacted when it is opened and whenever it grows too large.
+//
+// FileStore only persists peers and the session, see Store for the data kept by a GormStore alone.
+type FileStore struct {
+	mem     *MemoryStore
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	records int
+}
+
+type fileRecord struct {
+	Peer    *Peer    `json:"peer,omitempty"`
+	Session *Session `json:"session,omitempty"`
+}
+
+// NewFileStore opens the FileStore at the provided path, creating it if it doesn't exist.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{mem: NewMemoryStore(), path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A last line without a newline was cut off by a crash while writing it.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var rec fileRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		s.apply(&rec)
+	}
+}
+
+func (s *FileStore) apply(rec *fileRecord) {
+	if rec.Peer != nil {
+		s.mem.savePeer(*rec.Peer)
+	}
+	if rec.Session != nil {
+		s.mem.session = rec.Session
+	}
+}
+
+// compact rewrites the log with only the latest record of every key.
+func (s *FileStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	records := 0
+	for _, peer := range s.mem.peers {
+		peer := peer
+		if err = enc.Encode(fileRecord{Peer: &peer}); err != nil {
+			break
+		}
+		records++
+	}
+	if err == nil && s.mem.session != nil {
+		err = enc.Encode(fileRecord{Session: s.mem.session})
+		records++
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	s.records = records
+	return err
+}
+
+func (s *FileStore) write(rec *fileRecord, sync bool) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.mem.lock.Lock()
+	s.apply(rec)
+	s.records++
+	s.mem.lock.Unlock()
+	if s.records > 1024 && s.records > 2*len(s.mem.peers) {
+		s.mem.lock.RLock()
+		defer s.mem.lock.RUnlock()
+		return s.compact()
+	}
+	if sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+func (s *FileStore) SavePeer(peer *Peer) error {
+	p := *peer
+	return s.write(&fileRecord{Peer: &p}, false)
+}
+
+func (s *FileStore) GetPeerByID(id PeerID) (*Peer, error) {
+	return s.mem.GetPeerByID(id)
+}
+
+func (s *FileStore) GetPeerByUsername(username string) (*Peer, error) {
+	return s.mem.GetPeerByUsername(username)
+}
+
+func (s *FileStore) GetPeerByPhone(phone string) (*Peer, error) {
+	return s.mem.GetPeerByPhone(phone)
+}
+
+func (s *FileStore) SaveSession(session *Session) error {
+	return s.write(&fileRecord{Session: copySession(session)}, true)
+}
+
+func (s *FileStore) GetSession() (*Session, error) {
+	return s.mem.GetSession()
+}
+
+// Close flushes the log to the disk and closes it.
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// maxPeerContexts is the number of peer contexts after which the oldest ones may be dropped.
+const maxPeerContexts = 10000
+
+// PeerContext is a message a peer was seen in, through which the peer can be used without its access hash,
+// i.e. with tg.InputPeerUserFromMessage or tg.InputPeerChannelFromMessage.
+type PeerContext struct {
+	// Chat is the chat of the message.
+	Chat PeerID
+	// MsgID is the ID of the message.
+	MsgID int
+}
+
+// SavePeerContext saves the message the provided user or channel was last seen in.
+// Peer contexts are only kept in memory.
+func (p *PeerStorage) SavePeerContext(peer PeerID, c PeerContext) {
+	p.contextLock.Lock()
+	defer p.contextLock.Unlock()
+	if _, ok := p.contexts[peer]; !ok && len(p.contexts) >= maxPeerContexts {
+		// Maps are iterated in random order, which is good enough to evict contexts.
+		for id := range p.contexts {
+			delete(p.contexts, id)
+			if len(p.contexts) < maxPeerContexts/2 {
+				break
+			}
+		}
+	}
+	p.contexts[peer] = c
+}
+
+// GetPeerContext returns the message the provided user or channel was last seen in.
+func (p *PeerStorage) GetPeerContext(peer PeerID) (PeerContext, bool) {
+	p.contextLock.Lock()
+	defer p.contextLock.Unlock()
+	c, ok := p.contexts[peer]
+	return c, ok
+}
+
+func (p *PeerStorage) hasPeerContext(peer PeerID) bool {
+	_, ok := p.GetPeerContext(peer)
+	return ok
+}
+
+// SaveMessageContext saves the message as the context of the users and channels it refers to,
+// which are missing from the peer storage or only have a min access hash.
+func (p *PeerStorage) SaveMessageContext(msg *tg.Message) {
+	chat := PeerIDFromPeer(msg.PeerID)
+	if chat == 0 {
+		return
+	}
+	save := func(peer tg.PeerClass) {
+		id := PeerIDFromPeer(peer)
+		if id == chat || (id.Type() != TypeUser && id.Type() != TypeChannel) {
+			return
+		}
+		if saved := p.GetPeer(id); saved.ID != 0 && !saved.IsMin {
+			return
+		}
+		p.SavePeerContext(id, PeerContext{Chat: chat, MsgID: msg.ID})
+	}
+	if msg.FromID != nil {
+		save(msg.FromID)
+	}
+	if msg.FwdFrom.FromID != nil {
+		save(msg.FwdFrom.FromID)
+	}
+	if msg.ViaBotID != 0 {
+		save(&tg.PeerUser{UserID: msg.ViaBotID})
+	}
+	for _, e := range msg.Entities {
+		if mention, ok := e.(*tg.MessageEntityMentionName); ok {
+			save(&tg.PeerUser{UserID: mention.UserID})
+		}
+	}
+}
+
+// inputPeerFromMessage returns the input peer of the provided peer through the message it was last seen in,
+// depth limits the chats of the messages which are themselves used through their contexts.
+func (p *PeerStorage) inputPeerFromMessage(id PeerID, depth int) tg.InputPeerClass {
+	c, ok := p.GetPeerContext(id)
+	if !ok {
+		return nil
+	}
+	chat := p.getInputPeer(c.Chat, depth+1)
+	if chat == nil || chat.Zero() {
+		return nil
+	}
+	switch id.Type() {
+	case TypeUser:
+		return &tg.InputPeerUserFromMessage{Peer: chat, MsgID: c.MsgID, UserID: id.ID()}
+	case TypeChannel:
+		return &tg.InputPeerChannelFromMessage{Peer: chat, MsgID: c.MsgID, ChannelID: id.ID()}
+	}
+	return nil
+}
+
+// getInputPeer returns the input peer of the provided PeerID, using the context of the peer
+// if it is missing from the storage or only has a min access hash.
+func (p *PeerStorage) getInputPeer(id PeerID, depth int) tg.InputPeerClass {
+	peer := p.GetPeer(id)
+	if (peer.ID == 0 || peer.IsMin) && depth < 2 {
+		if fromMessage := p.inputPeerFromMessage(id, depth); fromMessage != nil {
+			return fromMessage
+		}
+	}
+	return getInputPeerFromStoragePeer(peer)
+}
+
+// RecoveryInterval is the minimum interval between two runs of the recovery of Recover.
+var RecoveryInterval = 5 * time.Second
+
+// recovery is a run of the recovery of Recover, done is closed once err is set.
+type recovery struct {
+	done chan struct{}
+	err  error
+}
+
+// Recover runs fetch, which recovers the peers missing from the storage from the server, i.e. with updates.getDifference.
+// Concurrent calls share a single run of fetch, and fetch runs at most once per RecoveryInterval:
+// the calls within the interval return the error of the last run without running it again.
+func (p *PeerStorage) Recover(fetch func() error) error {
+	p.recoveryLock.Lock()
+	if r := p.recovery; r != nil {
+		select {
+		case <-r.done:
+			if time.Since(p.recoveredAt) < RecoveryInterval {
+				p.recoveryLock.Unlock()
+				return r.err
+			}
+		default:
+			p.recoveryLock.Unlock()
+			<-r.done
+			return r.err
+		}
+	}
+	r := &recovery{done: make(chan struct{})}
+	p.recovery = r
+	p.recoveryLock.Unlock()
+
+	r.err = fetch()
+	p.recoveryLock.Lock()
+	p.recoveredAt = time.Now()
+	p.recoveryLock.Unlock()
+	close(r.done)
+	return r.err
+}
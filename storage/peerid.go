@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"strconv"
+
+	"github.com/gotd/td/tg"
+)
+
+// PeerID identifies a user, chat or channel with a Bot API-style marked ID:
+// users keep their ID, basic groups are negated and channels are negated and shifted by 10^12,
+// i.e. 1234567890 is a user, -1234567890 a basic group and -1001234567890 a channel.
+//
+// Unlike bare IDs, which are only unique among peers of the same type, a PeerID is unique among all peers.
+type PeerID int64
+
+// channelShift is added to the IDs of channels before negating them.
+const channelShift = 1000000000000
+
+// NewPeerID returns the PeerID of the peer with the provided bare ID and type.
+func NewPeerID(id int64, peerType EntityType) PeerID {
+	switch peerType {
+	case TypeChat:
+		return PeerID(-id)
+	case TypeChannel:
+		return PeerID(-channelShift - id)
+	default:
+		return PeerID(id)
+	}
+}
+
+// PeerIDFromBotAPI returns the PeerID of a Bot API-style marked ID.
+func PeerIDFromBotAPI(id int64) PeerID {
+	return PeerID(id)
+}
+
+// PeerIDFromPeer returns the PeerID of the provided tg.PeerClass, 0 if it is nil.
+func PeerIDFromPeer(peer tg.PeerClass) PeerID {
+	switch peer := peer.(type) {
+	case *tg.PeerUser:
+		return NewPeerID(peer.UserID, TypeUser)
+	case *tg.PeerChat:
+		return NewPeerID(peer.ChatID, TypeChat)
+	case *tg.PeerChannel:
+		return NewPeerID(peer.ChannelID, TypeChannel)
+	}
+	return 0
+}
+
+// PeerIDFromInputPeer returns the PeerID of the provided tg.InputPeerClass, 0 if it doesn't refer to a peer by its ID.
+func PeerIDFromInputPeer(peer tg.InputPeerClass) PeerID {
+	switch peer := peer.(type) {
+	case *tg.InputPeerUser:
+		return NewPeerID(peer.UserID, TypeUser)
+	case *tg.InputPeerUserFromMessage:
+		return NewPeerID(peer.UserID, TypeUser)
+	case *tg.InputPeerChat:
+		return NewPeerID(peer.ChatID, TypeChat)
+	case *tg.InputPeerChannel:
+		return NewPeerID(peer.ChannelID, TypeChannel)
+	case *tg.InputPeerChannelFromMessage:
+		return NewPeerID(peer.ChannelID, TypeChannel)
+	}
+	return 0
+}
+
+// ID returns the bare ID of the peer, as used by the Telegram API.
+func (id PeerID) ID() int64 {
+	switch {
+	case id > 0:
+		return int64(id)
+	case id < -channelShift:
+		return -int64(id) - channelShift
+	default:
+		return -int64(id)
+	}
+}
+
+// Type returns the type of the peer, 0 if id is 0.
+func (id PeerID) Type() EntityType {
+	switch {
+	case id > 0:
+		return TypeUser
+	case id < -channelShift:
+		return TypeChannel
+	case id < 0:
+		return TypeChat
+	}
+	return 0
+}
+
+// BotAPI returns the Bot API-style marked ID of the peer.
+func (id PeerID) BotAPI() int64 {
+	return int64(id)
+}
+
+// Peer returns the tg.PeerClass of the peer, nil if id is 0.
+func (id PeerID) Peer() tg.PeerClass {
+	switch id.Type() {
+	case TypeUser:
+		return &tg.PeerUser{UserID: id.ID()}
+	case TypeChat:
+		return &tg.PeerChat{ChatID: id.ID()}
+	case TypeChannel:
+		return &tg.PeerChannel{ChannelID: id.ID()}
+	}
+	return nil
+}
+
+// String returns the Bot API-style marked ID of the peer.
+func (id PeerID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
@@ -1,15 +1,152 @@
 package storage
 
 import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/gotd/td/tg"
 )
 
+// Peer is the saved record of a user, chat or channel.
+// Peers are keyed by both their ID and Type, which is their PeerID, since bare IDs are only unique among peers of the same type.
 type Peer struct {
-	ID         int64 `gorm:"primary_key"`
+	ID         int64 `gorm:"primary_key;autoIncrement:false"`
 	AccessHash int64
-	Type       int
-	Username   string
+	Type       int `gorm:"primary_key;autoIncrement:false"`
+	// Username is the main username of the peer, in lower case.
+	Username string
+	// Usernames contains all the active usernames of the peer, including Username, in lower case.
+	Usernames Usernames
+	Phone     string
+	FirstName string
+	LastName  string
+	// Title is the title of a chat or channel.
+	Title string
+	IsBot bool
+	// IsMin is true if AccessHash is a min access hash, which can only be
+	// used in the context of the message the peer was received with.
+	IsMin bool
+	// UpdatedAt is the last time the peer was seen in an update or a response.
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false"`
+}
+
+// PeerID returns the Bot API-style marked ID of the peer.
+func (p *Peer) PeerID() PeerID {
+	return NewPeerID(p.ID, EntityType(p.Type))
+}
+
+// HasUsername reports whether the provided username is one of the usernames of the peer, ignoring the case.
+func (p *Peer) HasUsername(username string) bool {
+	if username == "" {
+		return false
+	}
+	if strings.EqualFold(p.Username, username) {
+		return true
+	}
+	for _, u := range p.Usernames {
+		if strings.EqualFold(u, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerFromUser creates a peer record of the provided user.
+func PeerFromUser(u *tg.User) *Peer {
+	peer := &Peer{
+		ID:         u.ID,
+		AccessHash: u.AccessHash,
+		Type:       TypeUser.GetInt(),
+		Username:   u.Username,
+		Phone:      u.Phone,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		IsBot:      u.Bot,
+		IsMin:      u.Min,
+	}
+	peer.Usernames = activeUsernames(u.Username, u.Usernames)
+	if peer.Username == "" && len(peer.Usernames) > 0 {
+		peer.Username = peer.Usernames[0]
+	}
+	return peer
+}
+
+// PeerFromChannel creates a peer record of the provided channel.
+func PeerFromChannel(c *tg.Channel) *Peer {
+	peer := &Peer{
+		ID:         c.ID,
+		AccessHash: c.AccessHash,
+		Type:       TypeChannel.GetInt(),
+		Username:   c.Username,
+		Title:      c.Title,
+		IsMin:      c.Min,
+	}
+	peer.Usernames = activeUsernames(c.Username, c.Usernames)
+	if peer.Username == "" && len(peer.Usernames) > 0 {
+		peer.Username = peer.Usernames[0]
+	}
+	return peer
+}
+
+// PeerFromChat creates a peer record of the provided basic group.
+func PeerFromChat(c *tg.Chat) *Peer {
+	return &Peer{
+		ID:    c.ID,
+		Type:  TypeChat.GetInt(),
+		Title: c.Title,
+	}
+}
+
+func activeUsernames(username string, usernames []tg.Username) Usernames {
+	var res Usernames
+	if username != "" {
+		res = append(res, username)
+	}
+	for _, u := range usernames {
+		if u.Active && u.Username != username {
+			res = append(res, u.Username)
+		}
+	}
+	return res
 }
+
+// Usernames is a list of usernames, saved as a space separated string in sql databases.
+type Usernames []string
+
+func (u Usernames) GormDataType() string {
+	return "string"
+}
+
+// Value implements driver.Valuer. The value is padded with spaces so that
+// a username can be matched with a LIKE query.
+func (u Usernames) Value() (driver.Value, error) {
+	if len(u) == 0 {
+		return "", nil
+	}
+	return " " + strings.Join(u, " ") + " ", nil
+}
+
+// Scan implements sql.Scanner.
+func (u *Usernames) Scan(value any) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("can't scan %T into Usernames", value)
+	}
+	*u = strings.Fields(s)
+	if len(*u) == 0 {
+		*u = nil
+	}
+	return nil
+}
+
 type EntityType int
 
 func (e EntityType) GetInt() int {
@@ -28,9 +165,34 @@ const (
 	TypeChannel
 )
 
+// AddPeer saves a peer with the provided id, access hash, type and username.
+// Use SavePeer to save the other details of the peer too.
 func (p *PeerStorage) AddPeer(iD, accessHash int64, peerType EntityType, userName string) {
 	peer := &Peer{ID: iD, AccessHash: accessHash, Type: peerType.GetInt(), Username: userName}
-	p.peerCache.Set(iD, peer)
+	if userName != "" {
+		peer.Usernames = Usernames{userName}
+	}
+	p.SavePeer(peer)
+}
+
+// SavePeer saves the provided peer in the peer storage, its usernames are saved in lower case.
+// A min peer never overwrites the access hash or the phone of a saved full peer.
+func (p *PeerStorage) SavePeer(peer *Peer) {
+	// The peer is copied, so that the provided one is never modified.
+	peer = lowerUsernames(peer)
+	if peer.IsMin {
+		if old := p.GetPeer(peer.PeerID()); old.ID == peer.ID && !old.IsMin && old.AccessHash != 0 {
+			peer.AccessHash = old.AccessHash
+			peer.IsMin = false
+			if peer.Phone == "" {
+				peer.Phone = old.Phone
+			}
+		}
+	}
+	if peer.UpdatedAt.IsZero() {
+		peer.UpdatedAt = time.Now()
+	}
+	p.peerCache.Set(peer.PeerID(), peer)
 	if p.inMemory {
 		return
 	}
@@ -38,47 +200,121 @@ func (p *PeerStorage) AddPeer(iD, accessHash int64, peerType EntityType, userNam
 }
 
 func (p *PeerStorage) addPeerToDb(peer *Peer) {
-	tx := p.SqlSession.Begin()
-	tx.Save(peer)
-	p.peerLock.Lock()
-	defer p.peerLock.Unlock()
-	tx.Commit()
+	_ = p.store.SavePeer(peer)
+}
+
+// lowerUsernames returns a copy of the peer with its usernames in lower case,
+// as usernames are case-insensitive.
+func lowerUsernames(peer *Peer) *Peer {
+	lower := *peer
+	lower.Username = strings.ToLower(peer.Username)
+	if peer.Usernames != nil {
+		lower.Usernames = make(Usernames, len(peer.Usernames))
+		for i, u := range peer.Usernames {
+			lower.Usernames[i] = strings.ToLower(u)
+		}
+	}
+	return &lower
 }
 
 // GetPeerById finds the provided id in the peer storage and return it if found.
+// A negative id is a Bot API-style marked ID, while a bare id is looked up as a user, then as a channel and then as a chat.
+// Use GetPeer to find a peer whose type is known.
 func (p *PeerStorage) GetPeerById(iD int64) *Peer {
-	peer, ok := p.peerCache.Get(iD)
-	if p.inMemory {
-		if !ok {
-			return &Peer{}
+	if iD <= 0 {
+		return p.GetPeer(PeerIDFromBotAPI(iD))
+	}
+	for _, peerType := range []EntityType{TypeUser, TypeChannel, TypeChat} {
+		if peer := p.GetPeer(NewPeerID(iD, peerType)); peer.ID != 0 {
+			return peer
 		}
-	} else {
-		if !ok {
-			return p.cachePeers(iD)
+	}
+	return &Peer{}
+}
+
+// GetPeer finds the peer with the provided PeerID in the peer storage and return it if found.
+func (p *PeerStorage) GetPeer(id PeerID) *Peer {
+	if id == 0 {
+		return &Peer{}
+	}
+	peer, ok := p.peerCache.Get(id)
+	if ok {
+		return peer
+	}
+	if p.inMemory {
+		return &Peer{}
+	}
+	return p.cachePeers(id)
+}
+
+// PeerIDOf returns the PeerID of the provided bare or Bot API-style marked id.
+// The type of a bare id is the type of the peer saved with it or, if none is, of the peer seen in a message with it;
+// ids of unknown peers are assumed to be users like in the Bot API.
+func (p *PeerStorage) PeerIDOf(iD int64) PeerID {
+	if iD <= 0 {
+		return PeerIDFromBotAPI(iD)
+	}
+	if peer := p.GetPeerById(iD); peer.ID != 0 {
+		return peer.PeerID()
+	}
+	if id := NewPeerID(iD, TypeChannel); p.hasPeerContext(id) {
+		return id
+	}
+	return NewPeerID(iD, TypeUser)
+}
+
+// GetPeerByUsername finds the provided username in the peer storage and return it if found, ignoring the case.
+func (p *PeerStorage) GetPeerByUsername(username string) *Peer {
+	username = strings.ToLower(username)
+	if p.inMemory {
+		for _, peer := range p.peerCache.GetAll() {
+			if peer.HasUsername(username) {
+				return peer
+			}
 		}
+		return &Peer{}
+	}
+	peer, err := p.store.GetPeerByUsername(username)
+	if err != nil {
+		return &Peer{}
 	}
 	return peer
 }
 
-// GetPeerByUsername finds the provided username in the peer storage and return it if found.
-func (p *PeerStorage) GetPeerByUsername(username string) *Peer {
+// GetPeerByPhone finds the user with the provided phone number in the peer storage and return it if found.
+func (p *PeerStorage) GetPeerByPhone(phone string) *Peer {
+	phone = strings.TrimPrefix(phone, "+")
 	if p.inMemory {
 		for _, peer := range p.peerCache.GetAll() {
-			if peer.Username == username {
+			if phone != "" && peer.Phone == phone {
 				return peer
 			}
 		}
-	} else {
-		peer := Peer{}
-		p.SqlSession.Where("username = ?", username).Find(&peer)
-		return &peer
+		return &Peer{}
 	}
-	return &Peer{}
+	peer, err := p.store.GetPeerByPhone(phone)
+	if err != nil {
+		return &Peer{}
+	}
+	return peer
 }
 
 // GetInputPeerById finds the provided id in the peer storage and return its tg.InputPeerClass if found.
+// Peers which are missing or only have a min access hash are returned through the message they were last seen in,
+// see SaveMessageContext.
 func (p *PeerStorage) GetInputPeerById(iD int64) tg.InputPeerClass {
-	return getInputPeerFromStoragePeer(p.GetPeerById(iD))
+	return p.GetInputPeer(p.PeerIDOf(iD))
+}
+
+// GetInputPeer finds the provided PeerID in the peer storage and return its tg.InputPeerClass if found,
+// see GetInputPeerById.
+func (p *PeerStorage) GetInputPeer(id PeerID) tg.InputPeerClass {
+	return p.getInputPeer(id, 0)
+}
+
+// GetInputPeerByPhone finds the provided phone number in the peer storage and return its tg.InputPeerClass if found.
+func (p *PeerStorage) GetInputPeerByPhone(phone string) tg.InputPeerClass {
+	return getInputPeerFromStoragePeer(p.GetPeerByPhone(phone))
 }
 
 // GetInputPeerByUsername finds the provided username in the peer storage and return its tg.InputPeerClass if found.
@@ -86,11 +322,13 @@ func (p *PeerStorage) GetInputPeerByUsername(userName string) tg.InputPeerClass
 	return getInputPeerFromStoragePeer(p.GetPeerByUsername(userName))
 }
 
-func (p *PeerStorage) cachePeers(id int64) *Peer {
-	var peer = Peer{}
-	p.SqlSession.Where("id = ?", id).Find(&peer)
-	p.peerCache.Set(id, &peer)
-	return &peer
+func (p *PeerStorage) cachePeers(id PeerID) *Peer {
+	peer, err := p.store.GetPeerByID(id)
+	if err != nil {
+		peer = &Peer{}
+	}
+	p.peerCache.Set(id, peer)
+	return peer
 }
 
 func getInputPeerFromStoragePeer(peer *Peer) tg.InputPeerClass {
@@ -15,15 +15,16 @@ const LatestVersion = 1
 // 	AuthKeyID []byte
 // }
 
+// UpdateSession replaces the session saved in storage.
 func (p *PeerStorage) UpdateSession(session *Session) {
-	tx := p.SqlSession.Begin()
-	tx.Save(session)
-	tx.Commit()
+	_ = p.store.SaveSession(session)
 }
 
 // GetSession returns the session saved in storage.
 func (p *PeerStorage) GetSession() *Session {
-	session := &Session{Version: LatestVersion}
-	p.SqlSession.Model(&Session{}).Find(&session)
+	session, err := p.store.GetSession()
+	if err != nil {
+		return &Session{Version: LatestVersion}
+	}
 	return session
 }
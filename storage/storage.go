@@ -1,48 +1,75 @@
 package storage
 
 import (
-	"log"
 	"sync"
 	"time"
 
 	"github.com/AnimeKaizoku/cacher"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
+// PeerStorage is a cached front of the Store which saves the peers and the session of a client.
 type PeerStorage struct {
-	peerCache  *cacher.Cacher[int64, *Peer]
-	peerLock   *sync.RWMutex
-	inMemory   bool
+	peerCache *cacher.Cacher[PeerID, *Peer]
+	peerLock  *sync.RWMutex
+	inMemory  bool
+	store     Store
+	// SqlSession is the database of the storage, it is nil unless the storage is backed by a GormStore.
+	// It is required for conversation states and updates storage.
 	SqlSession *gorm.DB
+	// tablePrefix is the table prefix of the account of the GormStore.
+	tablePrefix string
+	// contexts are the messages the peers missing from the storage were last seen in.
+	contexts    map[PeerID]PeerContext
+	contextLock sync.Mutex
+	// recovery is the last run of Recover, which ended at recoveredAt.
+	recovery     *recovery
+	recoveredAt  time.Time
+	recoveryLock sync.Mutex
 }
 
-func NewPeerStorage(dialector gorm.Dialector, inMemory bool) *PeerStorage {
+// NewPeerStorage creates a PeerStorage backed by a GormStore of the provided dialector,
+// it returns an error if the database can't be opened or migrated.
+// If inMemory is true, the dialector is ignored, nothing is persisted and no error is returned.
+func NewPeerStorage(dialector gorm.Dialector, inMemory bool) (*PeerStorage, error) {
+	if inMemory {
+		p := newPeerStorage(NewMemoryStore(), nil)
+		p.inMemory = true
+		return p, nil
+	}
+	store, err := NewGormStore(dialector)
+	if err != nil {
+		return nil, err
+	}
+	return NewPeerStorageWithStore(store), nil
+}
+
+// NewPeerStorageWithStore creates a PeerStorage backed by the provided store.
+// Conversation states, throttle counters and the updates state are only persisted if it is a GormStore.
+func NewPeerStorageWithStore(store Store) *PeerStorage {
+	// Revaluation renews the expiry of a peer outside of the lock of the cacher, which races with its cleaner
+	// and concurrent lookups, so the peers expire 6 hours after they were cached and are loaded from the store again.
+	return newPeerStorage(store, &cacher.NewCacherOpts{
+		TimeToLive:    6 * time.Hour,
+		CleanInterval: 24 * time.Hour,
+	})
+}
+
+func newPeerStorage(store Store, opts *cacher.NewCacherOpts) *PeerStorage {
 	p := PeerStorage{
-		inMemory: inMemory,
-		peerLock: new(sync.RWMutex),
+		peerLock:  new(sync.RWMutex),
+		store:     store,
+		peerCache: cacher.NewCacher[PeerID, *Peer](opts),
+		contexts:  make(map[PeerID]PeerContext),
 	}
-	var opts *cacher.NewCacherOpts
-	if inMemory {
-		opts = nil
-	} else {
-		opts = &cacher.NewCacherOpts{
-			TimeToLive:    6 * time.Hour,
-			CleanInterval: 24 * time.Hour,
-			Revaluate:     true,
-		}
-		db, err := gorm.Open(dialector, &gorm.Config{
-			SkipDefaultTransaction: true,
-			Logger:                 logger.Default.LogMode(logger.Silent),
-		})
-		if err != nil {
-			log.Panicln(err)
-		}
-		p.SqlSession = db
-		dB, _ := db.DB()
-		dB.SetMaxOpenConns(100)
-		_ = p.SqlSession.AutoMigrate(&Session{}, &Peer{})
+	if gs, ok := store.(*GormStore); ok {
+		p.SqlSession = gs.DB
+		p.tablePrefix = gs.prefix
 	}
-	p.peerCache = cacher.NewCacher[int64, *Peer](opts)
 	return &p
 }
+
+// Store returns the backend of the peer storage.
+func (p *PeerStorage) Store() Store {
+	return p.store
+}
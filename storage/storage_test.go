@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"gorm.io/gorm"
+)
+
+func newSqlStorage(t *testing.T) *PeerStorage {
+	t.Helper()
+	p, err := NewPeerStorage(sqlite.Open(filepath.Join(t.TempDir(), "test.session")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, _ := p.SqlSession.DB()
+		_ = db.Close()
+	})
+	return p
+}
+
+func TestNewPeerStorageError(t *testing.T) {
+	// A directory can't be opened as a database.
+	if _, err := NewPeerStorage(sqlite.Open(t.TempDir()), false); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestPeerStorageInMemory(t *testing.T) {
+	p, _ := NewPeerStorage(nil, true)
+	p.AddPeer(10, 20, TypeUser, "alice")
+	p.AddPeer(100, 200, TypeChannel, "group")
+
+	if peer := p.GetPeerById(10); peer.AccessHash != 20 || peer.Username != "alice" {
+		t.Fatalf("unexpected peer %v", peer)
+	}
+	if peer := p.GetPeerByUsername("group"); peer.ID != 100 {
+		t.Fatalf("unexpected peer %v", peer)
+	}
+	if peer := p.GetPeerById(11); peer.ID != 0 {
+		t.Fatalf("expected empty peer, got %v", peer)
+	}
+	if peer, ok := p.GetInputPeerById(100).(*tg.InputPeerChannel); !ok || peer.AccessHash != 200 {
+		t.Fatalf("unexpected input peer %v", peer)
+	}
+	if _, ok := p.GetInputPeerById(11).(*tg.InputPeerEmpty); !ok {
+		t.Fatal("expected empty input peer for unknown id")
+	}
+	if err := p.SetConversationState(&ConversationState{Key: "k"}); err == nil {
+		t.Fatal("expected conversation states to be unsupported in memory")
+	}
+}
+
+func TestPeerStorageSql(t *testing.T) {
+	p := newSqlStorage(t)
+	p.addPeerToDb(&Peer{ID: 10, AccessHash: 20, Type: TypeUser.GetInt(), Username: "alice"})
+
+	if peer := p.GetPeerById(10); peer.AccessHash != 20 {
+		t.Fatalf("unexpected peer %v", peer)
+	}
+	if peer := p.GetPeerByUsername("alice"); peer.ID != 10 {
+		t.Fatalf("unexpected peer %v", peer)
+	}
+	if peer := p.GetPeerById(11); peer.ID != 0 {
+		t.Fatalf("expected empty peer, got %v", peer)
+	}
+}
+
+func TestPeerStorageUsernameCase(t *testing.T) {
+	mem, _ := NewPeerStorage(nil, true)
+	for name, p := range map[string]*PeerStorage{"memory": mem, "sql": newSqlStorage(t)} {
+		peer := &Peer{ID: 10, AccessHash: 20, Type: TypeUser.GetInt(), Username: "Alice", Usernames: Usernames{"Alice", "Wonder_Land"}}
+		p.SavePeer(peer)
+		if peer.Username != "Alice" {
+			t.Fatalf("%s: the saved peer was modified", name)
+		}
+		// Peers are saved in the background by the sql storage.
+		p.addPeerToDb(lowerUsernames(peer))
+		for _, username := range []string{"alice", "ALICE", "wonder_land", "Wonder_Land"} {
+			if got := p.GetPeerByUsername(username); got.ID != 10 {
+				t.Fatalf("%s: %s not found", name, username)
+			}
+		}
+	}
+}
+
+func TestPeerStorageConcurrentGet(t *testing.T) {
+	p := newSqlStorage(t)
+	p.AddPeer(10, 20, TypeUser, "alice")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if peer := p.GetPeer(NewPeerID(10, TypeUser)); peer.AccessHash != 20 {
+				t.Errorf("unexpected peer %v", peer)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPeerStorageMinPeer(t *testing.T) {
+	mem, _ := NewPeerStorage(nil, true)
+	for _, p := range []*PeerStorage{mem, newSqlStorage(t)} {
+		p.SavePeer(&Peer{ID: 10, AccessHash: 20, Type: TypeUser.GetInt(), Phone: "1555"})
+		p.SavePeer(&Peer{ID: 10, AccessHash: 99, Type: TypeUser.GetInt(), FirstName: "Alice", IsMin: true})
+		peer := p.GetPeerById(10)
+		if peer.AccessHash != 20 || peer.IsMin || peer.Phone != "1555" || peer.FirstName != "Alice" {
+			t.Fatalf("min peer overwrote the full peer: %+v", peer)
+		}
+		if peer.UpdatedAt.IsZero() {
+			t.Fatal("expected UpdatedAt to be set")
+		}
+		p.SavePeer(&Peer{ID: 11, AccessHash: 30, Type: TypeUser.GetInt(), IsMin: true})
+		if peer := p.GetPeerById(11); peer.AccessHash != 30 || !peer.IsMin {
+			t.Fatalf("unexpected min peer %+v", peer)
+		}
+	}
+	p, _ := NewPeerStorage(nil, true)
+	p.SavePeer(&Peer{ID: 10, Type: TypeUser.GetInt(), Phone: "1555"})
+	if peer := p.GetPeerByPhone("+1555"); peer.ID != 10 {
+		t.Fatalf("unexpected peer by phone %+v", peer)
+	}
+}
+
+func TestPeerContext(t *testing.T) {
+	p, _ := NewPeerStorage(nil, true)
+	p.SavePeer(&Peer{ID: 100, AccessHash: 200, Type: TypeChannel.GetInt()})
+	p.SavePeer(&Peer{ID: 11, AccessHash: 30, Type: TypeUser.GetInt(), IsMin: true})
+	p.SavePeer(&Peer{ID: 12, AccessHash: 40, Type: TypeUser.GetInt()})
+	p.SaveMessageContext(&tg.Message{
+		ID:     7,
+		PeerID: &tg.PeerChannel{ChannelID: 100},
+		FromID: &tg.PeerUser{UserID: 10},
+		FwdFrom: tg.MessageFwdHeader{
+			FromID: &tg.PeerChannel{ChannelID: 101},
+		},
+		ViaBotID: 11,
+		Entities: []tg.MessageEntityClass{&tg.MessageEntityMentionName{UserID: 12}},
+	})
+	chat := &tg.InputPeerChannel{ChannelID: 100, AccessHash: 200}
+	if peer, ok := p.GetInputPeerById(10).(*tg.InputPeerUserFromMessage); !ok || peer.UserID != 10 || peer.MsgID != 7 || *peer.Peer.(*tg.InputPeerChannel) != *chat {
+		t.Fatalf("unexpected input peer of a missing user %v", p.GetInputPeerById(10))
+	}
+	if peer, ok := p.GetInputPeerById(101).(*tg.InputPeerChannelFromMessage); !ok || peer.ChannelID != 101 || peer.MsgID != 7 {
+		t.Fatalf("unexpected input peer of a missing channel %v", p.GetInputPeerById(101))
+	}
+	if _, ok := p.GetInputPeerById(11).(*tg.InputPeerUserFromMessage); !ok {
+		t.Fatalf("unexpected input peer of a min user %v", p.GetInputPeerById(11))
+	}
+	if peer, ok := p.GetInputPeerById(12).(*tg.InputPeerUser); !ok || peer.AccessHash != 40 {
+		t.Fatalf("unexpected input peer of a full user %v", p.GetInputPeerById(12))
+	}
+	if _, ok := p.GetPeerContext(12); ok {
+		t.Fatal("saved the context of a full user")
+	}
+	if _, ok := p.GetInputPeerById(13).(*tg.InputPeerEmpty); !ok {
+		t.Fatalf("unexpected input peer of an unknown user %v", p.GetInputPeerById(13))
+	}
+}
+
+func TestRecover(t *testing.T) {
+	p, _ := NewPeerStorage(nil, true)
+	var (
+		runs    atomic.Int32
+		wg      sync.WaitGroup
+		release = make(chan struct{})
+	)
+	fetch := func() error {
+		runs.Add(1)
+		<-release
+		return errors.New("flood wait")
+	}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Recover(fetch); err == nil {
+				t.Error("expected the error of the shared run")
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	// The recovery isn't run again within the interval.
+	if err := p.Recover(fetch); err == nil || runs.Load() != 1 {
+		t.Fatalf("unexpected runs %d: %v", runs.Load(), err)
+	}
+	p.recoveredAt = time.Now().Add(-RecoveryInterval)
+	if _ = p.Recover(fetch); runs.Load() != 2 {
+		t.Fatalf("expected the recovery to run again after the interval, got %d runs", runs.Load())
+	}
+}
+
+func TestPeerFromUser(t *testing.T) {
+	peer := PeerFromUser(&tg.User{
+		ID:         10,
+		AccessHash: 20,
+		Bot:        true,
+		Min:        true,
+		FirstName:  "Alice",
+		Usernames: []tg.Username{
+			{Username: "alice", Editable: true, Active: true},
+			{Username: "inactive"},
+			{Username: "alice_2", Active: true},
+		},
+	})
+	if peer.Username != "alice" || len(peer.Usernames) != 2 || peer.Usernames[1] != "alice_2" {
+		t.Fatalf("unexpected usernames %q %q", peer.Username, peer.Usernames)
+	}
+	if !peer.IsBot || !peer.IsMin || peer.FirstName != "Alice" || peer.Type != TypeUser.GetInt() {
+		t.Fatalf("unexpected peer %+v", peer)
+	}
+	if !peer.HasUsername("alice_2") || peer.HasUsername("inactive") {
+		t.Fatal("unexpected HasUsername result")
+	}
+	channel := PeerFromChannel(&tg.Channel{ID: 5, AccessHash: 6, Title: "News", Username: "news"})
+	if channel.Title != "News" || channel.Type != TypeChannel.GetInt() || len(channel.Usernames) != 1 {
+		t.Fatalf("unexpected channel %+v", channel)
+	}
+}
+
+type legacyPeer struct {
+	ID         int64 `gorm:"primary_key"`
+	AccessHash int64
+	Type       int
+	Username   string
+}
+
+func (legacyPeer) TableName() string { return "peers" }
+
+func TestPeerMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.session")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&legacyPeer{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&legacyPeer{ID: 10, AccessHash: 20, Type: TypeUser.GetInt(), Username: "alice"})
+	db.Create(&legacyPeer{ID: 11, AccessHash: 21, Type: TypeUser.GetInt()})
+	db.Create(&legacyPeer{ID: 12, AccessHash: 22, Type: TypeChannel.GetInt(), Username: "news"})
+	sqlDB, _ := db.DB()
+	_ = sqlDB.Close()
+
+	s, err := NewGormStore(sqlite.Open(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, _ := s.DB.DB()
+		_ = db.Close()
+	})
+	peer, err := s.GetPeerByID(10)
+	if err != nil || peer.AccessHash != 20 || len(peer.Usernames) != 1 || peer.Usernames[0] != "alice" || peer.IsMin {
+		t.Fatalf("unexpected migrated peer %+v, %v", peer, err)
+	}
+	if peer, err := s.GetPeerByID(11); err != nil || peer.Usernames != nil {
+		t.Fatalf("unexpected migrated peer %+v, %v", peer, err)
+	}
+	if peer, err := s.GetPeerByID(NewPeerID(12, TypeChannel)); err != nil || peer.AccessHash != 22 || peer.Username != "news" {
+		t.Fatalf("unexpected migrated channel %+v, %v", peer, err)
+	}
+	// Peers are keyed by their type too once migrated.
+	if err := s.SavePeer(&Peer{ID: 10, Type: TypeChat.GetInt(), Title: "Group"}); err != nil {
+		t.Fatal(err)
+	}
+	if peer, err := s.GetPeerByID(10); err != nil || peer.AccessHash != 20 {
+		t.Fatalf("chat overwrote the user with the same ID: %+v, %v", peer, err)
+	}
+	if peer, err := s.GetPeerByID(NewPeerID(10, TypeChat)); err != nil || peer.Title != "Group" {
+		t.Fatalf("unexpected chat %+v, %v", peer, err)
+	}
+}
+
+func TestPeerMigrationAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.session")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table("bot_peers").AutoMigrate(&legacyPeer{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Table("bot_peers").Create(&legacyPeer{ID: 10, AccessHash: 20, Type: TypeChannel.GetInt(), Username: "news"})
+	sqlDB, _ := db.DB()
+	_ = sqlDB.Close()
+
+	s, err := NewGormStore(sqlite.Open(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, _ := s.DB.DB()
+		_ = db.Close()
+	})
+	a, err := s.Account("bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer, err := a.GetPeerByID(NewPeerID(10, TypeChannel)); err != nil || peer.AccessHash != 20 || peer.Username != "news" {
+		t.Fatalf("unexpected migrated peer %+v, %v", peer, err)
+	}
+	// Migrating again leaves the peers as they are.
+	a, err = s.Account("bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GetPeerByID(NewPeerID(10, TypeChannel)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeerID(t *testing.T) {
+	tests := []struct {
+		id     int64
+		typ    EntityType
+		botAPI int64
+		peer   tg.PeerClass
+	}{
+		{id: 1234567890, typ: TypeUser, botAPI: 1234567890, peer: &tg.PeerUser{UserID: 1234567890}},
+		{id: 1234567890, typ: TypeChat, botAPI: -1234567890, peer: &tg.PeerChat{ChatID: 1234567890}},
+		{id: 1234567890, typ: TypeChannel, botAPI: -1001234567890, peer: &tg.PeerChannel{ChannelID: 1234567890}},
+	}
+	for _, tt := range tests {
+		id := NewPeerID(tt.id, tt.typ)
+		if id.BotAPI() != tt.botAPI || id.ID() != tt.id || id.Type() != tt.typ {
+			t.Fatalf("unexpected PeerID %d of %d with type %d", id, tt.id, tt.typ)
+		}
+		if PeerIDFromBotAPI(tt.botAPI) != id || PeerIDFromPeer(tt.peer) != id || id.Peer().String() != tt.peer.String() {
+			t.Fatalf("unexpected conversions of %s", id)
+		}
+	}
+	if id := PeerID(0); id.Type() != 0 || id.Peer() != nil {
+		t.Fatal("unexpected zero PeerID")
+	}
+}
+
+func TestSession(t *testing.T) {
+	p := newSqlStorage(t)
+	p.UpdateSession(&Session{Version: LatestVersion, Data: []byte("data")})
+	if s := p.GetSession(); string(s.Data) != "data" {
+		t.Fatalf("unexpected session %v", s)
+	}
+	p.UpdateSession(&Session{Version: LatestVersion, Data: []byte("updated")})
+	if s := p.GetSession(); string(s.Data) != "updated" {
+		t.Fatalf("unexpected session %v", s)
+	}
+}
+
+func TestConversationState(t *testing.T) {
+	p := newSqlStorage(t)
+	if err := p.SetConversationState(&ConversationState{Key: "conv:1:2", State: "name"}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.GetConversationState("conv:1:2")
+	if err != nil || s.State != "name" {
+		t.Fatalf("unexpected state %v, %v", s, err)
+	}
+	if err := p.DeleteConversationState("conv:1:2"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := p.GetConversationState("conv:1:2"); err != nil || s.Key != "" {
+		t.Fatalf("expected deleted state, got %v, %v", s, err)
+	}
+}
+
+func TestThrottleCounter(t *testing.T) {
+	p := newSqlStorage(t)
+	until := time.Unix(100, 0)
+	if err := p.SetThrottleCounter(&ThrottleCounter{Key: "user:1", Hits: []int64{1, 2}, BlockedUntil: until}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.GetThrottleCounter("user:1")
+	if err != nil || len(c.Hits) != 2 || c.Hits[1] != 2 || !c.BlockedUntil.Equal(until) {
+		t.Fatalf("unexpected counter %+v, %v", c, err)
+	}
+	if err := p.DeleteThrottleCounter("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := p.GetThrottleCounter("user:1"); err != nil || c.Key != "" {
+		t.Fatalf("expected deleted counter, got %+v, %v", c, err)
+	}
+}
+
+func TestUpdatesStorage(t *testing.T) {
+	ctx := context.Background()
+	p := newSqlStorage(t)
+	s := NewUpdatesStorage(p)
+
+	if _, found, err := s.GetState(ctx, 1); err != nil || found {
+		t.Fatalf("expected no state, got %v, %v", found, err)
+	}
+	if err := s.SetPts(ctx, 1, 5); err == nil {
+		t.Fatal("expected an error setting pts without a state")
+	}
+	if err := s.SetState(ctx, 1, updates.State{Pts: 1, Qts: 2, Date: 3, Seq: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPts(ctx, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDateSeq(ctx, 1, 30, 40); err != nil {
+		t.Fatal(err)
+	}
+	state, found, err := s.GetState(ctx, 1)
+	if err != nil || !found || state != (updates.State{Pts: 10, Qts: 2, Date: 30, Seq: 40}) {
+		t.Fatalf("unexpected state %v, %v, %v", state, found, err)
+	}
+
+	if err := s.SetChannelPts(ctx, 1, 100, 7); err != nil {
+		t.Fatal(err)
+	}
+	if pts, found, err := s.GetChannelPts(ctx, 1, 100); err != nil || !found || pts != 7 {
+		t.Fatalf("unexpected channel pts %v, %v, %v", pts, found, err)
+	}
+	channels := map[int64]int{}
+	err = s.ForEachChannels(ctx, 1, func(_ context.Context, channelID int64, pts int) error {
+		channels[channelID] = pts
+		return nil
+	})
+	if err != nil || len(channels) != 1 || channels[100] != 7 {
+		t.Fatalf("unexpected channels %v, %v", channels, err)
+	}
+
+	// A user with the same bare ID as the channel.
+	p.SavePeer(&Peer{ID: 100, AccessHash: 11, Type: TypeUser.GetInt(), FirstName: "Namesake"})
+	if _, found, err := s.GetChannelAccessHash(ctx, 1, 100); err != nil || found {
+		t.Fatalf("expected no channel access hash, got %v, %v", found, err)
+	}
+	if err := s.SetChannelAccessHash(ctx, 1, 100, 55); err != nil {
+		t.Fatal(err)
+	}
+	if hash, found, err := s.GetChannelAccessHash(ctx, 1, 100); err != nil || !found || hash != 55 {
+		t.Fatalf("unexpected access hash %v, %v, %v", hash, found, err)
+	}
+	if channel := p.GetPeer(NewPeerID(100, TypeChannel)); channel.FirstName != "" {
+		t.Fatalf("channel copied the user with the same id: %+v", channel)
+	}
+	if user := p.GetPeer(NewPeerID(100, TypeUser)); user.AccessHash != 11 {
+		t.Fatalf("user was overwritten: %+v", user)
+	}
+}
@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ErrNotFound is returned by a Store when the requested peer or session doesn't exist.
+var ErrNotFound = errors.New("not found in store")
+
+// PeerStore is the backend used by a PeerStorage to persist peers.
+// Implementations must be safe for concurrent use.
+type PeerStore interface {
+	// SavePeer inserts or replaces the peer with the same PeerID, i.e. the same ID and Type.
+	SavePeer(peer *Peer) error
+	// GetPeerByID returns ErrNotFound if no peer has the provided PeerID.
+	GetPeerByID(id PeerID) (*Peer, error)
+	// GetPeerByUsername returns ErrNotFound if no peer has the provided username
+	// as its Username or in its Usernames. PeerStorage saves and looks up usernames in lower case.
+	GetPeerByUsername(username string) (*Peer, error)
+	// GetPeerByPhone returns ErrNotFound if no peer has the provided phone number.
+	GetPeerByPhone(phone string) (*Peer, error)
+}
+
+// SessionStore is the backend used by a PeerStorage to persist the session.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// SaveSession replaces the saved session.
+	SaveSession(session *Session) error
+	// GetSession returns ErrNotFound if no session was saved yet.
+	GetSession() (*Session, error)
+}
+
+// Store is a backend for both peers and sessions.
+//
+// Only peers and the session go through a Store. Conversation states, throttle counters and
+// the updates state are saved in the database of a GormStore, so with any other store
+// (i.e. MemoryStore or FileStore) the conversation and database throttle storages return an error
+// and the updates state is only kept in memory, which makes the client miss the updates
+// received while it was offline.
+type Store interface {
+	PeerStore
+	SessionStore
+}
+
+// AccountStore is a backend shared by multiple accounts, it provides a separate Store for every account.
+// It is only implemented by GormStore.
+type AccountStore interface {
+	// Account returns the Store of the account with the provided name.
+	Account(name string) (Store, error)
+}
+
+// GormStore is the default Store which saves peers and sessions in an sql database using gorm.
+type GormStore struct {
+	DB   *gorm.DB
+	lock sync.Mutex
+	// prefix is prepended to the tables of the store, it is empty unless the store belongs to an account.
+	prefix string
+}
+
+// NewGormStore opens the database of the provided dialector and migrates all the models used by gotgproto.
+func NewGormStore(dialector gorm.Dialector) (*GormStore, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dB, err := db.DB(); err == nil {
+		dB.SetMaxOpenConns(100)
+	}
+	s := &GormStore{DB: db}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Account returns a store of the provided account which shares the database of s.
+// Its peers, session, conversation states and throttle counters are kept in tables prefixed with the name of the account,
+// the updates states are shared as they are already keyed by the ID of the account.
+// The name may only contain ASCII letters, digits and underscores.
+func (s *GormStore) Account(name string) (Store, error) {
+	if name == "" || strings.TrimFunc(name, isTableNameRune) != "" {
+		return nil, fmt.Errorf("invalid account name %q", name)
+	}
+	a := &GormStore{DB: s.DB, prefix: s.prefix + name + "_"}
+	if err := a.migrate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func isTableNameRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// table returns a session of the database using the table with the provided name,
+// prefixed with the account of the store.
+func (s *GormStore) table(name string) *gorm.DB {
+	if s.prefix == "" {
+		return s.DB
+	}
+	return s.DB.Table(s.prefix + name)
+}
+
+func (s *GormStore) migrate() error {
+	peers := s.prefix + "peers"
+	m := s.DB.Migrator()
+	hasPeers := m.HasTable(peers)
+	// Peers saved before usernames were added only have a single username.
+	fillUsernames := hasPeers && !m.HasColumn(peers, "usernames")
+	if hasPeers {
+		keyed, err := s.peersKeyedByType(peers)
+		if err != nil {
+			return err
+		}
+		if !keyed {
+			if err := s.rekeyPeers(peers); err != nil {
+				return err
+			}
+		}
+	}
+	if s.prefix == "" {
+		if err := s.DB.AutoMigrate(&Session{}, &Peer{}, &ConversationState{}, &ThrottleCounter{}, &UpdatesState{}, &ChannelState{}); err != nil {
+			return err
+		}
+	} else {
+		if err := s.DB.AutoMigrate(&UpdatesState{}, &ChannelState{}); err != nil {
+			return err
+		}
+		tables := []struct {
+			name  string
+			model any
+		}{{"sessions", &Session{}}, {"peers", &Peer{}}, {"conversation_states", &ConversationState{}}, {"throttle_counters", &ThrottleCounter{}}}
+		for _, t := range tables {
+			if err := s.table(t.name).AutoMigrate(t.model); err != nil {
+				return err
+			}
+		}
+	}
+	if !fillUsernames {
+		return nil
+	}
+	var saved []Peer
+	return s.table("peers").Where("username <> ?", "").FindInBatches(&saved, 500, func(tx *gorm.DB, _ int) error {
+		for _, peer := range saved {
+			err := s.table("peers").Model(&Peer{}).Where("id = ? AND type = ?", peer.ID, peer.Type).Update("usernames", Usernames{peer.Username}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// peersKeyedByType reports whether the type of the peers is a part of the primary key of the provided table,
+// it isn't in the tables created before peers were keyed by their PeerID.
+func (s *GormStore) peersKeyedByType(table string) (bool, error) {
+	columns, err := s.DB.Migrator().ColumnTypes(table)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range columns {
+		if c.Name() == "type" {
+			// Tables are left as they are if the driver doesn't report primary keys.
+			primary, ok := c.PrimaryKey()
+			return primary || !ok, nil
+		}
+	}
+	return true, nil
+}
+
+// rekeyPeers recreates the provided table of peers keyed only by their ID with the primary key of Peer.
+func (s *GormStore) rekeyPeers(table string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var peers []Peer
+		if err := tx.Table(table).Find(&peers).Error; err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropTable(table); err != nil {
+			return err
+		}
+		if err := tx.Table(table).AutoMigrate(&Peer{}); err != nil {
+			return err
+		}
+		if len(peers) == 0 {
+			return nil
+		}
+		return tx.Table(table).CreateInBatches(&peers, 500).Error
+	})
+}
+
+func (s *GormStore) SavePeer(peer *Peer) error {
+	// gorm writes into the saved value, which may be shared with the peer cache.
+	p := *peer
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.table("peers").Save(&p).Error
+}
+
+func (s *GormStore) GetPeerByID(id PeerID) (*Peer, error) {
+	return s.findPeer(s.table("peers").Where("id = ? AND type = ?", id.ID(), id.Type().GetInt()))
+}
+
+func (s *GormStore) GetPeerByUsername(username string) (*Peer, error) {
+	if username == "" {
+		return nil, ErrNotFound
+	}
+	pattern := "% " + likeEscaper.Replace(username) + " %"
+	return s.findPeer(s.table("peers").Where("username = ? OR usernames LIKE ? ESCAPE '!'", username, pattern))
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (s *GormStore) GetPeerByPhone(phone string) (*Peer, error) {
+	if phone == "" {
+		return nil, ErrNotFound
+	}
+	return s.findPeer(s.table("peers").Where("phone = ?", phone))
+}
+
+func (s *GormStore) findPeer(tx *gorm.DB) (*Peer, error) {
+	peer := Peer{}
+	tx = tx.Limit(1).Find(&peer)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &peer, nil
+}
+
+func (s *GormStore) SaveSession(session *Session) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.table("sessions").Save(copySession(session)).Error
+}
+
+func (s *GormStore) GetSession() (*Session, error) {
+	session := Session{}
+	tx := s.table("sessions").Model(&Session{}).Limit(1).Find(&session)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &session, nil
+}
+
+// MemoryStore is a Store which keeps peers and sessions in memory only.
+type MemoryStore struct {
+	lock      sync.RWMutex
+	peers     map[PeerID]Peer
+	usernames map[string]PeerID
+	phones    map[string]PeerID
+	session   *Session
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		peers:     make(map[PeerID]Peer),
+		usernames: make(map[string]PeerID),
+		phones:    make(map[string]PeerID),
+	}
+}
+
+func (s *MemoryStore) SavePeer(peer *Peer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.savePeer(*peer)
+	return nil
+}
+
+func (s *MemoryStore) savePeer(peer Peer) {
+	peer.Usernames = append(Usernames(nil), peer.Usernames...)
+	id := peer.PeerID()
+	if old, ok := s.peers[id]; ok {
+		for _, u := range append(old.Usernames, old.Username) {
+			if s.usernames[u] == id {
+				delete(s.usernames, u)
+			}
+		}
+		if s.phones[old.Phone] == id {
+			delete(s.phones, old.Phone)
+		}
+	}
+	s.peers[id] = peer
+	for _, u := range append(peer.Usernames, peer.Username) {
+		if u != "" {
+			s.usernames[u] = id
+		}
+	}
+	if peer.Phone != "" {
+		s.phones[peer.Phone] = id
+	}
+}
+
+func (s *MemoryStore) GetPeerByID(id PeerID) (*Peer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	peer, ok := s.peers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	peer.Usernames = append(Usernames(nil), peer.Usernames...)
+	return &peer, nil
+}
+
+func (s *MemoryStore) GetPeerByUsername(username string) (*Peer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.lookup(s.usernames, username)
+}
+
+func (s *MemoryStore) GetPeerByPhone(phone string) (*Peer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.lookup(s.phones, phone)
+}
+
+func (s *MemoryStore) lookup(index map[string]PeerID, key string) (*Peer, error) {
+	id, ok := index[key]
+	if !ok || key == "" {
+		return nil, ErrNotFound
+	}
+	peer := s.peers[id]
+	peer.Usernames = append(Usernames(nil), peer.Usernames...)
+	return &peer, nil
+}
+
+func (s *MemoryStore) SaveSession(session *Session) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.session = copySession(session)
+	return nil
+}
+
+func (s *MemoryStore) GetSession() (*Session, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.session == nil {
+		return nil, ErrNotFound
+	}
+	return copySession(s.session), nil
+}
+
+func copySession(session *Session) *Session {
+	return &Session{Version: session.Version, Data: append([]byte(nil), session.Data...)}
+}
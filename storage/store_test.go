@@ -0,0 +1,155 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/celestix/gotgproto/storage"
+	"github.com/celestix/gotgproto/storage/storetest"
+	"github.com/glebarez/sqlite"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		return storage.NewMemoryStore()
+	})
+}
+
+func newFileStore(t *testing.T, path string) *storage.FileStore {
+	t.Helper()
+	s, err := storage.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		return newFileStore(t, filepath.Join(t.TempDir(), "store.log"))
+	})
+}
+
+func TestGormStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		return newGormStore(t)
+	})
+}
+
+func newGormStore(t *testing.T) *storage.GormStore {
+	t.Helper()
+	s, err := storage.NewGormStore(sqlite.Open(filepath.Join(t.TempDir(), "test.session")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, _ := s.DB.DB()
+		_ = db.Close()
+	})
+	return s
+}
+
+func TestGormAccountStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		s, err := newGormStore(t).Account("bot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestGormAccountStoreIsolation(t *testing.T) {
+	shared := newGormStore(t)
+	stores := make(map[string]storage.Store)
+	for _, name := range []string{"alice", "bob"} {
+		s, err := shared.Account(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores[name] = s
+	}
+	if err := stores["alice"].SavePeer(&storage.Peer{ID: 1, AccessHash: 2, Type: storage.TypeUser.GetInt(), Username: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores["alice"].SaveSession(&storage.Session{Version: storage.LatestVersion, Data: []byte("alice")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stores["bob"].GetPeerByID(1); err != storage.ErrNotFound {
+		t.Fatalf("expected the peer of another account to be missing, got %v", err)
+	}
+	if _, err := stores["bob"].GetSession(); err != storage.ErrNotFound {
+		t.Fatalf("expected the session of another account to be missing, got %v", err)
+	}
+	if _, err := shared.GetPeerByID(1); err != storage.ErrNotFound {
+		t.Fatalf("expected the peer to be missing from the shared tables, got %v", err)
+	}
+	if peer, err := stores["alice"].GetPeerByUsername("carol"); err != nil || peer.AccessHash != 2 {
+		t.Fatalf("unexpected peer %v, %v", peer, err)
+	}
+
+	p := storage.NewPeerStorageWithStore(stores["alice"])
+	if err := p.SetConversationState(&storage.ConversationState{Key: "1:2", State: "name"}); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := storage.NewPeerStorageWithStore(stores["bob"]).GetConversationState("1:2"); err != nil || state.Key != "" {
+		t.Fatalf("unexpected conversation state %v, %v", state, err)
+	}
+	if state, err := p.GetConversationState("1:2"); err != nil || state.State != "name" {
+		t.Fatalf("unexpected conversation state %v, %v", state, err)
+	}
+
+	if _, err := shared.Account("bad name"); err == nil {
+		t.Fatal("expected an invalid account name to be rejected")
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	s := newFileStore(t, path)
+	for _, name := range []string{"old", "new"} {
+		if err := s.SavePeer(&storage.Peer{ID: 1, AccessHash: 2, Type: storage.TypeUser.GetInt(), Username: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveSession(&storage.Session{Version: storage.LatestVersion, Data: []byte("data")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newFileStore(t, path)
+	if peer, err := s.GetPeerByUsername("new"); err != nil || peer.AccessHash != 2 {
+		t.Fatalf("unexpected peer %v, %v", peer, err)
+	}
+	if _, err := s.GetPeerByUsername("old"); err == nil {
+		t.Fatal("expected the old username to be dropped")
+	}
+	if session, err := s.GetSession(); err != nil || string(session.Data) != "data" {
+		t.Fatalf("unexpected session %v, %v", session, err)
+	}
+}
+
+func TestPeerStorageWithStore(t *testing.T) {
+	store := storage.NewMemoryStore()
+	_ = store.SavePeer(&storage.Peer{ID: 10, AccessHash: 20, Type: storage.TypeUser.GetInt(), Username: "alice"})
+	p := storage.NewPeerStorageWithStore(store)
+	if peer := p.GetPeerById(10); peer.AccessHash != 20 {
+		t.Fatalf("unexpected peer %v", peer)
+	}
+	if peer := p.GetPeerByUsername("alice"); peer.ID != 10 {
+		t.Fatalf("unexpected peer %v", peer)
+	}
+	if s := p.GetSession(); s.Version != storage.LatestVersion || s.Data != nil {
+		t.Fatalf("unexpected session %v", s)
+	}
+	p.UpdateSession(&storage.Session{Version: storage.LatestVersion, Data: []byte("data")})
+	if s, err := store.GetSession(); err != nil || string(s.Data) != "data" {
+		t.Fatalf("unexpected stored session %v, %v", s, err)
+	}
+	if err := p.SetConversationState(&storage.ConversationState{Key: "k"}); err == nil {
+		t.Fatal("expected conversation states to be unsupported")
+	}
+}
@@ -0,0 +1,226 @@
+// Package storetest provides a conformance test suite for implementations of storage.Store.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/storage"
+)
+
+// Run runs the conformance suite against the stores created by newStore.
+// Every subtest calls newStore once and expects an empty store.
+func Run(t *testing.T, newStore func(t *testing.T) storage.Store) {
+	t.Run("Peer", func(t *testing.T) { testPeer(t, newStore(t)) })
+	t.Run("PeerSameID", func(t *testing.T) { testPeerSameID(t, newStore(t)) })
+	t.Run("PeerNotFound", func(t *testing.T) { testPeerNotFound(t, newStore(t)) })
+	t.Run("PeerUsernameChange", func(t *testing.T) { testPeerUsernameChange(t, newStore(t)) })
+	t.Run("PeerUsernames", func(t *testing.T) { testPeerUsernames(t, newStore(t)) })
+	t.Run("PeerPhone", func(t *testing.T) { testPeerPhone(t, newStore(t)) })
+	t.Run("Session", func(t *testing.T) { testSession(t, newStore(t)) })
+	t.Run("SessionNotFound", func(t *testing.T) { testSessionNotFound(t, newStore(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, newStore(t)) })
+}
+
+func equalPeers(a, b *storage.Peer) bool {
+	if !a.UpdatedAt.Equal(b.UpdatedAt) || len(a.Usernames) != len(b.Usernames) {
+		return false
+	}
+	for i := range a.Usernames {
+		if a.Usernames[i] != b.Usernames[i] {
+			return false
+		}
+	}
+	x, y := *a, *b
+	x.Usernames, x.UpdatedAt, y.Usernames, y.UpdatedAt = nil, time.Time{}, nil, time.Time{}
+	return reflect.DeepEqual(x, y)
+}
+
+func testPeer(t *testing.T, s storage.Store) {
+	want := storage.Peer{
+		ID:         10,
+		AccessHash: 20,
+		Type:       storage.TypeUser.GetInt(),
+		Username:   "alice",
+		Usernames:  storage.Usernames{"alice", "alice_2"},
+		Phone:      "15550001",
+		FirstName:  "Alice",
+		LastName:   "Liddell",
+		IsBot:      true,
+		IsMin:      true,
+		UpdatedAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := s.SavePeer(&want); err != nil {
+		t.Fatal(err)
+	}
+	if peer, err := s.GetPeerByID(10); err != nil || !equalPeers(peer, &want) {
+		t.Fatalf("GetPeerByID: got %v, %v, want %v", peer, err, want)
+	}
+	if peer, err := s.GetPeerByUsername("alice"); err != nil || !equalPeers(peer, &want) {
+		t.Fatalf("GetPeerByUsername: got %v, %v, want %v", peer, err, want)
+	}
+	want.AccessHash, want.IsMin = 30, false
+	if err := s.SavePeer(&want); err != nil {
+		t.Fatal(err)
+	}
+	if peer, err := s.GetPeerByID(10); err != nil || !equalPeers(peer, &want) {
+		t.Fatalf("GetPeerByID after overwrite: got %v, %v, want %v", peer, err, want)
+	}
+	chat := storage.Peer{ID: 20, Type: storage.TypeChannel.GetInt(), Title: "Group"}
+	if err := s.SavePeer(&chat); err != nil {
+		t.Fatal(err)
+	}
+	if peer, err := s.GetPeerByID(storage.NewPeerID(20, storage.TypeChannel)); err != nil || !equalPeers(peer, &chat) {
+		t.Fatalf("GetPeerByID: got %v, %v, want %v", peer, err, chat)
+	}
+	if _, err := s.GetPeerByID(20); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByID of a user with the ID of a channel: got %v, want ErrNotFound", err)
+	}
+}
+
+func testPeerSameID(t *testing.T, s storage.Store) {
+	user := storage.Peer{ID: 10, AccessHash: 20, Type: storage.TypeUser.GetInt(), FirstName: "Alice"}
+	chat := storage.Peer{ID: 10, Type: storage.TypeChat.GetInt(), Title: "Group"}
+	channel := storage.Peer{ID: 10, AccessHash: 30, Type: storage.TypeChannel.GetInt(), Title: "Channel"}
+	for _, peer := range []*storage.Peer{&user, &chat, &channel} {
+		if err := s.SavePeer(peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []*storage.Peer{&user, &chat, &channel} {
+		if peer, err := s.GetPeerByID(want.PeerID()); err != nil || !equalPeers(peer, want) {
+			t.Fatalf("GetPeerByID(%s): got %v, %v, want %v", want.PeerID(), peer, err, want)
+		}
+	}
+}
+
+func testPeerNotFound(t *testing.T, s storage.Store) {
+	if err := s.SavePeer(&storage.Peer{ID: 1, Type: storage.TypeChat.GetInt()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPeerByID(2); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByID: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetPeerByUsername("nobody"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByUsername: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetPeerByUsername(""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByUsername of an empty username: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetPeerByPhone(""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByPhone of an empty phone: got %v, want ErrNotFound", err)
+	}
+}
+
+func testPeerUsernames(t *testing.T, s storage.Store) {
+	peer := &storage.Peer{ID: 10, Type: storage.TypeChannel.GetInt(), Username: "main", Usernames: storage.Usernames{"main", "extra_1", "100%"}}
+	if err := s.SavePeer(peer); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePeer(&storage.Peer{ID: 11, Type: storage.TypeUser.GetInt(), Username: "extra11"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"main", "extra_1", "100%"} {
+		if peer, err := s.GetPeerByUsername(username); err != nil || peer.ID != 10 {
+			t.Fatalf("GetPeerByUsername(%q): got %v, %v", username, peer, err)
+		}
+	}
+	for _, username := range []string{"extra", "100", "ma"} {
+		if _, err := s.GetPeerByUsername(username); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("GetPeerByUsername(%q): got %v, want ErrNotFound", username, err)
+		}
+	}
+	peer.Usernames = storage.Usernames{"main"}
+	if err := s.SavePeer(peer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPeerByUsername("extra_1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByUsername of a removed username: got %v, want ErrNotFound", err)
+	}
+}
+
+func testPeerPhone(t *testing.T, s storage.Store) {
+	if err := s.SavePeer(&storage.Peer{ID: 10, Type: storage.TypeUser.GetInt(), Phone: "15550001"}); err != nil {
+		t.Fatal(err)
+	}
+	if peer, err := s.GetPeerByPhone("15550001"); err != nil || peer.ID != 10 {
+		t.Fatalf("GetPeerByPhone: got %v, %v", peer, err)
+	}
+	if _, err := s.GetPeerByPhone("15550002"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByPhone: got %v, want ErrNotFound", err)
+	}
+}
+
+func testPeerUsernameChange(t *testing.T, s storage.Store) {
+	if err := s.SavePeer(&storage.Peer{ID: 10, Type: storage.TypeUser.GetInt(), Username: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePeer(&storage.Peer{ID: 10, Type: storage.TypeUser.GetInt(), Username: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if peer, err := s.GetPeerByUsername("new"); err != nil || peer.ID != 10 {
+		t.Fatalf("GetPeerByUsername: got %v, %v", peer, err)
+	}
+	if _, err := s.GetPeerByUsername("old"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPeerByUsername of the old username: got %v, want ErrNotFound", err)
+	}
+}
+
+func testSession(t *testing.T, s storage.Store) {
+	for _, data := range []string{"first", "second"} {
+		if err := s.SaveSession(&storage.Session{Version: storage.LatestVersion, Data: []byte(data)}); err != nil {
+			t.Fatal(err)
+		}
+		session, err := s.GetSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.Version != storage.LatestVersion || string(session.Data) != data {
+			t.Fatalf("GetSession: got %v, want %q", session, data)
+		}
+	}
+}
+
+func testSessionNotFound(t *testing.T, s storage.Store) {
+	if _, err := s.GetSession(); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetSession: got %v, want ErrNotFound", err)
+	}
+}
+
+func testConcurrent(t *testing.T, s storage.Store) {
+	const workers, peers = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < peers; i++ {
+				id := int64(w*peers + i + 1)
+				peer := &storage.Peer{ID: id, AccessHash: id, Type: storage.TypeUser.GetInt(), Username: fmt.Sprintf("user%d", id)}
+				if err := s.SavePeer(peer); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := s.GetPeerByID(storage.PeerID(id)); err != nil {
+					errs <- fmt.Errorf("GetPeerByID(%d): %w", id, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= workers*peers; id++ {
+		if peer, err := s.GetPeerByUsername(fmt.Sprintf("user%d", id)); err != nil || peer.ID != id {
+			t.Fatalf("GetPeerByUsername(user%d): got %v, %v", id, peer, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ThrottleCounter is the database model of a counter saved by a throttle storage.
+type ThrottleCounter struct {
+	Key string `gorm:"primary_key"`
+	// Hits are the unix times in nanoseconds of the events counted in the current window.
+	Hits         []int64 `gorm:"serializer:json"`
+	BlockedUntil time.Time
+	// Notified is true if the action of the counter was taken during the current block.
+	Notified bool
+}
+
+var errInMemoryThrottle = errors.New("throttle counters can only be saved in a peer storage backed by a GormStore")
+
+// SetThrottleCounter saves the provided throttle counter in the database.
+func (p *PeerStorage) SetThrottleCounter(counter *ThrottleCounter) error {
+	if p.SqlSession == nil {
+		return errInMemoryThrottle
+	}
+	return p.throttleCounters().Save(counter).Error
+}
+
+// GetThrottleCounter finds the throttle counter of the provided key in the database.
+// Returned counter has an empty Key if it was not found.
+func (p *PeerStorage) GetThrottleCounter(key string) (*ThrottleCounter, error) {
+	if p.SqlSession == nil {
+		return nil, errInMemoryThrottle
+	}
+	counter := ThrottleCounter{}
+	return &counter, p.throttleCounters().Where(&ThrottleCounter{Key: key}).Find(&counter).Error
+}
+
+// DeleteThrottleCounter removes the throttle counter of the provided key from the database.
+func (p *PeerStorage) DeleteThrottleCounter(key string) error {
+	if p.SqlSession == nil {
+		return errInMemoryThrottle
+	}
+	return p.throttleCounters().Delete(&ThrottleCounter{Key: key}).Error
+}
+
+// throttleCounters returns a session of the database using the throttle counters table of the account of the storage.
+func (p *PeerStorage) throttleCounters() *gorm.DB {
+	if p.tablePrefix == "" {
+		return p.SqlSession
+	}
+	return p.SqlSession.Table(p.tablePrefix + "throttle_counters")
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gotd/td/telegram/updates"
+)
+
+// UpdatesState is the database model of the common updates state (pts, qts, date and seq) of an account.
+type UpdatesState struct {
+	UserID int64 `gorm:"primary_key;autoIncrement:false"`
+	Pts    int
+	Qts    int
+	Date   int
+	Seq    int
+}
+
+// ChannelState is the database model of the pts of a channel for an account.
+type ChannelState struct {
+	UserID    int64 `gorm:"primary_key;autoIncrement:false"`
+	ChannelID int64 `gorm:"primary_key;autoIncrement:false"`
+	Pts       int
+}
+
+var errStateNotFound = errors.New("updates state not found")
+
+// UpdatesStorage implements updates.StateStorage and updates.ChannelAccessHasher of gotd
+// using the database and peers of PeerStorage, so that missed updates can be recovered after a restart.
+//
+// Note: It can only be used with a PeerStorage backed by a GormStore.
+type UpdatesStorage struct {
+	peerStorage *PeerStorage
+}
+
+// NewUpdatesStorage creates a new UpdatesStorage using the provided peer storage.
+func NewUpdatesStorage(p *PeerStorage) *UpdatesStorage {
+	return &UpdatesStorage{peerStorage: p}
+}
+
+func (s *UpdatesStorage) GetState(_ context.Context, userID int64) (updates.State, bool, error) {
+	var state UpdatesState
+	tx := s.peerStorage.SqlSession.Where(&UpdatesState{UserID: userID}).Limit(1).Find(&state)
+	if tx.Error != nil {
+		return updates.State{}, false, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return updates.State{}, false, nil
+	}
+	return updates.State{
+		Pts:  state.Pts,
+		Qts:  state.Qts,
+		Date: state.Date,
+		Seq:  state.Seq,
+	}, true, nil
+}
+
+func (s *UpdatesStorage) SetState(_ context.Context, userID int64, state updates.State) error {
+	return s.peerStorage.SqlSession.Save(&UpdatesState{
+		UserID: userID,
+		Pts:    state.Pts,
+		Qts:    state.Qts,
+		Date:   state.Date,
+		Seq:    state.Seq,
+	}).Error
+}
+
+func (s *UpdatesStorage) SetPts(_ context.Context, userID int64, pts int) error {
+	return s.updateState(userID, map[string]interface{}{"pts": pts})
+}
+
+func (s *UpdatesStorage) SetQts(_ context.Context, userID int64, qts int) error {
+	return s.updateState(userID, map[string]interface{}{"qts": qts})
+}
+
+func (s *UpdatesStorage) SetDate(_ context.Context, userID int64, date int) error {
+	return s.updateState(userID, map[string]interface{}{"date": date})
+}
+
+func (s *UpdatesStorage) SetSeq(_ context.Context, userID int64, seq int) error {
+	return s.updateState(userID, map[string]interface{}{"seq": seq})
+}
+
+func (s *UpdatesStorage) SetDateSeq(_ context.Context, userID int64, date, seq int) error {
+	return s.updateState(userID, map[string]interface{}{"date": date, "seq": seq})
+}
+
+func (s *UpdatesStorage) updateState(userID int64, values map[string]interface{}) error {
+	tx := s.peerStorage.SqlSession.Model(&UpdatesState{}).Where(&UpdatesState{UserID: userID}).Updates(values)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return errStateNotFound
+	}
+	return nil
+}
+
+func (s *UpdatesStorage) GetChannelPts(_ context.Context, userID, channelID int64) (int, bool, error) {
+	var state ChannelState
+	tx := s.peerStorage.SqlSession.Where(&ChannelState{UserID: userID, ChannelID: channelID}).Limit(1).Find(&state)
+	if tx.Error != nil {
+		return 0, false, tx.Error
+	}
+	return state.Pts, tx.RowsAffected != 0, nil
+}
+
+func (s *UpdatesStorage) SetChannelPts(_ context.Context, userID, channelID int64, pts int) error {
+	return s.peerStorage.SqlSession.Save(&ChannelState{
+		UserID:    userID,
+		ChannelID: channelID,
+		Pts:       pts,
+	}).Error
+}
+
+func (s *UpdatesStorage) ForEachChannels(ctx context.Context, userID int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	var states []ChannelState
+	if err := s.peerStorage.SqlSession.Where(&ChannelState{UserID: userID}).Find(&states).Error; err != nil {
+		return err
+	}
+	for _, state := range states {
+		if err := f(ctx, state.ChannelID, state.Pts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UpdatesStorage) GetChannelAccessHash(_ context.Context, _, channelID int64) (int64, bool, error) {
+	peer := s.peerStorage.GetPeer(NewPeerID(channelID, TypeChannel))
+	if peer.ID == 0 {
+		return 0, false, nil
+	}
+	return peer.AccessHash, true, nil
+}
+
+func (s *UpdatesStorage) SetChannelAccessHash(_ context.Context, _, channelID, accessHash int64) error {
+	peer := s.peerStorage.GetPeer(NewPeerID(channelID, TypeChannel))
+	if peer.ID == channelID && peer.AccessHash == accessHash {
+		return nil
+	}
+	updated := *peer
+	updated.ID, updated.Type = channelID, TypeChannel.GetInt()
+	updated.AccessHash, updated.IsMin = accessHash, false
+	updated.UpdatedAt = time.Time{}
+	s.peerStorage.SavePeer(&updated)
+	return nil
+}
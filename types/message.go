@@ -75,7 +75,7 @@ func (m *Message) SetRepliedToMessage(ctx context.Context, raw *tg.Client, p *st
 	if replyTo == 0 {
 		return errors.ErrMessageNotExist
 	}
-	chatId := functions.GetChatIdFromPeer(m.PeerID)
+	chatId := storage.PeerIDFromPeer(m.PeerID).BotAPI()
 	msgs, err := functions.GetMessages(ctx, raw, p, chatId, []tg.InputMessageClass{
 		&tg.InputMessageID{
 			ID: replyTo,
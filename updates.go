@@ -0,0 +1,167 @@
+package gotgproto
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/pkg/errors"
+)
+
+// updatesFilter sits between the updates manager and the dispatcher.
+//
+// Results of the requests sent by the client are passed to the updates manager to keep
+// pts/qts/seq in sync, updatesFilter makes sure that they are not dispatched to the handlers
+// since they were never delivered as updates by telegram.
+type updatesFilter struct {
+	next telegram.UpdateHandler
+	// sent contains the updates which were returned as a result of a request and when they were recorded.
+	// The updates dropped by the manager as outdated or replaced by a difference are never handled,
+	// so the entries older than sentUpdateTTL are removed on every record.
+	sent     map[tg.UpdateClass]time.Time
+	sentLock sync.Mutex
+}
+
+// sentUpdateTTL is how long the result of a request is remembered,
+// the manager passes the updates it keeps to the handler long before it expires.
+const sentUpdateTTL = time.Minute
+
+// hook records the updates of a request result and passes them to the provided handler.
+func (f *updatesFilter) hook(next func(context.Context, tg.UpdatesClass) error) func(context.Context, tg.UpdatesClass) error {
+	return func(ctx context.Context, u tg.UpdatesClass) error {
+		switch u := u.(type) {
+		case *tg.Updates:
+			f.record(u.Updates)
+		case *tg.UpdatesCombined:
+			f.record(u.Updates)
+		case *tg.UpdateShort:
+			f.record([]tg.UpdateClass{u.Update})
+		}
+		return next(ctx, u)
+	}
+}
+
+// middleware passes the updates returned by the requests of the client to the provided handler through hook.
+// The tg.UpdateShortSentMessage returned by messages.sendMessage lacks the peer and the text of the message,
+// so it is converted to the message it stands for using the request, selfID returns the ID of the client.
+func (f *updatesFilter) middleware(selfID func() int64, next func(context.Context, tg.UpdatesClass) error) telegram.Middleware {
+	hook := f.hook(next)
+	return telegram.MiddlewareFunc(func(invoker tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			if err := invoker.Invoke(ctx, input, output); err != nil {
+				return err
+			}
+			box, ok := output.(*tg.UpdatesBox)
+			if !ok {
+				return nil
+			}
+			u := box.Updates
+			if sent, ok := u.(*tg.UpdateShortSentMessage); ok {
+				if req, ok := input.(*tg.MessagesSendMessageRequest); ok {
+					u = sentMessageUpdate(selfID(), req, sent)
+				}
+			}
+			return errors.Wrap(hook(ctx, u), "hook")
+		}
+	})
+}
+
+// sentMessageUpdate converts the tg.UpdateShortSentMessage returned by the provided request to the update
+// with the new message it stands for.
+func sentMessageUpdate(selfID int64, req *tg.MessagesSendMessageRequest, u *tg.UpdateShortSentMessage) tg.UpdatesClass {
+	var peer tg.PeerClass
+	if _, ok := req.Peer.(*tg.InputPeerSelf); ok {
+		peer = &tg.PeerUser{UserID: selfID}
+	} else if id := storage.PeerIDFromInputPeer(req.Peer); id != 0 {
+		peer = id.Peer()
+	} else {
+		return u
+	}
+	msg := &tg.Message{ID: u.ID, PeerID: peer, Message: req.Message, Date: u.Date}
+	msg.SetOut(u.Out)
+	msg.SetFromID(&tg.PeerUser{UserID: selfID})
+	msg.SetSilent(req.Silent)
+	msg.SetNoforwards(req.Noforwards)
+	if v, ok := u.GetMedia(); ok {
+		msg.SetMedia(v)
+	}
+	if v, ok := u.GetEntities(); ok {
+		msg.SetEntities(v)
+	} else if v, ok := req.GetEntities(); ok {
+		msg.SetEntities(v)
+	}
+	if v, ok := u.GetTTLPeriod(); ok {
+		msg.SetTTLPeriod(v)
+	}
+	if v, ok := req.ReplyTo.(*tg.InputReplyToMessage); ok {
+		header := tg.MessageReplyHeader{}
+		header.SetReplyToMsgID(v.ReplyToMsgID)
+		if top, ok := v.GetTopMsgID(); ok {
+			header.SetReplyToTopID(top)
+		}
+		msg.SetReplyTo(&header)
+	}
+	update := &tg.UpdateNewMessage{Message: msg, Pts: u.Pts, PtsCount: u.PtsCount}
+	return &tg.UpdateShort{Update: update, Date: u.Date}
+}
+
+func (f *updatesFilter) record(upds []tg.UpdateClass) {
+	now := time.Now()
+	f.sentLock.Lock()
+	defer f.sentLock.Unlock()
+	if f.sent == nil {
+		f.sent = make(map[tg.UpdateClass]time.Time)
+	}
+	for update, recorded := range f.sent {
+		if now.Sub(recorded) > sentUpdateTTL {
+			delete(f.sent, update)
+		}
+	}
+	for _, update := range upds {
+		f.sent[update] = now
+	}
+}
+
+// wasSent reports whether the update was returned as a result of a request and forgets it.
+func (f *updatesFilter) wasSent(update tg.UpdateClass) bool {
+	f.sentLock.Lock()
+	defer f.sentLock.Unlock()
+	_, ok := f.sent[update]
+	delete(f.sent, update)
+	return ok
+}
+
+func (f *updatesFilter) Handle(ctx context.Context, u tg.UpdatesClass) error {
+	switch u := u.(type) {
+	case *tg.Updates:
+		u.Updates = f.filter(u.Updates)
+	case *tg.UpdatesCombined:
+		u.Updates = f.filter(u.Updates)
+	case *tg.UpdateShort:
+		if len(f.filter([]tg.UpdateClass{u.Update})) == 0 {
+			return nil
+		}
+	}
+	return f.next.Handle(ctx, u)
+}
+
+func (f *updatesFilter) filter(upds []tg.UpdateClass) []tg.UpdateClass {
+	filtered := make([]tg.UpdateClass, 0, len(upds))
+	for _, update := range upds {
+		if f.wasSent(update) {
+			continue
+		}
+		// Sent messages which only advance pts are converted to empty messages by the updates manager.
+		if m, ok := update.(*tg.UpdateNewMessage); ok {
+			if _, ok := m.Message.(*tg.MessageEmpty); ok {
+				continue
+			}
+		}
+		filtered = append(filtered, update)
+	}
+	return filtered
+}
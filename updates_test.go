@@ -0,0 +1,83 @@
+package gotgproto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+)
+
+func TestUpdatesFilter(t *testing.T) {
+	var handled []tg.UpdateClass
+	f := &updatesFilter{next: telegram.UpdateHandlerFunc(func(_ context.Context, u tg.UpdatesClass) error {
+		handled = append(handled, u.(*tg.Updates).Updates...)
+		return nil
+	})}
+	sent := &tg.UpdateReadHistoryOutbox{Pts: 1}
+	dropped := &tg.UpdateReadHistoryOutbox{Pts: 2}
+	hook := f.hook(func(context.Context, tg.UpdatesClass) error { return nil })
+	_ = hook(context.Background(), &tg.Updates{Updates: []tg.UpdateClass{sent, dropped}})
+
+	received := &tg.UpdateReadHistoryOutbox{Pts: 3}
+	_ = f.Handle(context.Background(), &tg.Updates{Updates: []tg.UpdateClass{sent, received}})
+	if len(handled) != 1 || handled[0] != received {
+		t.Fatalf("unexpected handled updates %v", handled)
+	}
+
+	// The manager never passes the dropped update to the filter, it expires instead.
+	f.sent[dropped] = time.Now().Add(-2 * sentUpdateTTL)
+	_ = hook(context.Background(), &tg.Updates{Updates: []tg.UpdateClass{&tg.UpdateReadHistoryOutbox{Pts: 4}}})
+	if _, ok := f.sent[dropped]; ok || len(f.sent) != 1 {
+		t.Fatalf("expired updates were not removed: %v", f.sent)
+	}
+}
+
+func TestUpdatesFilterSentMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		peer tg.InputPeerClass
+		want tg.PeerClass
+	}{
+		{name: "user", peer: &tg.InputPeerUser{UserID: 10, AccessHash: 1}, want: &tg.PeerUser{UserID: 10}},
+		{name: "chat", peer: &tg.InputPeerChat{ChatID: 20}, want: &tg.PeerChat{ChatID: 20}},
+		{name: "self", peer: &tg.InputPeerSelf{}, want: &tg.PeerUser{UserID: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hooked tg.UpdatesClass
+			f := &updatesFilter{next: telegram.UpdateHandlerFunc(func(context.Context, tg.UpdatesClass) error {
+				t.Fatal("the sent message was dispatched")
+				return nil
+			})}
+			invoker := f.middleware(func() int64 { return 1 }, func(_ context.Context, u tg.UpdatesClass) error {
+				hooked = u
+				return nil
+			}).Handle(telegram.InvokeFunc(func(_ context.Context, _ bin.Encoder, output bin.Decoder) error {
+				output.(*tg.UpdatesBox).Updates = &tg.UpdateShortSentMessage{Out: true, ID: 5, Pts: 7, PtsCount: 1, Date: 100}
+				return nil
+			}))
+			req := &tg.MessagesSendMessageRequest{Peer: tt.peer, Message: "hi", ReplyTo: &tg.InputReplyToMessage{ReplyToMsgID: 3}}
+			if err := invoker.Invoke(context.Background(), req, &tg.UpdatesBox{}); err != nil {
+				t.Fatal(err)
+			}
+
+			short, ok := hooked.(*tg.UpdateShort)
+			if !ok {
+				t.Fatalf("unexpected update %T", hooked)
+			}
+			update := short.Update.(*tg.UpdateNewMessage)
+			msg := update.Message.(*tg.Message)
+			if msg.ID != 5 || msg.Message != "hi" || !msg.Out || update.Pts != 7 || msg.PeerID.String() != tt.want.String() {
+				t.Fatalf("unexpected message %v", msg)
+			}
+			if reply, ok := msg.ReplyTo.(*tg.MessageReplyHeader); !ok || reply.ReplyToMsgID != 3 {
+				t.Fatalf("unexpected reply header %v", msg.ReplyTo)
+			}
+			// The result of the request is never dispatched.
+			_ = f.Handle(context.Background(), short)
+		})
+	}
+}